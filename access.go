@@ -0,0 +1,483 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// certificate attribute carrying the caller's role
+const roleAttribute = "role"
+
+// object type of the composite key registering bank MSPs
+const bankMSPIndex = "BankMSP"
+
+// world state key of the access configuration set when the chaincode is initialized
+const accessConfigKey = "AccessConfig"
+
+// object type of the composite key binding an employer name to the identity that first used it
+const employerIndex = "Employer"
+
+// Constants for caller roles
+const (
+	RoleEmployer = "employer"
+	RoleEmployee = "employee"
+	RoleBank     = "bank"
+	RoleAuditor  = "auditor"
+	RoleAdmin    = "admin"
+)
+
+// roles allowed to submit each transaction. Checks that depend on the record being
+// touched (e.g. only the contract's own employer) are made by the transaction itself
+var permissions = map[string][]string{
+	"ProposeContract":           {RoleEmployer},
+	"HashContractTerms":         {RoleEmployer, RoleEmployee},
+	"AcceptContract":            {RoleEmployer, RoleEmployee},
+	"CounterContract":           {RoleEmployer, RoleEmployee},
+	"GetContractSignatures":     {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"RevokeContract":            {RoleEmployer},
+	"WithdrawContract":          {RoleEmployer},
+	"SuspendContract":           {RoleEmployer},
+	"ReinstateContract":         {RoleEmployer},
+	"GiveNotice":                {RoleEmployer, RoleEmployee},
+	"TerminateContract":         {RoleEmployer},
+	"AmendContract":             {RoleEmployer},
+	"AcceptAmendment":           {RoleEmployee},
+	"GetContractTerms":          {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"CalculateMonthlyPayment":   {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"GetContractByID":           {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"AdvanceRequest":            {RoleEmployee},
+	"ApproveAdvanceRequest":     {RoleEmployer},
+	"RejectAdvanceRequest":      {RoleEmployer},
+	"SetApprovalPolicy":         {RoleEmployer},
+	"GetApprovalPolicies":       {RoleEmployer, RoleBank, RoleAuditor},
+	"CancelAdvanceRequest":      {RoleEmployee},
+	"RepayAdvance":              {RoleEmployee},
+	"GetAdvanceRequests":        {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"ProcessPayment":            {RoleEmployer},
+	"WithdrawPayment":           {RoleEmployee},
+	"GetLastPaymentDate":        {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"GetLastPayment":            {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"GetBalance":                {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"GetPostings":               {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"ReversePayment":            {RoleEmployer},
+	"CorrectPayment":            {RoleEmployer},
+	"ApprovePaymentAdjustment":  {RoleEmployer},
+	"GetPaymentAdjustment":      {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"ProcessBankPayment":        {RoleEmployer},
+	"ApproveCrossBorderPayment": {RoleEmployer, RoleBank},
+	"ProcessLocalPayment":       {RoleBank},
+	"SetEmployerBank":           {RoleEmployer},
+	"InitiateSettlement":        {RoleBank},
+	"AdvanceSettlement":         {RoleBank},
+	"FlagStuckSettlements":      {RoleBank, RoleAdmin},
+	"GetSettlementExceptions":   {RoleBank, RoleAuditor, RoleAdmin},
+	"RegisterRateProvider":      {RoleAdmin},
+	"HashFxRate":                {RoleBank},
+	"PublishFxRate":             {RoleBank},
+	"GetFxRates":                {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"QuoteFxRate":               {RoleBank},
+	"GetFxQuote":                {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"ExportSettlementMessage":   {RoleEmployer, RoleBank},
+	"RenderSettlementMessage":   {RoleEmployer, RoleBank},
+	"GetPaymentByEndToEndID":    {RoleBank, RoleAuditor},
+	"ReconcilePayment":          {RoleBank},
+	"GenerateNachaFile":         {RoleEmployer, RoleBank},
+	"GenerateSepaFile":          {RoleEmployer, RoleBank},
+	"RenderBatchFile":           {RoleEmployer, RoleBank},
+	"GetBatchFile":              {RoleEmployer, RoleBank, RoleAuditor},
+	"CreateAccount":             {RoleEmployer, RoleEmployee},
+	"UpdateAccount":             {RoleEmployer, RoleEmployee},
+	"GetAccount":                {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"CloseAccount":              {RoleEmployer, RoleEmployee},
+	"RunPayroll":                {RoleEmployer},
+	"PublishTaxRuleSet":         {RoleAdmin},
+	"GetTaxRuleSets":            {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor, RoleAdmin},
+	"GetYearToDate":             {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"GetPayrollRuns":            {RoleEmployer, RoleAuditor},
+	"GetPayslip":                {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"MigratePaymentIndexes":     {RoleAdmin},
+	"MigrateContractIndexes":    {RoleAdmin},
+	"RegisterBankMSP":           {RoleAdmin},
+	"SetPolicyConfig":           {RoleAdmin},
+	"GetPolicyConfigs":          {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor, RoleAdmin},
+	"GetEffectivePolicy":        {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor},
+	"GetAccessConfig":           {RoleEmployer, RoleEmployee, RoleBank, RoleAuditor, RoleAdmin},
+}
+
+// MSPs the privileged roles are bound to. A role attribute can be minted by the CA of
+// any org, so admin, auditor and bank clients must also belong to one of these MSPs
+type AccessConfig struct {
+	AdminMSPs   []string `json:"AdminMSPs"`   // MSPs whose role=admin clients administer the chaincode
+	AuditorMSPs []string `json:"AuditorMSPs"` // MSPs whose role=auditor clients may read every record
+	BankMSPs    []string `json:"BankMSPs"`    // MSPs registered as banks at initialization, see RegisterBankMSP
+}
+
+// a party bound to a record: the MSP that issued its certificate and its unique client
+// identity within that MSP. Unlike the certificate common name it cannot be claimed
+// by a client of another org
+type PartyIdentity struct {
+	MSPID string `json:"MSPID"` // MSP the party's certificate was issued by
+	ID    string `json:"ID"`    // Unique client identity (subject and issuer of the certificate)
+}
+
+// isSet reports whether the identity was bound. Records written before parties were
+// bound have none, and no caller matches them
+func (p PartyIdentity) isSet() bool {
+	return p.MSPID != "" && p.ID != ""
+}
+
+// matches reports whether two bound identities are the same party
+func (p PartyIdentity) matches(other PartyIdentity) bool {
+	return p.isSet() && p == other
+}
+
+// the submitter of a transaction as read from its client certificate
+type identity struct {
+	ID    string // Unique client identity (subject and issuer of the certificate)
+	MSPID string // MSP the client certificate was issued by
+	Name  string // Enrollment ID (certificate common name), matched against contract parties
+	Role  string // Value of the role attribute
+}
+
+// getIdentity reads the submitter of the current transaction
+func getIdentity(ctx contractapi.TransactionContextInterface) (*identity, error) {
+	clientIdentity := ctx.GetClientIdentity()
+
+	id, err := clientIdentity.GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client identity: %v", err)
+	}
+
+	mspID, err := clientIdentity.GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client MSP ID: %v", err)
+	}
+
+	cert, err := clientIdentity.GetX509Certificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate: %v", err)
+	}
+
+	role, found, err := clientIdentity.GetAttributeValue(roleAttribute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client role: %v", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("client %s has no %s attribute", cert.Subject.CommonName, roleAttribute)
+	}
+
+	return &identity{ID: id, MSPID: mspID, Name: cert.Subject.CommonName, Role: role}, nil
+}
+
+// party returns the identity the caller is bound to records by
+func (c *identity) party() PartyIdentity {
+	return PartyIdentity{MSPID: c.MSPID, ID: c.ID}
+}
+
+// is reports whether the caller is the bound party
+func (c *identity) is(party PartyIdentity) bool {
+	return c.party().matches(party)
+}
+
+// qualifiedName returns the caller's enrollment ID qualified by its MSP, e.g.
+// "Org1MSP/alice", as approvers are listed in approval policies
+func (c *identity) qualifiedName() string {
+	return c.MSPID + "/" + c.Name
+}
+
+// isEmployerOf reports whether the caller is the employer bound to a contract
+func (c *identity) isEmployerOf(contract *Contract) bool {
+	return c.Role == RoleEmployer && c.is(contract.EmployerIdentity)
+}
+
+// belongsToEmployerOf reports whether the caller is a member of the org of the
+// employer bound to a contract, whatever its role
+func (c *identity) belongsToEmployerOf(contract *Contract) bool {
+	return contract.EmployerIdentity.isSet() && c.MSPID == contract.EmployerIdentity.MSPID
+}
+
+// isEmployeeOf reports whether the caller is the employee bound to a contract
+func (c *identity) isEmployeeOf(contract *Contract) bool {
+	return c.Role == RoleEmployee && c.is(contract.EmployeeIdentity)
+}
+
+// containsMSP reports whether mspID is one of mspIDs
+func containsMSP(mspIDs []string, mspID string) bool {
+	for _, id := range mspIDs {
+		if id == mspID {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks the submitter's role against the permission matrix entry of a
+// transaction and returns the submitter. Admin and auditor roles must also belong to
+// an MSP of the access configuration, and bank roles to a registered bank MSP
+func authorize(ctx contractapi.TransactionContextInterface, transaction string) (*identity, error) {
+	caller, err := getIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := false
+	for _, role := range permissions[transaction] {
+		if caller.Role == role {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("role %q of %s is not allowed to call %s", caller.Role, caller.Name, transaction)
+	}
+
+	config, err := readAccessConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch caller.Role {
+	case RoleAdmin:
+		if !containsMSP(config.AdminMSPs, caller.MSPID) {
+			return nil, fmt.Errorf("MSP %s is not an admin MSP", caller.MSPID)
+		}
+	case RoleAuditor:
+		if !containsMSP(config.AuditorMSPs, caller.MSPID) {
+			return nil, fmt.Errorf("MSP %s is not an auditor MSP", caller.MSPID)
+		}
+	case RoleBank:
+		registered, err := isBankMSP(ctx, caller.MSPID)
+		if err != nil {
+			return nil, err
+		}
+		if !registered {
+			return nil, fmt.Errorf("MSP %s is not a registered bank", caller.MSPID)
+		}
+	}
+
+	return caller, nil
+}
+
+// authorizeContractRead lets banks and auditors read any contract and employers
+// and employees only the contracts they are a party to
+func authorizeContractRead(caller *identity, contract *Contract) error {
+	switch caller.Role {
+	case RoleBank, RoleAuditor:
+		return nil
+	case RoleEmployer:
+		if caller.isEmployerOf(contract) {
+			return nil
+		}
+	case RoleEmployee:
+		if caller.isEmployeeOf(contract) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is not a party to contract %s", caller.Name, contract.ID)
+}
+
+// authorizeRead authorizes a read-only transaction on the data of a contract
+func authorizeRead(ctx contractapi.TransactionContextInterface, transaction string, contractID string) error {
+	caller, err := authorize(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return err
+	}
+
+	return authorizeContractRead(caller, contract)
+}
+
+// isBankMSP reports whether an MSP was registered as a bank
+func isBankMSP(ctx contractapi.TransactionContextInterface, mspID string) (bool, error) {
+	bankKey, err := ctx.GetStub().CreateCompositeKey(bankMSPIndex, []string{mspID})
+	if err != nil {
+		return false, fmt.Errorf("failed to create bank key: %v", err)
+	}
+
+	bankJSON, err := ctx.GetStub().GetState(bankKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	return bankJSON != nil, nil
+}
+
+// RegisterBankMSP marks an MSP as a bank whose role=bank clients may settle payments
+func (s *PaymentContract) RegisterBankMSP(ctx contractapi.TransactionContextInterface, mspID string) error {
+	_, err := authorize(ctx, "RegisterBankMSP")
+	if err != nil {
+		return err
+	}
+
+	return putBankMSP(ctx, mspID)
+}
+
+// putBankMSP registers an MSP as a bank
+func putBankMSP(ctx contractapi.TransactionContextInterface, mspID string) error {
+	bankKey, err := ctx.GetStub().CreateCompositeKey(bankMSPIndex, []string{mspID})
+	if err != nil {
+		return fmt.Errorf("failed to create bank key: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(bankKey, []byte(mspID))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// readAccessConfig reads the access configuration set by Initialize
+func readAccessConfig(ctx contractapi.TransactionContextInterface) (*AccessConfig, error) {
+	configJSON, err := ctx.GetStub().GetState(accessConfigKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if configJSON == nil {
+		return nil, fmt.Errorf("the chaincode is not initialized, call Initialize first")
+	}
+
+	var config AccessConfig
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Initialize binds the admin, auditor and bank roles to the MSPs of the deployment.
+// Deploy the chaincode with --init-required and submit this with --isInit, from an
+// admin client of one of the admin MSPs. It can only be called once
+func (s *PaymentContract) Initialize(ctx contractapi.TransactionContextInterface, config AccessConfig) error {
+	caller, err := getIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing, err := ctx.GetStub().GetState(accessConfigKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("the chaincode is already initialized")
+	}
+
+	if len(config.AdminMSPs) == 0 {
+		return fmt.Errorf("at least one admin MSP is required")
+	}
+	if caller.Role != RoleAdmin || !containsMSP(config.AdminMSPs, caller.MSPID) {
+		return fmt.Errorf("the chaincode must be initialized by an admin of one of the admin MSPs %v", config.AdminMSPs)
+	}
+
+	for _, mspID := range config.BankMSPs {
+		err = putBankMSP(ctx, mspID)
+		if err != nil {
+			return err
+		}
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(accessConfigKey, configJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// GetAccessConfig returns the MSPs the privileged roles are bound to
+func (s *PaymentContract) GetAccessConfig(ctx contractapi.TransactionContextInterface) (*AccessConfig, error) {
+	_, err := authorize(ctx, "GetAccessConfig")
+	if err != nil {
+		return nil, err
+	}
+
+	return readAccessConfig(ctx)
+}
+
+// bindEmployer checks that the caller acts as the named employer. Records such as
+// approval policies and payroll runs are kept per employer name, so the first
+// identity to use a name is bound to it and clients of other identities presenting
+// the same common name are refused
+func bindEmployer(ctx contractapi.TransactionContextInterface, caller *identity, employer string) error {
+	if caller.Role != RoleEmployer || caller.Name != employer {
+		return fmt.Errorf("%s cannot act on behalf of employer %s", caller.Name, employer)
+	}
+
+	bound, err := employerIdentity(ctx, employer)
+	if err != nil {
+		return err
+	}
+	if bound != nil {
+		if !caller.is(*bound) {
+			return fmt.Errorf("employer %s is bound to another identity of MSP %s", employer, bound.MSPID)
+		}
+		return nil
+	}
+
+	employerKey, err := ctx.GetStub().CreateCompositeKey(employerIndex, []string{employer})
+	if err != nil {
+		return fmt.Errorf("failed to create employer key: %v", err)
+	}
+
+	partyJSON, err := json.Marshal(caller.party())
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(employerKey, partyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// requireEmployer checks that the caller is the identity bound to the named employer
+func requireEmployer(ctx contractapi.TransactionContextInterface, caller *identity, employer string) error {
+	bound, err := employerIdentity(ctx, employer)
+	if err != nil {
+		return err
+	}
+	if caller.Role != RoleEmployer || bound == nil || !caller.is(*bound) {
+		return fmt.Errorf("%s is not the employer %s", caller.Name, employer)
+	}
+
+	return nil
+}
+
+// employerIdentity returns the identity bound to an employer name, or nil if the name is unused
+func employerIdentity(ctx contractapi.TransactionContextInterface, employer string) (*PartyIdentity, error) {
+	employerKey, err := ctx.GetStub().CreateCompositeKey(employerIndex, []string{employer})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create employer key: %v", err)
+	}
+
+	partyJSON, err := ctx.GetStub().GetState(employerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if partyJSON == nil {
+		return nil, nil
+	}
+
+	var party PartyIdentity
+	err = json.Unmarshal(partyJSON, &party)
+	if err != nil {
+		return nil, err
+	}
+
+	return &party, nil
+}
@@ -0,0 +1,58 @@
+package chaincode
+
+import (
+	"testing"
+)
+
+// A role attribute can be minted by the CA of any org, so privileged roles are bound
+// to the MSPs set at initialization and contract parties to their client identity
+func TestRolesAndPartiesAreBoundToIdentities(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+
+	err := s.RegisterBankMSP(l.begin(p.admin, nil), "OtherBankMSP")
+	if err == nil {
+		t.Fatal("transactions ran before the chaincode was initialized")
+	}
+
+	initialize(l, s, p)
+	err = s.Initialize(l.begin(p.admin, nil), AccessConfig{AdminMSPs: []string{p.admin.mspID}})
+	if err == nil {
+		t.Error("the chaincode was initialized twice")
+	}
+
+	rogueAdmin := newTestClient(t, "admin", p.employee.mspID, RoleAdmin)
+	err = s.RegisterBankMSP(l.begin(rogueAdmin, nil), p.employee.mspID)
+	if err == nil {
+		t.Error("an admin of an MSP outside the access configuration registered a bank")
+	}
+	rogueBank := newTestClient(t, "bank", p.employer.mspID, RoleBank)
+	_, err = s.GetPaymentByEndToEndID(l.begin(rogueBank, nil), "E2E")
+	if err == nil || err.Error() != "MSP "+p.employer.mspID+" is not a registered bank" {
+		t.Errorf("a bank client of an unregistered MSP was accepted: %v", err)
+	}
+
+	setupContract(l, s, p, "C1")
+
+	// same common name and role as the employer, issued by another org
+	impostor := newTestClient(t, p.employer.name, p.employee.mspID, RoleEmployer)
+	err = s.RevokeContract(l.begin(impostor, nil), "C1", "Impostor")
+	if err == nil {
+		t.Error("a client of another MSP revoked the contract under the employer's name")
+	}
+	_, err = s.GetContractByID(l.begin(impostor, nil), "C1")
+	if err == nil {
+		t.Error("a client of another MSP read the contract under the employer's name")
+	}
+	_, err = s.SetApprovalPolicy(l.begin(impostor, nil), p.employer.name, ApprovalPolicy{Kind: AdvanceApproval, Levels: []ApprovalLevel{{Name: "Manager", Approvers: []string{p.employee.mspID + "/" + p.employer.name}, Required: 1}}})
+	if err == nil {
+		t.Error("a client of another MSP set the approval policy of the employer")
+	}
+
+	contract, err := s.GetContractByID(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	if !contract.EmployerIdentity.isSet() || !contract.EmployeeIdentity.isSet() || contract.EmployerIdentity == contract.EmployeeIdentity {
+		t.Errorf("contract parties are not bound: %+v %+v", contract.EmployerIdentity, contract.EmployeeIdentity)
+	}
+}
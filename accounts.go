@@ -0,0 +1,315 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key accounts are stored under, keeping them apart from contract IDs
+const accountIndex = "Account"
+
+// Constants for account statuses
+const (
+	AccountOpen   = "Open"
+	AccountClosed = "Closed"
+)
+
+// accountKey returns the world state key of an account
+func accountKey(ctx contractapi.TransactionContextInterface, accountID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(accountIndex, []string{accountID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create account key: %v", err)
+	}
+	return key, nil
+}
+
+// readAccount reads an account from the world state without access checks
+func readAccount(ctx contractapi.TransactionContextInterface, accountID string) (*Account, error) {
+	key, err := accountKey(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	accountJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if accountJSON == nil {
+		return nil, fmt.Errorf("the account %s does not exist", accountID)
+	}
+
+	var account Account
+	err = json.Unmarshal(accountJSON, &account)
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// putAccount writes an account to the world state. Accounts with private details keep
+// them in the account collection only
+func putAccount(ctx contractapi.TransactionContextInterface, account *Account) error {
+	key, err := accountKey(ctx, account.AccountID)
+	if err != nil {
+		return err
+	}
+
+	public := *account
+	if public.PrivateDataHash != "" {
+		public.TaxComplianceInfo = ""
+		public.FinancialInfo = ""
+		public.BankAccount = ""
+		public.BankBIC = ""
+		public.RoutingNumber = ""
+	}
+
+	accountJSON, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, accountJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// validateContractAccount checks that an account exists, is open, belongs to the
+// contract's employee and is not linked to another live contract, and returns it
+func validateContractAccount(ctx contractapi.TransactionContextInterface, accountID string, contractID string, employee string) (*Account, error) {
+	account, err := readAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.Status != AccountOpen {
+		return nil, fmt.Errorf("the account %s is %s", accountID, account.Status)
+	}
+	if account.Owner != employee {
+		return nil, fmt.Errorf("the account %s does not belong to employee %s", accountID, employee)
+	}
+	if account.ContractID != "" && account.ContractID != contractID && !isFinalStatus(account.ContractStatus) {
+		return nil, fmt.Errorf("the account %s is already linked to contract %s", accountID, account.ContractID)
+	}
+
+	return account, nil
+}
+
+// validateEmployeeAccount checks an account a contract's pay moves to, which must be
+// owned by the employee bound to the contract
+func validateEmployeeAccount(ctx contractapi.TransactionContextInterface, accountID string, contract *Contract) error {
+	account, err := validateContractAccount(ctx, accountID, contract.ID, contract.Employee)
+	if err != nil {
+		return err
+	}
+	if !account.OwnerIdentity.matches(contract.EmployeeIdentity) {
+		return fmt.Errorf("the account %s does not belong to the employee of contract %s", accountID, contract.ID)
+	}
+
+	return nil
+}
+
+// isFinalStatus reports whether a contract status can no longer change
+func isFinalStatus(status string) bool {
+	return status == StatusTerminated || status == StatusRevoked
+}
+
+// accountExists reports whether an account with the given ID exists
+func accountExists(ctx contractapi.TransactionContextInterface, accountID string) (bool, error) {
+	key, err := accountKey(ctx, accountID)
+	if err != nil {
+		return false, err
+	}
+
+	accountJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	return accountJSON != nil, nil
+}
+
+// syncAccount links the contract's account to it and copies the contract status.
+// Contracts recorded before accounts existed hold a bare account string and are skipped
+func syncAccount(ctx contractapi.TransactionContextInterface, contract *Contract) error {
+	exists, err := accountExists(ctx, contract.AccountID)
+	if err != nil || !exists {
+		return err
+	}
+
+	account, err := readAccount(ctx, contract.AccountID)
+	if err != nil {
+		return err
+	}
+	if account.ContractID == contract.ID && account.ContractStatus == contract.Status {
+		return nil
+	}
+
+	account.ContractID = contract.ID
+	account.ContractStatus = contract.Status
+	return putAccount(ctx, account)
+}
+
+// unlinkAccount detaches an account that a contract no longer pays into
+func unlinkAccount(ctx contractapi.TransactionContextInterface, accountID string, contractID string) error {
+	exists, err := accountExists(ctx, accountID)
+	if err != nil || !exists {
+		return err
+	}
+
+	account, err := readAccount(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	if account.ContractID != contractID {
+		return nil
+	}
+
+	account.ContractID = ""
+	account.ContractStatus = ""
+	return putAccount(ctx, account)
+}
+
+// CreateAccount opens an account owned by the caller. Tax compliance, financial and
+// bank account details are submitted in the transient map with a salt and are kept
+// in the account collection. Pay into the account is withheld under the tax rules of
+// taxJurisdiction, or not at all when it is empty
+func (s *PaymentContract) CreateAccount(ctx contractapi.TransactionContextInterface, accountID string, company string, taxJurisdiction string, preferredCurrency string) error {
+	caller, err := authorize(ctx, "CreateAccount")
+	if err != nil {
+		return err
+	}
+
+	exists, err := accountExists(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the account %s already exists", accountID)
+	}
+
+	if _, err := CurrencyExponent(preferredCurrency); err != nil {
+		return err
+	}
+
+	account := Account{
+		AccountID:         accountID,
+		Owner:             caller.Name,
+		OwnerIdentity:     caller.party(),
+		Company:           company,
+		TaxJurisdiction:   taxJurisdiction,
+		PreferredCurrency: preferredCurrency,
+		Status:            AccountOpen,
+	}
+
+	err = putAccountDetails(ctx, &account)
+	if err != nil {
+		return err
+	}
+
+	return putAccount(ctx, &account)
+}
+
+// UpdateAccount replaces the details of an open account, reading the private details
+// from the transient map as CreateAccount does. Only the owner can update it and the
+// linked contract is maintained by the contract transactions
+func (s *PaymentContract) UpdateAccount(ctx contractapi.TransactionContextInterface, accountID string, company string, taxJurisdiction string, preferredCurrency string) error {
+	caller, err := authorize(ctx, "UpdateAccount")
+	if err != nil {
+		return err
+	}
+
+	account, err := readAccount(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	if !caller.is(account.OwnerIdentity) {
+		return fmt.Errorf("only the owner %s can update account %s", account.Owner, accountID)
+	}
+	if account.Status != AccountOpen {
+		return fmt.Errorf("the account %s is %s", accountID, account.Status)
+	}
+
+	if _, err := CurrencyExponent(preferredCurrency); err != nil {
+		return err
+	}
+
+	account.Company = company
+	account.TaxJurisdiction = taxJurisdiction
+	account.PreferredCurrency = preferredCurrency
+
+	err = putAccountDetails(ctx, account)
+	if err != nil {
+		return err
+	}
+
+	return putAccount(ctx, account)
+}
+
+// GetAccount returns an account to its owner, the parties of its linked contract,
+// banks and auditors. The private details are only included for clients of orgs that
+// are members of the account collection
+func (s *PaymentContract) GetAccount(ctx contractapi.TransactionContextInterface, accountID string) (*Account, error) {
+	caller, err := authorize(ctx, "GetAccount")
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := readAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case caller.is(account.OwnerIdentity):
+	case account.ContractID == "":
+		if caller.Role != RoleBank && caller.Role != RoleAuditor {
+			return nil, fmt.Errorf("%s cannot read account %s", caller.Name, accountID)
+		}
+	default:
+		contract, err := readContract(ctx, account.ContractID)
+		if err != nil {
+			return nil, err
+		}
+		err = authorizeContractRead(caller, contract)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = loadVisibleAccountDetails(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// CloseAccount closes an account that no live contract pays into. Only the owner can close it
+func (s *PaymentContract) CloseAccount(ctx contractapi.TransactionContextInterface, accountID string) error {
+	caller, err := authorize(ctx, "CloseAccount")
+	if err != nil {
+		return err
+	}
+
+	account, err := readAccount(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	if !caller.is(account.OwnerIdentity) {
+		return fmt.Errorf("only the owner %s can close account %s", account.Owner, accountID)
+	}
+	if account.Status != AccountOpen {
+		return fmt.Errorf("the account %s is already %s", accountID, account.Status)
+	}
+	if account.ContractID != "" && !isFinalStatus(account.ContractStatus) {
+		return fmt.Errorf("the account %s is still used by contract %s", accountID, account.ContractID)
+	}
+
+	account.Status = AccountClosed
+	return putAccount(ctx, account)
+}
@@ -0,0 +1,701 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key marking a payment as reversed or corrected
+const adjustedPaymentIndex = "AdjustedPayment"
+
+// Constants for the kinds of payment adjustment
+const (
+	ReversalAdjustment   = "Reversal"   // the payment is undone
+	CorrectionAdjustment = "Correction" // the payment is undone and paid again with another gross amount
+)
+
+// Constants for payment adjustment statuses
+const (
+	AdjustmentPending  = "Pending"
+	AdjustmentExecuted = "Executed"
+)
+
+// Constants for why a payment is adjusted
+const (
+	ReasonDuplicate       = "Duplicate"       // the payment was made twice
+	ReasonIncorrectAmount = "IncorrectAmount" // the gross amount was wrong
+	ReasonWrongRecipient  = "WrongRecipient"  // the payment went to the wrong contract or employee
+	ReasonOther           = "Other"           // explained in the note
+)
+
+// a request to reverse or correct a regular payment. It is executed once the
+// employer's Adjustment approval policy is satisfied
+type PaymentAdjustment struct {
+	ID                string        `json:"ID"`                // Unique identifier for the adjustment
+	Type              string        `json:"Type"`              // Reversal or Correction
+	PaymentID         string        `json:"PaymentID"`         // ID of the payment adjusted
+	ContractID        string        `json:"ContractID"`        // ID of the contract of the payment
+	Employee          string        `json:"Employee"`          // Name of the employee paid
+	ReasonCode        string        `json:"ReasonCode"`        // Duplicate, IncorrectAmount, WrongRecipient or Other
+	Note              string        `json:"Note"`              // Free text explanation, required for Other
+	Amount            Money         `json:"Amount"`            // Gross amount the payment is corrected to, zero for a reversal
+	Status            string        `json:"Status"`            // Pending or Executed
+	RequestedBy       string        `json:"RequestedBy"`       // Name of the party that requested the adjustment
+	RequesterIdentity PartyIdentity `json:"RequesterIdentity"` // MSP and client identity of the party that requested the adjustment
+	RequestedAt       time.Time     `json:"RequestedAt"`       // Transaction timestamp of the request
+	Approvals         []Approval    `json:"Approvals"`         // Signatures collected under the approval policy
+	ReversalID        string        `json:"ReversalID"`        // Compensating entry written on execution
+	CorrectionID      string        `json:"CorrectionID"`      // Corrected payment written on execution of a correction
+	ExecutedAt        time.Time     `json:"ExecutedAt"`        // Transaction timestamp of the execution
+
+	PrivateDataHash string `json:"PrivateDataHash"` // Salted hash of the amount held in the contract collection
+	salt            string // salt of the amount, once written or read from the contract collection
+}
+
+// readPaymentAdjustment reads a payment adjustment from the world state together with
+// its private amount
+func readPaymentAdjustment(ctx contractapi.TransactionContextInterface, adjustmentID string) (*PaymentAdjustment, error) {
+	return getPaymentAdjustment(ctx, adjustmentID, false)
+}
+
+// getPaymentAdjustment reads a payment adjustment from the world state. When
+// visibleOnly is set, as in read transactions, its amount is filled in only if the
+// client may see it
+func getPaymentAdjustment(ctx contractapi.TransactionContextInterface, adjustmentID string, visibleOnly bool) (*PaymentAdjustment, error) {
+	adjustmentJSON, err := ctx.GetStub().GetState(adjustmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if adjustmentJSON == nil {
+		return nil, fmt.Errorf("the payment adjustment %s does not exist", adjustmentID)
+	}
+
+	var adjustment PaymentAdjustment
+	err = json.Unmarshal(adjustmentJSON, &adjustment)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadAdjustmentAmount(ctx, &adjustment, visibleOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return &adjustment, nil
+}
+
+// putPaymentAdjustment writes a payment adjustment to the world state and its amount
+// to the contract collection
+func putPaymentAdjustment(ctx contractapi.TransactionContextInterface, adjustment *PaymentAdjustment) error {
+	public, err := putAdjustmentAmount(ctx, adjustment)
+	if err != nil {
+		return err
+	}
+
+	adjustmentJSON, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(adjustment.ID, adjustmentJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// checkAdjustable rejects payments that are not regular pay, were adjusted before,
+// or whose funds were already withdrawn to the employee's bank
+func checkAdjustable(ctx contractapi.TransactionContextInterface, payment *Payment) error {
+	if payment.PeriodStart.IsZero() || payment.Type == AdvancePayment || payment.Type == WithdrawalPayment || payment.Type == ReversalPayment {
+		return fmt.Errorf("payment %s is not a payment of pay and cannot be adjusted", payment.ID)
+	}
+
+	markerKey, err := ctx.GetStub().CreateCompositeKey(adjustedPaymentIndex, []string{payment.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create adjusted payment key: %v", err)
+	}
+	adjustedBy, err := ctx.GetStub().GetState(markerKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if adjustedBy != nil {
+		return fmt.Errorf("payment %s was already adjusted by %s", payment.ID, adjustedBy)
+	}
+
+	withdrawalID, err := withdrawnBy(ctx, payment)
+	if err != nil {
+		return err
+	}
+	if withdrawalID != "" {
+		return fmt.Errorf("payment %s was settled to the bank by withdrawal %s", payment.ID, withdrawalID)
+	}
+
+	return nil
+}
+
+// funds a record credited to the employee's wallet and not yet drawn on
+type walletFunds struct {
+	paymentID string
+	remaining Money
+}
+
+// draw takes up to amount from the funds and returns what is left to draw elsewhere
+func (f *walletFunds) draw(amount Money) (Money, error) {
+	taken := amount
+	if cmp, err := amount.Cmp(f.remaining); err != nil {
+		return Money{}, err
+	} else if cmp > 0 {
+		taken = f.remaining
+	}
+
+	var err error
+	f.remaining, err = f.remaining.Sub(taken)
+	if err != nil {
+		return Money{}, err
+	}
+	return amount.Sub(taken)
+}
+
+// withdrawnBy returns the first withdrawal that carried funds a payment credited to
+// the employee's wallet to the bank, or "" when none did. The wallet postings of the
+// employee are replayed in order: an adjustment takes back the funds of the payment
+// it adjusts, while withdrawals and advance repayments draw on the oldest funds first
+func withdrawnBy(ctx contractapi.TransactionContextInterface, payment *Payment) (string, error) {
+	entries, err := getPaymentIndexEntries(ctx, payment.ContractID, payment.Employee)
+	if err != nil {
+		return "", err
+	}
+	payments := map[string]string{}
+	for _, entry := range entries {
+		payments[entry.PaymentID] = entry.Type
+	}
+
+	postings, err := walletPostings(ctx, payment.ContractID, payment.Employee)
+	if err != nil {
+		return "", err
+	}
+
+	var wallet []*walletFunds
+	for _, posting := range postings {
+		var adjustment *PaymentAdjustment
+		if _, ok := payments[posting.Reference]; !ok {
+			adjustment, err = adjustmentOf(ctx, posting.Reference)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		// a correction credits the wallet under its adjustment
+		if posting.CreditAccount == EmployeeWallet {
+			source := posting.Reference
+			if adjustment != nil {
+				source = adjustment.CorrectionID
+			}
+			wallet = append(wallet, &walletFunds{paymentID: source, remaining: posting.Amount})
+			continue
+		}
+
+		left := posting.Amount
+		if adjustment != nil {
+			for _, funds := range wallet {
+				if funds.paymentID == adjustment.PaymentID && funds.remaining.Currency == left.Currency {
+					left, err = funds.draw(left)
+					if err != nil {
+						return "", err
+					}
+				}
+			}
+		}
+		for _, funds := range wallet {
+			if !left.IsPositive() {
+				break
+			}
+			if !funds.remaining.IsPositive() || funds.remaining.Currency != left.Currency {
+				continue
+			}
+			left, err = funds.draw(left)
+			if err != nil {
+				return "", err
+			}
+			if funds.paymentID == payment.ID && payments[posting.Reference] == WithdrawalPayment {
+				return posting.Reference, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// adjustmentOf returns the payment adjustment recorded under a posting reference, or
+// nil if the reference is not one
+func adjustmentOf(ctx contractapi.TransactionContextInterface, reference string) (*PaymentAdjustment, error) {
+	recordJSON, err := ctx.GetStub().GetState(reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if recordJSON == nil {
+		return nil, nil
+	}
+
+	// advance repayments and other references are not records of their own
+	var adjustment PaymentAdjustment
+	if json.Unmarshal(recordJSON, &adjustment) != nil || adjustment.ID != reference || adjustment.PaymentID == "" {
+		return nil, nil
+	}
+	return &adjustment, nil
+}
+
+// reversalOf returns the compensating entry of a payment: a copy with every amount
+// negated. With keepRecoveries the advance installments recovered from the payment
+// are left in place, as a correction recovers them again
+func reversalOf(payment *Payment, id string, adjustmentID string, date time.Time, keepRecoveries bool) *Payment {
+	reversal := Payment{
+		ID:           id,
+		ContractID:   payment.ContractID,
+		Employee:     payment.Employee,
+		Amount:       payment.Amount.Neg(),
+		Date:         date,
+		Type:         ReversalPayment,
+		PeriodStart:  payment.PeriodStart,
+		PeriodEnd:    payment.PeriodEnd,
+		TermsVersion: payment.TermsVersion,
+		Position:     payment.Position,
+		Net:          payment.Net.Neg(),
+		Adjusts:      payment.ID,
+		AdjustmentID: adjustmentID,
+	}
+	for _, component := range payment.Components {
+		reversal.Components = append(reversal.Components, PayComponent{Code: component.Code, Amount: component.Amount.Neg()})
+	}
+	for _, line := range payment.Deductions {
+		reversal.Deductions = append(reversal.Deductions, DeductionLine{Code: line.Code, Base: line.Base.Neg(), Rate: line.Rate, Amount: line.Amount.Neg()})
+	}
+	if !keepRecoveries {
+		for _, recovery := range payment.Recoveries {
+			reversal.Recoveries = append(reversal.Recoveries, AdvanceRecovery{
+				RequestID: recovery.RequestID,
+				Principal: recovery.Principal.Neg(),
+				Charges:   recovery.Charges.Neg(),
+				Amount:    recovery.Amount.Neg(),
+			})
+		}
+		reversal.Recovered = payment.Recovered.Neg()
+	}
+	return &reversal
+}
+
+// adjustmentLegs returns the postings of a payment from EmployerPayable, leaving out
+// the advance installments unless withRecoveries is set. When reverse is set every
+// leg is turned around to undo the payment
+func adjustmentLegs(payment *Payment, withRecoveries bool, reverse bool) ([]postingLeg, error) {
+	basis := *payment
+	if !withRecoveries {
+		basis.Recoveries = nil
+	}
+
+	legs, err := paymentLegs(&basis, EmployerPayable)
+	if err != nil {
+		return nil, err
+	}
+	if reverse {
+		for i := range legs {
+			legs[i].debitAccount, legs[i].creditAccount = legs[i].creditAccount, legs[i].debitAccount
+		}
+	}
+	return legs, nil
+}
+
+// credited returns the amount a payment credited to the employee's wallet
+func credited(payment *Payment) (Money, error) {
+	if !payment.Recovered.IsPositive() {
+		return payment.Net, nil
+	}
+	return payment.Net.Sub(payment.Recovered)
+}
+
+// reinstateAdvances hands the installments recovered from a reversed payment back to
+// the outstanding balances of their advances
+func reinstateAdvances(ctx contractapi.TransactionContextInterface, payment *Payment, reference string, date time.Time) error {
+	for _, recovery := range payment.Recoveries {
+		advance, err := readAdvanceRequest(ctx, recovery.RequestID)
+		if err != nil {
+			return err
+		}
+		if advance.Status == AdvanceWrittenOff {
+			return fmt.Errorf("advance %s recovered from payment %s was written off", advance.ID, payment.ID)
+		}
+
+		advance.Outstanding, err = advance.Outstanding.Add(recovery.Amount)
+		if err != nil {
+			return err
+		}
+		advance.ChargesOutstanding, err = advance.ChargesOutstanding.Add(recovery.Charges)
+		if err != nil {
+			return err
+		}
+		advance.Repayments = append(advance.Repayments, AdvanceRepayment{
+			Reference: reference,
+			Type:      RepaymentReversal,
+			Principal: recovery.Principal.Neg(),
+			Charges:   recovery.Charges.Neg(),
+			Date:      date,
+		})
+		advance.Status = AdvanceApproved
+
+		err = putAdvanceRequest(ctx, advance)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// executeAdjustment writes the compensating entry of an adjustment and, for a
+// correction, the corrected payment, then updates the balances, year-to-date totals
+// and advances in a single journal entry
+func executeAdjustment(ctx contractapi.TransactionContextInterface, adjustment *PaymentAdjustment) error {
+	original, err := readPaymentAmounts(ctx, adjustment.PaymentID)
+	if err != nil {
+		return err
+	}
+	err = checkAdjustable(ctx, original)
+	if err != nil {
+		return err
+	}
+
+	contract, err := readContract(ctx, original.ContractID)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	correcting := adjustment.Type == CorrectionAdjustment
+
+	// the prefixes sort the reversal before the correction in the payment index, so
+	// the correction's payslip counts the reversal in its year-to-date totals
+	reversal := reversalOf(original, recordID(ctx, "CANCEL", original.ContractID, original.Employee), adjustment.ID, now, correcting)
+	legs, err := adjustmentLegs(original, !correcting, true)
+	if err != nil {
+		return err
+	}
+
+	ytd, err := getYearToDate(ctx, original.Employee, original.ContractID, now.Year(), original.Amount.Currency)
+	if err != nil {
+		return err
+	}
+	err = ytd.add(reversal)
+	if err != nil {
+		return err
+	}
+
+	walletChange, err := credited(original)
+	if err != nil {
+		return err
+	}
+	walletChange = walletChange.Neg()
+
+	var corrected *Payment
+	if correcting {
+		corrected = &Payment{
+			ID:           recordID(ctx, "CORRECT", original.ContractID, original.Employee),
+			ContractID:   original.ContractID,
+			Employee:     original.Employee,
+			Amount:       adjustment.Amount,
+			Date:         now,
+			Type:         CorrectionPayment,
+			PayrollRunID: original.PayrollRunID,
+			PeriodStart:  original.PeriodStart,
+			PeriodEnd:    original.PeriodEnd,
+			TermsVersion: original.TermsVersion,
+			Position:     original.Position,
+			Components:   []PayComponent{{Code: original.Type, Amount: adjustment.Amount}},
+			Recoveries:   original.Recoveries,
+			Recovered:    original.Recovered,
+			Adjusts:      original.ID,
+			AdjustmentID: adjustment.ID,
+		}
+
+		err = withholdOnTotals(ctx, contract, corrected, daysBetween(corrected.PeriodStart, corrected.PeriodEnd.AddDate(0, 0, 1)), ytd)
+		if err != nil {
+			return err
+		}
+		credit, err := credited(corrected)
+		if err != nil {
+			return err
+		}
+		if credit.IsNegative() {
+			return fmt.Errorf("corrected net pay %s does not cover the %s of advances recovered from payment %s", corrected.Net, corrected.Recovered, original.ID)
+		}
+		walletChange, err = walletChange.Add(credit)
+		if err != nil {
+			return err
+		}
+
+		correctionLegs, err := adjustmentLegs(corrected, false, false)
+		if err != nil {
+			return err
+		}
+		legs = append(legs, correctionLegs...)
+	} else {
+		err = reinstateAdvances(ctx, original, reversal.ID, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	// what the employee was credited must still be in the wallet to be taken back
+	if walletChange.IsNegative() {
+		available, err := availableBalance(ctx, contract, original.Employee)
+		if err != nil {
+			return err
+		}
+		exceeded, err := exceedsLimit(walletChange.Neg(), available)
+		if err != nil {
+			return err
+		}
+		if exceeded {
+			return fmt.Errorf("insufficient funds: %s of payment %s left the wallet", walletChange.Neg(), original.ID)
+		}
+	}
+
+	err = putPayment(ctx, reversal)
+	if err != nil {
+		return err
+	}
+	adjustment.ReversalID = reversal.ID
+	if corrected != nil {
+		err = putPayment(ctx, corrected)
+		if err != nil {
+			return err
+		}
+		err = anchorPayslip(ctx, contract, corrected, reversal)
+		if err != nil {
+			return err
+		}
+		adjustment.CorrectionID = corrected.ID
+	}
+	err = putYearToDate(ctx, ytd)
+	if err != nil {
+		return err
+	}
+	if len(legs) > 0 {
+		err = post(ctx, original.ContractID, original.Employee, adjustment.ID, legs...)
+		if err != nil {
+			return err
+		}
+	}
+
+	markerKey, err := ctx.GetStub().CreateCompositeKey(adjustedPaymentIndex, []string{original.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create adjusted payment key: %v", err)
+	}
+	err = ctx.GetStub().PutState(markerKey, []byte(adjustment.ID))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	adjustment.Status = AdjustmentExecuted
+	adjustment.ExecutedAt = now
+	return putPaymentAdjustment(ctx, adjustment)
+}
+
+// requestAdjustment records a pending reversal or correction of a payment requested
+// by the employer of its contract
+func requestAdjustment(ctx contractapi.TransactionContextInterface, transaction string, adjustmentID string, paymentID string, adjustmentType string, amount string, reasonCode string, note string) error {
+	caller, err := authorize(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	switch reasonCode {
+	case ReasonDuplicate, ReasonIncorrectAmount, ReasonWrongRecipient:
+	case ReasonOther:
+		if note == "" {
+			return fmt.Errorf("a note is required for reason code %s", ReasonOther)
+		}
+	default:
+		return fmt.Errorf("unknown reason code %q", reasonCode)
+	}
+
+	existing, err := ctx.GetStub().GetState(adjustmentID)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("the payment adjustment %s already exists", adjustmentID)
+	}
+
+	payment, err := readPaymentAmounts(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+	contract, err := readContract(ctx, payment.ContractID)
+	if err != nil {
+		return err
+	}
+	if !caller.isEmployerOf(contract) {
+		return fmt.Errorf("only the employer %s can adjust payments of contract %s", contract.Employer, contract.ID)
+	}
+	err = checkAdjustable(ctx, payment)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	adjustment := PaymentAdjustment{
+		ID:                adjustmentID,
+		Type:              adjustmentType,
+		PaymentID:         paymentID,
+		ContractID:        payment.ContractID,
+		Employee:          payment.Employee,
+		ReasonCode:        reasonCode,
+		Note:              note,
+		Status:            AdjustmentPending,
+		RequestedBy:       caller.Name,
+		RequesterIdentity: caller.party(),
+		RequestedAt:       now,
+	}
+	if adjustmentType == CorrectionAdjustment {
+		adjustment.Amount, err = parseAmount(amount, payment.Amount.Currency)
+		if err != nil {
+			return err
+		}
+	}
+
+	return putPaymentAdjustment(ctx, &adjustment)
+}
+
+// ReversePayment requests the reversal of a regular payment with a reason code. The
+// payment is never changed: once approved a compensating entry is written
+func (s *PaymentContract) ReversePayment(ctx contractapi.TransactionContextInterface, adjustmentID string, paymentID string, reasonCode string, note string) error {
+	return requestAdjustment(ctx, "ReversePayment", adjustmentID, paymentID, ReversalAdjustment, "", reasonCode, note)
+}
+
+// CorrectPayment requests the correction of a regular payment to another gross
+// amount with a reason code. Once approved the payment is reversed and a corrected
+// payment for the same pay period is made
+func (s *PaymentContract) CorrectPayment(ctx contractapi.TransactionContextInterface, adjustmentID string, paymentID string, amount string, reasonCode string, note string) error {
+	return requestAdjustment(ctx, "CorrectPayment", adjustmentID, paymentID, CorrectionAdjustment, amount, reasonCode, note)
+}
+
+// ApprovePaymentAdjustment adds the caller's signature to a pending reversal or
+// correction under the employer's Adjustment approval policy and executes it once
+// the policy is satisfied. Employers without such a policy cannot adjust payments. A
+// retry with the same idempotency key and parameters neither signs nor executes again
+func (s *PaymentContract) ApprovePaymentAdjustment(ctx contractapi.TransactionContextInterface, idempotencyKey string, adjustmentID string) error {
+	caller, err := authorize(ctx, "ApprovePaymentAdjustment")
+	if err != nil {
+		return err
+	}
+
+	claim, replayed, err := claimIdempotencyKey(ctx, caller, "ApprovePaymentAdjustment", idempotencyKey, "", adjustmentID)
+	if err != nil {
+		return err
+	}
+	if replayed {
+		return nil
+	}
+
+	adjustment, err := readPaymentAdjustment(ctx, adjustmentID)
+	if err != nil {
+		return err
+	}
+	if adjustment.Status != AdjustmentPending {
+		return fmt.Errorf("payment adjustment %s is %s", adjustmentID, adjustment.Status)
+	}
+
+	contract, err := readContract(ctx, adjustment.ContractID)
+	if err != nil {
+		return err
+	}
+	policy, err := currentApprovalPolicy(ctx, contract.Employer, AdjustmentApproval)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return fmt.Errorf("employer %s has no approval policy for payment adjustments", contract.Employer)
+	}
+
+	original, err := readPaymentAmounts(ctx, adjustment.PaymentID)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	// adjustments below every threshold of the policy are approved by another member
+	// of the employer's org than the one that requested them
+	employerApproves := func() error {
+		if !caller.belongsToEmployerOf(contract) {
+			return fmt.Errorf("only members of employer %s can approve adjustments of payment %s", contract.Employer, adjustment.PaymentID)
+		}
+		return nil
+	}
+
+	terms, err := approvalTerms(adjustment.salt, fmt.Sprintf("%s of %s to %s", adjustment.Type, adjustment.PaymentID, adjustment.Amount))
+	if err != nil {
+		return err
+	}
+	// a correction is approved at the level of whichever of the original and corrected
+	// amounts is larger, so raising a payment cannot slip under the level it ends up in
+	amount := original.Amount
+	if adjustment.Type == CorrectionAdjustment {
+		larger, err := adjustment.Amount.Cmp(amount)
+		if err != nil {
+			return err
+		}
+		if larger > 0 {
+			amount = adjustment.Amount
+		}
+	}
+	satisfied, err := approve(policy, &adjustment.Approvals, caller, contract, adjustment.RequesterIdentity, amount, terms, now, employerApproves)
+	if err != nil {
+		return err
+	}
+	if !satisfied {
+		err = putPaymentAdjustment(ctx, adjustment)
+		if err != nil {
+			return err
+		}
+		return completeIdempotencyKey(ctx, claim, adjustmentID)
+	}
+
+	err = executeAdjustment(ctx, adjustment)
+	if err != nil {
+		return err
+	}
+
+	return completeIdempotencyKey(ctx, claim, adjustmentID)
+}
+
+// GetPaymentAdjustment returns a reversal or correction request with its approvals
+func (s *PaymentContract) GetPaymentAdjustment(ctx contractapi.TransactionContextInterface, adjustmentID string) (*PaymentAdjustment, error) {
+	adjustment, err := getPaymentAdjustment(ctx, adjustmentID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = authorizeRead(ctx, "GetPaymentAdjustment", adjustment.ContractID)
+	if err != nil {
+		return nil, err
+	}
+
+	return adjustment, nil
+}
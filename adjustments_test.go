@@ -0,0 +1,65 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Only a payment whose own funds were withdrawn counts as settled, not every payment
+// made before a withdrawal
+func TestAdjustableUntilItsFundsAreWithdrawn(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	march, err := s.ProcessPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", RegularPayment)
+	l.must(err)
+	l.clock = time.Date(2024, 4, 2, 9, 0, 0, 0, time.UTC)
+	april, err := s.ProcessPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", RegularPayment)
+	l.must(err)
+
+	// the withdrawal draws on the funds of March, which were credited first
+	withdrawal, err := s.WithdrawPayment(l.begin(p.employee, nil), "", "C1", p.employee.name, "10.00")
+	l.must(err)
+
+	err = s.ReversePayment(l.begin(p.employer, nil), "ADJ1", march, ReasonDuplicate, "")
+	if err == nil || !strings.Contains(err.Error(), withdrawal) {
+		t.Errorf("March was reversed after its funds were withdrawn: %v", err)
+	}
+	l.must(s.ReversePayment(l.begin(p.employer, nil), "ADJ2", april, ReasonDuplicate, ""))
+}
+
+// A correction is approved at the level of the corrected amount when it raises the
+// payment above a threshold the original amount was below
+func TestCorrectionIsApprovedAtTheLevelOfTheLargerAmount(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	threshold, err := NewMoney(200000, "EUR")
+	l.must(err)
+	policy := ApprovalPolicy{Kind: AdjustmentApproval, Levels: []ApprovalLevel{{Name: "Finance", Approvers: []string{"EmployerMSP/cfo"}, Required: 1, MinAmount: threshold}}}
+	_, err = s.SetApprovalPolicy(l.begin(p.employer, nil), p.employer.name, policy)
+	l.must(err)
+
+	paymentID, err := s.ProcessPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", RegularPayment)
+	l.must(err)
+	l.must(s.CorrectPayment(l.begin(p.employer, nil), "ADJ1", paymentID, "3000.00", ReasonIncorrectAmount, ""))
+
+	colleague := newTestClient(t, "bob", "EmployerMSP", RoleEmployer)
+	if err := s.ApprovePaymentAdjustment(l.begin(colleague, nil), "", "ADJ1"); err == nil {
+		t.Error("a correction above the Finance threshold was approved below that level")
+	}
+	cfo := newTestClient(t, "cfo", "EmployerMSP", RoleEmployer)
+	l.must(s.ApprovePaymentAdjustment(l.begin(cfo, nil), "", "ADJ1"))
+	adjustment, err := s.GetPaymentAdjustment(l.begin(p.employer, nil), "ADJ1")
+	l.must(err)
+	if adjustment.Status != AdjustmentExecuted || len(adjustment.Approvals) != 1 || adjustment.Approvals[0].Level != "Finance" {
+		t.Errorf("the correction was approved with %+v", adjustment)
+	}
+}
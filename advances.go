@@ -0,0 +1,572 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key indexing advance requests by contract
+const contractAdvanceIndex = "ContractAdvance"
+
+// Constants for advance request statuses. A Pending request is Approved, Rejected by
+// the employer or Cancelled by the employee. An Approved advance is being repaid until
+// it is Repaid, or WrittenOff when its contract is terminated first
+const (
+	AdvancePending    = "Pending"
+	AdvanceApproved   = "Approved"
+	AdvanceRejected   = "Rejected"
+	AdvanceCancelled  = "Cancelled"
+	AdvanceRepaid     = "Repaid"
+	AdvanceWrittenOff = "WrittenOff"
+)
+
+// Constants for how an advance was repaid
+const (
+	PayrollRepayment  = "Payroll"  // installment kept from a regular payment
+	EarlyRepayment    = "Early"    // paid by the employee from their wallet
+	WriteOff          = "WriteOff" // outstanding balance forgiven on termination
+	RepaymentReversal = "Reversal" // installment handed back when its payment was reversed
+)
+
+// one repayment of an advance
+type AdvanceRepayment struct {
+	Reference string    `json:"Reference"` // ID of the payment or repayment record
+	Type      string    `json:"Type"`      // Payroll, Early or WriteOff
+	Principal Money     `json:"Principal"` // Part of the advanced amount repaid
+	Charges   Money     `json:"Charges"`   // Part of the fee and interest repaid
+	Date      time.Time `json:"Date"`      // Transaction timestamp of the repayment
+}
+
+// an advance installment kept from a regular payment
+type AdvanceRecovery struct {
+	RequestID string `json:"RequestID"` // ID of the advance request
+	Principal Money  `json:"Principal"` // Part of the advanced amount recovered
+	Charges   Money  `json:"Charges"`   // Part of the fee and interest recovered
+	Amount    Money  `json:"Amount"`    // Total recovered
+}
+
+// putContractAdvanceIndex writes the index entry listing an advance request under its contract
+func putContractAdvanceIndex(ctx contractapi.TransactionContextInterface, request *AdvanceRequest) error {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(contractAdvanceIndex, []string{request.ContractID, request.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create advance index key: %v", err)
+	}
+
+	// an empty value would delete the key, so store a single null byte
+	err = ctx.GetStub().PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// readAdvanceRequest reads an advance request from the world state together with its
+// private amounts
+func readAdvanceRequest(ctx contractapi.TransactionContextInterface, requestID string) (*AdvanceRequest, error) {
+	return getAdvanceRequest(ctx, requestID, false)
+}
+
+// getAdvanceRequest reads an advance request from the world state. When visibleOnly is
+// set, as in read transactions, its amounts are filled in only if the client may see them
+func getAdvanceRequest(ctx contractapi.TransactionContextInterface, requestID string, visibleOnly bool) (*AdvanceRequest, error) {
+	requestJSON, err := ctx.GetStub().GetState(requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if requestJSON == nil {
+		return nil, fmt.Errorf("the advance request %s does not exist", requestID)
+	}
+
+	var request AdvanceRequest
+	err = json.Unmarshal(requestJSON, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadAdvanceAmounts(ctx, &request, visibleOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// putAdvanceRequest writes an advance request to the world state and its amounts to
+// the contract collection
+func putAdvanceRequest(ctx contractapi.TransactionContextInterface, request *AdvanceRequest) error {
+	public, err := putAdvanceAmounts(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	requestJSON, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(request.ID, requestJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// contractAdvances returns every advance request of a contract in order of request ID.
+// Requests made before the index existed are not listed. When visibleOnly is set, as
+// in read transactions, their amounts are filled in only if the client may see them
+func contractAdvances(ctx contractapi.TransactionContextInterface, contractID string, visibleOnly bool) ([]*AdvanceRequest, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(contractAdvanceIndex, []string{contractID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var requests []*AdvanceRequest
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		request, err := getAdvanceRequest(ctx, keyParts[1], visibleOnly)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+
+	return requests, nil
+}
+
+// checkAdvanceLimits rejects a new advance request when the contract already had the
+// policy's number of requests this pay period, or when the amount together with the
+// requests still pending and the balances still owed would exceed limit. Advances
+// pending or owed in another currency, left from before a currency change, cannot be
+// counted against the limit, so no new advance is made until they are settled
+func checkAdvanceLimits(ctx contractapi.TransactionContextInterface, contract *Contract, policy PolicyConfig, amount Money, limit Money, now time.Time) error {
+	advances, err := contractAdvances(ctx, contract.ID, false)
+	if err != nil {
+		return err
+	}
+
+	periodStart, periodEnd := payPeriod(contract.termsAt(now).withDefaults().PayFrequency, now)
+	requested := 0
+	exposure := amount
+	for _, advance := range advances {
+		if !advance.RequestedAt.Before(periodStart) && advance.RequestedAt.Before(periodEnd) {
+			requested++
+		}
+
+		owed := Money{}
+		switch advance.Status {
+		case AdvancePending:
+			owed = advance.Amount
+		case AdvanceApproved:
+			owed = advance.Outstanding
+		}
+		if !owed.IsPositive() {
+			continue
+		}
+		if owed.Currency != exposure.Currency {
+			return fmt.Errorf("advance %s of %s is still pending or outstanding, so no advance can be requested in %s", advance.ID, owed, exposure.Currency)
+		}
+		exposure, err = exposure.Add(owed)
+		if err != nil {
+			return err
+		}
+	}
+
+	if requested >= policy.AdvanceRequestsPerPeriod {
+		return fmt.Errorf("at most %d advances can be requested per pay period", policy.AdvanceRequestsPerPeriod)
+	}
+	exceeded, err := exceedsLimit(exposure, limit)
+	if err != nil {
+		return err
+	}
+	if exceeded {
+		return fmt.Errorf("advances of %s pending or outstanding would exceed the limit of %s", exposure, limit)
+	}
+
+	return nil
+}
+
+// setRepaymentPlan fixes the charges and installments of an advance being approved.
+// Interest accrues on the amount for the pay periods of the installments
+func setRepaymentPlan(ctx contractapi.TransactionContextInterface, request *AdvanceRequest, contract *Contract, fee string, interestRate int64) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	currency := request.Amount.Currency
+	request.Fee, err = ZeroMoney(currency)
+	if err != nil {
+		return err
+	}
+	if fee != "" {
+		request.Fee, err = ParseMoney(fee, currency, RoundExact)
+		if err != nil {
+			return err
+		}
+		if request.Fee.IsNegative() {
+			return fmt.Errorf("advance fee %s cannot be negative", request.Fee)
+		}
+	}
+	if interestRate < 0 || interestRate > basisPoints {
+		return fmt.Errorf("interest rate of %d basis points is out of range", interestRate)
+	}
+
+	// advances requested before repayment plans are repaid in one installment
+	if request.Installments < 1 {
+		request.Installments = 1
+	}
+
+	frequency := contract.termsAt(now).withDefaults().PayFrequency
+	interest, err := request.Amount.MulRat(interestRate*int64(request.Installments), basisPoints*periodsPerYear[frequency], RoundHalfEven)
+	if err != nil {
+		return err
+	}
+
+	request.InterestRate = interestRate
+	request.Charges, err = request.Fee.Add(interest)
+	if err != nil {
+		return err
+	}
+	request.ChargesOutstanding = request.Charges
+	request.Outstanding, err = request.Amount.Add(request.Charges)
+	if err != nil {
+		return err
+	}
+	request.Installment, err = request.Outstanding.MulRat(1, int64(request.Installments), RoundUp)
+	if err != nil {
+		return err
+	}
+	request.ApprovedAt = now
+
+	return nil
+}
+
+// repay applies a repayment to the outstanding balance of an advance, settling the
+// charges first, and returns the parts of the principal and charges repaid
+func (a *AdvanceRequest) repay(amount Money, reference string, repaymentType string, date time.Time) (Money, Money, error) {
+	exceeded, err := exceedsLimit(amount, a.Outstanding)
+	if err != nil {
+		return Money{}, Money{}, err
+	}
+	if exceeded {
+		return Money{}, Money{}, fmt.Errorf("repayment %s exceeds the %s outstanding on advance %s", amount, a.Outstanding, a.ID)
+	}
+
+	charges := amount
+	exceeded, err = exceedsLimit(charges, a.ChargesOutstanding)
+	if err != nil {
+		return Money{}, Money{}, err
+	}
+	if exceeded {
+		charges = a.ChargesOutstanding
+	}
+	principal, err := amount.Sub(charges)
+	if err != nil {
+		return Money{}, Money{}, err
+	}
+
+	a.ChargesOutstanding, err = a.ChargesOutstanding.Sub(charges)
+	if err != nil {
+		return Money{}, Money{}, err
+	}
+	a.Outstanding, err = a.Outstanding.Sub(amount)
+	if err != nil {
+		return Money{}, Money{}, err
+	}
+	a.Repayments = append(a.Repayments, AdvanceRepayment{
+		Reference: reference,
+		Type:      repaymentType,
+		Principal: principal,
+		Charges:   charges,
+		Date:      date,
+	})
+	if a.Outstanding.IsZero() {
+		a.Status = AdvanceRepaid
+	}
+
+	return principal, charges, nil
+}
+
+// repaymentLegs returns the postings of an advance repayment paid out of debitAccount.
+// The principal settles AdvanceReceivable and the charges are earned as AdvanceFeeIncome
+func repaymentLegs(debitAccount string, principal Money, charges Money) []postingLeg {
+	var legs []postingLeg
+	if principal.IsPositive() {
+		legs = append(legs, postingLeg{debitAccount, AdvanceReceivable, principal})
+	}
+	if charges.IsPositive() {
+		legs = append(legs, postingLeg{debitAccount, AdvanceFeeIncome, charges})
+	}
+	return legs
+}
+
+// recoverAdvances keeps the installments of the advances being repaid on the
+// payment's contract from its net pay, oldest request ID first. When the net pay
+// does not cover every installment the rest stays outstanding for the next payment
+func recoverAdvances(ctx contractapi.TransactionContextInterface, payment *Payment) error {
+	advances, err := contractAdvances(ctx, payment.ContractID, false)
+	if err != nil {
+		return err
+	}
+
+	available := payment.Net
+	recovered, err := ZeroMoney(payment.Net.Currency)
+	if err != nil {
+		return err
+	}
+	for _, advance := range advances {
+		if advance.Status != AdvanceApproved || !advance.Outstanding.IsPositive() || advance.Outstanding.Currency != available.Currency {
+			continue
+		}
+		if !available.IsPositive() {
+			break
+		}
+
+		due := advance.Installment
+		for _, limit := range []Money{advance.Outstanding, available} {
+			exceeded, err := exceedsLimit(due, limit)
+			if err != nil {
+				return err
+			}
+			if exceeded {
+				due = limit
+			}
+		}
+
+		principal, charges, err := advance.repay(due, payment.ID, PayrollRepayment, payment.Date)
+		if err != nil {
+			return err
+		}
+		err = putAdvanceRequest(ctx, advance)
+		if err != nil {
+			return err
+		}
+
+		payment.Recoveries = append(payment.Recoveries, AdvanceRecovery{
+			RequestID: advance.ID,
+			Principal: principal,
+			Charges:   charges,
+			Amount:    due,
+		})
+		available, err = available.Sub(due)
+		if err != nil {
+			return err
+		}
+		recovered, err = recovered.Add(due)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(payment.Recoveries) > 0 {
+		payment.Recovered = recovered
+	}
+
+	return nil
+}
+
+// writeOffAdvances forgives the outstanding balance of every advance still being
+// repaid on a terminated or revoked contract. The unpaid principal is moved from
+// AdvanceReceivable to AdvanceWriteOff, unpaid charges are never earned
+func writeOffAdvances(ctx contractapi.TransactionContextInterface, contract *Contract) error {
+	advances, err := contractAdvances(ctx, contract.ID, false)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	reference := recordID(ctx, "WRITEOFF", contract.ID)
+	var legs []postingLeg
+	for _, advance := range advances {
+		if advance.Status != AdvanceApproved || !advance.Outstanding.IsPositive() {
+			continue
+		}
+
+		principal, _, err := advance.repay(advance.Outstanding, reference, WriteOff, now)
+		if err != nil {
+			return err
+		}
+		advance.Status = AdvanceWrittenOff
+		err = putAdvanceRequest(ctx, advance)
+		if err != nil {
+			return err
+		}
+
+		if principal.IsPositive() {
+			legs = append(legs, postingLeg{AdvanceWriteOff, AdvanceReceivable, principal})
+		}
+	}
+	if len(legs) == 0 {
+		return nil
+	}
+
+	return post(ctx, contract.ID, contract.Employee, reference, legs...)
+}
+
+// RepayAdvance repays part or all of the outstanding balance of an advance early
+// from the employee's wallet. A retry with the same idempotency key and parameters
+// does not repay again
+func (s *PaymentContract) RepayAdvance(ctx contractapi.TransactionContextInterface, idempotencyKey string, requestID string, amount string) error {
+	caller, err := authorize(ctx, "RepayAdvance")
+	if err != nil {
+		return err
+	}
+
+	request, err := readAdvanceRequest(ctx, requestID)
+	if err != nil {
+		return err
+	}
+
+	claim, replayed, err := claimIdempotencyKey(ctx, caller, "RepayAdvance", idempotencyKey, request.ContractID, requestID, amount)
+	if err != nil {
+		return err
+	}
+	if replayed {
+		return nil
+	}
+	contract, err := readContract(ctx, request.ContractID)
+	if err != nil {
+		return err
+	}
+	if request.Employee != contract.Employee || !caller.isEmployeeOf(contract) {
+		return fmt.Errorf("%s cannot repay the advance of %s", caller.Name, request.Employee)
+	}
+	if request.Status != AdvanceApproved || !request.Outstanding.IsPositive() {
+		return fmt.Errorf("advance %s is %s and has nothing outstanding", requestID, request.Status)
+	}
+
+	repayment, err := parseAmount(amount, request.Outstanding.Currency)
+	if err != nil {
+		return err
+	}
+
+	available, err := availableBalance(ctx, contract, request.Employee)
+	if err != nil {
+		return err
+	}
+	exceeded, err := exceedsLimit(repayment, available)
+	if err != nil {
+		return err
+	}
+	if exceeded {
+		return fmt.Errorf("insufficient funds: %s available", available)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	reference := recordID(ctx, "REPAY", requestID)
+	principal, charges, err := request.repay(repayment, reference, EarlyRepayment, now)
+	if err != nil {
+		return err
+	}
+	err = putAdvanceRequest(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	err = post(ctx, request.ContractID, request.Employee, reference, repaymentLegs(EmployeeWallet, principal, charges)...)
+	if err != nil {
+		return err
+	}
+
+	return completeIdempotencyKey(ctx, claim, reference)
+}
+
+// closeAdvanceRequest moves a Pending advance request to Rejected or Cancelled
+func closeAdvanceRequest(ctx contractapi.TransactionContextInterface, request *AdvanceRequest, status string, reason string, closedBy string) error {
+	if request.Status != AdvancePending {
+		return fmt.Errorf("advance request %s is %s, only %s requests can be %s", request.ID, request.Status, AdvancePending, status)
+	}
+	if reason == "" {
+		return fmt.Errorf("a reason is required to move advance request %s to %s", request.ID, status)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	request.Status = status
+	request.Reason = reason
+	request.ClosedBy = closedBy
+	request.ClosedAt = now
+
+	return putAdvanceRequest(ctx, request)
+}
+
+// RejectAdvanceRequest lets the contract's employer turn down a Pending advance request
+func (s *PaymentContract) RejectAdvanceRequest(ctx contractapi.TransactionContextInterface, requestID string, reason string) error {
+	caller, err := authorize(ctx, "RejectAdvanceRequest")
+	if err != nil {
+		return err
+	}
+
+	request, err := readAdvanceRequest(ctx, requestID)
+	if err != nil {
+		return err
+	}
+	contract, err := readContract(ctx, request.ContractID)
+	if err != nil {
+		return err
+	}
+	if !caller.isEmployerOf(contract) {
+		return fmt.Errorf("only the employer %s can reject advances on contract %s", contract.Employer, contract.ID)
+	}
+
+	return closeAdvanceRequest(ctx, request, AdvanceRejected, reason, caller.Name)
+}
+
+// CancelAdvanceRequest lets the employee withdraw their own Pending advance request
+func (s *PaymentContract) CancelAdvanceRequest(ctx contractapi.TransactionContextInterface, requestID string, reason string) error {
+	caller, err := authorize(ctx, "CancelAdvanceRequest")
+	if err != nil {
+		return err
+	}
+
+	request, err := readAdvanceRequest(ctx, requestID)
+	if err != nil {
+		return err
+	}
+	contract, err := readContract(ctx, request.ContractID)
+	if err != nil {
+		return err
+	}
+	if request.Employee != contract.Employee || !caller.isEmployeeOf(contract) {
+		return fmt.Errorf("%s cannot cancel the advance request of %s", caller.Name, request.Employee)
+	}
+
+	return closeAdvanceRequest(ctx, request, AdvanceCancelled, reason, caller.Name)
+}
+
+// GetAdvanceRequests returns the advance requests of a contract with their repayment
+// plans and outstanding balances
+func (s *PaymentContract) GetAdvanceRequests(ctx contractapi.TransactionContextInterface, contractID string) ([]*AdvanceRequest, error) {
+	err := authorizeRead(ctx, "GetAdvanceRequests", contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	return contractAdvances(ctx, contractID, true)
+}
@@ -0,0 +1,59 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The advance limit caps the advances pending and still owed on the contract, and
+// only so many advances are requested per pay period
+func TestAdvanceLimitsCountPendingAndOutstanding(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	// twice the monthly pay of 5000.00
+	l.must(s.AdvanceRequest(l.begin(p.employee, nil), "ADV1", "C1", p.employee.name, "6000.00", 2))
+	l.must(s.ApproveAdvanceRequest(l.begin(p.employer, nil), "", "ADV1", "0", 0))
+	if err := s.AdvanceRequest(l.begin(p.employee, nil), "ADV2", "C1", p.employee.name, "5000.00", 2); err == nil {
+		t.Error("an advance was requested above the limit left by the outstanding one")
+	}
+	l.must(s.AdvanceRequest(l.begin(p.employee, nil), "ADV2", "C1", p.employee.name, "3000.00", 2))
+
+	err := s.AdvanceRequest(l.begin(p.employee, nil), "ADV3", "C1", p.employee.name, "10.00", 2)
+	if err == nil || !strings.HasPrefix(err.Error(), "at most 2 advances") {
+		t.Errorf("a third advance was requested in the pay period: %v", err)
+	}
+}
+
+// Advances left pending in the currency of earlier terms cannot be counted against
+// the limit in the new currency, so they block new requests until settled
+func TestNoAdvanceWhileAnotherCurrencyIsOwed(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	l.must(s.AdvanceRequest(l.begin(p.employee, nil), "ADV1", "C1", p.employee.name, "100.00", 2))
+
+	account := map[string]interface{}{transientAccountKey: transientAccount{BankAccount: "DE02120300000000202051", Salt: "account-salt-0123456789"}}
+	l.must(s.CreateAccount(l.begin(p.employee, account), "ACC2", "Acme", "", "USD"))
+	pay := map[string]interface{}{transientPayKey: transientPay{Salary: "65000.00", VariablePay: "0", Salt: "amendment-salt-0123456789"}}
+	hash, err := s.HashContractTerms(l.begin(p.employer, pay), "C1", p.employer.name, p.employee.name, "Engineer", "USD", "ACC2", PaySchedule{})
+	l.must(err)
+	l.must(s.AmendContract(l.begin(p.employer, pay), "C1", "Engineer", "USD", "ACC2", PaySchedule{}, "2024-04-01", "Relocation", p.employer.sign(t, hash)))
+	l.must(s.AcceptAmendment(l.begin(p.employee, nil), "C1", 2, p.employee.sign(t, hash)))
+
+	l.clock = time.Date(2024, 4, 2, 9, 0, 0, 0, time.UTC)
+	err = s.AdvanceRequest(l.begin(p.employee, nil), "ADV2", "C1", p.employee.name, "100.00", 2)
+	if err == nil || !strings.Contains(err.Error(), "ADV1") {
+		t.Errorf("an advance in USD was requested while one in EUR is pending: %v", err)
+	}
+
+	l.must(s.CancelAdvanceRequest(l.begin(p.employee, nil), "ADV1", "Relocated"))
+	l.must(s.AdvanceRequest(l.begin(p.employee, nil), "ADV2", "C1", p.employee.name, "100.00", 2))
+}
@@ -0,0 +1,331 @@
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// a version of the terms of a contract and the date it takes effect
+type TermsVersion struct {
+	Version int `json:"Version"` // Version number, starting at 1 for the signed terms
+	ContractTerms
+	EffectiveFrom time.Time `json:"EffectiveFrom"` // First day the terms apply
+	Reason        string    `json:"Reason"`        // Why the terms were amended
+	AmendedBy     string    `json:"AmendedBy"`     // Name of the party that recorded the version
+	RecordedAt    time.Time `json:"RecordedAt"`    // Transaction timestamp of the version
+	TermsHash     string    `json:"TermsHash"`     // Hash of the terms both parties signed
+	Pending       bool      `json:"Pending"`       // Set until the employee accepts the amendment
+}
+
+// startOfDay truncates a time to midnight UTC
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// monthOf returns the calendar month containing t as a [start, end) interval
+func monthOf(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// daysBetween returns the number of whole days from start to end
+func daysBetween(start time.Time, end time.Time) int64 {
+	return int64(startOfDay(end).Sub(startOfDay(start)).Hours() / 24)
+}
+
+// agreedVersions returns the versions of the terms both parties signed, leaving out
+// an amendment still awaiting the employee's acceptance
+func (c *Contract) agreedVersions() []TermsVersion {
+	versions := c.TermsVersions
+	if n := len(versions); n > 0 && versions[n-1].Pending {
+		versions = versions[:n-1]
+	}
+	return versions
+}
+
+// versionAt returns the agreed version of the terms effective on the given date, the
+// first version for earlier dates, or nil for contracts recorded before terms were versioned
+func (c *Contract) versionAt(date time.Time) *TermsVersion {
+	versions := c.agreedVersions()
+	if len(versions) == 0 {
+		return nil
+	}
+
+	version := &versions[0]
+	for i := range versions {
+		if versions[i].EffectiveFrom.After(date) {
+			break
+		}
+		version = &versions[i]
+	}
+	return version
+}
+
+// termsAt returns the terms of a contract effective on the given date. Dates before
+// the first version fall back to the first version, and contracts recorded before
+// terms were versioned always use their current terms
+func (c *Contract) termsAt(date time.Time) ContractTerms {
+	version := c.versionAt(date)
+	if version == nil {
+		return c.ContractTerms
+	}
+	return version.ContractTerms
+}
+
+// snapshotTerms records on a regular payment the terms version in effect at the start
+// of its pay period and the position it pays, so its payslip is built from the terms
+// it was paid on whatever becomes of the contract later
+func (p *Payment) snapshotTerms(contract *Contract) {
+	p.Position = contract.termsAt(p.PeriodStart).Position
+	if version := contract.versionAt(p.PeriodStart); version != nil {
+		p.TermsVersion = version.Version
+	}
+}
+
+// advanceTerms makes the version in effect on date the current terms of the contract
+// and returns the account the contract paid into before. An amendment takes effect
+// on the first write of the contract on or after its effective date
+func (c *Contract) advanceTerms(date time.Time) string {
+	previousAccount := c.AccountID
+	version := c.versionAt(date)
+	if version == nil || version.Version == c.TermsVersion {
+		return previousAccount
+	}
+
+	c.ContractTerms = version.ContractTerms
+	c.TermsVersion = version.Version
+	return previousAccount
+}
+
+// checkAmendmentDate rejects terms taking effect on or before the last day of a pay
+// period already paid, which was paid on the terms in force at the time
+func checkAmendmentDate(ctx contractapi.TransactionContextInterface, contract *Contract, effective time.Time) error {
+	entries, err := getPaymentIndexEntries(ctx, contract.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Type != RegularPayment && entry.Type != CorrectionPayment {
+			continue
+		}
+		payment, err := readPayment(ctx, entry.PaymentID)
+		if err != nil {
+			return err
+		}
+		if !effective.After(payment.PeriodEnd) {
+			return fmt.Errorf("terms cannot take effect on %s, the pay period %s to %s was already paid by %s", effective.Format(dateLayout), payment.PeriodStart.Format(dateLayout), payment.PeriodEnd.Format(dateLayout), payment.ID)
+		}
+	}
+
+	return nil
+}
+
+// currentTerms returns the terms of a contract effective at the transaction timestamp
+func currentTerms(ctx contractapi.TransactionContextInterface, contract *Contract) (ContractTerms, error) {
+	now, err := txTime(ctx)
+	if err != nil {
+		return ContractTerms{}, err
+	}
+
+	return contract.termsAt(now), nil
+}
+
+// AmendContract proposes a new version of the terms of a contract taking effect on
+// effectiveFrom ("YYYY-MM-DD"), which must fall after every pay period already paid.
+// Signature is the employer's base64 signature over the hash returned by
+// HashContractTerms for the new terms. The version only applies once the employee
+// accepts it with AcceptAmendment, and a new amendment replaces one still awaiting
+// acceptance. Earlier versions are kept so that past periods are still paid on the
+// terms that applied to them. Salary, variable pay and salt are read from the transient map
+func (s *PaymentContract) AmendContract(ctx contractapi.TransactionContextInterface, contractID string, position string, currency string, account string, schedule PaySchedule, effectiveFrom string, reason string, signature string) error {
+	caller, err := authorize(ctx, "AmendContract")
+	if err != nil {
+		return err
+	}
+
+	contract, err := readContractPay(ctx, contractID)
+	if err != nil {
+		return err
+	}
+	if !caller.isEmployerOf(contract) {
+		return fmt.Errorf("only the employer %s can amend contract %s", contract.Employer, contractID)
+	}
+	if contract.Status != StatusActive && contract.Status != StatusSuspended && contract.Status != StatusNoticePeriod {
+		return fmt.Errorf("contract %s is %s and cannot be amended", contractID, contract.Status)
+	}
+	if reason == "" {
+		return fmt.Errorf("a reason is required to amend contract %s", contractID)
+	}
+
+	pay, err := readTransientPay(ctx)
+	if err != nil {
+		return err
+	}
+
+	terms, err := newContractTerms(position, pay.Salary, pay.VariablePay, currency, account, schedule)
+	if err != nil {
+		return err
+	}
+
+	policy, err := effectivePolicy(ctx, contract.Employer, contract.ID)
+	if err != nil {
+		return err
+	}
+	err = policy.requireCurrency(terms.Currency)
+	if err != nil {
+		return err
+	}
+
+	err = validateEmployeeAccount(ctx, account, contract)
+	if err != nil {
+		return err
+	}
+
+	effective, err := parseDate(effectiveFrom)
+	if err != nil {
+		return err
+	}
+	effective = startOfDay(effective)
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(contract.TermsVersions) == 0 {
+		// contract recorded before terms were versioned
+		contract.TermsVersions = []TermsVersion{{Version: 1, ContractTerms: contract.ContractTerms, Reason: "Signed terms", RecordedAt: now, TermsHash: contract.TermsHash}}
+	}
+	contract.TermsVersions = contract.agreedVersions()
+	latest := contract.TermsVersions[len(contract.TermsVersions)-1]
+	if !effective.After(latest.EffectiveFrom) {
+		return fmt.Errorf("amendment must take effect after version %d, effective %s", latest.Version, latest.EffectiveFrom.Format(dateLayout))
+	}
+	err = checkAmendmentDate(ctx, contract, effective)
+	if err != nil {
+		return err
+	}
+
+	// periods of the old frequency must not be cut short by a new one
+	if terms.PayFrequency != latest.withDefaults().PayFrequency {
+		periodStart, _ := payPeriod(latest.withDefaults().PayFrequency, effective)
+		if !periodStart.Equal(effective) {
+			return fmt.Errorf("a pay frequency change must take effect at the start of a %s pay period", latest.withDefaults().PayFrequency)
+		}
+	}
+
+	// balances are kept per currency, so a currency change must start a fresh month
+	// and may not leave funds behind in the old currency
+	if terms.Currency != latest.Currency {
+		if effective.Day() != 1 {
+			return fmt.Errorf("a currency change must take effect on the first day of a month")
+		}
+		for _, account := range []string{EmployeeWallet, AdvanceReceivable} {
+			ledgerAccount, err := getLedgerAccount(ctx, contract.ID, contract.Employee, account, latest.Currency, false)
+			if err != nil {
+				return err
+			}
+			if ledgerAccount.Debits != ledgerAccount.Credits {
+				return fmt.Errorf("cannot change currency while the %s balance is not settled", account)
+			}
+		}
+	}
+
+	termsHash, err := hashTerms(contract.ID, contract.Employer, contract.Employee, terms, pay.Salt)
+	if err != nil {
+		return err
+	}
+	err = signTerms(ctx, caller, contract.ID, termsHash, PartyEmployer, signature)
+	if err != nil {
+		return err
+	}
+
+	contract.TermsVersions = append(contract.TermsVersions, TermsVersion{
+		Version:       latest.Version + 1,
+		ContractTerms: terms,
+		EffectiveFrom: effective,
+		Reason:        reason,
+		AmendedBy:     caller.Name,
+		RecordedAt:    now,
+		TermsHash:     termsHash,
+		Pending:       true,
+	})
+
+	err = putContractPay(ctx, contract, pay.Salt)
+	if err != nil {
+		return err
+	}
+
+	return putContract(ctx, contract)
+}
+
+// AcceptAmendment records the employee's signature over the terms of the amendment
+// awaiting acceptance, which then applies from its effective date
+func (s *PaymentContract) AcceptAmendment(ctx contractapi.TransactionContextInterface, contractID string, version int, signature string) error {
+	caller, err := authorize(ctx, "AcceptAmendment")
+	if err != nil {
+		return err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return err
+	}
+	if !caller.isEmployeeOf(contract) {
+		return fmt.Errorf("only the employee %s can accept amendments of contract %s", contract.Employee, contractID)
+	}
+	if isFinalStatus(contract.Status) {
+		return fmt.Errorf("contract %s is %s and cannot be amended", contractID, contract.Status)
+	}
+
+	n := len(contract.TermsVersions)
+	if n == 0 || !contract.TermsVersions[n-1].Pending || contract.TermsVersions[n-1].Version != version {
+		return fmt.Errorf("contract %s has no amendment version %d awaiting acceptance", contractID, version)
+	}
+	amendment := &contract.TermsVersions[n-1]
+
+	// payroll may have paid into the amended period since the amendment was proposed
+	err = checkAmendmentDate(ctx, contract, amendment.EffectiveFrom)
+	if err != nil {
+		return err
+	}
+
+	err = signTerms(ctx, caller, contract.ID, amendment.TermsHash, PartyEmployee, signature)
+	if err != nil {
+		return err
+	}
+	amendment.Pending = false
+
+	return putContract(ctx, contract)
+}
+
+// GetContractTerms returns the terms of a contract effective on a date ("YYYY-MM-DD").
+// The pay is only included for clients of orgs that are members of the contract collection
+func (s *PaymentContract) GetContractTerms(ctx contractapi.TransactionContextInterface, contractID string, date string) (*ContractTerms, error) {
+	err := authorizeRead(ctx, "GetContractTerms", contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return nil, err
+	}
+	err = loadVisibleContractPay(ctx, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	effective, err := parseDate(date)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := contract.termsAt(effective)
+	return &terms, nil
+}
@@ -0,0 +1,87 @@
+package chaincode
+
+import (
+	"testing"
+)
+
+// An amendment applies neither before the employee accepts it nor before its
+// effective date, and cannot reach back into a pay period already paid
+func TestAmendmentTakesEffectOnceAcceptedAndDue(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	account := map[string]interface{}{transientAccountKey: transientAccount{BankAccount: "DE02120300000000202051", Salt: "account-salt-0123456789"}}
+	l.must(s.CreateAccount(l.begin(p.employee, account), "ACC2", "Acme", "", "EUR"))
+
+	paymentID, err := s.ProcessPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", RegularPayment)
+	l.must(err)
+
+	pay := map[string]interface{}{transientPayKey: transientPay{Salary: "72000.00", VariablePay: "0", Salt: "amendment-salt-0123456789"}}
+	hash, err := s.HashContractTerms(l.begin(p.employer, pay), "C1", p.employer.name, p.employee.name, "Lead Engineer", "EUR", "ACC2", PaySchedule{})
+	l.must(err)
+
+	// March 2024 was paid above
+	err = s.AmendContract(l.begin(p.employer, pay), "C1", "Lead Engineer", "EUR", "ACC2", PaySchedule{}, "2024-03-15", "Promotion", p.employer.sign(t, hash))
+	if err == nil {
+		t.Error("an amendment took effect in a pay period already paid")
+	}
+
+	l.must(s.AmendContract(l.begin(p.employer, pay), "C1", "Lead Engineer", "EUR", "ACC2", PaySchedule{}, "2024-05-01", "Promotion", p.employer.sign(t, hash)))
+	contract, err := s.GetContractByID(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	if contract.Position != "Engineer" || contract.AccountID != "ACC1" {
+		t.Errorf("an amendment awaiting acceptance changed the current terms to %s paid into %s", contract.Position, contract.AccountID)
+	}
+	if terms := contract.termsAt(l.clock.AddDate(0, 3, 0)); terms.Position != "Engineer" {
+		t.Errorf("an amendment awaiting acceptance applies from its effective date: %s", terms.Position)
+	}
+
+	err = s.AcceptAmendment(l.begin(p.employee, nil), "C1", 2, p.employee.sign(t, "00"+hash[2:]))
+	if err == nil {
+		t.Error("an amendment was accepted with a signature over other terms")
+	}
+	l.must(s.AcceptAmendment(l.begin(p.employee, nil), "C1", 2, p.employee.sign(t, hash)))
+
+	contract, err = s.GetContractByID(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	if contract.Position != "Engineer" || contract.AccountID != "ACC1" {
+		t.Errorf("a future amendment changed the current terms to %s paid into %s", contract.Position, contract.AccountID)
+	}
+	if terms := contract.termsAt(l.clock.AddDate(0, 3, 0)); terms.Position != "Lead Engineer" {
+		t.Errorf("an accepted amendment does not apply from its effective date: %s", terms.Position)
+	}
+	current, err := s.GetAccount(l.begin(p.employee, nil), "ACC1")
+	l.must(err)
+	if current.ContractID != "C1" {
+		t.Errorf("the account of the current terms was unlinked before the amendment took effect")
+	}
+
+	// the first write of the contract once the amendment is due moves it to the new account
+	l.clock = l.clock.AddDate(0, 3, 0)
+	l.must(s.SuspendContract(l.begin(p.employer, nil), "C1", "Leave", ""))
+	contract, err = s.GetContractByID(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	if contract.Position != "Lead Engineer" || contract.AccountID != "ACC2" || contract.TermsVersion != 2 {
+		t.Errorf("the amendment did not take effect: %s paid into %s, version %d", contract.Position, contract.AccountID, contract.TermsVersion)
+	}
+	previous, err := s.GetAccount(l.begin(p.employee, nil), "ACC1")
+	l.must(err)
+	if previous.ContractID != "" {
+		t.Errorf("the account of the previous terms is still linked to %s", previous.ContractID)
+	}
+
+	// the payslip of March shows the terms March was paid on
+	payment, err := readPayment(l.begin(p.employer, nil), paymentID)
+	l.must(err)
+	if payment.Position != "Engineer" || payment.TermsVersion != 1 {
+		t.Errorf("the payment was recorded on %s, version %d", payment.Position, payment.TermsVersion)
+	}
+	payslip, err := s.GetPayslip(l.begin(p.employer, nil), "C1", "2024-03-01", "2024-03-31")
+	l.must(err)
+	if payslip.Position != "Engineer" {
+		t.Errorf("the payslip of March shows the position %s", payslip.Position)
+	}
+}
@@ -0,0 +1,316 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key storing approval policies
+const approvalPolicyIndex = "ApprovalPolicy"
+
+// Constants for the kinds of request an approval policy governs
+const (
+	AdvanceApproval     = "Advance"
+	CrossBorderApproval = "CrossBorder"
+	AdjustmentApproval  = "Adjustment"
+)
+
+// a step of an approval chain. Required of the listed approvers must sign at this
+// level before the next level can sign
+type ApprovalLevel struct {
+	Name      string   `json:"Name"`      // Name of the level, e.g. Manager or Finance
+	Approvers []string `json:"Approvers"` // Parties allowed to sign at this level, as MSP ID and enrollment ID, e.g. Org1MSP/alice
+	Required  int      `json:"Required"`  // Signatures needed from the approvers (m of n)
+	MinAmount Money    `json:"MinAmount"` // Level only applies to amounts of at least this, zero to always apply
+}
+
+// how requests of one kind made by an employer are approved. Each version replaces
+// the previous one; approvals given under an earlier version no longer count
+type ApprovalPolicy struct {
+	Employer   string          `json:"Employer"`   // Name of the employer the policy belongs to
+	Kind       string          `json:"Kind"`       // Advance, CrossBorder or Adjustment
+	Version    int             `json:"Version"`    // Version number, starting at 1
+	Levels     []ApprovalLevel `json:"Levels"`     // Approval chain in signing order
+	ExpiryDays int64           `json:"ExpiryDays"` // Days an approval stays valid, zero if approvals do not expire
+	UpdatedBy  string          `json:"UpdatedBy"`  // Name of the party that set the version
+	UpdatedAt  time.Time       `json:"UpdatedAt"`  // Transaction timestamp of the version
+}
+
+// a signature given under an approval policy
+type Approval struct {
+	Level         string    `json:"Level"`         // Level of the policy the approval was given at
+	Approver      string    `json:"Approver"`      // MSP ID and enrollment ID of the approver
+	PolicyVersion int       `json:"PolicyVersion"` // Version of the policy the approval was given under
+	Terms         string    `json:"Terms"`         // Terms the approver agreed to, every approval must agree
+	ApprovedAt    time.Time `json:"ApprovedAt"`    // Transaction timestamp of the approval
+}
+
+// validate checks that every level of a policy can be satisfied
+func (p *ApprovalPolicy) validate() error {
+	if p.Kind != AdvanceApproval && p.Kind != CrossBorderApproval && p.Kind != AdjustmentApproval {
+		return fmt.Errorf("unknown approval kind %q", p.Kind)
+	}
+	if len(p.Levels) == 0 {
+		return fmt.Errorf("an approval policy needs at least one level")
+	}
+	if p.ExpiryDays < 0 {
+		return fmt.Errorf("approval expiry of %d days cannot be negative", p.ExpiryDays)
+	}
+
+	names := map[string]bool{}
+	for i := range p.Levels {
+		level := &p.Levels[i]
+		if level.Name == "" || names[level.Name] {
+			return fmt.Errorf("approval level %d needs a unique name", i+1)
+		}
+		names[level.Name] = true
+		if level.Required < 1 || level.Required > len(level.Approvers) {
+			return fmt.Errorf("approval level %s requires %d of %d approvers", level.Name, level.Required, len(level.Approvers))
+		}
+		for _, approver := range level.Approvers {
+			if !strings.Contains(approver, "/") {
+				return fmt.Errorf("approver %q of level %s must be given as MSP ID and enrollment ID, e.g. Org1MSP/alice", approver, level.Name)
+			}
+		}
+		if level.MinAmount.IsNegative() {
+			return fmt.Errorf("approval level %s has a negative minimum amount", level.Name)
+		}
+	}
+
+	return nil
+}
+
+// applies reports whether a level must sign a request for amount. Thresholds in
+// another currency always apply
+func (l ApprovalLevel) applies(amount Money) bool {
+	if l.MinAmount.IsZero() || l.MinAmount.Currency != amount.Currency {
+		return true
+	}
+	cmp, _ := amount.Cmp(l.MinAmount)
+	return cmp >= 0
+}
+
+// lists reports whether approver is listed at any level of a policy
+func (p *ApprovalPolicy) lists(approver string) bool {
+	for _, level := range p.Levels {
+		for _, listedApprover := range level.Approvers {
+			if listedApprover == approver {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// approvalPolicyKey returns the world state key of a version of a policy
+func approvalPolicyKey(ctx contractapi.TransactionContextInterface, employer string, kind string, version int) (string, error) {
+	// zero padded so that versions sort numerically
+	key, err := ctx.GetStub().CreateCompositeKey(approvalPolicyIndex, []string{employer, kind, fmt.Sprintf("%06d", version)})
+	if err != nil {
+		return "", fmt.Errorf("failed to create approval policy key: %v", err)
+	}
+	return key, nil
+}
+
+// approvalPolicies returns every version of the policy of an employer for a kind of request
+func approvalPolicies(ctx contractapi.TransactionContextInterface, employer string, kind string) ([]*ApprovalPolicy, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(approvalPolicyIndex, []string{employer, kind})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var policies []*ApprovalPolicy
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var policy ApprovalPolicy
+		err = json.Unmarshal(queryResponse.Value, &policy)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, &policy)
+	}
+
+	return policies, nil
+}
+
+// currentApprovalPolicy returns the latest policy of an employer for a kind of
+// request, or nil if the employer has none
+func currentApprovalPolicy(ctx contractapi.TransactionContextInterface, employer string, kind string) (*ApprovalPolicy, error) {
+	policies, err := approvalPolicies(ctx, employer, kind)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	return policies[len(policies)-1], nil
+}
+
+// approve records the caller's approval of a request on a contract under a policy and
+// reports whether the policy is now satisfied. Approvals given under another policy
+// version, older than the policy's expiry or for other terms are discarded first. The
+// caller signs at the first level still short of signatures, must belong to the
+// contract's employer or be listed in the policy, and may neither be the requester
+// nor have signed the request before. When no level is left for the caller to sign,
+// as when none applies to the amount, the request is approved only if unsigned, the
+// rule for employers without a policy, accepts the caller
+func approve(policy *ApprovalPolicy, approvals *[]Approval, caller *identity, contract *Contract, requester PartyIdentity, amount Money, terms string, now time.Time, unsigned func() error) (bool, error) {
+	approver := caller.qualifiedName()
+	if caller.is(requester) {
+		return false, fmt.Errorf("%s cannot approve their own request", caller.Name)
+	}
+
+	applicable := false
+	for _, level := range policy.Levels {
+		if level.applies(amount) {
+			applicable = true
+			break
+		}
+	}
+	if applicable && !caller.belongsToEmployerOf(contract) && !policy.lists(approver) {
+		return false, fmt.Errorf("%s neither belongs to employer %s nor is an approver of its %s policy", caller.Name, contract.Employer, policy.Kind)
+	}
+
+	var valid []Approval
+	for _, approval := range *approvals {
+		if approval.PolicyVersion != policy.Version || approval.Terms != terms {
+			continue
+		}
+		if policy.ExpiryDays > 0 && !now.Before(approval.ApprovedAt.AddDate(0, 0, int(policy.ExpiryDays))) {
+			continue
+		}
+		if approval.Approver == approver {
+			return false, fmt.Errorf("%s already approved this request at level %s", caller.Name, approval.Level)
+		}
+		valid = append(valid, approval)
+	}
+
+	signed := false
+	for _, level := range policy.Levels {
+		if !level.applies(amount) {
+			continue
+		}
+
+		count := 0
+		for _, approval := range valid {
+			if approval.Level == level.Name {
+				count++
+			}
+		}
+		if count >= level.Required {
+			continue
+		}
+		if signed {
+			*approvals = valid
+			return false, nil
+		}
+
+		listed := false
+		for _, listedApprover := range level.Approvers {
+			if listedApprover == approver {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			return false, fmt.Errorf("%s is not an approver at level %s, which still needs %d signatures", caller.Name, level.Name, level.Required-count)
+		}
+
+		valid = append(valid, Approval{
+			Level:         level.Name,
+			Approver:      approver,
+			PolicyVersion: policy.Version,
+			Terms:         terms,
+			ApprovedAt:    now,
+		})
+		signed = true
+		if count+1 < level.Required {
+			*approvals = valid
+			return false, nil
+		}
+	}
+
+	*approvals = valid
+	if !signed {
+		err := unsigned()
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// SetApprovalPolicy sets a new version of the approval chain for advances,
+// cross-border payments or payment adjustments of the calling employer
+func (s *PaymentContract) SetApprovalPolicy(ctx contractapi.TransactionContextInterface, employer string, policy ApprovalPolicy) (*ApprovalPolicy, error) {
+	caller, err := authorize(ctx, "SetApprovalPolicy")
+	if err != nil {
+		return nil, err
+	}
+	err = bindEmployer(ctx, caller, employer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = policy.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := approvalPolicies(ctx, employer, policy.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policy.Employer = employer
+	policy.Version = len(policies) + 1
+	policy.UpdatedBy = caller.Name
+	policy.UpdatedAt = now
+
+	policyKey, err := approvalPolicyKey(ctx, employer, policy.Kind, policy.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(policyKey, policyJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return &policy, nil
+}
+
+// GetApprovalPolicies returns every version of an employer's approval policy for a kind of request
+func (s *PaymentContract) GetApprovalPolicies(ctx contractapi.TransactionContextInterface, employer string, kind string) ([]*ApprovalPolicy, error) {
+	caller, err := authorize(ctx, "GetApprovalPolicies")
+	if err != nil {
+		return nil, err
+	}
+	if caller.Role == RoleEmployer {
+		err = requireEmployer(ctx, caller, employer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return approvalPolicies(ctx, employer, kind)
+}
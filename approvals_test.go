@@ -0,0 +1,41 @@
+package chaincode
+
+import (
+	"testing"
+)
+
+// A request no level of the policy applies to is approved as without a policy, and
+// never by a party outside the employer that the policy does not list
+func TestApprovalFallsBackWhenNoLevelApplies(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	threshold, err := NewMoney(500000, "EUR")
+	l.must(err)
+	policy := ApprovalPolicy{Kind: AdvanceApproval, Levels: []ApprovalLevel{{Name: "Finance", Approvers: []string{"EmployerMSP/cfo"}, Required: 1, MinAmount: threshold}}}
+	_, err = s.SetApprovalPolicy(l.begin(p.employer, nil), p.employer.name, policy)
+	l.must(err)
+
+	l.must(s.AdvanceRequest(l.begin(p.employee, nil), "ADV1", "C1", p.employee.name, "100.00", 2))
+
+	outsider := newTestClient(t, "mallory", "OtherMSP", RoleEmployer)
+	err = s.ApproveAdvanceRequest(l.begin(outsider, nil), "", "ADV1", "0", 0)
+	if err == nil {
+		t.Error("a party outside the employer approved an advance below every threshold")
+	}
+	colleague := newTestClient(t, "bob", "EmployerMSP", RoleEmployer)
+	err = s.ApproveAdvanceRequest(l.begin(colleague, nil), "", "ADV1", "0", 0)
+	if err == nil {
+		t.Error("a member of the employer's org other than the employer approved an advance without a policy level")
+	}
+
+	l.must(s.ApproveAdvanceRequest(l.begin(p.employer, nil), "", "ADV1", "0", 0))
+	requests, err := s.GetAdvanceRequests(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	if len(requests) != 1 || requests[0].Status != AdvanceApproved {
+		t.Errorf("the employer's approval did not approve the advance: %+v", requests)
+	}
+}
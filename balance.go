@@ -0,0 +1,348 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object types of the composite keys used by the balance ledger
+const (
+	balanceIndex = "Balance"
+	postingIndex = "Posting"
+)
+
+// Constants for the ledger accounts kept per contract and employee
+const (
+	EmployerPayable    = "EmployerPayable"    // amounts the employer owes for earned pay
+	EmployeeWallet     = "EmployeeWallet"     // funds credited to the employee and not yet withdrawn
+	AdvanceReceivable  = "AdvanceReceivable"  // advances paid out and still owed back by the employee
+	SettlementClearing = "SettlementClearing" // withdrawals and bank payments on their way to the employee's bank
+	WithholdingPayable = "WithholdingPayable" // tax and contributions withheld from pay and owed to the authorities
+	AdvanceFeeIncome   = "AdvanceFeeIncome"   // fees and interest repaid on advances
+	AdvanceWriteOff    = "AdvanceWriteOff"    // advanced amounts forgiven when a contract was terminated
+)
+
+// running totals of a ledger account for one employee in one contract
+type LedgerAccount struct {
+	ContractID string `json:"ContractID"` // ID of the contract the account belongs to
+	Employee   string `json:"Employee"`   // Name of the employee
+	Account    string `json:"Account"`    // Ledger account (EmployerPayable, EmployeeWallet, etc.)
+	Debits     Money  `json:"Debits"`     // Sum of all debits posted to the account
+	Credits    Money  `json:"Credits"`    // Sum of all credits posted to the account
+
+	PrivateDataHash string `json:"PrivateDataHash"` // Salted hash of the totals held in the ledger collection
+}
+
+// a double-entry posting moving an amount from the credit account to the debit account
+type Posting struct {
+	ID            string    `json:"ID"`            // Unique identifier for the posting
+	ContractID    string    `json:"ContractID"`    // ID of the contract
+	Employee      string    `json:"Employee"`      // Name of the employee
+	DebitAccount  string    `json:"DebitAccount"`  // Ledger account debited
+	CreditAccount string    `json:"CreditAccount"` // Ledger account credited
+	Amount        Money     `json:"Amount"`        // Amount posted
+	Reference     string    `json:"Reference"`     // ID of the record that caused the posting
+	Date          time.Time `json:"Date"`          // Transaction timestamp of the posting
+
+	PrivateDataHash string `json:"PrivateDataHash"` // Salted hash of the amount held in the ledger collection
+}
+
+// balances of every ledger account of an employee in a contract
+type EmployeeBalance struct {
+	ContractID string           `json:"ContractID"` // ID of the contract
+	Employee   string           `json:"Employee"`   // Name of the employee
+	Available  Money            `json:"Available"`  // Wallet funds the employee can withdraw
+	Accounts   []*LedgerAccount `json:"Accounts"`   // Totals of each ledger account
+}
+
+// one debit/credit pair of a journal entry
+type postingLeg struct {
+	debitAccount  string
+	creditAccount string
+	amount        Money
+}
+
+// post writes the postings of a journal entry caused by the record with the given
+// reference and updates the totals of every account involved. Fabric does not
+// expose a transaction's own writes to GetState, so all legs touching the same
+// account within one transaction must be passed in a single call
+func post(ctx contractapi.TransactionContextInterface, contractID string, employee string, reference string, legs ...postingLeg) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	accounts := map[string]*LedgerAccount{}
+	var accountOrder []string
+	account := func(name string, currency string) (*LedgerAccount, error) {
+		if ledgerAccount, ok := accounts[name]; ok {
+			return ledgerAccount, nil
+		}
+		ledgerAccount, err := getLedgerAccount(ctx, contractID, employee, name, currency, false)
+		if err != nil {
+			return nil, err
+		}
+		accounts[name] = ledgerAccount
+		accountOrder = append(accountOrder, name)
+		return ledgerAccount, nil
+	}
+
+	for i, leg := range legs {
+		if !leg.amount.IsPositive() {
+			return fmt.Errorf("posting amount %s must be positive", leg.amount)
+		}
+		if leg.debitAccount == leg.creditAccount {
+			return fmt.Errorf("cannot post from %s to itself", leg.debitAccount)
+		}
+
+		debit, err := account(leg.debitAccount, leg.amount.Currency)
+		if err != nil {
+			return err
+		}
+		debit.Debits, err = debit.Debits.Add(leg.amount)
+		if err != nil {
+			return err
+		}
+
+		credit, err := account(leg.creditAccount, leg.amount.Currency)
+		if err != nil {
+			return err
+		}
+		credit.Credits, err = credit.Credits.Add(leg.amount)
+		if err != nil {
+			return err
+		}
+
+		posting := Posting{
+			ID:            fmt.Sprintf("POST_%s_%d", reference, i),
+			ContractID:    contractID,
+			Employee:      employee,
+			DebitAccount:  leg.debitAccount,
+			CreditAccount: leg.creditAccount,
+			Amount:        leg.amount,
+			Reference:     reference,
+			Date:          now,
+		}
+
+		postingKey, err := ctx.GetStub().CreateCompositeKey(postingIndex, []string{contractID, employee, now.Format(paymentIndexTimeLayout), posting.ID})
+		if err != nil {
+			return fmt.Errorf("failed to create posting key: %v", err)
+		}
+
+		public, err := putPostingAmount(ctx, &posting)
+		if err != nil {
+			return err
+		}
+
+		postingJSON, err := json.Marshal(public)
+		if err != nil {
+			return err
+		}
+
+		err = ctx.GetStub().PutState(postingKey, postingJSON)
+		if err != nil {
+			return fmt.Errorf("failed to put to world state. %v", err)
+		}
+	}
+
+	for _, name := range accountOrder {
+		err = putLedgerAccount(ctx, accounts[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getLedgerAccount reads a ledger account in the given currency, returning an empty
+// account if nothing was posted to it yet. Accounts are kept per currency so that a
+// contract amended to a new currency starts fresh totals. When visibleOnly is set, as
+// in read transactions, the totals are filled in only if the client may see them
+func getLedgerAccount(ctx contractapi.TransactionContextInterface, contractID string, employee string, account string, currency string, visibleOnly bool) (*LedgerAccount, error) {
+	accountKey, err := ctx.GetStub().CreateCompositeKey(balanceIndex, []string{contractID, employee, account, currency})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create balance key: %v", err)
+	}
+
+	accountJSON, err := ctx.GetStub().GetState(accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if accountJSON == nil {
+		zero, err := ZeroMoney(currency)
+		if err != nil {
+			return nil, err
+		}
+		return &LedgerAccount{ContractID: contractID, Employee: employee, Account: account, Debits: zero, Credits: zero}, nil
+	}
+
+	var ledgerAccount LedgerAccount
+	err = json.Unmarshal(accountJSON, &ledgerAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadLedgerAccountTotals(ctx, accountKey, &ledgerAccount, visibleOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ledgerAccount, nil
+}
+
+// putLedgerAccount writes a ledger account under its composite key
+func putLedgerAccount(ctx contractapi.TransactionContextInterface, account *LedgerAccount) error {
+	accountKey, err := ctx.GetStub().CreateCompositeKey(balanceIndex, []string{account.ContractID, account.Employee, account.Account, account.Debits.Currency})
+	if err != nil {
+		return fmt.Errorf("failed to create balance key: %v", err)
+	}
+
+	public, err := putLedgerAccountTotals(ctx, accountKey, account)
+	if err != nil {
+		return err
+	}
+
+	accountJSON, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(accountKey, accountJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// availableBalance returns the wallet funds an employee can withdraw from a contract
+// in the currency of its current terms
+func availableBalance(ctx contractapi.TransactionContextInterface, contract *Contract, employee string) (Money, error) {
+	terms, err := currentTerms(ctx, contract)
+	if err != nil {
+		return Money{}, err
+	}
+
+	wallet, err := getLedgerAccount(ctx, contract.ID, employee, EmployeeWallet, terms.Currency, false)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return wallet.Credits.Sub(wallet.Debits)
+}
+
+// GetBalance returns the ledger account totals and withdrawable balance of an employee in a contract
+func (s *PaymentContract) GetBalance(ctx contractapi.TransactionContextInterface, contractID string, employee string) (*EmployeeBalance, error) {
+	caller, err := authorize(ctx, "GetBalance")
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = authorizeContractRead(caller, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	terms, err := currentTerms(ctx, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	// the totals are private, so a peer of another org returns the accounts without them
+	balance := EmployeeBalance{
+		ContractID: contractID,
+		Employee:   employee,
+	}
+	for _, account := range []string{EmployerPayable, EmployeeWallet, AdvanceReceivable, SettlementClearing, WithholdingPayable, AdvanceFeeIncome, AdvanceWriteOff} {
+		ledgerAccount, err := getLedgerAccount(ctx, contractID, employee, account, terms.Currency, true)
+		if err != nil {
+			return nil, err
+		}
+		if account == EmployeeWallet && ledgerAccount.Credits.Currency != "" {
+			balance.Available, err = ledgerAccount.Credits.Sub(ledgerAccount.Debits)
+			if err != nil {
+				return nil, err
+			}
+		}
+		balance.Accounts = append(balance.Accounts, ledgerAccount)
+	}
+
+	return &balance, nil
+}
+
+// walletPostings returns the postings of an employee in a contract that credit or
+// debit the employee's wallet, in chronological order
+func walletPostings(ctx contractapi.TransactionContextInterface, contractID string, employee string) ([]*Posting, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(postingIndex, []string{contractID, employee})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var postings []*Posting
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var posting Posting
+		err = json.Unmarshal(queryResponse.Value, &posting)
+		if err != nil {
+			return nil, err
+		}
+		if posting.DebitAccount == EmployeeWallet || posting.CreditAccount == EmployeeWallet {
+			err = loadPostingAmount(ctx, &posting, false)
+			if err != nil {
+				return nil, err
+			}
+			postings = append(postings, &posting)
+		}
+	}
+
+	return postings, nil
+}
+
+// GetPostings returns every posting of an employee in a contract in chronological order
+func (s *PaymentContract) GetPostings(ctx contractapi.TransactionContextInterface, contractID string, employee string) ([]*Posting, error) {
+	err := authorizeRead(ctx, "GetPostings", contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(postingIndex, []string{contractID, employee})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var postings []*Posting
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var posting Posting
+		err = json.Unmarshal(queryResponse.Value, &posting)
+		if err != nil {
+			return nil, err
+		}
+		err = loadPostingAmount(ctx, &posting, true)
+		if err != nil {
+			return nil, err
+		}
+		postings = append(postings, &posting)
+	}
+
+	return postings, nil
+}
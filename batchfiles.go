@@ -0,0 +1,489 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object types of the composite keys indexing approved local payments by settlement
+// date and batch files by employer, format and creation date
+const (
+	approvedLocalIndex = "ApprovedLocalPayment"
+	batchFileIndex     = "BatchFile"
+)
+
+// Constants for batch file formats
+const (
+	NachaPPD           = "NACHA-PPD"
+	SepaCreditTransfer = "SEPA-pain.001.001.09"
+)
+
+// company entry description of payroll NACHA batches
+const nachaPayrollDescription = "PAYROLL"
+
+// a bank batch file generated from approved local payments, recorded by its hash
+// only; see renderPrivateDocument
+type BatchFile struct {
+	FileID          string    `json:"FileID"`                  // ID of the file, the MsgId of a SEPA file
+	Format          string    `json:"Format"`                  // NACHA-PPD or SEPA-pain.001.001.09
+	Employer        string    `json:"Employer"`                // Employer paying the file
+	DebtorAccountID string    `json:"DebtorAccountID"`         // Account the file is paid from
+	SettlementDate  time.Time `json:"SettlementDate"`          // Settlement date of the payments
+	FileIDModifier  string    `json:"FileIDModifier"`          // NACHA file ID modifier, empty for SEPA files
+	CompanyID       string    `json:"CompanyID,omitempty"`     // NACHA company identification, empty for SEPA files
+	PaymentInfoID   string    `json:"PaymentInfoID,omitempty"` // PmtInfId of a SEPA file, empty for NACHA files
+	PaymentIDs      []string  `json:"PaymentIDs"`              // Local payments in the file
+	EntryCount      int       `json:"EntryCount"`              // Number of entries or transactions
+	ControlTotal    Money     `json:"ControlTotal"`            // Sum of the entry amounts
+	HashTotal       string    `json:"HashTotal,omitempty"`     // NACHA entry hash, empty for SEPA files
+	FileHash        string    `json:"FileHash"`                // SHA-256 of the file content
+	CreatedBy       string    `json:"CreatedBy"`               // Name of the client that generated the file
+	CreatedAt       time.Time `json:"CreatedAt"`               // Transaction timestamp of the generation
+
+	PrivateDataHash string `json:"PrivateDataHash"` // Salted hash of the control total held in the ledger collection
+}
+
+// approvedLocalKey returns the index key of an approved local payment
+func approvedLocalKey(ctx contractapi.TransactionContextInterface, settlementDate time.Time, paymentID string) (string, error) {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(approvedLocalIndex, []string{settlementDate.Format(dateLayout), paymentID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create approved local payment key: %v", err)
+	}
+	return indexKey, nil
+}
+
+// removeApprovedLocal takes a local payment out of the approved index once it was
+// exported or its settlement initiated, so it cannot be picked up by a batch file as well
+func removeApprovedLocal(ctx contractapi.TransactionContextInterface, payment *bankPayment) error {
+	local, ok := payment.record.(*LocalPayment)
+	if !ok || local.SettlementDate.IsZero() {
+		return nil
+	}
+
+	indexKey, err := approvedLocalKey(ctx, local.SettlementDate, payment.ID)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(indexKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state. %v", err)
+	}
+
+	return nil
+}
+
+// approvedLocalPayments returns the approved local payments settling on a date
+func approvedLocalPayments(ctx contractapi.TransactionContextInterface, settlementDate time.Time) ([]*bankPayment, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(approvedLocalIndex, []string{settlementDate.Format(dateLayout)})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var payments []*bankPayment
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		payment, err := readBankPayment(ctx, keyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+// readBatchFile reads a batch file by its file ID
+func readBatchFile(ctx contractapi.TransactionContextInterface, fileID string) (*BatchFile, error) {
+	fileJSON, err := ctx.GetStub().GetState(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if fileJSON == nil {
+		return nil, fmt.Errorf("the batch file %s does not exist", fileID)
+	}
+
+	var file BatchFile
+	err = json.Unmarshal(fileJSON, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// countBatchFiles returns the number of files of a format the employer generated on
+// the day of created
+func countBatchFiles(ctx contractapi.TransactionContextInterface, employer string, format string, created time.Time) (int, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(batchFileIndex, []string{employer, format, created.UTC().Format(dateLayout)})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	count := 0
+	for resultsIterator.HasNext() {
+		_, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// a batch of approved local payments of one employer before it is rendered
+type localBatch struct {
+	caller         *identity
+	now            time.Time
+	settlementDate time.Time
+	debtorAccount  *Account
+	debtor         PartyAccount
+	payments       []*bankPayment
+	transfers      []CreditTransfer
+}
+
+// collectLocalBatch collects the approved local payments in a currency that settle on
+// a date and are owed by the owner of the debtor account, with their credit transfers
+func collectLocalBatch(ctx contractapi.TransactionContextInterface, caller *identity, settlementDate string, debtorAccountID string, currency string) (*localBatch, error) {
+	date, err := parseDate(settlementDate)
+	if err != nil {
+		return nil, err
+	}
+	date = startOfDay(date)
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if date.Before(startOfDay(now)) {
+		return nil, fmt.Errorf("settlement date %s has passed", date.Format(dateLayout))
+	}
+
+	debtorAccount, debtor, err := partyAccountOf(ctx, debtorAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if caller.Role == RoleEmployer && !caller.is(debtorAccount.OwnerIdentity) {
+		return nil, fmt.Errorf("%s cannot generate batch files paid from account %s", caller.Name, debtorAccountID)
+	}
+
+	approved, err := approvedLocalPayments(ctx, date)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := localBatch{caller: caller, now: now, settlementDate: date, debtorAccount: debtorAccount, debtor: debtor}
+	for _, payment := range approved {
+		if payment.Amount.Currency != currency {
+			continue
+		}
+
+		contract, err := readContract(ctx, payment.ContractID)
+		if err != nil {
+			return nil, err
+		}
+		if !contract.EmployerIdentity.matches(debtorAccount.OwnerIdentity) {
+			continue
+		}
+
+		// settlements initiated before they left the index are no longer approved
+		if payment.Status != BankPaymentApproved {
+			continue
+		}
+		local := payment.record.(*LocalPayment)
+		if local.FileID != "" || len(payment.Messages) > 0 {
+			return nil, fmt.Errorf("payment %s was already exported and cannot be included in another file", payment.ID)
+		}
+
+		transfer, err := creditTransferOf(ctx, payment, contract, Pain001, now)
+		if err != nil {
+			return nil, err
+		}
+		batch.transfers = append(batch.transfers, transfer)
+		batch.payments = append(batch.payments, payment)
+	}
+	if len(batch.payments) == 0 {
+		return nil, fmt.Errorf("no approved %s local payments of %s settle on %s", currency, debtorAccount.Owner, date.Format(dateLayout))
+	}
+
+	return &batch, nil
+}
+
+// record writes the batch file and its index entry, and stamps its ID on every
+// payment in it, taking them out of the approved index
+func (b *localBatch) record(ctx contractapi.TransactionContextInterface, file *BatchFile, content []byte) (*BatchFile, error) {
+	file.Employer = b.debtorAccount.Owner
+	file.DebtorAccountID = b.debtorAccount.AccountID
+	file.SettlementDate = b.settlementDate
+	file.EntryCount = len(b.payments)
+	file.CreatedBy = b.caller.Name
+	file.CreatedAt = b.now
+
+	file.FileHash = documentHash(content)
+
+	total, err := ZeroMoney(b.payments[0].Amount.Currency)
+	if err != nil {
+		return nil, err
+	}
+	for _, payment := range b.payments {
+		total, err = total.Add(payment.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		err = removeApprovedLocal(ctx, payment)
+		if err != nil {
+			return nil, err
+		}
+		payment.record.(*LocalPayment).FileID = file.FileID
+		err = payment.put(ctx)
+		if err != nil {
+			return nil, err
+		}
+		file.PaymentIDs = append(file.PaymentIDs, payment.ID)
+	}
+	file.ControlTotal = total
+
+	// the control total is private, so the file is returned as written to the world state
+	public, err := putBatchFileTotal(ctx, file, b.payments[0].ContractID)
+	if err != nil {
+		return nil, err
+	}
+	fileJSON, err := json.Marshal(public)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(file.FileID, fileJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	indexKey, err := ctx.GetStub().CreateCompositeKey(batchFileIndex, []string{file.Employer, file.Format, b.now.UTC().Format(dateLayout), file.FileID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch file key: %v", err)
+	}
+	err = ctx.GetStub().PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return public, nil
+}
+
+// render renders the batch into the content of a file of its format
+func (b *localBatch) render(file *BatchFile) ([]byte, error) {
+	switch file.Format {
+	case NachaPPD:
+		nacha := NachaFile{
+			OriginatingDFI:   b.debtor.RoutingNumber,
+			CompanyID:        file.CompanyID,
+			CompanyName:      b.debtor.Name,
+			CreatedAt:        b.now,
+			FileIDModifier:   file.FileIDModifier,
+			EntryDescription: nachaPayrollDescription,
+			EffectiveDate:    b.settlementDate,
+		}
+		for i, transfer := range b.transfers {
+			nacha.Entries = append(nacha.Entries, NachaEntry{
+				RoutingNumber: transfer.Creditor.RoutingNumber,
+				AccountNumber: transfer.Creditor.Account,
+				Amount:        transfer.Amount,
+				IndividualID:  b.payments[i].record.(*LocalPayment).IndividualID,
+				Name:          transfer.Creditor.Name,
+			})
+		}
+
+		content, err := RenderNachaPPD(&nacha)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NACHA file: %v", err)
+		}
+		file.HashTotal = nachaNumber(nacha.EntryHash(), 10)
+		return content, nil
+
+	case SepaCreditTransfer:
+		content, err := RenderPain001(&CreditTransferBatch{
+			MessageID:       file.FileID,
+			PaymentInfoID:   file.PaymentInfoID,
+			CreatedAt:       b.now,
+			ExecutionDate:   b.settlementDate,
+			InitiatingParty: b.debtor.Name,
+			Debtor:          b.debtor,
+			ServiceLevel:    "SEPA",
+			ChargeBearer:    "SLEV",
+			Transfers:       b.transfers,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid SEPA file: %v", err)
+		}
+		return content, nil
+	}
+
+	return nil, fmt.Errorf("unknown batch file format %q", file.Format)
+}
+
+// GenerateNachaFile records a NACHA PPD file of credits to checking accounts paying
+// the approved USD local payments of the owner of the debtor account that settle on a
+// date. companyID is the 10 character company identification the ODFI assigned to the
+// employer. The response is written to the ledger, so it holds no bank details; the
+// file itself is fetched with RenderBatchFile. Its payments are never included in
+// another file
+func (s *PaymentContract) GenerateNachaFile(ctx contractapi.TransactionContextInterface, settlementDate string, debtorAccountID string, companyID string) (*BatchFile, error) {
+	caller, err := authorize(ctx, "GenerateNachaFile")
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := collectLocalBatch(ctx, caller, settlementDate, debtorAccountID, "USD")
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := countBatchFiles(ctx, batch.debtorAccount.Owner, NachaPPD, batch.now)
+	if err != nil {
+		return nil, err
+	}
+	if count >= len(nachaFileIDModifiers) {
+		return nil, fmt.Errorf("%s already generated %d NACHA files today", batch.debtorAccount.Owner, count)
+	}
+
+	// returns and statements of NACHA entries carry the individual ID, so it is indexed
+	// like an end-to-end ID
+	for _, payment := range batch.payments {
+		local := payment.record.(*LocalPayment)
+		local.IndividualID = isoIdentifier("N", payment.ID)[:nachaIndividualIDLength]
+		err = putEndToEndIndex(ctx, local.IndividualID, payment.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file := &BatchFile{
+		FileID:         isoIdentifier("FILE", ctx.GetStub().GetTxID()),
+		Format:         NachaPPD,
+		FileIDModifier: nachaFileIDModifiers[count : count+1],
+		CompanyID:      companyID,
+	}
+	content, err := batch.render(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch.record(ctx, file, content)
+}
+
+// GenerateSepaFile records a SEPA credit transfer pain.001.001.09 batch paying the
+// approved EUR local payments of the owner of the debtor account that settle on a
+// date. Both parties need an IBAN. The response is written to the ledger, so it holds
+// no bank details; the file itself is fetched with RenderBatchFile. Its payments are
+// never included in another file
+func (s *PaymentContract) GenerateSepaFile(ctx contractapi.TransactionContextInterface, settlementDate string, debtorAccountID string) (*BatchFile, error) {
+	caller, err := authorize(ctx, "GenerateSepaFile")
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := collectLocalBatch(ctx, caller, settlementDate, debtorAccountID, "EUR")
+	if err != nil {
+		return nil, err
+	}
+
+	if isoAccountOf(batch.debtor.Account).ID.IBAN == "" {
+		return nil, fmt.Errorf("SEPA credit transfers are paid from an IBAN, account %s has none", debtorAccountID)
+	}
+	for i, transfer := range batch.transfers {
+		if isoAccountOf(transfer.Creditor.Account).ID.IBAN == "" {
+			return nil, fmt.Errorf("SEPA credit transfers are paid into an IBAN, the creditor of payment %s has none", batch.payments[i].ID)
+		}
+	}
+
+	file := &BatchFile{
+		FileID:        isoIdentifier("FILE", ctx.GetStub().GetTxID()),
+		Format:        SepaCreditTransfer,
+		PaymentInfoID: isoIdentifier("PMT", ctx.GetStub().GetTxID()),
+	}
+	content, err := batch.render(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch.record(ctx, file, content)
+}
+
+// RenderBatchFile renders a generated batch file from its payments and the bank
+// details in the account collection. It is evaluated, not submitted; see
+// renderPrivateDocument
+func (s *PaymentContract) RenderBatchFile(ctx contractapi.TransactionContextInterface, fileID string) (string, error) {
+	caller, err := authorize(ctx, "RenderBatchFile")
+	if err != nil {
+		return "", err
+	}
+
+	file, err := readBatchFile(ctx, fileID)
+	if err != nil {
+		return "", err
+	}
+
+	return renderPrivateDocument(ctx, caller, "batch file "+fileID, file.DebtorAccountID, file.FileHash, func(debtor PartyAccount) ([]byte, error) {
+		batch := localBatch{caller: caller, now: file.CreatedAt, settlementDate: file.SettlementDate, debtor: debtor}
+		for _, paymentID := range file.PaymentIDs {
+			payment, err := readBankPayment(ctx, paymentID)
+			if err != nil {
+				return nil, err
+			}
+			contract, err := readContract(ctx, payment.ContractID)
+			if err != nil {
+				return nil, err
+			}
+
+			transfer, err := creditTransferOf(ctx, payment, contract, Pain001, file.CreatedAt)
+			if err != nil {
+				return nil, err
+			}
+			batch.transfers = append(batch.transfers, transfer)
+			batch.payments = append(batch.payments, payment)
+		}
+
+		return batch.render(file)
+	})
+}
+
+// GetBatchFile returns the ledger record of a batch file
+func (s *PaymentContract) GetBatchFile(ctx contractapi.TransactionContextInterface, fileID string) (*BatchFile, error) {
+	caller, err := authorize(ctx, "GetBatchFile")
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := readBatchFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if caller.Role == RoleEmployer {
+		err = requireEmployer(ctx, caller, file.Employer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = loadVisibleBatchFileTotal(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
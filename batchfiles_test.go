@@ -0,0 +1,97 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// A generated file is recorded without its bank details, which only the evaluated
+// render returns, and payments whose settlement was initiated are left out of it
+func TestBatchFilesAreRenderedOffLedger(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+	fundWallet(l, s, p, "C1")
+	openDebtorAccount(l, s, p, "ACME1")
+
+	settlementDate := l.clock.AddDate(0, 0, 1).Format(dateLayout)
+	var paymentIDs []string
+	for i := 0; i < 2; i++ {
+		paymentID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", Local)
+		l.must(err)
+		l.must(s.ProcessLocalPayment(l.begin(p.bank, nil), paymentID, settlementDate))
+		paymentIDs = append(paymentIDs, paymentID)
+	}
+	l.must(s.InitiateSettlement(l.begin(p.bank, nil), paymentIDs[0], SettlementRoute{MemberBank: p.bank.mspID}, "SENT1"))
+
+	file, err := s.GenerateSepaFile(l.begin(p.employer, nil), settlementDate, "ACME1")
+	l.must(err)
+	if len(file.PaymentIDs) != 1 || file.PaymentIDs[0] != paymentIDs[1] {
+		t.Errorf("the file pays %v, want only %s", file.PaymentIDs, paymentIDs[1])
+	}
+	response, err := json.Marshal(file)
+	l.must(err)
+	if strings.Contains(string(response), "DE89370400440532013000") || file.FileHash == "" {
+		t.Errorf("the generation returned %s", response)
+	}
+
+	content, err := s.RenderBatchFile(l.begin(p.employer, nil), file.FileID)
+	l.must(err)
+	if len(l.stub.writes) != 0 {
+		t.Errorf("rendering wrote %v", l.writeSet())
+	}
+	if !strings.Contains(content, "<MsgId>"+file.FileID+"</MsgId>") || !strings.Contains(content, "<IBAN>DE89370400440532013000</IBAN>") {
+		t.Errorf("the rendered file is %s", content)
+	}
+}
+
+// A NACHA file is generated the same through a peer of any org, and its entries carry
+// an individual ID that finds the payment like an end-to-end ID
+func TestNachaFilesIndexTheIndividualID(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+
+	account := map[string]interface{}{transientAccountKey: transientAccount{BankAccount: "123456789", RoutingNumber: "021000021", Salt: "account-salt-0123456789"}}
+	l.must(s.CreateAccount(l.begin(p.employee, account), "ACC1", "", "", "USD"))
+	pay := map[string]interface{}{transientPayKey: transientPay{Salary: "60000.00", VariablePay: "0", Salt: "contract-salt-0123456789"}}
+	hash, err := s.HashContractTerms(l.begin(p.employer, pay), "C1", p.employer.name, p.employee.name, "Engineer", "USD", "ACC1", PaySchedule{})
+	l.must(err)
+	l.must(s.ProposeContract(l.begin(p.employer, pay), "C1", p.employer.name, p.employee.name, "Engineer", "USD", "ACC1", PaySchedule{}, p.employer.sign(t, hash)))
+	l.must(s.AcceptContract(l.begin(p.employee, nil), "C1", p.employee.sign(t, hash)))
+	l.must(s.SetEmployerBank(l.begin(p.employer, nil), p.employer.name, p.bank.mspID))
+	fundWallet(l, s, p, "C1")
+	debtor := map[string]interface{}{transientAccountKey: transientAccount{BankAccount: "987654321", RoutingNumber: "011000015", Salt: "debtor-salt-0123456789"}}
+	l.must(s.CreateAccount(l.begin(p.employer, debtor), "ACME1", "Acme", "", "USD"))
+
+	settlementDate := l.clock.AddDate(0, 0, 1).Format(dateLayout)
+	paymentID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", Local)
+	l.must(err)
+	l.must(s.ProcessLocalPayment(l.begin(p.bank, nil), paymentID, settlementDate))
+
+	t.Setenv("CORE_PEER_LOCALMSPID", p.bank.mspID)
+	file, err := s.GenerateNachaFile(l.begin(p.employer, nil), settlementDate, "ACME1", "1234567890")
+	l.must(err)
+	t.Setenv("CORE_PEER_LOCALMSPID", p.employer.mspID)
+
+	payment, err := readBankPayment(l.begin(p.employer, nil), paymentID)
+	l.must(err)
+	individualID := payment.record.(*LocalPayment).IndividualID
+	if len(individualID) != nachaIndividualIDLength {
+		t.Fatalf("the individual ID is %q", individualID)
+	}
+	content, err := s.RenderBatchFile(l.begin(p.employer, nil), file.FileID)
+	l.must(err)
+	if !strings.Contains(content, individualID) {
+		t.Errorf("the file does not carry the individual ID %s", individualID)
+	}
+	candidate, err := s.GetPaymentByEndToEndID(l.begin(p.bank, nil), individualID)
+	l.must(err)
+	if candidate.PaymentID != paymentID {
+		t.Errorf("the individual ID found %s, want %s", candidate.PaymentID, paymentID)
+	}
+}
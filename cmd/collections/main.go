@@ -0,0 +1,109 @@
+// Command collections writes the private data collection config of the payroll
+// chaincode for the MSPs of a deployment.
+//
+// Collection membership is fixed when the chaincode definition is approved, before
+// Initialize binds any roles, so it is generated from the same MSP IDs the
+// deployment later passes to Initialize and RegisterBankMSP:
+//
+//	collections -employers EmployerMSP -employees EmployeeMSP -banks BankMSP \
+//		> collections_config.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	chaincode "github.com/blockchain-project/chaincode"
+)
+
+// a static collection definition as read by the peer lifecycle commands
+type collection struct {
+	Name              string `json:"name"`
+	Policy            string `json:"policy"`
+	RequiredPeerCount int    `json:"requiredPeerCount"`
+	MaxPeerCount      int    `json:"maxPeerCount"`
+	BlockToLive       int    `json:"blockToLive"`
+	MemberOnlyRead    bool   `json:"memberOnlyRead"`
+	MemberOnlyWrite   bool   `json:"memberOnlyWrite"`
+}
+
+func main() {
+	employers := flag.String("employers", "", "comma-separated MSP IDs of the employer orgs")
+	employees := flag.String("employees", "", "comma-separated MSP IDs of the employee orgs")
+	banks := flag.String("banks", "", "comma-separated MSP IDs of the bank orgs")
+	requiredPeers := flag.Int("required-peers", 1, "peers private data must be disseminated to before endorsing")
+	maxPeers := flag.Int("max-peers", 3, "peers private data is disseminated to at most")
+	flag.Parse()
+
+	if len(mspIDs(*employers)) == 0 || len(mspIDs(*employees)) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: collections -employers MSP[,MSP...] -employees MSP[,MSP...] [-banks MSP[,MSP...]]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	// an org may take part as employer, employee and bank alike, but every employer
+	// keeps its payroll in collections no other employer is a member of
+	var collections []collection
+	for _, employer := range mspIDs(*employers) {
+		collections = append(collections,
+			collection{
+				Name:              chaincode.EmployerCollection(chaincode.ContractCollection, employer),
+				Policy:            memberPolicy(mspIDs(employer + "," + *employees)),
+				RequiredPeerCount: *requiredPeers,
+				MaxPeerCount:      *maxPeers,
+				MemberOnlyRead:    true,
+				MemberOnlyWrite:   true,
+			},
+			collection{
+				Name:              chaincode.EmployerCollection(chaincode.LedgerCollection, employer),
+				Policy:            memberPolicy(mspIDs(employer + "," + *employees + "," + *banks)),
+				RequiredPeerCount: *requiredPeers,
+				MaxPeerCount:      *maxPeers,
+				MemberOnlyRead:    true,
+				MemberOnlyWrite:   true,
+			},
+		)
+	}
+	collections = append(collections, collection{
+		Name:              chaincode.AccountCollection,
+		Policy:            memberPolicy(mspIDs(*employers + "," + *employees + "," + *banks)),
+		RequiredPeerCount: *requiredPeers,
+		MaxPeerCount:      *maxPeers,
+		MemberOnlyRead:    true,
+		MemberOnlyWrite:   true,
+	})
+
+	config, err := json.MarshalIndent(collections, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "collections:", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(config))
+}
+
+// mspIDs splits a comma-separated list of MSP IDs, dropping duplicates and blanks
+func mspIDs(list string) []string {
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(list, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// memberPolicy returns the signature policy satisfied by a member of any of the MSPs
+func memberPolicy(ids []string) string {
+	members := make([]string, len(ids))
+	for i, id := range ids {
+		members[i] = fmt.Sprintf("'%s.member'", id)
+	}
+	return fmt.Sprintf("OR(%s)", strings.Join(members, ", "))
+}
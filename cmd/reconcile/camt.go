@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// a booked statement line that is reconciled on its own: an entry, or one transaction
+// of a batched entry
+type statementItem struct {
+	File        string    // statement file the item was read from
+	StatementID string    // Stmt/Id of a camt.053 or Ntfctn/Id of a camt.054
+	EntryRef    string    // NtryRef, AcctSvcrRef or the position of the entry
+	EndToEndID  string    // EndToEndId of the transaction, empty if not provided
+	Amount      string    // booked amount, e.g. "1234.56"
+	Currency    string    // ISO 4217 code of the booked amount
+	CreditDebit string    // CRDT or DBIT
+	BookingDate time.Time // booking date of the entry
+}
+
+// StatementRef is the reference recorded on the ledger for a reconciled item
+func (item statementItem) StatementRef() string {
+	return item.StatementID + "/" + item.EntryRef
+}
+
+// the elements of camt.053 and camt.054 (versions 001.02 to 001.08) the tool reads.
+// Element names are matched regardless of the message namespace
+type camtDocument struct {
+	Statements    []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+	Notifications []camtStatement `xml:"BkToCstmrDbtCdtNtfctn>Ntfctn"`
+}
+
+type camtStatement struct {
+	ID      string      `xml:"Id"`
+	Entries []camtEntry `xml:"Ntry"`
+}
+
+type camtEntry struct {
+	NtryRef      string            `xml:"NtryRef"`
+	Amount       camtAmount        `xml:"Amt"`
+	CdtDbtInd    string            `xml:"CdtDbtInd"`
+	Status       camtStatus        `xml:"Sts"`
+	BookingDate  camtDate          `xml:"BookgDt"`
+	ValueDate    camtDate          `xml:"ValDt"`
+	AcctSvcrRef  string            `xml:"AcctSvcrRef"`
+	Transactions []camtTransaction `xml:"NtryDtls>TxDtls"`
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// entry status, a plain code up to version 001.06 and a Cd element after it
+type camtStatus struct {
+	Text string `xml:",chardata"`
+	Code string `xml:"Cd"`
+}
+
+func (s camtStatus) code() string {
+	if s.Code != "" {
+		return strings.TrimSpace(s.Code)
+	}
+	return strings.TrimSpace(s.Text)
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+func (d camtDate) parse() (time.Time, error) {
+	if d.Date != "" {
+		return time.Parse("2006-01-02", d.Date)
+	}
+	if d.DateTime != "" {
+		value, err := time.Parse(time.RFC3339, d.DateTime)
+		if err != nil {
+			// ISODateTime may omit the UTC offset
+			value, err = time.Parse("2006-01-02T15:04:05", d.DateTime)
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		year, month, day := value.Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Time{}, fmt.Errorf("no date")
+}
+
+type camtTransaction struct {
+	EndToEndID string     `xml:"Refs>EndToEndId"`
+	Amount     camtAmount `xml:"Amt"`               // from version 001.03
+	TxAmount   camtAmount `xml:"AmtDtls>TxAmt>Amt"` // up to version 001.02
+}
+
+// readStatement reads the booked items of a camt.053 or camt.054 file. Pending and
+// informational entries are skipped and counted
+func readStatement(file string) ([]statementItem, int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var document camtDocument
+	err = xml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", file, err)
+	}
+
+	statements := append(document.Statements, document.Notifications...)
+	if len(statements) == 0 {
+		return nil, 0, fmt.Errorf("%s: not a camt.053 statement or camt.054 notification", file)
+	}
+
+	var items []statementItem
+	skipped := 0
+	for _, statement := range statements {
+		for i, entry := range statement.Entries {
+			if entry.Status.code() != "BOOK" {
+				skipped++
+				continue
+			}
+
+			bookingDate, err := entry.BookingDate.parse()
+			if err != nil {
+				bookingDate, err = entry.ValueDate.parse()
+			}
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: entry %d of %s has no booking date", file, i+1, statement.ID)
+			}
+
+			entryRef := entry.NtryRef
+			if entryRef == "" {
+				entryRef = entry.AcctSvcrRef
+			}
+			if entryRef == "" {
+				entryRef = fmt.Sprintf("%d", i+1)
+			}
+
+			item := statementItem{
+				File:        file,
+				StatementID: statement.ID,
+				EntryRef:    entryRef,
+				Amount:      strings.TrimSpace(entry.Amount.Value),
+				Currency:    entry.Amount.Currency,
+				CreditDebit: entry.CdtDbtInd,
+				BookingDate: bookingDate,
+			}
+
+			if len(entry.Transactions) == 0 {
+				items = append(items, item)
+				continue
+			}
+			for j, transaction := range entry.Transactions {
+				txItem := item
+				txItem.EndToEndID = strings.TrimSpace(transaction.EndToEndID)
+				if txItem.EndToEndID == "NOTPROVIDED" {
+					txItem.EndToEndID = ""
+				}
+				if len(entry.Transactions) > 1 {
+					txItem.EntryRef = fmt.Sprintf("%s/%d", entryRef, j+1)
+					// a batched entry books the total, so each transaction needs its own amount
+					txItem.Amount, txItem.Currency = "", ""
+				}
+				amount := transaction.Amount
+				if amount.Value == "" {
+					amount = transaction.TxAmount
+				}
+				if amount.Value != "" {
+					txItem.Amount = strings.TrimSpace(amount.Value)
+					txItem.Currency = amount.Currency
+				}
+				items = append(items, txItem)
+			}
+		}
+	}
+
+	return items, skipped, nil
+}
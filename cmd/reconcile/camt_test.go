@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeStatement writes a statement file into a temporary directory
+func writeStatement(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+const camt053 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.08">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Id>STMT1</Id>
+      <Ntry>
+        <NtryRef>N1</NtryRef>
+        <Amt Ccy="EUR">1000.50</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts><Cd>BOOK</Cd></Sts>
+        <BookgDt><Dt>2024-03-04</Dt></BookgDt>
+        <NtryDtls><TxDtls><Refs><EndToEndId>E2E1</EndToEndId></Refs></TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">300.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts><Cd>BOOK</Cd></Sts>
+        <BookgDt><DtTm>2024-03-05T10:30:00</DtTm></BookgDt>
+        <AcctSvcrRef>BATCH7</AcctSvcrRef>
+        <NtryDtls>
+          <TxDtls><Refs><EndToEndId>E2E2</EndToEndId></Refs><Amt Ccy="EUR">100.00</Amt></TxDtls>
+          <TxDtls><Refs><EndToEndId>NOTPROVIDED</EndToEndId></Refs><Amt Ccy="EUR">200.00</Amt></TxDtls>
+        </NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">5.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts><Cd>PDNG</Cd></Sts>
+        <BookgDt><Dt>2024-03-05</Dt></BookgDt>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+const camt054 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.054.001.02">
+  <BkToCstmrDbtCdtNtfctn>
+    <Ntfctn>
+      <Id>NTF1</Id>
+      <Ntry>
+        <Amt Ccy="USD">75.25</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <ValDt><Dt>2024-03-06</Dt></ValDt>
+        <NtryDtls><TxDtls><Refs><EndToEndId> E2E3 </EndToEndId></Refs><AmtDtls><TxAmt><Amt Ccy="USD">75.25</Amt></TxAmt></AmtDtls></TxDtls></NtryDtls>
+      </Ntry>
+    </Ntfctn>
+  </BkToCstmrDbtCdtNtfctn>
+</Document>`
+
+// Booked entries are read from statements and notifications of old and new versions,
+// one item per transaction of a batched entry
+func TestReadStatement(t *testing.T) {
+	statement := writeStatement(t, "statement.xml", camt053)
+	items, skipped, err := readStatement(statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 1 {
+		t.Errorf("%d entries were skipped, want the pending one", skipped)
+	}
+	want := []statementItem{
+		{File: statement, StatementID: "STMT1", EntryRef: "N1", EndToEndID: "E2E1", Amount: "1000.50", Currency: "EUR", CreditDebit: "DBIT", BookingDate: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{File: statement, StatementID: "STMT1", EntryRef: "BATCH7/1", EndToEndID: "E2E2", Amount: "100.00", Currency: "EUR", CreditDebit: "DBIT", BookingDate: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{File: statement, StatementID: "STMT1", EntryRef: "BATCH7/2", Amount: "200.00", Currency: "EUR", CreditDebit: "DBIT", BookingDate: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("read %+v, want %+v", items, want)
+	}
+
+	notification := writeStatement(t, "notification.xml", camt054)
+	items, skipped, err = readStatement(notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []statementItem{
+		{File: notification, StatementID: "NTF1", EntryRef: "1", EndToEndID: "E2E3", Amount: "75.25", Currency: "USD", CreditDebit: "CRDT", BookingDate: time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC)},
+	}
+	if skipped != 0 || !reflect.DeepEqual(items, want) {
+		t.Errorf("read %+v and skipped %d, want %+v", items, skipped, want)
+	}
+	if ref := items[0].StatementRef(); ref != "NTF1/1" {
+		t.Errorf("the statement reference is %s", ref)
+	}
+}
+
+// Files that are not camt statements, or book entries without a date, are rejected
+func TestReadStatementRejectsInvalidFiles(t *testing.T) {
+	for name, content := range map[string]string{
+		"not camt": `<Document><CstmrCdtTrfInitn/></Document>`,
+		"not XML":  `BOOK 1000.50 EUR`,
+		"no date":  `<Document><BkToCstmrStmt><Stmt><Id>S</Id><Ntry><Amt Ccy="EUR">1</Amt><Sts>BOOK</Sts></Ntry></Stmt></BkToCstmrStmt></Document>`,
+	} {
+		if _, _, err := readStatement(writeStatement(t, "statement.xml", content)); err == nil {
+			t.Errorf("a statement that is %s was read", name)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// the payment a ledger holds under an end-to-end ID, as returned by the
+// GetPaymentByEndToEndID transaction
+type candidate struct {
+	PaymentID    string    `json:"PaymentID"`
+	Type         string    `json:"Type"`
+	Status       string    `json:"Status"`
+	EndToEndID   string    `json:"EndToEndID"`
+	Amounts      []string  `json:"Amounts"`
+	SettledAt    time.Time `json:"SettledAt"`
+	Reconciled   bool      `json:"Reconciled"`
+	StatementRef string    `json:"StatementRef"`
+}
+
+// the payroll chaincode as the reconciliation needs it
+type ledger interface {
+	// Lookup returns the payment exported under an end-to-end ID
+	Lookup(endToEndID string) (*candidate, error)
+	// Reconcile records the statement item a payment was matched to
+	Reconcile(item statementItem) error
+	Close()
+}
+
+// connection settings of a Fabric gateway peer and the bank client identity
+type gatewayConfig struct {
+	Endpoint   string // host:port of the gateway peer
+	TLSCert    string // PEM file of the peer's TLS CA certificate
+	ServerName string // TLS server name override, if the endpoint is not the peer's host name
+	MSPID      string // MSP ID of the bank org
+	Cert       string // PEM file of the client's signing certificate
+	Key        string // PEM file of the client's private key
+	Channel    string
+	Chaincode  string
+}
+
+type gatewayLedger struct {
+	connection *grpc.ClientConn
+	gateway    *client.Gateway
+	contract   *client.Contract
+}
+
+// connectGateway connects to the chaincode through a Fabric gateway peer
+func connectGateway(config gatewayConfig) (*gatewayLedger, error) {
+	tlsPEM, err := os.ReadFile(config.TLSCert)
+	if err != nil {
+		return nil, err
+	}
+	tlsCert, err := identity.CertificateFromPEM(tlsPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", config.TLSCert, err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsCert)
+
+	connection, err := grpc.Dial(config.Endpoint, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, config.ServerName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", config.Endpoint, err)
+	}
+
+	certPEM, err := os.ReadFile(config.Cert)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+	cert, err := identity.CertificateFromPEM(certPEM)
+	if err != nil {
+		connection.Close()
+		return nil, fmt.Errorf("%s: %v", config.Cert, err)
+	}
+	id, err := identity.NewX509Identity(config.MSPID, cert)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	keyPEM, err := os.ReadFile(config.Key)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+	key, err := identity.PrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		connection.Close()
+		return nil, fmt.Errorf("%s: %v", config.Key, err)
+	}
+	sign, err := identity.NewPrivateKeySign(key)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	gateway, err := client.Connect(id,
+		client.WithSign(sign),
+		client.WithClientConnection(connection),
+		client.WithEvaluateTimeout(10*time.Second),
+		client.WithEndorseTimeout(30*time.Second),
+		client.WithSubmitTimeout(10*time.Second),
+		client.WithCommitStatusTimeout(time.Minute),
+	)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	contract := gateway.GetNetwork(config.Channel).GetContract(config.Chaincode)
+	return &gatewayLedger{connection: connection, gateway: gateway, contract: contract}, nil
+}
+
+func (l *gatewayLedger) Lookup(endToEndID string) (*candidate, error) {
+	result, err := l.contract.EvaluateTransaction("GetPaymentByEndToEndID", endToEndID)
+	if err != nil {
+		return nil, err
+	}
+
+	var payment candidate
+	err = json.Unmarshal(result, &payment)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected GetPaymentByEndToEndID result: %v", err)
+	}
+	return &payment, nil
+}
+
+func (l *gatewayLedger) Reconcile(item statementItem) error {
+	_, err := l.contract.SubmitTransaction("ReconcilePayment",
+		item.EndToEndID,
+		item.Amount,
+		item.Currency,
+		item.BookingDate.Format("2006-01-02"),
+		item.StatementRef(),
+	)
+	return err
+}
+
+func (l *gatewayLedger) Close() {
+	l.gateway.Close()
+	l.connection.Close()
+}
@@ -0,0 +1,232 @@
+// Command reconcile matches camt.053 bank statements and camt.054 debit/credit
+// notifications against the bank payments settled on the payroll ledger.
+//
+// Booked statement items are matched to payments by the end-to-end ID the payments
+// were exported with, then checked against the amounts and the settlement date on the
+// ledger. Matches are recorded with the ReconcilePayment transaction; every other item
+// is written to a CSV break report.
+//
+//	reconcile -peer peer0.bank.example.com:7051 -tls-cert ca.pem -msp BankMSP \
+//		-cert cert.pem -key key.pem -report breaks.csv statement.xml...
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// reasons a statement item is reported as a break
+const (
+	breakUnmatched         = "Unmatched"         // no end-to-end ID, or none the ledger exported
+	breakAmountMismatch    = "AmountMismatch"    // booked amount is not one the payment settled
+	breakDateMismatch      = "DateMismatch"      // booked too far from the settlement date
+	breakNotCompleted      = "NotCompleted"      // the payment has not completed settlement
+	breakAlreadyReconciled = "AlreadyReconciled" // the payment was reconciled to another item
+	breakDuplicateItem     = "DuplicateItem"     // the end-to-end ID appears twice in the statements
+	breakRejected          = "Rejected"          // the ledger rejected the reconciliation
+)
+
+// a statement item that could not be reconciled
+type statementBreak struct {
+	Item      statementItem
+	PaymentID string
+	Reason    string
+	Detail    string
+}
+
+// totals printed after a run
+type summary struct {
+	Items      int
+	Skipped    int
+	Reconciled int
+	Previously int
+	Breaks     int
+}
+
+func main() {
+	var config gatewayConfig
+	flag.StringVar(&config.Endpoint, "peer", "localhost:7051", "host:port of the gateway peer")
+	flag.StringVar(&config.TLSCert, "tls-cert", "", "PEM file of the peer's TLS CA certificate")
+	flag.StringVar(&config.ServerName, "server-name", "", "TLS server name of the peer, if it differs from the endpoint host")
+	flag.StringVar(&config.MSPID, "msp", "", "MSP ID of the bank org")
+	flag.StringVar(&config.Cert, "cert", "", "PEM file of the bank client's certificate")
+	flag.StringVar(&config.Key, "key", "", "PEM file of the bank client's private key")
+	flag.StringVar(&config.Channel, "channel", "mychannel", "channel the chaincode is deployed on")
+	flag.StringVar(&config.Chaincode, "chaincode", "payroll", "name of the payroll chaincode")
+	report := flag.String("report", "breaks.csv", "file the break report is written to")
+	tolerance := flag.Int("date-tolerance", 3, "days a booking may differ from the settlement date, at most the ReconciliationDays policy of the ledger")
+	dryRun := flag.Bool("dry-run", false, "match and report without submitting reconciliations")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: reconcile [flags] statement.xml...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var items []statementItem
+	skipped := 0
+	for _, file := range flag.Args() {
+		fileItems, fileSkipped, err := readStatement(file)
+		if err != nil {
+			fatal(err)
+		}
+		items = append(items, fileItems...)
+		skipped += fileSkipped
+	}
+
+	gateway, err := connectGateway(config)
+	if err != nil {
+		fatal(err)
+	}
+	defer gateway.Close()
+
+	breaks, totals := reconcile(gateway, items, *tolerance, *dryRun)
+	totals.Skipped = skipped
+
+	err = writeBreakReport(*report, breaks)
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d booked items, %d reconciled, %d reconciled before, %d breaks written to %s; %d pending or informational entries skipped\n",
+		totals.Items, totals.Reconciled, totals.Previously, totals.Breaks, *report, totals.Skipped)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "reconcile:", err)
+	os.Exit(1)
+}
+
+// reconcile matches every item to the ledger and records the matches, unless dryRun
+func reconcile(l ledger, items []statementItem, tolerance int, dryRun bool) ([]statementBreak, summary) {
+	var breaks []statementBreak
+	totals := summary{Items: len(items)}
+	seen := map[string]string{}
+
+	for _, item := range items {
+		reject := func(paymentID string, reason string, detail string) {
+			breaks = append(breaks, statementBreak{Item: item, PaymentID: paymentID, Reason: reason, Detail: detail})
+		}
+
+		if item.EndToEndID == "" {
+			reject("", breakUnmatched, "the entry carries no end-to-end ID")
+			continue
+		}
+		if ref, ok := seen[item.EndToEndID]; ok {
+			reject("", breakDuplicateItem, fmt.Sprintf("the end-to-end ID was already booked by %s", ref))
+			continue
+		}
+		seen[item.EndToEndID] = item.StatementRef()
+
+		payment, err := l.Lookup(item.EndToEndID)
+		if err != nil {
+			reject("", breakUnmatched, err.Error())
+			continue
+		}
+
+		if payment.Reconciled {
+			if payment.StatementRef == item.StatementRef() {
+				totals.Previously++
+			} else {
+				reject(payment.PaymentID, breakAlreadyReconciled, fmt.Sprintf("reconciled to %s", payment.StatementRef))
+			}
+			continue
+		}
+
+		reason, detail := check(item, payment, tolerance)
+		if reason != "" {
+			reject(payment.PaymentID, reason, detail)
+			continue
+		}
+
+		if !dryRun {
+			err = l.Reconcile(item)
+			if err != nil {
+				reject(payment.PaymentID, breakRejected, err.Error())
+				continue
+			}
+		}
+		totals.Reconciled++
+	}
+
+	totals.Breaks = len(breaks)
+	return breaks, totals
+}
+
+// check returns why an item does not match the payment exported under its end-to-end
+// ID, or an empty reason if it does
+func check(item statementItem, payment *candidate, tolerance int) (string, string) {
+	booked, ok := new(big.Rat).SetString(item.Amount)
+	if item.Amount == "" || !ok {
+		return breakAmountMismatch, "the entry books no amount for the transaction"
+	}
+
+	matched := false
+	for _, amount := range payment.Amounts {
+		fields := strings.Fields(amount)
+		if len(fields) != 2 || fields[1] != item.Currency {
+			continue
+		}
+		settled, ok := new(big.Rat).SetString(fields[0])
+		if ok && settled.Cmp(booked) == 0 {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return breakAmountMismatch, fmt.Sprintf("booked %s %s, the payment settled %s", item.Amount, item.Currency, strings.Join(payment.Amounts, " / "))
+	}
+
+	if payment.Status != "Completed" {
+		return breakNotCompleted, fmt.Sprintf("the payment is %s", payment.Status)
+	}
+
+	year, month, day := payment.SettledAt.UTC().Date()
+	settled := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	days := int(item.BookingDate.Sub(settled).Hours() / 24)
+	if days < -tolerance || days > tolerance {
+		return breakDateMismatch, fmt.Sprintf("booked %s, the payment completed %s", item.BookingDate.Format("2006-01-02"), settled.Format("2006-01-02"))
+	}
+
+	return "", ""
+}
+
+// writeBreakReport writes the breaks as CSV, one line per statement item
+func writeBreakReport(file string, breaks []statementBreak) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(out)
+	writer.Write([]string{"Reason", "Detail", "File", "StatementID", "EntryRef", "EndToEndID", "CreditDebit", "Amount", "Currency", "BookingDate", "PaymentID"})
+	for _, b := range breaks {
+		writer.Write([]string{
+			b.Reason,
+			b.Detail,
+			b.Item.File,
+			b.Item.StatementID,
+			b.Item.EntryRef,
+			b.Item.EndToEndID,
+			b.Item.CreditDebit,
+			b.Item.Amount,
+			b.Item.Currency,
+			b.Item.BookingDate.Format("2006-01-02"),
+			b.PaymentID,
+		})
+	}
+	writer.Flush()
+
+	err = writer.Error()
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
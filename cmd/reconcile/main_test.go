@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// a ledger of candidates by end-to-end ID that records reconciliations
+type fakeLedger struct {
+	payments   map[string]*candidate
+	reject     map[string]bool
+	reconciled []string
+}
+
+func (l *fakeLedger) Lookup(endToEndID string) (*candidate, error) {
+	payment, ok := l.payments[endToEndID]
+	if !ok {
+		return nil, fmt.Errorf("no payment was exported with end-to-end ID %s", endToEndID)
+	}
+	return payment, nil
+}
+
+func (l *fakeLedger) Reconcile(item statementItem) error {
+	if l.reject[item.EndToEndID] {
+		return fmt.Errorf("endorsement failed")
+	}
+	l.reconciled = append(l.reconciled, item.EndToEndID)
+	return nil
+}
+
+func (l *fakeLedger) Close() {}
+
+// Items are matched by end-to-end ID and checked against the amounts, status and
+// completion date on the ledger; only matches are recorded
+func TestReconcile(t *testing.T) {
+	settled := time.Date(2024, 3, 4, 15, 0, 0, 0, time.UTC)
+	completed := func(id string, amounts ...string) *candidate {
+		return &candidate{PaymentID: "LOCAL-" + id, Status: "Completed", EndToEndID: id, Amounts: amounts, SettledAt: settled}
+	}
+	l := &fakeLedger{
+		payments: map[string]*candidate{
+			"OK":        completed("OK", "1000.50 EUR"),
+			"FX":        completed("FX", "100.00 USD", "100.00 USD", "92.10 EUR"),
+			"AMOUNT":    completed("AMOUNT", "1000.50 EUR"),
+			"LATE":      completed("LATE", "10.00 EUR"),
+			"PENDING":   {PaymentID: "LOCAL-PENDING", Status: "CreditedToMemberBank", Amounts: []string{"10.00 EUR"}},
+			"BEFORE":    {PaymentID: "LOCAL-BEFORE", Status: "Completed", Amounts: []string{"10.00 EUR"}, Reconciled: true, StatementRef: "STMT1/4"},
+			"ELSEWHERE": {PaymentID: "LOCAL-ELSEWHERE", Status: "Completed", Amounts: []string{"10.00 EUR"}, Reconciled: true, StatementRef: "STMT0/1"},
+			"REJECTED":  completed("REJECTED", "10.00 EUR"),
+		},
+		reject: map[string]bool{"REJECTED": true},
+	}
+	item := func(ref string, endToEndID string, amount string, currency string, days int) statementItem {
+		return statementItem{StatementID: "STMT1", EntryRef: ref, EndToEndID: endToEndID, Amount: amount, Currency: currency, BookingDate: time.Date(2024, 3, 4+days, 0, 0, 0, 0, time.UTC)}
+	}
+	items := []statementItem{
+		item("1", "OK", "1000.5", "EUR", 1),
+		item("2", "FX", "92.10", "EUR", -3),
+		item("3", "", "5.00", "EUR", 0),
+		item("4", "BEFORE", "10.00", "EUR", 0),
+		item("5", "OK", "1000.50", "EUR", 0),
+		item("6", "UNKNOWN", "10.00", "EUR", 0),
+		item("7", "AMOUNT", "1000.50", "USD", 0),
+		item("8", "LATE", "10.00", "EUR", 4),
+		item("9", "PENDING", "10.00", "EUR", 0),
+		item("10", "ELSEWHERE", "10.00", "EUR", 0),
+		item("11", "REJECTED", "10.00", "EUR", 0),
+		item("12", "NOAMOUNT", "", "", 0),
+	}
+	l.payments["NOAMOUNT"] = completed("NOAMOUNT", "10.00 EUR")
+
+	breaks, totals := reconcile(l, items, 3, false)
+	if want := []string{"OK", "FX"}; !reflect.DeepEqual(l.reconciled, want) {
+		t.Errorf("reconciled %v, want %v", l.reconciled, want)
+	}
+	if totals != (summary{Items: 12, Reconciled: 2, Previously: 1, Breaks: 9}) {
+		t.Errorf("the totals are %+v", totals)
+	}
+	reasons := map[string]string{}
+	for _, b := range breaks {
+		reasons[b.Item.EntryRef] = b.Reason
+	}
+	want := map[string]string{
+		"3":  breakUnmatched,
+		"5":  breakDuplicateItem,
+		"6":  breakUnmatched,
+		"7":  breakAmountMismatch,
+		"8":  breakDateMismatch,
+		"9":  breakNotCompleted,
+		"10": breakAlreadyReconciled,
+		"11": breakRejected,
+		"12": breakAmountMismatch,
+	}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("the breaks are %v, want %v", reasons, want)
+	}
+
+	// a dry run matches the same items without recording them
+	l.reconciled = nil
+	_, totals = reconcile(l, items, 3, true)
+	if len(l.reconciled) != 0 || totals.Reconciled != 3 {
+		t.Errorf("a dry run reconciled %v with totals %+v", l.reconciled, totals)
+	}
+}
@@ -0,0 +1,634 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key registering rate provider MSPs
+const rateProviderIndex = "RateProviderMSP"
+
+// object type of the composite key indexing FX rates by currency pair
+const fxRateIndex = "FxRate"
+
+// FX rates are held as integers of quote currency units per base currency unit,
+// scaled by rateScale (eight decimal places)
+const rateScale = 100000000
+
+// highest rate accepted, in quote currency units per base currency unit. It keeps
+// a scaled rate times basisPoints within int64 when the spread is applied
+const maxRate = 1000000
+
+// a rate published and signed by a rate provider for a currency pair
+type FxRate struct {
+	ID            string    `json:"ID"`            // Unique identifier for the rate
+	BaseCurrency  string    `json:"BaseCurrency"`  // Currency converted from
+	QuoteCurrency string    `json:"QuoteCurrency"` // Currency converted to
+	Rate          int64     `json:"Rate"`          // Mid rate in quote currency per base currency, scaled by 10^8
+	Spread        int64     `json:"Spread"`        // Basis points taken off the mid rate when converting
+	ValidFrom     time.Time `json:"ValidFrom"`     // Start of the validity window
+	ValidUntil    time.Time `json:"ValidUntil"`    // End of the validity window (exclusive)
+	Provider      string    `json:"Provider"`      // MSP of the rate provider
+	PublishedBy   string    `json:"PublishedBy"`   // Name of the client that published the rate
+	Hash          string    `json:"Hash"`          // Hex SHA-256 hash of the signed rate
+	Signature     string    `json:"Signature"`     // Base64 signature over the raw hash
+	Certificate   string    `json:"Certificate"`   // PEM certificate the signature was verified with
+	TxID          string    `json:"TxID"`          // Transaction that published the rate
+	PublishedAt   time.Time `json:"PublishedAt"`   // Transaction timestamp of the publication
+}
+
+// a rate locked for a cross-border payment until the end of the rate's validity window
+type FxQuote struct {
+	ID           string    `json:"ID"`           // Unique identifier for the quote
+	PaymentID    string    `json:"PaymentID"`    // ID of the cross-border payment
+	RateID       string    `json:"RateID"`       // ID of the locked rate
+	Rate         int64     `json:"Rate"`         // Locked mid rate, scaled by 10^8
+	Spread       int64     `json:"Spread"`       // Spread of the locked rate in basis points
+	Fee          Money     `json:"Fee"`          // Conversion fee in the source currency
+	SourceAmount Money     `json:"SourceAmount"` // Amount of the payment
+	TargetAmount Money     `json:"TargetAmount"` // Amount credited after fee and spread
+	ExpiresAt    time.Time `json:"ExpiresAt"`    // End of the validity window of the locked rate
+	QuotedBy     string    `json:"QuotedBy"`     // Name of the bank client that locked the rate
+	QuotedAt     time.Time `json:"QuotedAt"`     // Transaction timestamp of the quote
+
+	PrivateDataHash string `json:"PrivateDataHash"` // Salted hash of the amounts held in the ledger collection
+}
+
+// the conversion of a cross-border payment recorded when it reaches FxConverted
+type FxConversion struct {
+	QuoteID      string    `json:"QuoteID"`      // ID of the quote the conversion used
+	RateID       string    `json:"RateID"`       // ID of the rate the quote locked
+	Provider     string    `json:"Provider"`     // MSP of the rate provider
+	SourceAmount Money     `json:"SourceAmount"` // Amount converted from
+	TargetAmount Money     `json:"TargetAmount"` // Amount converted to
+	Rate         int64     `json:"Rate"`         // Mid rate, scaled by 10^8
+	Spread       int64     `json:"Spread"`       // Spread in basis points
+	Fee          Money     `json:"Fee"`          // Conversion fee in the source currency
+	ConvertedAt  time.Time `json:"ConvertedAt"`  // Transaction timestamp of the conversion
+}
+
+// parseRate parses a positive decimal rate with at most eight decimal places
+func parseRate(value string) (int64, error) {
+	rate, ok := new(big.Rat).SetString(value)
+	if !ok || rate.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	if rate.Cmp(new(big.Rat).SetInt64(maxRate)) > 0 {
+		return 0, fmt.Errorf("rate %q is above the highest rate of %d", value, maxRate)
+	}
+	rate.Mul(rate, new(big.Rat).SetInt64(rateScale))
+	if !rate.IsInt() || !rate.Num().IsInt64() {
+		return 0, fmt.Errorf("rate %q has more than eight decimal places", value)
+	}
+	return rate.Num().Int64(), nil
+}
+
+// spreadRate returns a scaled rate with a spread in basis points taken off, scaled
+// by rateScale*basisPoints. Rates recorded before they were bounded may not fit
+func spreadRate(rate int64, spread int64) (int64, error) {
+	product := new(big.Int).Mul(big.NewInt(rate), big.NewInt(basisPoints-spread))
+	if !product.IsInt64() {
+		return 0, fmt.Errorf("rate %d with a spread of %d basis points is out of range", rate, spread)
+	}
+	return product.Int64(), nil
+}
+
+// hashFxRate returns the hex SHA-256 hash of the terms of a rate a provider signs
+func hashFxRate(rate *FxRate) (string, error) {
+	termsJSON, err := json.Marshal(struct {
+		BaseCurrency  string    `json:"BaseCurrency"`
+		QuoteCurrency string    `json:"QuoteCurrency"`
+		Rate          int64     `json:"Rate"`
+		Spread        int64     `json:"Spread"`
+		ValidFrom     time.Time `json:"ValidFrom"`
+		ValidUntil    time.Time `json:"ValidUntil"`
+	}{rate.BaseCurrency, rate.QuoteCurrency, rate.Rate, rate.Spread, rate.ValidFrom, rate.ValidUntil})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(termsJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// newFxRate parses the terms of a rate from transaction arguments
+func newFxRate(baseCurrency string, quoteCurrency string, rate string, spread int64, validFrom string, validUntil string) (*FxRate, error) {
+	if _, err := CurrencyExponent(baseCurrency); err != nil {
+		return nil, err
+	}
+	if _, err := CurrencyExponent(quoteCurrency); err != nil {
+		return nil, err
+	}
+	if baseCurrency == quoteCurrency {
+		return nil, fmt.Errorf("a rate needs two different currencies")
+	}
+	if spread < 0 || spread >= basisPoints {
+		return nil, fmt.Errorf("spread of %d basis points is out of range", spread)
+	}
+
+	scaled, err := parseRate(rate)
+	if err != nil {
+		return nil, err
+	}
+	from, err := parseDate(validFrom)
+	if err != nil {
+		return nil, err
+	}
+	until, err := parseDate(validUntil)
+	if err != nil {
+		return nil, err
+	}
+	if !until.After(from) {
+		return nil, fmt.Errorf("rate validity window %s to %s is empty", validFrom, validUntil)
+	}
+
+	return &FxRate{
+		BaseCurrency:  baseCurrency,
+		QuoteCurrency: quoteCurrency,
+		Rate:          scaled,
+		Spread:        spread,
+		ValidFrom:     from.UTC(),
+		ValidUntil:    until.UTC(),
+	}, nil
+}
+
+// isRateProvider reports whether an MSP was registered as a rate provider
+func isRateProvider(ctx contractapi.TransactionContextInterface, mspID string) (bool, error) {
+	providerKey, err := ctx.GetStub().CreateCompositeKey(rateProviderIndex, []string{mspID})
+	if err != nil {
+		return false, fmt.Errorf("failed to create rate provider key: %v", err)
+	}
+
+	providerJSON, err := ctx.GetStub().GetState(providerKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	return providerJSON != nil, nil
+}
+
+// fxRates returns the rates published for a currency pair, oldest first
+func fxRates(ctx contractapi.TransactionContextInterface, baseCurrency string, quoteCurrency string) ([]*FxRate, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(fxRateIndex, []string{baseCurrency, quoteCurrency})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var rates []*FxRate
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var rate FxRate
+		err = json.Unmarshal(queryResponse.Value, &rate)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, &rate)
+	}
+
+	return rates, nil
+}
+
+// currentFxRate returns the latest published rate of a currency pair valid at a time.
+// Rates outside their validity window are stale and never used
+func currentFxRate(ctx contractapi.TransactionContextInterface, baseCurrency string, quoteCurrency string, at time.Time) (*FxRate, error) {
+	rates, err := fxRates(ctx, baseCurrency, quoteCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	var current *FxRate
+	for _, rate := range rates {
+		if at.Before(rate.ValidFrom) || !at.Before(rate.ValidUntil) {
+			continue
+		}
+		if current == nil || rate.PublishedAt.After(current.PublishedAt) {
+			current = rate
+		}
+	}
+	if current == nil {
+		return nil, fmt.Errorf("no valid %s/%s rate at %s", baseCurrency, quoteCurrency, at.Format(time.RFC3339))
+	}
+
+	return current, nil
+}
+
+// readCrossBorderPayment reads a cross-border payment from the world state, returning
+// it for settlement and as its record
+func readCrossBorderPayment(ctx contractapi.TransactionContextInterface, paymentID string) (*bankPayment, *CrossBorderPayment, error) {
+	payment, err := readBankPayment(ctx, paymentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	crossBorder, ok := payment.record.(*CrossBorderPayment)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a cross-border payment", paymentID)
+	}
+	return payment, crossBorder, nil
+}
+
+// targetCurrency returns the preferred currency of the account a contract pays into
+func targetCurrency(ctx contractapi.TransactionContextInterface, contractID string, at time.Time) (string, error) {
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return "", err
+	}
+
+	account, err := readAccount(ctx, contract.termsAt(at).AccountID)
+	if err != nil {
+		return "", err
+	}
+	if account.PreferredCurrency == "" {
+		return "", fmt.Errorf("account %s has no preferred currency to convert to", account.AccountID)
+	}
+
+	return account.PreferredCurrency, nil
+}
+
+// convertPayment records the conversion of a cross-border payment at its locked
+// quote. The quote must not have expired
+func convertPayment(ctx contractapi.TransactionContextInterface, payment *CrossBorderPayment) error {
+	if payment.QuoteID == "" {
+		return fmt.Errorf("cross-border payment %s has no locked FX quote", payment.ID)
+	}
+
+	quote, err := readFxQuote(ctx, payment.QuoteID)
+	if err != nil {
+		return err
+	}
+	err = loadFxQuoteAmounts(ctx, quote, payment.ContractID, false)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !now.Before(quote.ExpiresAt) {
+		return fmt.Errorf("FX quote %s of payment %s expired at %s", quote.ID, payment.ID, quote.ExpiresAt.Format(time.RFC3339))
+	}
+
+	rate, err := readFxRate(ctx, quote.RateID)
+	if err != nil {
+		return err
+	}
+
+	payment.Conversion = FxConversion{
+		QuoteID:      quote.ID,
+		RateID:       rate.ID,
+		Provider:     rate.Provider,
+		SourceAmount: quote.SourceAmount,
+		TargetAmount: quote.TargetAmount,
+		Rate:         quote.Rate,
+		Spread:       quote.Spread,
+		Fee:          quote.Fee,
+		ConvertedAt:  now,
+	}
+	return nil
+}
+
+// readFxRate reads a rate from the world state
+func readFxRate(ctx contractapi.TransactionContextInterface, rateID string) (*FxRate, error) {
+	rateJSON, err := ctx.GetStub().GetState(rateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if rateJSON == nil {
+		return nil, fmt.Errorf("the FX rate %s does not exist", rateID)
+	}
+
+	var rate FxRate
+	err = json.Unmarshal(rateJSON, &rate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rate, nil
+}
+
+// readFxQuote reads a quote from the world state
+func readFxQuote(ctx contractapi.TransactionContextInterface, quoteID string) (*FxQuote, error) {
+	quoteJSON, err := ctx.GetStub().GetState(quoteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if quoteJSON == nil {
+		return nil, fmt.Errorf("the FX quote %s does not exist", quoteID)
+	}
+
+	var quote FxQuote
+	err = json.Unmarshal(quoteJSON, &quote)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quote, nil
+}
+
+// RegisterRateProvider marks an MSP as a provider whose role=bank clients may publish FX rates
+func (s *PaymentContract) RegisterRateProvider(ctx contractapi.TransactionContextInterface, mspID string) error {
+	_, err := authorize(ctx, "RegisterRateProvider")
+	if err != nil {
+		return err
+	}
+
+	providerKey, err := ctx.GetStub().CreateCompositeKey(rateProviderIndex, []string{mspID})
+	if err != nil {
+		return fmt.Errorf("failed to create rate provider key: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(providerKey, []byte(mspID))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// HashFxRate returns the hash a rate provider must sign to publish a rate. The rate
+// is a decimal of quote currency per base currency, the window RFC 3339 timestamps
+func (s *PaymentContract) HashFxRate(ctx contractapi.TransactionContextInterface, baseCurrency string, quoteCurrency string, rate string, spread int64, validFrom string, validUntil string) (string, error) {
+	_, err := authorize(ctx, "HashFxRate")
+	if err != nil {
+		return "", err
+	}
+
+	fxRate, err := newFxRate(baseCurrency, quoteCurrency, rate, spread, validFrom, validUntil)
+	if err != nil {
+		return "", err
+	}
+
+	return hashFxRate(fxRate)
+}
+
+// PublishFxRate records a rate signed by the caller with the key of their client
+// certificate. Only clients of registered rate providers may publish, and rates
+// without a valid signature or whose window has already closed are rejected
+func (s *PaymentContract) PublishFxRate(ctx contractapi.TransactionContextInterface, baseCurrency string, quoteCurrency string, rate string, spread int64, validFrom string, validUntil string, signature string) (*FxRate, error) {
+	caller, err := authorize(ctx, "PublishFxRate")
+	if err != nil {
+		return nil, err
+	}
+	provider, err := isRateProvider(ctx, caller.MSPID)
+	if err != nil {
+		return nil, err
+	}
+	if !provider {
+		return nil, fmt.Errorf("MSP %s is not a registered rate provider", caller.MSPID)
+	}
+
+	fxRate, err := newFxRate(baseCurrency, quoteCurrency, rate, spread, validFrom, validUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !now.Before(fxRate.ValidUntil) {
+		return nil, fmt.Errorf("rate validity window ended at %s", fxRate.ValidUntil.Format(time.RFC3339))
+	}
+
+	hash, err := hashFxRate(fxRate)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if signature == "" {
+		return nil, fmt.Errorf("rate %s/%s is not signed", baseCurrency, quoteCurrency)
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("signature must be base64 encoded: %v", err)
+	}
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate: %v", err)
+	}
+	err = verifySignature(cert, digest, signatureBytes)
+	if err != nil {
+		return nil, fmt.Errorf("signature of %s over rate %s is invalid: %v", caller.Name, hash, err)
+	}
+
+	fxRate.ID = recordID(ctx, "FXRATE", baseCurrency, quoteCurrency)
+	fxRate.Provider = caller.MSPID
+	fxRate.PublishedBy = caller.Name
+	fxRate.Hash = hash
+	fxRate.Signature = signature
+	fxRate.Certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	fxRate.TxID = ctx.GetStub().GetTxID()
+	fxRate.PublishedAt = now
+
+	rateJSON, err := json.Marshal(fxRate)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(fxRate.ID, rateJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	indexKey, err := ctx.GetStub().CreateCompositeKey(fxRateIndex, []string{baseCurrency, quoteCurrency, fxRate.ID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FX rate key: %v", err)
+	}
+	err = ctx.GetStub().PutState(indexKey, rateJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return fxRate, nil
+}
+
+// GetFxRates returns every rate published for a currency pair
+func (s *PaymentContract) GetFxRates(ctx contractapi.TransactionContextInterface, baseCurrency string, quoteCurrency string) ([]*FxRate, error) {
+	_, err := authorize(ctx, "GetFxRates")
+	if err != nil {
+		return nil, err
+	}
+
+	return fxRates(ctx, baseCurrency, quoteCurrency)
+}
+
+// QuoteFxRate locks the current rate from the payment currency to the preferred
+// currency of the employee's account for a cross-border payment, charging a fee in
+// the payment currency. A new quote replaces the previous one until the payment is
+// converted. Only a registered rate provider can quote, and once the route is set only
+// its forex bank. The fee is capped by the FxFeeLimit of the contract's policy
+func (s *PaymentContract) QuoteFxRate(ctx contractapi.TransactionContextInterface, quoteID string, paymentID string, fee string) (*FxQuote, error) {
+	caller, err := authorize(ctx, "QuoteFxRate")
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := ctx.GetStub().GetState(quoteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("the FX quote %s already exists", quoteID)
+	}
+
+	payment, crossBorder, err := readCrossBorderPayment(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	switch payment.Status {
+	case BankPaymentPending, BankPaymentApproved, SettlementInitiated, CentralBankApproved:
+	default:
+		return nil, fmt.Errorf("cross-border payment %s is %s and can no longer be quoted", paymentID, payment.Status)
+	}
+	if payment.Route.FxBank != "" && payment.Route.FxBank != caller.MSPID {
+		return nil, fmt.Errorf("only MSP %s can quote payment %s", payment.Route.FxBank, paymentID)
+	}
+	if payment.Route.FxBank == "" {
+		provider, err := isRateProvider(ctx, caller.MSPID)
+		if err != nil {
+			return nil, err
+		}
+		if !provider {
+			return nil, fmt.Errorf("MSP %s is not a registered rate provider", caller.MSPID)
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currency, err := targetCurrency(ctx, payment.ContractID, now)
+	if err != nil {
+		return nil, err
+	}
+	if currency == payment.Amount.Currency {
+		return nil, fmt.Errorf("cross-border payment %s is already in %s", paymentID, currency)
+	}
+
+	rate, err := currentFxRate(ctx, payment.Amount.Currency, currency, now)
+	if err != nil {
+		return nil, err
+	}
+
+	feeAmount, err := ParseMoney(fee, payment.Amount.Currency, RoundExact)
+	if err != nil {
+		return nil, err
+	}
+	if feeAmount.IsNegative() {
+		return nil, fmt.Errorf("fee %s must not be negative", feeAmount)
+	}
+	contract, err := readContract(ctx, payment.ContractID)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := effectivePolicy(ctx, contract.Employer, contract.ID)
+	if err != nil {
+		return nil, err
+	}
+	maxFee, err := payment.Amount.MulRat(policy.FxFeeLimit, basisPoints, RoundDown)
+	if err != nil {
+		return nil, err
+	}
+	exceeded, err := exceedsLimit(feeAmount, maxFee)
+	if err != nil {
+		return nil, err
+	}
+	if exceeded {
+		return nil, fmt.Errorf("fee %s exceeds %s, %d basis points of the payment", feeAmount, maxFee, policy.FxFeeLimit)
+	}
+	converted, err := payment.Amount.Sub(feeAmount)
+	if err != nil {
+		return nil, err
+	}
+	if !converted.IsPositive() {
+		return nil, fmt.Errorf("fee %s leaves nothing of %s to convert", feeAmount, payment.Amount)
+	}
+
+	// the spread is taken off the mid rate, and the converted amount rounded down
+	numerator, err := spreadRate(rate.Rate, rate.Spread)
+	if err != nil {
+		return nil, err
+	}
+	target, err := converted.Convert(currency, numerator, rateScale*basisPoints, RoundDown)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := FxQuote{
+		ID:           quoteID,
+		PaymentID:    paymentID,
+		RateID:       rate.ID,
+		Rate:         rate.Rate,
+		Spread:       rate.Spread,
+		Fee:          feeAmount,
+		SourceAmount: payment.Amount,
+		TargetAmount: target,
+		ExpiresAt:    rate.ValidUntil,
+		QuotedBy:     caller.Name,
+		QuotedAt:     now,
+	}
+
+	// the amounts are private, so the quote is returned as written to the world state
+	public, err := putFxQuoteAmounts(ctx, &quote, payment.ContractID)
+	if err != nil {
+		return nil, err
+	}
+	quoteJSON, err := json.Marshal(public)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(quoteID, quoteJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	crossBorder.QuoteID = quoteID
+	err = payment.put(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return public, nil
+}
+
+// GetFxQuote returns a quote locked for a cross-border payment
+func (s *PaymentContract) GetFxQuote(ctx contractapi.TransactionContextInterface, quoteID string) (*FxQuote, error) {
+	quote, err := readFxQuote(ctx, quoteID)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := getBankPayment(ctx, quote.PaymentID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = authorizeRead(ctx, "GetFxQuote", payment.ContractID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadFxQuoteAmounts(ctx, quote, payment.ContractID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return quote, nil
+}
@@ -0,0 +1,54 @@
+package chaincode
+
+import (
+	"math"
+	"testing"
+)
+
+// Rates are bounded so that taking the spread off a scaled rate cannot overflow
+func TestRatesAreBounded(t *testing.T) {
+	for _, value := range []string{"0", "-1", "1000000.00000001", "1e30", "1.000000001"} {
+		if _, err := parseRate(value); err == nil {
+			t.Errorf("rate %q was accepted", value)
+		}
+	}
+
+	rate, err := parseRate("1000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := spreadRate(rate, 0); err != nil {
+		t.Errorf("the highest rate overflows: %v", err)
+	}
+	if _, err := spreadRate(math.MaxInt64/basisPoints+1, 0); err == nil {
+		t.Error("an overflowing rate was not rejected")
+	}
+}
+
+// The FX fee limit must leave part of the payment to convert
+func TestFxFeeLimitIsValidated(t *testing.T) {
+	for _, limit := range []int64{-1, basisPoints} {
+		config := PolicyConfig{FxFeeLimit: limit}
+		if err := config.validate(); err == nil {
+			t.Errorf("an FX fee limit of %d was accepted", limit)
+		}
+	}
+}
+
+// Only a registered rate provider can quote a payment before its route names a forex bank
+func TestOnlyRateProvidersQuote(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+	fundWallet(l, s, p, "C1")
+
+	paymentID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "100.00", CrossBorder)
+	l.must(err)
+
+	_, err = s.QuoteFxRate(l.begin(p.bank, nil), "Q1", paymentID, "0")
+	if err == nil || err.Error() != "MSP BankMSP is not a registered rate provider" {
+		t.Errorf("a bank that provides no rates quoted payment %s: %v", paymentID, err)
+	}
+}
@@ -0,0 +1,122 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key storing idempotency keys
+const idempotencyIndex = "IdempotencyKey"
+
+// the result of a money-moving transaction submitted with a client-supplied
+// idempotency key. Keys are scoped to the submitting client identity
+type IdempotencyRecord struct {
+	Key         string    `json:"Key"`         // Idempotency key supplied by the client
+	Caller      string    `json:"Caller"`      // Unique identity of the client that used the key
+	Transaction string    `json:"Transaction"` // Transaction the key was used with
+	Fingerprint string    `json:"Fingerprint"` // Hex SHA-256 hash of the transaction name and parameters, salted for private contracts
+	Result      string    `json:"Result"`      // ID of the record the transaction created
+	TxID        string    `json:"TxID"`        // Transaction that used the key first
+	CreatedAt   time.Time `json:"CreatedAt"`   // Transaction timestamp of the first use
+}
+
+// fingerprint returns the hex SHA-256 hash of a transaction name and its parameters,
+// preceded by a salt unless it is empty
+func fingerprint(salt string, transaction string, params ...string) string {
+	fields := append([]string{transaction}, params...)
+	if salt != "" {
+		fields = append([]string{salt}, fields...)
+	}
+	hash := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+// claimIdempotencyKey looks up an idempotency key of the caller. It reports a replay
+// with the original record when the key was used before with the same transaction
+// and parameters, and rejects a key used with others. Otherwise it returns the record
+// to complete once the transaction has its result. An empty key claims nothing. The
+// parameters of a transaction on a contract whose pay is private carry its amounts, so
+// they are fingerprinted with a salt of the contract's ledger, which stays the same
+// when the pay is amended
+func claimIdempotencyKey(ctx contractapi.TransactionContextInterface, caller *identity, transaction string, key string, contractID string, params ...string) (*IdempotencyRecord, bool, error) {
+	if key == "" {
+		return nil, false, nil
+	}
+
+	salt := ""
+	if contractID != "" {
+		var err error
+		_, salt, err = ledgerAmountsSalt(ctx, contractID, key)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	stateKey, err := ctx.GetStub().CreateCompositeKey(idempotencyIndex, []string{caller.ID, key})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create idempotency key: %v", err)
+	}
+	recordJSON, err := ctx.GetStub().GetState(stateKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	digest := fingerprint(salt, transaction, params...)
+	if recordJSON != nil {
+		var record IdempotencyRecord
+		err = json.Unmarshal(recordJSON, &record)
+		if err != nil {
+			return nil, false, err
+		}
+		if record.Fingerprint != digest {
+			return nil, false, fmt.Errorf("idempotency key %s was already used by %s with different parameters", key, record.Transaction)
+		}
+		return &record, true, nil
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &IdempotencyRecord{
+		Key:         key,
+		Caller:      caller.ID,
+		Transaction: transaction,
+		Fingerprint: digest,
+		TxID:        ctx.GetStub().GetTxID(),
+		CreatedAt:   now,
+	}, false, nil
+}
+
+// completeIdempotencyKey stores the result of a transaction under the idempotency key
+// claimed for it. A nil record, claimed with an empty key, is not stored
+func completeIdempotencyKey(ctx contractapi.TransactionContextInterface, record *IdempotencyRecord, result string) error {
+	if record == nil {
+		return nil
+	}
+	record.Result = result
+
+	stateKey, err := ctx.GetStub().CreateCompositeKey(idempotencyIndex, []string{record.Caller, record.Key})
+	if err != nil {
+		return fmt.Errorf("failed to create idempotency key: %v", err)
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(stateKey, recordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
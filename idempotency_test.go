@@ -0,0 +1,40 @@
+package chaincode
+
+import (
+	"testing"
+	"time"
+)
+
+// Retrying a money-moving transaction with its idempotency key returns the first
+// result instead of moving the money again
+func TestRetriesWithIdempotencyKeyDoNotRepeat(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	march := PayrollInterval{StartDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)}
+	run, err := s.RunPayroll(l.begin(p.employer, nil), "run-1", p.employer.name, march)
+	l.must(err)
+	retried, err := s.RunPayroll(l.begin(p.employer, nil), "run-1", p.employer.name, march)
+	l.must(err)
+	if retried.ID != run.ID || retried.Paid != run.Paid {
+		t.Errorf("the retried run %+v is not the run %+v", retried, run)
+	}
+
+	l.must(s.AdvanceRequest(l.begin(p.employee, nil), "ADV1", "C1", p.employee.name, "100.00", 2))
+	l.must(s.ApproveAdvanceRequest(l.begin(p.employer, nil), "approve-1", "ADV1", "0", 0))
+	l.must(s.ApproveAdvanceRequest(l.begin(p.employer, nil), "approve-1", "ADV1", "0", 0))
+	l.must(s.RepayAdvance(l.begin(p.employee, nil), "repay-1", "ADV1", "10.00"))
+	l.must(s.RepayAdvance(l.begin(p.employee, nil), "repay-1", "ADV1", "10.00"))
+
+	requests, err := s.GetAdvanceRequests(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	if want, _ := NewMoney(9000, "EUR"); len(requests) != 1 || requests[0].Outstanding != want {
+		t.Errorf("the advance was repaid more than once: %+v", requests)
+	}
+	if err := s.RepayAdvance(l.begin(p.employee, nil), "repay-1", "ADV1", "20.00"); err == nil {
+		t.Error("an idempotency key was reused with other parameters")
+	}
+}
@@ -0,0 +1,340 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// an ISO 20022 message recorded on the ledger when it is exported, by its hash only;
+// see renderPrivateDocument
+type ExportedMessage struct {
+	MessageID       string    `json:"MessageID"`       // MsgId of the message
+	Type            string    `json:"Type"`            // pain.001 or pacs.008
+	PaymentInfoID   string    `json:"PaymentInfoID"`   // PmtInfId of a pain.001 message
+	DebtorAccountID string    `json:"DebtorAccountID"` // Account the payments are paid from
+	PaymentIDs      []string  `json:"PaymentIDs"`      // Cross-border and local payments in the message
+	DocumentHash    string    `json:"DocumentHash"`    // SHA-256 of the rendered message
+	ExportedBy      string    `json:"ExportedBy"`      // Name of the client that exported the message
+	ExportedAt      time.Time `json:"ExportedAt"`      // Transaction timestamp of the export
+}
+
+// isoIdentifier derives an ISO 20022 identifier of at most 35 characters from a seed,
+// so that every endorsing peer derives the same one
+func isoIdentifier(prefix string, seed string) string {
+	hash := sha256.Sum256([]byte(seed))
+	return strings.ToUpper(prefix + hex.EncodeToString(hash[:]))[:35]
+}
+
+// documentHash returns the hex SHA-256 of a rendered document
+func documentHash(document []byte) string {
+	hash := sha256.Sum256(document)
+	return hex.EncodeToString(hash[:])
+}
+
+// renderPrivateDocument renders an exported message or generated batch file again for
+// the client. These documents hold the private bank details of the debtor and the
+// creditors, and a submitted response is written to the block, so the submit records
+// only the document's hash and the document is rendered by an evaluated query that
+// writes nothing. The bank details are only returned to clients of the org of the
+// peer, to the owner of the debtor account or a bank, and only while the document
+// still matches the recorded hash
+func renderPrivateDocument(ctx contractapi.TransactionContextInterface, caller *identity, what string, debtorAccountID string, recordedHash string, render func(debtor PartyAccount) ([]byte, error)) (string, error) {
+	visible, err := clientOrgIsPeerOrg(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !visible {
+		return "", fmt.Errorf("%s carries private bank details and must be rendered through a peer of the client's org", what)
+	}
+
+	debtorAccount, debtor, err := partyAccountOf(ctx, debtorAccountID)
+	if err != nil {
+		return "", err
+	}
+	if caller.Role == RoleEmployer && !caller.is(debtorAccount.OwnerIdentity) {
+		return "", fmt.Errorf("%s cannot render %s, it is paid from account %s", caller.Name, what, debtorAccountID)
+	}
+
+	document, err := render(debtor)
+	if err != nil {
+		return "", err
+	}
+	if documentHash(document) != recordedHash {
+		return "", fmt.Errorf("the bank details of %s changed since it was recorded", what)
+	}
+
+	return string(document), nil
+}
+
+// partyAccountOf returns the name, bank account and BIC of an account from its
+// private details, which the peer must hold and the client's org be allowed to see
+func partyAccountOf(ctx contractapi.TransactionContextInterface, accountID string) (*Account, PartyAccount, error) {
+	account, err := readAccount(ctx, accountID)
+	if err != nil {
+		return nil, PartyAccount{}, err
+	}
+	if account.Status != AccountOpen {
+		return nil, PartyAccount{}, fmt.Errorf("the account %s is %s", accountID, account.Status)
+	}
+
+	details, err := getAccountPrivateDetails(ctx, accountID)
+	if err != nil {
+		return nil, PartyAccount{}, err
+	}
+	if details == nil {
+		return nil, PartyAccount{}, fmt.Errorf("this peer holds no bank details of account %s", accountID)
+	}
+
+	name := account.Company
+	if name == "" {
+		name = account.Owner
+	}
+	return account, PartyAccount{Name: name, Account: details.BankAccount, BIC: details.BankBIC, RoutingNumber: details.RoutingNumber}, nil
+}
+
+// creditTransferOf returns the credit transfer of a bank payment into the contract's
+// account. Converted cross-border payments are settled in pacs.008 in the target currency
+func creditTransferOf(ctx contractapi.TransactionContextInterface, payment *bankPayment, contract *Contract, messageType string, now time.Time) (CreditTransfer, error) {
+	_, creditor, err := partyAccountOf(ctx, contract.termsAt(now).AccountID)
+	if err != nil {
+		return CreditTransfer{}, err
+	}
+
+	if payment.EndToEndID == "" {
+		payment.EndToEndID = isoIdentifier("E2E", payment.ID)
+		err = putEndToEndIndex(ctx, payment.EndToEndID, payment.ID)
+		if err != nil {
+			return CreditTransfer{}, err
+		}
+	}
+
+	transfer := CreditTransfer{
+		InstructionID: isoIdentifier("I", payment.ID),
+		EndToEndID:    payment.EndToEndID,
+		Amount:        payment.Amount,
+		Creditor:      creditor,
+		Remittance:    fmt.Sprintf("Pay under contract %s", contract.ID),
+	}
+
+	crossBorder, ok := payment.record.(*CrossBorderPayment)
+	if ok && messageType == Pacs008 && crossBorder.Conversion.QuoteID != "" {
+		conversion := crossBorder.Conversion
+		transfer.Amount = conversion.TargetAmount
+		transfer.InstructedAmount = conversion.SourceAmount
+		numerator, err := spreadRate(conversion.Rate, conversion.Spread)
+		if err != nil {
+			return CreditTransfer{}, err
+		}
+		transfer.ExchangeRate = big.NewRat(numerator, rateScale*basisPoints)
+	}
+
+	return transfer, nil
+}
+
+// readExportedMessage reads an exported ISO 20022 message by its message ID
+func readExportedMessage(ctx contractapi.TransactionContextInterface, messageID string) (*ExportedMessage, error) {
+	messageJSON, err := ctx.GetStub().GetState(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if messageJSON == nil {
+		return nil, fmt.Errorf("the settlement message %s does not exist", messageID)
+	}
+
+	var message ExportedMessage
+	err = json.Unmarshal(messageJSON, &message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// render renders the message paid from the debtor's account with the credit
+// transfers of its payments as of the export
+func (m *ExportedMessage) render(ctx contractapi.TransactionContextInterface, debtor PartyAccount, payments []*bankPayment) ([]byte, error) {
+	batch := CreditTransferBatch{
+		MessageID:       m.MessageID,
+		PaymentInfoID:   m.PaymentInfoID,
+		CreatedAt:       m.ExportedAt,
+		ExecutionDate:   startOfDay(m.ExportedAt),
+		InitiatingParty: debtor.Name,
+		Debtor:          debtor,
+		ChargeBearer:    "SHAR",
+	}
+	for _, payment := range payments {
+		contract, err := readContract(ctx, payment.ContractID)
+		if err != nil {
+			return nil, err
+		}
+
+		transfer, err := creditTransferOf(ctx, payment, contract, m.Type, m.ExportedAt)
+		if err != nil {
+			return nil, err
+		}
+		batch.Transfers = append(batch.Transfers, transfer)
+	}
+
+	var document []byte
+	var err error
+	if m.Type == Pain001 {
+		document, err = RenderPain001(&batch)
+	} else {
+		document, err = RenderPacs008(&batch)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s message: %v", m.Type, err)
+	}
+
+	return document, nil
+}
+
+// ExportSettlementMessage exports approved or initiated cross-border and local
+// payments of one employer in an ISO 20022 pain.001 or pacs.008 message paid from the
+// employer's debtor account. Cross-border payments are only exported in pacs.008 once
+// converted, so the message carries the conversion and renders the same afterwards. A
+// payment is exported in at most one message of each type. The message is validated
+// and recorded with its hash, and its message ID is stamped on each payment. The
+// response is written to the ledger, so it holds no bank details; the message itself
+// is fetched with RenderSettlementMessage
+func (s *PaymentContract) ExportSettlementMessage(ctx contractapi.TransactionContextInterface, messageType string, debtorAccountID string, paymentIDs []string) (*ExportedMessage, error) {
+	caller, err := authorize(ctx, "ExportSettlementMessage")
+	if err != nil {
+		return nil, err
+	}
+	if messageType != Pain001 && messageType != Pacs008 {
+		return nil, fmt.Errorf("unknown ISO 20022 message type %q, use %s or %s", messageType, Pain001, Pacs008)
+	}
+
+	debtorAccount, debtor, err := partyAccountOf(ctx, debtorAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	message := ExportedMessage{
+		MessageID:       isoIdentifier("MSG", ctx.GetStub().GetTxID()),
+		Type:            messageType,
+		PaymentInfoID:   isoIdentifier("PMT", ctx.GetStub().GetTxID()),
+		DebtorAccountID: debtorAccountID,
+		ExportedBy:      caller.Name,
+		ExportedAt:      now,
+	}
+
+	var payments []*bankPayment
+	seen := map[string]bool{}
+	for _, paymentID := range paymentIDs {
+		if seen[paymentID] {
+			return nil, fmt.Errorf("payment %s is listed twice", paymentID)
+		}
+		seen[paymentID] = true
+
+		payment, err := readBankPayment(ctx, paymentID)
+		if err != nil {
+			return nil, err
+		}
+		if messageType == Pacs008 && payment.Type == CrossBorder {
+			if payment.Status != FxConverted {
+				return nil, fmt.Errorf("cross-border payment %s is %s, it is exported in %s once %s", paymentID, payment.Status, Pacs008, FxConverted)
+			}
+		} else if payment.Status != BankPaymentApproved && payment.Status != SettlementInitiated {
+			return nil, fmt.Errorf("payment %s is %s, only Approved or Initiated payments are exported", paymentID, payment.Status)
+		}
+		for _, exported := range payment.Messages {
+			if exported.Type == messageType {
+				return nil, fmt.Errorf("payment %s was already exported in %s message %s", paymentID, messageType, exported.MessageID)
+			}
+		}
+		if local, ok := payment.record.(*LocalPayment); ok && local.FileID != "" {
+			return nil, fmt.Errorf("payment %s was included in batch file %s", paymentID, local.FileID)
+		}
+
+		contract, err := readContract(ctx, payment.ContractID)
+		if err != nil {
+			return nil, err
+		}
+		if !contract.EmployerIdentity.matches(debtorAccount.OwnerIdentity) {
+			return nil, fmt.Errorf("payment %s is owed by %s, not by the owner of account %s", paymentID, contract.Employer, debtorAccountID)
+		}
+		if caller.Role == RoleEmployer && !caller.isEmployerOf(contract) {
+			return nil, fmt.Errorf("%s cannot export payments of %s", caller.Name, contract.Employer)
+		}
+
+		payments = append(payments, payment)
+		message.PaymentIDs = append(message.PaymentIDs, paymentID)
+	}
+
+	// rendering assigns the end-to-end IDs of payments exported for the first time
+	document, err := message.render(ctx, debtor, payments)
+	if err != nil {
+		return nil, err
+	}
+	message.DocumentHash = documentHash(document)
+
+	for _, payment := range payments {
+		payment.Messages = append(payment.Messages, SettlementMessage{
+			MessageID:  message.MessageID,
+			Type:       messageType,
+			ExportedBy: caller.Name,
+			ExportedAt: now,
+		})
+		err = removeApprovedLocal(ctx, payment)
+		if err != nil {
+			return nil, err
+		}
+		err = payment.put(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(message.MessageID, messageJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return &message, nil
+}
+
+// RenderSettlementMessage renders an exported ISO 20022 message from its payments and
+// the bank details in the account collection. It is evaluated, not submitted; see
+// renderPrivateDocument
+func (s *PaymentContract) RenderSettlementMessage(ctx contractapi.TransactionContextInterface, messageID string) (string, error) {
+	caller, err := authorize(ctx, "RenderSettlementMessage")
+	if err != nil {
+		return "", err
+	}
+
+	message, err := readExportedMessage(ctx, messageID)
+	if err != nil {
+		return "", err
+	}
+
+	return renderPrivateDocument(ctx, caller, "settlement message "+messageID, message.DebtorAccountID, message.DocumentHash, func(debtor PartyAccount) ([]byte, error) {
+		var payments []*bankPayment
+		for _, paymentID := range message.PaymentIDs {
+			payment, err := readBankPayment(ctx, paymentID)
+			if err != nil {
+				return nil, err
+			}
+			payments = append(payments, payment)
+		}
+
+		return message.render(ctx, debtor, payments)
+	})
+}
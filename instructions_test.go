@@ -0,0 +1,87 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// openDebtorAccount opens the employer's account the bank payments are paid from
+func openDebtorAccount(l *testLedger, s *PaymentContract, p *testParties, accountID string) {
+	l.t.Helper()
+
+	account := map[string]interface{}{transientAccountKey: transientAccount{BankAccount: "FR1420041010050500013M02606", BankBIC: "BNPAFRPP", Salt: "debtor-salt-0123456789"}}
+	l.must(s.CreateAccount(l.begin(p.employer, account), accountID, "Acme", "", "EUR"))
+}
+
+// An export records the message on the ledger without its bank details, which only
+// the evaluated render returns
+func TestExportedMessagesAreRenderedOffLedger(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+	fundWallet(l, s, p, "C1")
+	openDebtorAccount(l, s, p, "ACME1")
+
+	paymentID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", Local)
+	l.must(err)
+	l.must(s.ProcessLocalPayment(l.begin(p.bank, nil), paymentID, l.clock.Format(dateLayout)))
+
+	message, err := s.ExportSettlementMessage(l.begin(p.employer, nil), Pain001, "ACME1", []string{paymentID})
+	l.must(err)
+	response, err := json.Marshal(message)
+	l.must(err)
+	for key, value := range l.stub.State {
+		if strings.Contains(string(value), "FR1420041010050500013M02606") || strings.Contains(string(value), "DE89370400440532013000") {
+			t.Errorf("the ledger holds bank details under %q", key)
+		}
+	}
+	if strings.Contains(string(response), "DE89370400440532013000") || message.DocumentHash == "" || len(message.PaymentIDs) != 1 {
+		t.Errorf("the export returned %s", response)
+	}
+
+	document, err := s.RenderSettlementMessage(l.begin(p.employer, nil), message.MessageID)
+	l.must(err)
+	if len(l.stub.writes) != 0 {
+		t.Errorf("rendering wrote %v", l.writeSet())
+	}
+	if !strings.Contains(document, "<MsgId>"+message.MessageID+"</MsgId>") || !strings.Contains(document, "<IBAN>DE89370400440532013000</IBAN>") {
+		t.Errorf("the rendered message is %s", document)
+	}
+
+	if _, err := s.RenderSettlementMessage(l.begin(p.employee, nil), message.MessageID); err == nil {
+		t.Error("the employee rendered the employer's message")
+	}
+}
+
+// A payment is instructed once per message type, and a cross-border payment is only
+// exported in pacs.008 with its conversion
+func TestPaymentsAreExportedOncePerMessageType(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+	fundWallet(l, s, p, "C1")
+	openDebtorAccount(l, s, p, "ACME1")
+
+	paymentID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", Local)
+	l.must(err)
+	l.must(s.ProcessLocalPayment(l.begin(p.bank, nil), paymentID, l.clock.Format(dateLayout)))
+	message, err := s.ExportSettlementMessage(l.begin(p.employer, nil), Pain001, "ACME1", []string{paymentID})
+	l.must(err)
+	_, err = s.ExportSettlementMessage(l.begin(p.employer, nil), Pain001, "ACME1", []string{paymentID})
+	if err == nil || err.Error() != "payment "+paymentID+" was already exported in pain.001 message "+message.MessageID {
+		t.Errorf("the payment was instructed twice: %v", err)
+	}
+
+	crossBorderID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "100.00", CrossBorder)
+	l.must(err)
+	l.must(s.ApproveCrossBorderPayment(l.begin(p.bank, nil), crossBorderID))
+	_, err = s.ExportSettlementMessage(l.begin(p.bank, nil), Pacs008, "ACME1", []string{crossBorderID})
+	if err == nil || !strings.HasPrefix(err.Error(), "cross-border payment "+crossBorderID+" is Approved") {
+		t.Errorf("an unconverted payment was exported in pacs.008: %v", err)
+	}
+}
@@ -0,0 +1,486 @@
+package chaincode
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Constants for the ISO 20022 messages settlement instructions are rendered into
+const (
+	Pain001 = "pain.001" // customer credit transfer initiation, pain.001.001.09
+	Pacs008 = "pacs.008" // FI to FI customer credit transfer, pacs.008.001.08
+)
+
+// XML namespaces of the rendered message versions
+const (
+	pain001Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.001.001.09"
+	pacs008Namespace = "urn:iso:std:iso:20022:tech:xsd:pacs.008.001.08"
+)
+
+// identifier used by ISO 20022 when a financial institution is not known by BIC
+const notProvided = "NOTPROVIDED"
+
+// patterns of the ISO 20022 simple types the messages use
+var (
+	bicPattern      = regexp.MustCompile(`^[A-Z0-9]{4}[A-Z]{2}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+	ibanPattern     = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[a-zA-Z0-9]{1,30}$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// a party of a credit transfer with the account and bank it is paid from or into
+type PartyAccount struct {
+	Name    string // Name of the party
+	Account string // IBAN, or another identifier of the account
+	BIC     string // BIC of the bank holding the account, empty if not known
+
+	RoutingNumber string // ABA routing number of a US bank account, used by NACHA files
+}
+
+// a credit transfer rendered into ISO 20022 messages
+type CreditTransfer struct {
+	InstructionID    string       // Identifier of the instruction between the parties, optional
+	EndToEndID       string       // Identifier carried unchanged to the creditor
+	Amount           Money        // Amount settled
+	InstructedAmount Money        // Amount instructed before currency conversion, zero if not converted
+	ExchangeRate     *big.Rat     // Rate from the instructed to the settled currency, nil if not converted
+	Creditor         PartyAccount // Party paid
+	Remittance       string       // Unstructured remittance information, optional
+}
+
+// credit transfers from one debtor account rendered into a single message
+type CreditTransferBatch struct {
+	MessageID       string           // Identifier of the message, at most 35 characters
+	PaymentInfoID   string           // Identifier of the payment information block of pain.001
+	CreatedAt       time.Time        // Creation time of the message
+	ExecutionDate   time.Time        // Date the transfers are to be executed or settled
+	InitiatingParty string           // Name of the party initiating the message
+	Debtor          PartyAccount     // Party paying
+	ServiceLevel    string           // Service level code such as SEPA, empty for none
+	ChargeBearer    string           // SLEV, SHAR, DEBT or CRED
+	Transfers       []CreditTransfer // Transfers of the batch
+}
+
+// XML elements of the rendered messages, named after their ISO 20022 tags
+type isoAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type isoGenericID struct {
+	ID string `xml:"Id"`
+}
+
+type isoAccountID struct {
+	IBAN  string        `xml:"IBAN,omitempty"`
+	Other *isoGenericID `xml:"Othr,omitempty"`
+}
+
+type isoAccount struct {
+	ID isoAccountID `xml:"Id"`
+}
+
+type isoFinancialInstitutionID struct {
+	BICFI string        `xml:"BICFI,omitempty"`
+	Other *isoGenericID `xml:"Othr,omitempty"`
+}
+
+type isoAgent struct {
+	FinancialInstitutionID isoFinancialInstitutionID `xml:"FinInstnId"`
+}
+
+type isoParty struct {
+	Name string `xml:"Nm"`
+}
+
+type isoPaymentID struct {
+	InstructionID string `xml:"InstrId,omitempty"`
+	EndToEndID    string `xml:"EndToEndId"`
+	TransactionID string `xml:"TxId,omitempty"`
+}
+
+type isoServiceLevel struct {
+	Code string `xml:"Cd"`
+}
+
+type isoPaymentType struct {
+	ServiceLevel isoServiceLevel `xml:"SvcLvl"`
+}
+
+type isoRemittance struct {
+	Unstructured string `xml:"Ustrd"`
+}
+
+type isoDate struct {
+	Date string `xml:"Dt"`
+}
+
+type pain001Document struct {
+	XMLName    xml.Name          `xml:"Document"`
+	Namespace  string            `xml:"xmlns,attr"`
+	Initiation pain001Initiation `xml:"CstmrCdtTrfInitn"`
+}
+
+type pain001Initiation struct {
+	GroupHeader        pain001GroupHeader `xml:"GrpHdr"`
+	PaymentInformation pain001PaymentInfo `xml:"PmtInf"`
+}
+
+type pain001GroupHeader struct {
+	MessageID       string   `xml:"MsgId"`
+	CreatedAt       string   `xml:"CreDtTm"`
+	NumberOfTxs     string   `xml:"NbOfTxs"`
+	ControlSum      string   `xml:"CtrlSum"`
+	InitiatingParty isoParty `xml:"InitgPty"`
+}
+
+type pain001PaymentInfo struct {
+	PaymentInfoID   string            `xml:"PmtInfId"`
+	PaymentMethod   string            `xml:"PmtMtd"`
+	NumberOfTxs     string            `xml:"NbOfTxs"`
+	ControlSum      string            `xml:"CtrlSum"`
+	PaymentType     *isoPaymentType   `xml:"PmtTpInf,omitempty"`
+	ExecutionDate   isoDate           `xml:"ReqdExctnDt"`
+	Debtor          isoParty          `xml:"Dbtr"`
+	DebtorAccount   isoAccount        `xml:"DbtrAcct"`
+	DebtorAgent     isoAgent          `xml:"DbtrAgt"`
+	ChargeBearer    string            `xml:"ChrgBr,omitempty"`
+	CreditTransfers []pain001Transfer `xml:"CdtTrfTxInf"`
+}
+
+type pain001Transfer struct {
+	PaymentID       isoPaymentID   `xml:"PmtId"`
+	Amount          pain001Amount  `xml:"Amt"`
+	CreditorAgent   *isoAgent      `xml:"CdtrAgt,omitempty"`
+	Creditor        isoParty       `xml:"Cdtr"`
+	CreditorAccount isoAccount     `xml:"CdtrAcct"`
+	Remittance      *isoRemittance `xml:"RmtInf,omitempty"`
+}
+
+type pain001Amount struct {
+	Instructed isoAmount `xml:"InstdAmt"`
+}
+
+type pacs008Document struct {
+	XMLName   xml.Name        `xml:"Document"`
+	Namespace string          `xml:"xmlns,attr"`
+	Transfer  pacs008Transfer `xml:"FIToFICstmrCdtTrf"`
+}
+
+type pacs008Transfer struct {
+	GroupHeader     pacs008GroupHeader `xml:"GrpHdr"`
+	CreditTransfers []pacs008Tx        `xml:"CdtTrfTxInf"`
+}
+
+type pacs008GroupHeader struct {
+	MessageID      string            `xml:"MsgId"`
+	CreatedAt      string            `xml:"CreDtTm"`
+	NumberOfTxs    string            `xml:"NbOfTxs"`
+	SettlementInfo pacs008Settlement `xml:"SttlmInf"`
+}
+
+type pacs008Settlement struct {
+	Method string `xml:"SttlmMtd"`
+}
+
+type pacs008Tx struct {
+	PaymentID        isoPaymentID    `xml:"PmtId"`
+	PaymentType      *isoPaymentType `xml:"PmtTpInf,omitempty"`
+	SettlementAmount isoAmount       `xml:"IntrBkSttlmAmt"`
+	SettlementDate   string          `xml:"IntrBkSttlmDt"`
+	Instructed       *isoAmount      `xml:"InstdAmt,omitempty"`
+	ExchangeRate     string          `xml:"XchgRate,omitempty"`
+	ChargeBearer     string          `xml:"ChrgBr"`
+	Debtor           isoParty        `xml:"Dbtr"`
+	DebtorAccount    isoAccount      `xml:"DbtrAcct"`
+	DebtorAgent      isoAgent        `xml:"DbtrAgt"`
+	CreditorAgent    isoAgent        `xml:"CdtrAgt"`
+	Creditor         isoParty        `xml:"Cdtr"`
+	CreditorAccount  isoAccount      `xml:"CdtrAcct"`
+	Remittance       *isoRemittance  `xml:"RmtInf,omitempty"`
+}
+
+// isoDecimal formats an amount as an ISO 20022 decimal without its currency
+func isoDecimal(amount Money) string {
+	return strings.Fields(amount.String())[0]
+}
+
+// isoAccountOf identifies an account by IBAN when it is one, otherwise by its identifier
+func isoAccountOf(account string) isoAccount {
+	compact := strings.ToUpper(strings.ReplaceAll(account, " ", ""))
+	if ibanPattern.MatchString(compact) {
+		return isoAccount{ID: isoAccountID{IBAN: compact}}
+	}
+	return isoAccount{ID: isoAccountID{Other: &isoGenericID{ID: account}}}
+}
+
+// isoAgentOf identifies a bank by BIC, or as not provided when its BIC is unknown
+func isoAgentOf(bic string) isoAgent {
+	if bic == "" {
+		return isoAgent{FinancialInstitutionID: isoFinancialInstitutionID{Other: &isoGenericID{ID: notProvided}}}
+	}
+	return isoAgent{FinancialInstitutionID: isoFinancialInstitutionID{BICFI: bic}}
+}
+
+// controlSum returns the sum of the transfer amounts as an ISO 20022 decimal. Like
+// the CtrlSum element it adds amounts irrespective of their currency
+func controlSum(transfers []CreditTransfer) string {
+	sum := new(big.Rat)
+	for _, transfer := range transfers {
+		value, _ := new(big.Rat).SetString(isoDecimal(transfer.Amount))
+		sum.Add(sum, value)
+	}
+	return strings.TrimRight(strings.TrimRight(sum.FloatString(5), "0"), ".")
+}
+
+// baseOneRate formats an exchange rate as an ISO 20022 BaseOneRate, rounded to the
+// 11 significant digits the type allows, of which at most 10 are fractional
+func baseOneRate(rate *big.Rat) string {
+	integerDigits := 0
+	if integer := new(big.Int).Quo(rate.Num(), rate.Denom()); integer.Sign() != 0 {
+		integerDigits = len(integer.String())
+	}
+	decimals := 11 - integerDigits
+	if decimals > 10 {
+		decimals = 10
+	}
+	if decimals <= 0 {
+		return rate.FloatString(0)
+	}
+	return strings.TrimRight(strings.TrimRight(rate.FloatString(decimals), "0"), ".")
+}
+
+// checkText checks the length facets of an ISO 20022 MaxNText element
+func checkText(element string, value string, max int) error {
+	if n := len([]rune(value)); n < 1 || n > max {
+		return fmt.Errorf("%s must be 1 to %d characters, got %d", element, max, n)
+	}
+	return nil
+}
+
+// checkPartyAccount checks a party and its account against the schema facets
+func checkPartyAccount(role string, party PartyAccount) error {
+	if err := checkText(role+" Nm", party.Name, 140); err != nil {
+		return err
+	}
+	account := isoAccountOf(party.Account)
+	if account.ID.IBAN == "" {
+		if err := checkText(role+"Acct Othr Id", party.Account, 34); err != nil {
+			return err
+		}
+	}
+	if party.BIC != "" && !bicPattern.MatchString(party.BIC) {
+		return fmt.Errorf("%s BIC %q is not a valid BICFI", role, party.BIC)
+	}
+	return nil
+}
+
+// checkAmount checks an amount against the ActiveOrHistoricCurrencyAndAmount facets
+func checkAmount(element string, amount Money) error {
+	if !currencyPattern.MatchString(amount.Currency) {
+		return fmt.Errorf("%s currency %q is not an ISO 4217 code", element, amount.Currency)
+	}
+	if !amount.IsPositive() {
+		return fmt.Errorf("%s %s must be positive", element, amount)
+	}
+	if digits := strings.Replace(isoDecimal(amount), ".", "", 1); len(strings.TrimLeft(digits, "0")) > 18 {
+		return fmt.Errorf("%s %s has more than 18 digits", element, amount)
+	}
+	return nil
+}
+
+// checkRate checks an exchange rate against the BaseOneRate facets once rounded
+func checkRate(element string, rate *big.Rat) error {
+	if rate.Sign() <= 0 {
+		return fmt.Errorf("%s %s must be positive", element, rate.FloatString(10))
+	}
+	value := baseOneRate(rate)
+	if value == "0" {
+		return fmt.Errorf("%s %s rounds to zero", element, rate.FloatString(10))
+	}
+	if digits := strings.Replace(value, ".", "", 1); len(strings.TrimLeft(digits, "0")) > 11 {
+		return fmt.Errorf("%s %s has more than 11 digits", element, value)
+	}
+	return nil
+}
+
+// validate checks a batch against the facets of the pain.001.001.09 and
+// pacs.008.001.08 schemas: lengths of texts and identifiers, BIC, IBAN and currency
+// patterns, positive amounts and rates, and at least one transfer
+func (b *CreditTransferBatch) validate() error {
+	if err := checkText("MsgId", b.MessageID, 35); err != nil {
+		return err
+	}
+	if err := checkText("PmtInfId", b.PaymentInfoID, 35); err != nil {
+		return err
+	}
+	if err := checkText("InitgPty Nm", b.InitiatingParty, 140); err != nil {
+		return err
+	}
+	if err := checkPartyAccount("Dbtr", b.Debtor); err != nil {
+		return err
+	}
+	switch b.ChargeBearer {
+	case "SLEV", "SHAR", "DEBT", "CRED":
+	default:
+		return fmt.Errorf("unknown charge bearer %q", b.ChargeBearer)
+	}
+	if b.ServiceLevel != "" {
+		if err := checkText("SvcLvl Cd", b.ServiceLevel, 4); err != nil {
+			return err
+		}
+	}
+	if len(b.Transfers) == 0 {
+		return fmt.Errorf("a credit transfer message needs at least one transfer")
+	}
+
+	endToEndIDs := map[string]bool{}
+	for _, transfer := range b.Transfers {
+		if transfer.InstructionID != "" {
+			if err := checkText("InstrId", transfer.InstructionID, 35); err != nil {
+				return err
+			}
+		}
+		if err := checkText("EndToEndId", transfer.EndToEndID, 35); err != nil {
+			return err
+		}
+		if endToEndIDs[transfer.EndToEndID] {
+			return fmt.Errorf("EndToEndId %s appears twice in message %s", transfer.EndToEndID, b.MessageID)
+		}
+		endToEndIDs[transfer.EndToEndID] = true
+		if err := checkAmount("Amt", transfer.Amount); err != nil {
+			return err
+		}
+		if transfer.ExchangeRate != nil {
+			if err := checkAmount("InstdAmt", transfer.InstructedAmount); err != nil {
+				return err
+			}
+			if err := checkRate("XchgRate", transfer.ExchangeRate); err != nil {
+				return err
+			}
+		}
+		if err := checkPartyAccount("Cdtr", transfer.Creditor); err != nil {
+			return err
+		}
+		if transfer.Remittance != "" {
+			if err := checkText("Ustrd", transfer.Remittance, 140); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// marshalDocument renders a message document with the XML declaration
+func marshalDocument(document interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// RenderPain001 renders a batch into a validated pain.001.001.09 customer credit
+// transfer initiation with a single payment information block
+func RenderPain001(batch *CreditTransferBatch) ([]byte, error) {
+	err := batch.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	numberOfTxs := fmt.Sprint(len(batch.Transfers))
+	sum := controlSum(batch.Transfers)
+	info := pain001PaymentInfo{
+		PaymentInfoID: batch.PaymentInfoID,
+		PaymentMethod: "TRF",
+		NumberOfTxs:   numberOfTxs,
+		ControlSum:    sum,
+		ExecutionDate: isoDate{Date: batch.ExecutionDate.Format(dateLayout)},
+		Debtor:        isoParty{Name: batch.Debtor.Name},
+		DebtorAccount: isoAccountOf(batch.Debtor.Account),
+		DebtorAgent:   isoAgentOf(batch.Debtor.BIC),
+		ChargeBearer:  batch.ChargeBearer,
+	}
+	if batch.ServiceLevel != "" {
+		info.PaymentType = &isoPaymentType{ServiceLevel: isoServiceLevel{Code: batch.ServiceLevel}}
+	}
+	for _, transfer := range batch.Transfers {
+		tx := pain001Transfer{
+			PaymentID:       isoPaymentID{InstructionID: transfer.InstructionID, EndToEndID: transfer.EndToEndID},
+			Amount:          pain001Amount{Instructed: isoAmount{Currency: transfer.Amount.Currency, Value: isoDecimal(transfer.Amount)}},
+			Creditor:        isoParty{Name: transfer.Creditor.Name},
+			CreditorAccount: isoAccountOf(transfer.Creditor.Account),
+		}
+		if transfer.Creditor.BIC != "" {
+			agent := isoAgentOf(transfer.Creditor.BIC)
+			tx.CreditorAgent = &agent
+		}
+		if transfer.Remittance != "" {
+			tx.Remittance = &isoRemittance{Unstructured: transfer.Remittance}
+		}
+		info.CreditTransfers = append(info.CreditTransfers, tx)
+	}
+
+	return marshalDocument(pain001Document{
+		Namespace: pain001Namespace,
+		Initiation: pain001Initiation{
+			GroupHeader: pain001GroupHeader{
+				MessageID:       batch.MessageID,
+				CreatedAt:       batch.CreatedAt.UTC().Format(time.RFC3339),
+				NumberOfTxs:     numberOfTxs,
+				ControlSum:      sum,
+				InitiatingParty: isoParty{Name: batch.InitiatingParty},
+			},
+			PaymentInformation: info,
+		},
+	})
+}
+
+// RenderPacs008 renders a batch into a validated pacs.008.001.08 FI to FI customer
+// credit transfer settled through clearing
+func RenderPacs008(batch *CreditTransferBatch) ([]byte, error) {
+	err := batch.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := pacs008Transfer{
+		GroupHeader: pacs008GroupHeader{
+			MessageID:      batch.MessageID,
+			CreatedAt:      batch.CreatedAt.UTC().Format(time.RFC3339),
+			NumberOfTxs:    fmt.Sprint(len(batch.Transfers)),
+			SettlementInfo: pacs008Settlement{Method: "CLRG"},
+		},
+	}
+	for _, credit := range batch.Transfers {
+		tx := pacs008Tx{
+			PaymentID:        isoPaymentID{InstructionID: credit.InstructionID, EndToEndID: credit.EndToEndID, TransactionID: credit.EndToEndID},
+			SettlementAmount: isoAmount{Currency: credit.Amount.Currency, Value: isoDecimal(credit.Amount)},
+			SettlementDate:   batch.ExecutionDate.Format(dateLayout),
+			ChargeBearer:     batch.ChargeBearer,
+			Debtor:           isoParty{Name: batch.Debtor.Name},
+			DebtorAccount:    isoAccountOf(batch.Debtor.Account),
+			DebtorAgent:      isoAgentOf(batch.Debtor.BIC),
+			CreditorAgent:    isoAgentOf(credit.Creditor.BIC),
+			Creditor:         isoParty{Name: credit.Creditor.Name},
+			CreditorAccount:  isoAccountOf(credit.Creditor.Account),
+		}
+		if batch.ServiceLevel != "" {
+			tx.PaymentType = &isoPaymentType{ServiceLevel: isoServiceLevel{Code: batch.ServiceLevel}}
+		}
+		if credit.ExchangeRate != nil {
+			tx.Instructed = &isoAmount{Currency: credit.InstructedAmount.Currency, Value: isoDecimal(credit.InstructedAmount)}
+			tx.ExchangeRate = baseOneRate(credit.ExchangeRate)
+		}
+		if credit.Remittance != "" {
+			tx.Remittance = &isoRemittance{Unstructured: credit.Remittance}
+		}
+		transfer.CreditTransfers = append(transfer.CreditTransfers, tx)
+	}
+
+	return marshalDocument(pacs008Document{Namespace: pacs008Namespace, Transfer: transfer})
+}
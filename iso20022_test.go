@@ -0,0 +1,149 @@
+package chaincode
+
+import (
+	"encoding/xml"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBatch returns a valid batch of one credit transfer
+func testBatch() *CreditTransferBatch {
+	return &CreditTransferBatch{
+		MessageID:       "MSG1",
+		PaymentInfoID:   "PMT1",
+		CreatedAt:       time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+		ExecutionDate:   time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		InitiatingParty: "Acme",
+		Debtor:          PartyAccount{Name: "Acme", Account: "DE89 3704 0044 0532 0130 00", BIC: "COBADEFFXXX"},
+		ChargeBearer:    "SHAR",
+		Transfers: []CreditTransfer{{
+			InstructionID: "I1",
+			EndToEndID:    "E2E1",
+			Amount:        Money{Currency: "EUR", Units: 100050},
+			Creditor:      PartyAccount{Name: "Alice", Account: "FR1420041010050500013M02606", BIC: "BNPAFRPP"},
+			Remittance:    "Pay under contract C1",
+		}},
+	}
+}
+
+// A batch is checked against the schema facets before it is rendered
+func TestCreditTransferBatchIsValidated(t *testing.T) {
+	if err := testBatch().validate(); err != nil {
+		t.Fatalf("a valid batch was rejected: %v", err)
+	}
+
+	converted := func(rate *big.Rat) func(b *CreditTransferBatch) {
+		return func(b *CreditTransferBatch) {
+			b.Transfers[0].InstructedAmount = Money{Currency: "USD", Units: 108000}
+			b.Transfers[0].ExchangeRate = rate
+		}
+	}
+	invalid := map[string]func(b *CreditTransferBatch){
+		"empty MsgId":         func(b *CreditTransferBatch) { b.MessageID = "" },
+		"long MsgId":          func(b *CreditTransferBatch) { b.MessageID = strings.Repeat("M", 36) },
+		"long debtor name":    func(b *CreditTransferBatch) { b.Debtor.Name = strings.Repeat("A", 141) },
+		"long account":        func(b *CreditTransferBatch) { b.Debtor.Account = strings.Repeat("1", 35) },
+		"bad BIC":             func(b *CreditTransferBatch) { b.Transfers[0].Creditor.BIC = "BNPA" },
+		"charge bearer":       func(b *CreditTransferBatch) { b.ChargeBearer = "OUR" },
+		"long service level":  func(b *CreditTransferBatch) { b.ServiceLevel = "SEPAX" },
+		"no transfers":        func(b *CreditTransferBatch) { b.Transfers = nil },
+		"repeated EndToEndId": func(b *CreditTransferBatch) { b.Transfers = append(b.Transfers, b.Transfers[0]) },
+		"bad currency":        func(b *CreditTransferBatch) { b.Transfers[0].Amount.Currency = "eur" },
+		"zero amount":         func(b *CreditTransferBatch) { b.Transfers[0].Amount.Units = 0 },
+		"long amount":         func(b *CreditTransferBatch) { b.Transfers[0].Amount.Units = 1e18 + 1 },
+		"long remittance":     func(b *CreditTransferBatch) { b.Transfers[0].Remittance = strings.Repeat("R", 141) },
+		"negative rate":       converted(big.NewRat(-1, 2)),
+		"rate rounds to zero": converted(big.NewRat(1, 1e11)),
+		"long rate":           converted(new(big.Rat).SetInt64(1e11)),
+	}
+	for name, change := range invalid {
+		batch := testBatch()
+		change(batch)
+		if err := batch.validate(); err == nil {
+			t.Errorf("a batch with %s was accepted", name)
+		}
+		if _, err := RenderPain001(batch); err == nil {
+			t.Errorf("a pain.001 with %s was rendered", name)
+		}
+	}
+}
+
+// Exchange rates are rounded to the digits of a BaseOneRate
+func TestBaseOneRate(t *testing.T) {
+	for _, test := range []struct {
+		rate *big.Rat
+		want string
+	}{
+		{big.NewRat(1, 3), "0.3333333333"},
+		{big.NewRat(108, 100), "1.08"},
+		{big.NewRat(1234567123456789, 1e9), "1234567.1235"},
+		{big.NewRat(99999999999, 10), "9999999999.9"},
+		{big.NewRat(999999999999, 100), "10000000000"},
+	} {
+		if got := baseOneRate(test.rate); got != test.want {
+			t.Errorf("rate %s was formatted as %s, want %s", test.rate.FloatString(12), got, test.want)
+		}
+		if err := checkRate("XchgRate", test.rate); err != nil {
+			t.Errorf("rate %s was rejected: %v", test.want, err)
+		}
+	}
+}
+
+// The renderers carry the batch into the group header and transactions of each message
+func TestRenderCreditTransfers(t *testing.T) {
+	batch := testBatch()
+	batch.Transfers = append(batch.Transfers, CreditTransfer{
+		EndToEndID: "E2E2",
+		Amount:     Money{Currency: "EUR", Units: 2500},
+		Creditor:   PartyAccount{Name: "Bob", Account: "12345678"},
+	})
+
+	document, err := RenderPain001(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(document), xml.Header) {
+		t.Error("the pain.001 has no XML declaration")
+	}
+	var pain pain001Document
+	if err := xml.Unmarshal(document, &pain); err != nil {
+		t.Fatal(err)
+	}
+	header, info := pain.Initiation.GroupHeader, pain.Initiation.PaymentInformation
+	if pain.Namespace != pain001Namespace || header.MessageID != "MSG1" || header.NumberOfTxs != "2" || header.ControlSum != "1025.5" || header.CreatedAt != "2024-03-01T09:00:00Z" {
+		t.Errorf("the pain.001 group header is %+v", header)
+	}
+	if info.DebtorAccount.ID.IBAN != "DE89370400440532013000" || info.ExecutionDate.Date != "2024-03-01" || info.PaymentType != nil {
+		t.Errorf("the pain.001 payment information is %+v", info)
+	}
+	first, second := info.CreditTransfers[0], info.CreditTransfers[1]
+	if first.Amount.Instructed != (isoAmount{Currency: "EUR", Value: "1000.50"}) || first.CreditorAgent == nil || first.CreditorAgent.FinancialInstitutionID.BICFI != "BNPAFRPP" || first.CreditorAccount.ID.IBAN != "FR1420041010050500013M02606" {
+		t.Errorf("the first pain.001 transfer is %+v", first)
+	}
+	if second.CreditorAgent != nil || second.CreditorAccount.ID.Other == nil || second.CreditorAccount.ID.Other.ID != "12345678" || second.Remittance != nil {
+		t.Errorf("the second pain.001 transfer is %+v", second)
+	}
+
+	batch.Transfers[0].InstructedAmount = Money{Currency: "USD", Units: 108054}
+	batch.Transfers[0].ExchangeRate = big.NewRat(108, 100)
+	document, err = RenderPacs008(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pacs pacs008Document
+	if err := xml.Unmarshal(document, &pacs); err != nil {
+		t.Fatal(err)
+	}
+	if pacs.Namespace != pacs008Namespace || pacs.Transfer.GroupHeader.NumberOfTxs != "2" || pacs.Transfer.GroupHeader.SettlementInfo.Method != "CLRG" {
+		t.Errorf("the pacs.008 group header is %+v", pacs.Transfer.GroupHeader)
+	}
+	converted, plain := pacs.Transfer.CreditTransfers[0], pacs.Transfer.CreditTransfers[1]
+	if converted.ExchangeRate != "1.08" || converted.Instructed == nil || converted.Instructed.Value != "1080.54" || converted.PaymentID.TransactionID != "E2E1" || converted.SettlementDate != "2024-03-01" {
+		t.Errorf("the converted pacs.008 transfer is %+v", converted)
+	}
+	if plain.ExchangeRate != "" || plain.Instructed != nil || plain.CreditorAgent.FinancialInstitutionID.Other == nil || plain.CreditorAgent.FinancialInstitutionID.Other.ID != notProvided {
+		t.Errorf("the pacs.008 transfer without a rate is %+v", plain)
+	}
+}
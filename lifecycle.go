@@ -0,0 +1,249 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Constants for contract statuses
+const (
+	StatusDraft            = "Draft"
+	StatusPendingSignature = "PendingSignature"
+	StatusActive           = "Active"
+	StatusSuspended        = "Suspended"
+	StatusNoticePeriod     = "NoticePeriod"
+	StatusTerminated       = "Terminated"
+	StatusRevoked          = "Revoked"
+)
+
+// statuses a contract may move to from each status. Terminated and Revoked are final
+var contractTransitions = map[string][]string{
+	StatusDraft:            {StatusPendingSignature, StatusRevoked},
+	StatusPendingSignature: {StatusDraft, StatusActive, StatusRevoked},
+	StatusActive:           {StatusSuspended, StatusNoticePeriod, StatusTerminated, StatusRevoked},
+	StatusSuspended:        {StatusActive, StatusNoticePeriod, StatusTerminated},
+	StatusNoticePeriod:     {StatusActive, StatusTerminated},
+	StatusTerminated:       {},
+	StatusRevoked:          {},
+}
+
+// a recorded change of contract status
+type StatusChange struct {
+	From          string    `json:"From"`          // Status before the change
+	To            string    `json:"To"`            // Status after the change
+	Reason        string    `json:"Reason"`        // Why the status changed
+	EffectiveDate time.Time `json:"EffectiveDate"` // Date the change takes effect
+	ChangedBy     string    `json:"ChangedBy"`     // Name of the party that made the change
+	RecordedAt    time.Time `json:"RecordedAt"`    // Transaction timestamp of the change
+	Scheduled     bool      `json:"Scheduled"`     // Set until the change takes effect
+}
+
+// requireActive rejects transactions against contracts that are not Active. Payroll,
+// payments and advances only run against Active contracts
+func requireActive(contract *Contract) error {
+	if contract.Status != StatusActive {
+		return fmt.Errorf("contract %s is %s, not %s", contract.ID, contract.Status, StatusActive)
+	}
+	return nil
+}
+
+// latestStatus returns the status a contract ends up in once its scheduled changes
+// have taken effect
+func (c *Contract) latestStatus() string {
+	if n := len(c.StatusHistory); n > 0 {
+		return c.StatusHistory[n-1].To
+	}
+	return c.Status
+}
+
+// statusAt returns the status of a contract on the given date, including the
+// scheduled changes effective by then
+func (c *Contract) statusAt(date time.Time) string {
+	status := c.Status
+	for _, change := range c.StatusHistory {
+		if change.Scheduled && !change.EffectiveDate.After(date) {
+			status = change.To
+		}
+	}
+	return status
+}
+
+// statusChangesDue reports whether a scheduled change effective by date is still to
+// be written to the contract
+func (c *Contract) statusChangesDue(date time.Time) bool {
+	for _, change := range c.StatusHistory {
+		if change.Scheduled && !change.EffectiveDate.After(date) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyStatusChanges makes the scheduled changes effective by date the status of the
+// contract and returns them. Like an amendment, a future-dated change takes effect on
+// the first write of the contract on or after its effective date
+func (c *Contract) applyStatusChanges(date time.Time) []StatusChange {
+	var applied []StatusChange
+	for i := range c.StatusHistory {
+		change := &c.StatusHistory[i]
+		if !change.Scheduled || change.EffectiveDate.After(date) {
+			continue
+		}
+		change.Scheduled = false
+		c.Status = change.To
+		applied = append(applied, *change)
+	}
+	return applied
+}
+
+// transitionContract moves a contract to a new status if the transition is allowed,
+// records the change in its history and writes it to the ledger. A change effective
+// after the day of the transaction is scheduled; transitions are checked against the
+// status the contract has once its scheduled changes took effect
+func transitionContract(ctx contractapi.TransactionContextInterface, contract *Contract, to string, reason string, effectiveDate string, changedBy string) error {
+	from := contract.latestStatus()
+	allowed := false
+	for _, status := range contractTransitions[from] {
+		if status == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("contract %s cannot move from %s to %s", contract.ID, from, to)
+	}
+	if reason == "" {
+		return fmt.Errorf("a reason is required to move contract %s to %s", contract.ID, to)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	effective := now
+	if effectiveDate != "" {
+		effective, err = parseDate(effectiveDate)
+		if err != nil {
+			return err
+		}
+		// payroll and advances already ran on the status in force at the time
+		if effective.Before(startOfDay(now)) {
+			return fmt.Errorf("effective date %s is in the past", effective.Format(dateLayout))
+		}
+	}
+	if n := len(contract.StatusHistory); n > 0 && effective.Before(contract.StatusHistory[n-1].EffectiveDate) {
+		return fmt.Errorf("effective date %s precedes the previous status change", effective.Format(dateLayout))
+	}
+
+	contract.StatusHistory = append(contract.StatusHistory, StatusChange{
+		From:          from,
+		To:            to,
+		Reason:        reason,
+		EffectiveDate: effective,
+		ChangedBy:     changedBy,
+		RecordedAt:    now,
+		Scheduled:     true,
+	})
+
+	return putContract(ctx, contract)
+}
+
+// putContract writes a contract to the world state, without the pay held in the
+// contract collection, and keeps its employer index and account in sync. The terms
+// version and the status changes in effect at the transaction timestamp take effect
+func putContract(ctx contractapi.TransactionContextInterface, contract *Contract) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if previousAccount := contract.advanceTerms(now); previousAccount != contract.AccountID {
+		err = unlinkAccount(ctx, previousAccount, contract.ID)
+		if err != nil {
+			return err
+		}
+	}
+	applied := contract.applyStatusChanges(now)
+
+	contractJSON, err := json.Marshal(publicContract(contract))
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(contract.ID, contractJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	err = putEmployerContractIndex(ctx, contract)
+	if err != nil {
+		return err
+	}
+
+	err = syncAccount(ctx, contract)
+	if err != nil {
+		return err
+	}
+
+	// a terminated or revoked contract pays no more regular pay to recover advances from
+	for _, change := range applied {
+		if isFinalStatus(change.To) {
+			return writeOffAdvances(ctx, contract)
+		}
+	}
+
+	return nil
+}
+
+// changeContractStatus authorizes the caller as the contract's employer (or either
+// party when employeeAllowed is set) and applies the transition
+func changeContractStatus(ctx contractapi.TransactionContextInterface, transaction string, contractID string, to string, reason string, effectiveDate string, employeeAllowed bool) error {
+	caller, err := authorize(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return err
+	}
+
+	isParty := caller.isEmployerOf(contract)
+	if employeeAllowed {
+		isParty = isParty || caller.isEmployeeOf(contract)
+	}
+	if !isParty {
+		return fmt.Errorf("%s cannot change the status of contract %s", caller.Name, contractID)
+	}
+
+	return transitionContract(ctx, contract, to, reason, effectiveDate, caller.Name)
+}
+
+// WithdrawContract takes a contract pending signature back to Draft, so its terms can
+// no longer be accepted. The employer proposes new terms with CounterContract
+func (s *PaymentContract) WithdrawContract(ctx contractapi.TransactionContextInterface, contractID string, reason string) error {
+	return changeContractStatus(ctx, "WithdrawContract", contractID, StatusDraft, reason, "", false)
+}
+
+// SuspendContract temporarily stops payroll and advances on an Active contract
+func (s *PaymentContract) SuspendContract(ctx contractapi.TransactionContextInterface, contractID string, reason string, effectiveDate string) error {
+	return changeContractStatus(ctx, "SuspendContract", contractID, StatusSuspended, reason, effectiveDate, false)
+}
+
+// ReinstateContract returns a Suspended contract, or one in its notice period, to Active
+func (s *PaymentContract) ReinstateContract(ctx contractapi.TransactionContextInterface, contractID string, reason string, effectiveDate string) error {
+	return changeContractStatus(ctx, "ReinstateContract", contractID, StatusActive, reason, effectiveDate, false)
+}
+
+// GiveNotice starts the notice period of a contract. Either party may give notice
+func (s *PaymentContract) GiveNotice(ctx contractapi.TransactionContextInterface, contractID string, reason string, effectiveDate string) error {
+	return changeContractStatus(ctx, "GiveNotice", contractID, StatusNoticePeriod, reason, effectiveDate, true)
+}
+
+// TerminateContract ends a contract. The contract and its payments stay on the ledger
+func (s *PaymentContract) TerminateContract(ctx contractapi.TransactionContextInterface, contractID string, reason string, effectiveDate string) error {
+	return changeContractStatus(ctx, "TerminateContract", contractID, StatusTerminated, reason, effectiveDate, false)
+}
@@ -0,0 +1,118 @@
+package chaincode
+
+import (
+	"testing"
+	"time"
+)
+
+// A future-dated termination leaves the contract Active until its effective date,
+// and advances are written off by the first payroll run after it took effect
+func TestTerminationIsScheduledForItsEffectiveDate(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	l.must(s.AdvanceRequest(l.begin(p.employee, nil), "ADV1", "C1", p.employee.name, "100.00", 2))
+	l.must(s.ApproveAdvanceRequest(l.begin(p.employer, nil), "", "ADV1", "0", 0))
+
+	if err := s.GiveNotice(l.begin(p.employer, nil), "C1", "Redundancy", "2024-02-29"); err == nil {
+		t.Error("notice was given with an effective date in the past")
+	}
+	l.must(s.TerminateContract(l.begin(p.employer, nil), "C1", "Redundancy", "2024-03-20"))
+	if err := s.ReinstateContract(l.begin(p.employer, nil), "C1", "Changed our minds", ""); err == nil {
+		t.Error("a contract scheduled for termination was reinstated")
+	}
+
+	contract, err := s.GetContractByID(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	if contract.Status != StatusActive {
+		t.Errorf("the contract is %s before the termination took effect", contract.Status)
+	}
+	fundWallet(l, s, p, "C1")
+
+	l.clock = time.Date(2024, 3, 21, 9, 0, 0, 0, time.UTC)
+	contract, err = s.GetContractByID(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	if contract.Status != StatusTerminated {
+		t.Errorf("the contract is %s after the termination took effect", contract.Status)
+	}
+	if err := s.AdvanceRequest(l.begin(p.employee, nil), "ADV2", "C1", p.employee.name, "100.00", 2); err == nil {
+		t.Error("an advance was requested on a terminated contract")
+	}
+
+	april := PayrollInterval{StartDate: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC)}
+	run, err := s.RunPayroll(l.begin(p.employer, nil), "", p.employer.name, april)
+	l.must(err)
+	if run.Paid != 0 {
+		t.Errorf("the run paid a terminated contract: %+v", run)
+	}
+	requests, err := s.GetAdvanceRequests(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	if len(requests) != 1 || requests[0].Status != AdvanceWrittenOff {
+		t.Errorf("the advance was not written off: %+v", requests)
+	}
+}
+
+// Revoking an Active contract writes off its advances as terminating it does
+func TestRevokedContractWritesOffAdvances(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	l.must(s.AdvanceRequest(l.begin(p.employee, nil), "ADV1", "C1", p.employee.name, "100.00", 2))
+	l.must(s.ApproveAdvanceRequest(l.begin(p.employer, nil), "", "ADV1", "0", 0))
+	l.must(s.RevokeContract(l.begin(p.employer, nil), "C1", "Signed in error"))
+
+	requests, err := s.GetAdvanceRequests(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	if len(requests) != 1 || requests[0].Status != AdvanceWrittenOff || !requests[0].Outstanding.IsZero() {
+		t.Errorf("the advance was not written off: %+v", requests)
+	}
+	receivable, err := getLedgerAccount(l.begin(p.employer, nil), "C1", p.employee.name, AdvanceReceivable, "EUR", false)
+	l.must(err)
+	if receivable.Debits != receivable.Credits {
+		t.Errorf("the advance receivable is left at %s debits and %s credits", receivable.Debits, receivable.Credits)
+	}
+}
+
+// A withdrawn offer can no longer be accepted until the employer proposes it again
+func TestWithdrawnContractIsProposedAgain(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+
+	account := map[string]interface{}{transientAccountKey: transientAccount{BankAccount: "DE89370400440532013000", BankBIC: "COBADEFFXXX", Salt: "account-salt-0123456789"}}
+	l.must(s.CreateAccount(l.begin(p.employee, account), "ACC1", "Acme", "", "EUR"))
+	pay := map[string]interface{}{transientPayKey: transientPay{Salary: "60000.00", VariablePay: "0", Salt: "contract-salt-0123456789"}}
+	hash, err := s.HashContractTerms(l.begin(p.employer, pay), "C1", p.employer.name, p.employee.name, "Engineer", "EUR", "ACC1", PaySchedule{})
+	l.must(err)
+	l.must(s.ProposeContract(l.begin(p.employer, pay), "C1", p.employer.name, p.employee.name, "Engineer", "EUR", "ACC1", PaySchedule{}, p.employer.sign(l.t, hash)))
+
+	l.must(s.WithdrawContract(l.begin(p.employer, nil), "C1", "Wrong position"))
+	if err := s.AcceptContract(l.begin(p.employee, nil), "C1", p.employee.sign(l.t, hash)); err == nil {
+		t.Error("a withdrawn offer was accepted")
+	}
+
+	hash, err = s.HashContractTerms(l.begin(p.employer, pay), "C1", p.employer.name, p.employee.name, "Lead Engineer", "EUR", "ACC1", PaySchedule{})
+	l.must(err)
+	if err := s.CounterContract(l.begin(p.employee, pay), "C1", "Lead Engineer", "EUR", "ACC1", PaySchedule{}, p.employee.sign(l.t, hash)); err == nil {
+		t.Error("the employee proposed a draft contract")
+	}
+	l.must(s.CounterContract(l.begin(p.employer, pay), "C1", "Lead Engineer", "EUR", "ACC1", PaySchedule{}, p.employer.sign(l.t, hash)))
+	l.must(s.AcceptContract(l.begin(p.employee, nil), "C1", p.employee.sign(l.t, hash)))
+
+	contract, err := s.GetContractByID(l.begin(p.employer, nil), "C1")
+	l.must(err)
+	var statuses []string
+	for _, change := range contract.StatusHistory {
+		statuses = append(statuses, change.To)
+	}
+	if contract.Status != StatusActive || contract.Position != "Lead Engineer" || len(statuses) != 4 || statuses[1] != StatusDraft {
+		t.Errorf("the contract went through %v to %s as %s", statuses, contract.Status, contract.Position)
+	}
+}
@@ -0,0 +1,302 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Money is an amount held as an integer number of minor units (cents, pence, ...)
+// of an ISO 4217 currency, so ledger arithmetic never drifts the way float64 does
+type Money struct {
+	Currency string `json:"Currency"` // ISO 4217 currency code
+	Units    int64  `json:"Units"`    // Amount in minor units of the currency
+}
+
+// RoundingMode selects how an amount that falls between two minor units is rounded
+type RoundingMode string
+
+// Constants for rounding modes
+const (
+	RoundExact    RoundingMode = "Exact"    // reject any result that needs rounding
+	RoundDown     RoundingMode = "Down"     // towards zero
+	RoundUp       RoundingMode = "Up"       // away from zero
+	RoundHalfUp   RoundingMode = "HalfUp"   // to nearest, ties away from zero
+	RoundHalfEven RoundingMode = "HalfEven" // to nearest, ties to the even neighbour (banker's rounding)
+)
+
+// number of minor unit digits per ISO 4217 currency
+var currencyExponents = map[string]int{
+	"AED": 2, "AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2,
+	"CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2,
+	"INR": 2, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "LKR": 2, "MXN": 2,
+	"MYR": 2, "NOK": 2, "NZD": 2, "OMR": 3, "PHP": 2, "PKR": 2, "PLN": 2, "QAR": 2,
+	"RON": 2, "SAR": 2, "SEK": 2, "SGD": 2, "THB": 2, "TND": 3, "TRY": 2, "TWD": 2,
+	"UGX": 0, "USD": 2, "VND": 0, "ZAR": 2,
+}
+
+// CurrencyExponent returns the number of minor unit digits of an ISO 4217 currency
+func CurrencyExponent(currency string) (int, error) {
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %q", currency)
+	}
+	return exponent, nil
+}
+
+// NewMoney returns an amount of the given minor units in a supported currency
+func NewMoney(units int64, currency string) (Money, error) {
+	if _, err := CurrencyExponent(currency); err != nil {
+		return Money{}, err
+	}
+	return Money{Currency: currency, Units: units}, nil
+}
+
+// ZeroMoney returns a zero amount in the given currency
+func ZeroMoney(currency string) (Money, error) {
+	return NewMoney(0, currency)
+}
+
+// ParseMoney parses a decimal string such as "1234.56" or "-0.5" into an amount of
+// the given currency, rounding digits beyond the currency exponent with mode
+func ParseMoney(value string, currency string, mode RoundingMode) (Money, error) {
+	exponent, err := CurrencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	digits := strings.TrimSpace(value)
+	negative := strings.HasPrefix(digits, "-")
+	digits = strings.TrimPrefix(strings.TrimPrefix(digits, "-"), "+")
+
+	whole, fraction := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		whole, fraction = digits[:i], digits[i+1:]
+	}
+	if whole == "" && fraction == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+	for _, c := range whole + fraction {
+		if c < '0' || c > '9' {
+			return Money{}, fmt.Errorf("invalid amount %q", value)
+		}
+	}
+
+	numerator, ok := new(big.Int).SetString("0"+whole+fraction, 10)
+	if !ok {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+	if negative {
+		numerator.Neg(numerator)
+	}
+
+	// scale the parsed value so that one unit is one minor unit of the currency
+	scale := exponent - len(fraction)
+	denominator := big.NewInt(1)
+	if scale >= 0 {
+		numerator.Mul(numerator, pow10(scale))
+	} else {
+		denominator = pow10(-scale)
+	}
+
+	units, err := roundQuo(numerator, denominator, mode)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %v", value, err)
+	}
+	return Money{Currency: currency, Units: units}, nil
+}
+
+// String formats the amount as a decimal followed by the currency code, e.g. "1234.56 USD"
+func (m Money) String() string {
+	exponent, err := CurrencyExponent(m.Currency)
+	if err != nil || exponent == 0 {
+		return fmt.Sprintf("%d %s", m.Units, m.Currency)
+	}
+
+	sign, units := "", uint64(m.Units)
+	if m.Units < 0 {
+		sign, units = "-", uint64(-m.Units)
+	}
+	scale := pow10(exponent).Uint64()
+	return fmt.Sprintf("%s%d.%0*d %s", sign, units/scale, exponent, units%scale, m.Currency)
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Units == 0
+}
+
+// IsNegative reports whether the amount is below zero
+func (m Money) IsNegative() bool {
+	return m.Units < 0
+}
+
+// IsPositive reports whether the amount is above zero
+func (m Money) IsPositive() bool {
+	return m.Units > 0
+}
+
+// Neg returns the amount with its sign flipped
+func (m Money) Neg() Money {
+	return Money{Currency: m.Currency, Units: -m.Units}
+}
+
+// Cmp compares two amounts of the same currency and returns -1, 0 or +1
+func (m Money) Cmp(other Money) (int, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Units < other.Units:
+		return -1, nil
+	case m.Units > other.Units:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Add returns the sum of two amounts of the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	sum := m.Units + other.Units
+	if (other.Units > 0 && sum < m.Units) || (other.Units < 0 && sum > m.Units) {
+		return Money{}, fmt.Errorf("amount overflow adding %s and %s", m, other)
+	}
+	return Money{Currency: m.Currency, Units: sum}, nil
+}
+
+// Sub returns the difference of two amounts of the same currency
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Units == math.MinInt64 {
+		return Money{}, fmt.Errorf("amount overflow subtracting %s from %s", other, m)
+	}
+	return m.Add(other.Neg())
+}
+
+// Mul returns the amount multiplied by an integer factor
+func (m Money) Mul(factor int64) (Money, error) {
+	return m.MulRat(factor, 1, RoundExact)
+}
+
+// MulRat returns the amount multiplied by numerator/denominator, rounded with mode
+func (m Money) MulRat(numerator int64, denominator int64, mode RoundingMode) (Money, error) {
+	if denominator == 0 {
+		return Money{}, fmt.Errorf("division by zero")
+	}
+	product := new(big.Int).Mul(big.NewInt(m.Units), big.NewInt(numerator))
+	units, err := roundQuo(product, big.NewInt(denominator), mode)
+	if err != nil {
+		return Money{}, fmt.Errorf("failed to scale %s by %d/%d: %v", m, numerator, denominator, err)
+	}
+	return Money{Currency: m.Currency, Units: units}, nil
+}
+
+// Convert returns the amount in another currency at a rate of numerator/denominator
+// units of that currency per unit of this one, rounded with mode
+func (m Money) Convert(currency string, numerator int64, denominator int64, mode RoundingMode) (Money, error) {
+	if denominator == 0 {
+		return Money{}, fmt.Errorf("division by zero")
+	}
+	fromExponent, err := CurrencyExponent(m.Currency)
+	if err != nil {
+		return Money{}, err
+	}
+	toExponent, err := CurrencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	// scale the minor units of this currency to those of the other
+	product := new(big.Int).Mul(big.NewInt(m.Units), big.NewInt(numerator))
+	product.Mul(product, pow10(toExponent))
+	quotient := new(big.Int).Mul(big.NewInt(denominator), pow10(fromExponent))
+	units, err := roundQuo(product, quotient, mode)
+	if err != nil {
+		return Money{}, fmt.Errorf("failed to convert %s to %s: %v", m, currency, err)
+	}
+	return Money{Currency: currency, Units: units}, nil
+}
+
+// MarshalJSON encodes the amount with a fixed field order so that every peer
+// writes byte-identical JSON, rejecting amounts in unsupported currencies. The
+// zero value is allowed so that optional amounts can be left unset
+func (m Money) MarshalJSON() ([]byte, error) {
+	if m == (Money{}) {
+		return []byte(`{"Currency":"","Units":0}`), nil
+	}
+	if _, err := CurrencyExponent(m.Currency); err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(`{"Currency":%q,"Units":%d}`, m.Currency, m.Units)), nil
+}
+
+// UnmarshalJSON decodes an amount and validates its currency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Currency string `json:"Currency"`
+		Units    int64  `json:"Units"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Currency != "" || raw.Units != 0 {
+		if _, err := CurrencyExponent(raw.Currency); err != nil {
+			return err
+		}
+	}
+	m.Currency, m.Units = raw.Currency, raw.Units
+	return nil
+}
+
+// sameCurrency rejects arithmetic between amounts of different currencies
+func (m Money) sameCurrency(other Money) error {
+	if m.Currency != other.Currency {
+		return fmt.Errorf("currency mismatch: %s and %s", m.Currency, other.Currency)
+	}
+	return nil
+}
+
+// roundQuo divides numerator by denominator and rounds the quotient with mode
+func roundQuo(numerator *big.Int, denominator *big.Int, mode RoundingMode) (int64, error) {
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+
+	if remainder.Sign() != 0 {
+		// quotient is truncated towards zero; step is the direction away from zero
+		step := int64(numerator.Sign() * denominator.Sign())
+		twiceRemainder := new(big.Int).Abs(remainder)
+		twiceRemainder.Lsh(twiceRemainder, 1)
+		half := twiceRemainder.Cmp(new(big.Int).Abs(denominator))
+
+		awayFromZero := false
+		switch mode {
+		case RoundExact:
+			return 0, fmt.Errorf("amount has more precision than the currency allows")
+		case RoundDown:
+		case RoundUp:
+			awayFromZero = true
+		case RoundHalfUp:
+			awayFromZero = half >= 0
+		case RoundHalfEven:
+			awayFromZero = half > 0 || (half == 0 && quotient.Bit(0) == 1)
+		default:
+			return 0, fmt.Errorf("unknown rounding mode %q", mode)
+		}
+		if awayFromZero {
+			quotient.Add(quotient, big.NewInt(step))
+		}
+	}
+
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("amount overflow")
+	}
+	return quotient.Int64(), nil
+}
+
+// pow10 returns 10^n as a big integer
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
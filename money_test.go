@@ -0,0 +1,167 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Digits beyond the currency exponent are rounded with the requested mode, on both
+// sides of zero
+func TestParseMoneyRounds(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		mode  RoundingMode
+		units int64
+	}{
+		{"1.005", RoundDown, 100},
+		{"1.005", RoundUp, 101},
+		{"1.001", RoundUp, 101},
+		{"1.005", RoundHalfUp, 101},
+		{"1.0049", RoundHalfUp, 100},
+		{"1.005", RoundHalfEven, 100},
+		{"1.015", RoundHalfEven, 102},
+		{"1.0051", RoundHalfEven, 101},
+		{"-1.005", RoundDown, -100},
+		{"-1.005", RoundUp, -101},
+		{"-1.005", RoundHalfUp, -101},
+		{"-1.005", RoundHalfEven, -100},
+		{"-1.015", RoundHalfEven, -102},
+		{"1.50", RoundExact, 150},
+	} {
+		amount, err := ParseMoney(test.value, "EUR", test.mode)
+		if err != nil {
+			t.Errorf("parsing %s rounded %s: %v", test.value, test.mode, err)
+			continue
+		}
+		if amount.Units != test.units {
+			t.Errorf("%s rounded %s is %d minor units, want %d", test.value, test.mode, amount.Units, test.units)
+		}
+	}
+
+	if _, err := ParseMoney("1.005", "EUR", RoundExact); err == nil {
+		t.Error("an amount needing rounding was parsed exactly")
+	}
+	if _, err := ParseMoney("1.005", "EUR", "Sideways"); err == nil {
+		t.Error("an amount was rounded with an unknown mode")
+	}
+	for _, value := range []string{"", ".", "1,00", "1.2.3", "--1", "1e3", "99999999999999999999"} {
+		if _, err := ParseMoney(value, "EUR", RoundHalfEven); err == nil {
+			t.Errorf("%q was parsed as an amount", value)
+		}
+	}
+}
+
+// Amounts are held in the minor units of their currency and formatted with its
+// number of decimals
+func TestCurrencyExponents(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		currency string
+		units    int64
+		text     string
+	}{
+		{"1234.5", "EUR", 123450, "1234.50 EUR"},
+		{"1234", "JPY", 1234, "1234 JPY"},
+		{"1.234", "KWD", 1234, "1.234 KWD"},
+		{"-0.5", "USD", -50, "-0.50 USD"},
+		{"0.07", "BHD", 70, "0.070 BHD"},
+	} {
+		amount, err := ParseMoney(test.value, test.currency, RoundExact)
+		if err != nil {
+			t.Errorf("parsing %s %s: %v", test.value, test.currency, err)
+			continue
+		}
+		if amount.Units != test.units || amount.String() != test.text {
+			t.Errorf("%s %s is %d minor units formatted %q, want %d formatted %q", test.value, test.currency, amount.Units, amount, test.units, test.text)
+		}
+	}
+
+	if _, err := ParseMoney("1.5", "JPY", RoundExact); err == nil {
+		t.Error("a fraction of a yen was parsed")
+	}
+	if _, err := ParseMoney("1.00", "XXX", RoundExact); err == nil {
+		t.Error("an amount in an unsupported currency was parsed")
+	}
+	if _, err := NewMoney(1, "eur"); err == nil {
+		t.Error("an amount was made in a lower case currency code")
+	}
+
+	// converting scales between the exponents of both currencies
+	yen, err := NewMoney(10000, "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	euros, err := yen.Convert("EUR", 61, 10000, RoundHalfEven)
+	if err != nil || euros.String() != "61.00 EUR" {
+		t.Errorf("10000 JPY at 0.0061 converted to %s: %v", euros, err)
+	}
+}
+
+// Arithmetic and comparison refuse amounts of different currencies, and overflow
+// instead of wrapping around
+func TestMoneyArithmeticChecksCurrencyAndOverflow(t *testing.T) {
+	euros, _ := NewMoney(100, "EUR")
+	dollars, _ := NewMoney(100, "USD")
+
+	if _, err := euros.Add(dollars); err == nil || !strings.HasPrefix(err.Error(), "currency mismatch") {
+		t.Errorf("euros were added to dollars: %v", err)
+	}
+	if _, err := euros.Sub(dollars); err == nil {
+		t.Error("dollars were subtracted from euros")
+	}
+	if _, err := euros.Cmp(dollars); err == nil {
+		t.Error("euros were compared with dollars")
+	}
+
+	largest, _ := NewMoney(math.MaxInt64, "EUR")
+	if _, err := largest.Add(euros); err == nil {
+		t.Error("adding to the largest amount wrapped around")
+	}
+	smallest, _ := NewMoney(math.MinInt64, "EUR")
+	if _, err := euros.Sub(smallest); err == nil {
+		t.Error("subtracting the smallest amount wrapped around")
+	}
+	if _, err := largest.Mul(2); err == nil {
+		t.Error("doubling the largest amount wrapped around")
+	}
+	if _, err := euros.MulRat(1, 0, RoundHalfEven); err == nil {
+		t.Error("an amount was divided by zero")
+	}
+
+	third, err := euros.MulRat(1, 3, RoundHalfEven)
+	if err != nil || third.Units != 33 {
+		t.Errorf("a third of 1.00 EUR is %s: %v", third, err)
+	}
+}
+
+// Amounts encode to the same bytes on every peer, and decoding rejects unsupported
+// currencies except on the unset zero value
+func TestMoneyJSONIsStable(t *testing.T) {
+	amount, _ := NewMoney(-123450, "EUR")
+	encoded, err := json.Marshal(struct {
+		Amount Money
+		Unset  Money
+	}{Amount: amount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"Amount":{"Currency":"EUR","Units":-123450},"Unset":{"Currency":"","Units":0}}`; string(encoded) != want {
+		t.Errorf("encoded %s, want %s", encoded, want)
+	}
+
+	var decoded Money
+	if err := json.Unmarshal([]byte(`{"Units":-123450,"Currency":"EUR"}`), &decoded); err != nil || decoded != amount {
+		t.Errorf("decoded %s: %v", decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`{"Currency":"","Units":0}`), &decoded); err != nil || decoded != (Money{}) {
+		t.Errorf("the unset amount decoded to %s: %v", decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`{"Currency":"XXX","Units":1}`), &decoded); err == nil {
+		t.Error("an amount in an unsupported currency was decoded")
+	}
+	if _, err := json.Marshal(Money{Currency: "XXX", Units: 1}); err == nil {
+		t.Error("an amount in an unsupported currency was encoded")
+	}
+}
@@ -0,0 +1,271 @@
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NACHA record layout: fixed 94 character records in blocks of ten, with 15 characters
+// for the individual identification number of an entry
+const (
+	nachaRecordSize         = 94
+	nachaBlockingFactor     = 10
+	nachaIndividualIDLength = 15
+)
+
+// NACHA codes of a credits-only PPD batch into checking accounts
+const (
+	nachaServiceCreditsOnly = "220"
+	nachaCheckingCredit     = "22"
+)
+
+// characters a NACHA file ID modifier runs through for files of one origin on one day
+const nachaFileIDModifiers = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// ASCII spellings of letters that do not decompose into an ASCII letter and accents
+var nachaTransliterations = map[rune]string{
+	'ß': "SS", 'Æ': "AE", 'æ': "AE", 'Œ': "OE", 'œ': "OE", 'Ø': "O", 'ø': "O",
+	'Ł': "L", 'ł': "L", 'Đ': "D", 'đ': "D", 'Þ': "TH", 'þ': "TH", 'ı': "I",
+}
+
+// a PPD credit to an employee's checking account
+type NachaEntry struct {
+	RoutingNumber string // ABA routing number of the receiving bank
+	AccountNumber string // Account number at the receiving bank
+	Amount        Money  // Amount in USD
+	IndividualID  string // Reference of the payment, at most 15 characters are kept
+	Name          string // Name of the employee, at most 22 characters are kept
+}
+
+// a NACHA file holding one PPD batch of credits paid from an employer's account
+type NachaFile struct {
+	OriginatingDFI   string    // ABA routing number of the employer's bank, the file's destination
+	DestinationName  string    // Name of the employer's bank, may be empty
+	CompanyID        string    // 10 character company identification, usually "1" followed by the EIN
+	CompanyName      string    // Name of the employer
+	CreatedAt        time.Time // Creation time of the file
+	FileIDModifier   string    // Distinguishes files of the company created on the same day
+	EntryDescription string    // Company entry description shown to employees, e.g. "PAYROLL"
+	EffectiveDate    time.Time // Date the credits settle
+	Entries          []NachaEntry
+}
+
+// validRoutingNumber reports whether value is a nine digit ABA routing number with a
+// valid check digit
+func validRoutingNumber(value string) bool {
+	if len(value) != 9 {
+		return false
+	}
+	weights := []int{3, 7, 1, 3, 7, 1, 3, 7, 1}
+	sum := 0
+	for i, digit := range value {
+		if digit < '0' || digit > '9' {
+			return false
+		}
+		sum += int(digit-'0') * weights[i]
+	}
+	return sum%10 == 0
+}
+
+// nachaASCII transliterates a value into the upper case printable ASCII of NACHA
+// files. Accents are dropped, a few letters are spelled out and any other character
+// becomes "?"
+func nachaASCII(value string) string {
+	var ascii strings.Builder
+	for _, r := range norm.NFD.String(value) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case r >= ' ' && r <= '~':
+			ascii.WriteRune(unicode.ToUpper(r))
+		case nachaTransliterations[r] != "":
+			ascii.WriteString(nachaTransliterations[r])
+		default:
+			ascii.WriteByte('?')
+		}
+	}
+	return ascii.String()
+}
+
+// nachaText formats an alphanumeric field: upper case ASCII, left justified and cut
+// to width bytes
+func nachaText(value string, width int) string {
+	value = nachaASCII(value)
+	if len(value) > width {
+		value = value[:width]
+	}
+	return value + strings.Repeat(" ", width-len(value))
+}
+
+// nachaNumber formats a numeric field: right justified and zero filled
+func nachaNumber(value int64, width int) string {
+	return fmt.Sprintf("%0*d", width, value)
+}
+
+// EntryHash returns the NACHA entry hash of the file: the sum of the first eight
+// digits of the receiving routing numbers, keeping the rightmost ten digits
+func (f *NachaFile) EntryHash() int64 {
+	var hash int64
+	for _, entry := range f.Entries {
+		var dfi int64
+		fmt.Sscanf(entry.RoutingNumber[:8], "%d", &dfi)
+		hash += dfi
+	}
+	return hash % 10000000000
+}
+
+// TotalCredit returns the sum of the entry amounts
+func (f *NachaFile) TotalCredit() (Money, error) {
+	total, err := ZeroMoney("USD")
+	if err != nil {
+		return Money{}, err
+	}
+	for _, entry := range f.Entries {
+		total, err = total.Add(entry.Amount)
+		if err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+func (f *NachaFile) validate() error {
+	if !validRoutingNumber(f.OriginatingDFI) {
+		return fmt.Errorf("originating DFI %q is not a valid ABA routing number", f.OriginatingDFI)
+	}
+	if len(f.CompanyID) != 10 {
+		return fmt.Errorf("company identification %q must be 10 characters", f.CompanyID)
+	}
+	if f.CompanyName == "" {
+		return fmt.Errorf("a company name is required")
+	}
+	if len(f.FileIDModifier) != 1 || !strings.Contains(nachaFileIDModifiers, f.FileIDModifier) {
+		return fmt.Errorf("file ID modifier %q must be one of A-Z or 0-9", f.FileIDModifier)
+	}
+	if f.EntryDescription == "" {
+		return fmt.Errorf("a company entry description is required")
+	}
+	if len(f.Entries) == 0 {
+		return fmt.Errorf("a NACHA file needs at least one entry")
+	}
+
+	for i, entry := range f.Entries {
+		if !validRoutingNumber(entry.RoutingNumber) {
+			return fmt.Errorf("entry %d: routing number %q is not a valid ABA routing number", i+1, entry.RoutingNumber)
+		}
+		if entry.AccountNumber == "" || len(entry.AccountNumber) > 17 {
+			return fmt.Errorf("entry %d: account number must be 1 to 17 characters", i+1)
+		}
+		if entry.Amount.Currency != "USD" {
+			return fmt.Errorf("entry %d: NACHA entries are paid in USD, not %s", i+1, entry.Amount.Currency)
+		}
+		if !entry.Amount.IsPositive() || entry.Amount.Units > 9999999999 {
+			return fmt.Errorf("entry %d: amount %s does not fit a NACHA entry", i+1, entry.Amount)
+		}
+		if entry.Name == "" {
+			return fmt.Errorf("entry %d: a receiver name is required", i+1)
+		}
+	}
+
+	return nil
+}
+
+// RenderNachaPPD renders a NACHA file with a single credits-only PPD batch, padded to
+// full blocks
+func RenderNachaPPD(f *NachaFile) ([]byte, error) {
+	err := f.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := f.TotalCredit()
+	if err != nil {
+		return nil, err
+	}
+	if total.Units > 999999999999 {
+		return nil, fmt.Errorf("total credit %s does not fit a NACHA file", total)
+	}
+
+	odfi := f.OriginatingDFI[:8]
+	batchNumber := nachaNumber(1, 7)
+	entryCount := int64(len(f.Entries))
+	entryHash := nachaNumber(f.EntryHash(), 10)
+	created := f.CreatedAt.UTC()
+
+	var records []string
+	records = append(records, "1"+"01"+
+		" "+f.OriginatingDFI+
+		nachaText(f.CompanyID, 10)+
+		created.Format("060102")+
+		created.Format("1504")+
+		f.FileIDModifier+
+		"094"+
+		nachaNumber(nachaBlockingFactor, 2)+
+		"1"+
+		nachaText(f.DestinationName, 23)+
+		nachaText(f.CompanyName, 23)+
+		nachaText("", 8))
+
+	records = append(records, "5"+nachaServiceCreditsOnly+
+		nachaText(f.CompanyName, 16)+
+		nachaText("", 20)+
+		nachaText(f.CompanyID, 10)+
+		"PPD"+
+		nachaText(f.EntryDescription, 10)+
+		nachaText("", 6)+
+		f.EffectiveDate.UTC().Format("060102")+
+		nachaText("", 3)+
+		"1"+
+		odfi+
+		batchNumber)
+
+	for i, entry := range f.Entries {
+		records = append(records, "6"+nachaCheckingCredit+
+			entry.RoutingNumber+
+			nachaText(entry.AccountNumber, 17)+
+			nachaNumber(entry.Amount.Units, 10)+
+			nachaText(entry.IndividualID, nachaIndividualIDLength)+
+			nachaText(entry.Name, 22)+
+			nachaText("", 2)+
+			"0"+
+			odfi+nachaNumber(int64(i+1), 7))
+	}
+
+	records = append(records, "8"+nachaServiceCreditsOnly+
+		nachaNumber(entryCount, 6)+
+		entryHash+
+		nachaNumber(0, 12)+
+		nachaNumber(total.Units, 12)+
+		nachaText(f.CompanyID, 10)+
+		nachaText("", 19)+
+		nachaText("", 6)+
+		odfi+
+		batchNumber)
+
+	blocks := (len(records) + 1 + nachaBlockingFactor - 1) / nachaBlockingFactor
+	records = append(records, "9"+
+		nachaNumber(1, 6)+
+		nachaNumber(int64(blocks), 6)+
+		nachaNumber(entryCount, 8)+
+		entryHash+
+		nachaNumber(0, 12)+
+		nachaNumber(total.Units, 12)+
+		nachaText("", 39))
+
+	for len(records)%nachaBlockingFactor != 0 {
+		records = append(records, strings.Repeat("9", nachaRecordSize))
+	}
+
+	var file strings.Builder
+	for _, record := range records {
+		if len(record) != nachaRecordSize {
+			return nil, fmt.Errorf("NACHA record %q is %d characters, not %d", record[:1], len(record), nachaRecordSize)
+		}
+		file.WriteString(record)
+		file.WriteString("\n")
+	}
+	return []byte(file.String()), nil
+}
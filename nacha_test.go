@@ -0,0 +1,64 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Alphanumeric fields are transliterated to upper case ASCII before they are cut
+// and padded, so every field is exactly as many bytes wide as the layout says
+func TestNachaTextIsASCII(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		width int
+		want  string
+	}{
+		{"Acme", 6, "ACME  "},
+		{"José Müller", 11, "JOSE MULLER"},
+		{"Straße Łódź", 14, "STRASSE LODZ  "},
+		{"Ærøskøbing", 8, "AEROSKOB"},
+		{"王小明 Li", 7, "??? LI "},
+		{"Zoë\tBrontë", 20, "ZOE?BRONTE          "},
+	} {
+		if got := nachaText(test.value, test.width); got != test.want {
+			t.Errorf("nachaText(%q, %d) = %q, want %q", test.value, test.width, got, test.want)
+		}
+	}
+}
+
+// A file paying receivers with non-ASCII names keeps its fixed record layout
+func TestRenderNachaPPD(t *testing.T) {
+	content, err := RenderNachaPPD(&NachaFile{
+		OriginatingDFI:   "021000021",
+		CompanyID:        "1234567890",
+		CompanyName:      "Société Générale Façades",
+		CreatedAt:        time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+		FileIDModifier:   "A",
+		EntryDescription: nachaPayrollDescription,
+		EffectiveDate:    time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+		Entries: []NachaEntry{
+			{RoutingNumber: "011000015", AccountNumber: "12345678", Amount: Money{Currency: "USD", Units: 150000}, IndividualID: "E2E1", Name: "Zoë Ångström-Þórsdóttir"},
+			{RoutingNumber: "021000021", AccountNumber: "87654321", Amount: Money{Currency: "USD", Units: 2550}, IndividualID: "E2E2", Name: "Bob"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(records) != nachaBlockingFactor {
+		t.Fatalf("the file has %d records, want one block", len(records))
+	}
+	for i, record := range records {
+		if len(record) != nachaRecordSize {
+			t.Errorf("record %d is %d bytes: %q", i+1, len(record), record)
+		}
+	}
+	if name := records[2][54:76]; name != "ZOE ANGSTROM-THORSDOTT" {
+		t.Errorf("the first receiver is named %q", name)
+	}
+	if control := records[4]; control[:4] != "8220" || control[4:10] != "000002" || control[10:20] != "0003200003" || control[32:44] != "000000152550" {
+		t.Errorf("the batch control record is %q", control)
+	}
+}
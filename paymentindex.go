@@ -0,0 +1,168 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key indexing every payment-like record
+const paymentIndex = "Payment"
+
+// fixed width timestamp layout so index keys sort chronologically
+const paymentIndexTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// key prefixes of the payment-like records written under plain keys
+var paymentKeyPrefixes = []string{"PAY_", "WITHDRAW_", "CROSS_", "LOCAL_"}
+
+// value stored under a Payment~contract~employee~timestamp~id index key
+type PaymentIndexEntry struct {
+	PaymentID string    `json:"PaymentID"` // Key of the indexed payment record
+	Type      string    `json:"Type"`      // Payment type (Regular, Advance, Withdrawal, CrossBorder, Local)
+	Date      time.Time `json:"Date"`      // Date the payment was recorded
+}
+
+// putPaymentIndex writes the composite index entry for a payment-like record
+func putPaymentIndex(ctx contractapi.TransactionContextInterface, contractID string, employee string, date time.Time, paymentID string, paymentType string) error {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(paymentIndex, []string{contractID, employee, date.UTC().Format(paymentIndexTimeLayout), paymentID})
+	if err != nil {
+		return fmt.Errorf("failed to create payment index key: %v", err)
+	}
+
+	entryJSON, err := json.Marshal(PaymentIndexEntry{PaymentID: paymentID, Type: paymentType, Date: date.UTC()})
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(indexKey, entryJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// getPaymentIndexEntries returns the index entries matching the given partial key
+// (contract, or contract and employee) in chronological order
+func getPaymentIndexEntries(ctx contractapi.TransactionContextInterface, keys ...string) ([]PaymentIndexEntry, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(paymentIndex, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var entries []PaymentIndexEntry
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry PaymentIndexEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// MigratePaymentIndexes back-fills index entries for payments written under plain
+// keys before the index existed and returns the number of entries created
+func (s *PaymentContract) MigratePaymentIndexes(ctx contractapi.TransactionContextInterface) (int, error) {
+	_, err := authorize(ctx, "MigratePaymentIndexes")
+	if err != nil {
+		return 0, err
+	}
+
+	// composite keys are not returned by range queries, so this only visits plain records
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return migrated, err
+		}
+		if !isPaymentKey(queryResponse.Key) {
+			continue
+		}
+
+		var record struct {
+			ID         string    `json:"ID"`
+			ContractID string    `json:"ContractID"`
+			Employee   string    `json:"Employee"`
+			Date       time.Time `json:"Date"`
+			Type       string    `json:"Type"`
+		}
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to read payment %s: %v", queryResponse.Key, err)
+		}
+		if record.ContractID == "" || record.Employee == "" {
+			continue
+		}
+
+		// bank payments carry neither a type nor a date
+		switch {
+		case strings.HasPrefix(queryResponse.Key, "CROSS_"):
+			record.Type = CrossBorder
+		case strings.HasPrefix(queryResponse.Key, "LOCAL_"):
+			record.Type = Local
+		}
+		if record.Date.IsZero() {
+			record.Date = legacyPaymentDate(queryResponse.Key)
+		}
+
+		indexKey, err := ctx.GetStub().CreateCompositeKey(paymentIndex, []string{record.ContractID, record.Employee, record.Date.UTC().Format(paymentIndexTimeLayout), queryResponse.Key})
+		if err != nil {
+			return migrated, fmt.Errorf("failed to create payment index key: %v", err)
+		}
+		indexJSON, err := ctx.GetStub().GetState(indexKey)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if indexJSON != nil {
+			continue
+		}
+
+		err = putPaymentIndex(ctx, record.ContractID, record.Employee, record.Date, queryResponse.Key, record.Type)
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// isPaymentKey reports whether a plain key belongs to a payment-like record
+func isPaymentKey(key string) bool {
+	for _, prefix := range paymentKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyPaymentDate recovers the creation time of a payment whose key ends with the
+// UnixNano suffix used before IDs were derived from the transaction ID
+func legacyPaymentDate(key string) time.Time {
+	suffix := key[strings.LastIndex(key, "_")+1:]
+	nanos, err := strconv.ParseInt(suffix, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
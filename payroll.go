@@ -0,0 +1,418 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key indexing contracts by employer
+const employerContractIndex = "EmployerContract"
+
+// object type of the composite key storing payroll runs
+const payrollRunIndex = "PayrollRun"
+
+// days of the longest pay period, a month; a payroll run covers at most one
+const maxPayrollDays = 31
+
+// summary of a payroll run over an interval for one employer
+type PayrollRun struct {
+	ID        string           `json:"ID"`        // Unique identifier for the run
+	Employer  string           `json:"Employer"`  // Name of the employer
+	StartDate time.Time        `json:"StartDate"` // First day of the interval
+	EndDate   time.Time        `json:"EndDate"`   // Last day of the interval
+	Totals    map[string]Money `json:"Totals"`    // Gross paid per currency
+	NetTotals map[string]Money `json:"NetTotals"` // Net paid per currency after withholding
+	Paid      int              `json:"Paid"`      // Number of contracts paid
+	Failed    int              `json:"Failed"`    // Number of contracts that could not be paid
+	Payments  []string         `json:"Payments"`  // IDs of the payments made
+	Failures  []PayrollFailure `json:"Failures"`  // Contracts that could not be paid and why
+	RunBy     string           `json:"RunBy"`     // Name of the party that ran the payroll
+	RunAt     time.Time        `json:"RunAt"`     // Transaction timestamp of the run
+
+	PrivateDataHash string `json:"PrivateDataHash"` // Salted hash of the totals held in the contract collection
+}
+
+// a contract a payroll run could not pay
+type PayrollFailure struct {
+	ContractID string `json:"ContractID"` // ID of the contract
+	Employee   string `json:"Employee"`   // Name of the employee
+	Reason     string `json:"Reason"`     // Why the contract was not paid
+}
+
+// putEmployerContractIndex writes the index entry listing a contract under its employer
+func putEmployerContractIndex(ctx contractapi.TransactionContextInterface, contract *Contract) error {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(employerContractIndex, []string{contract.Employer, contract.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create employer index key: %v", err)
+	}
+
+	// an empty value would delete the key, so store a single null byte
+	err = ctx.GetStub().PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// employerContractIDs returns the IDs of every contract of an employer
+func employerContractIDs(ctx contractapi.TransactionContextInterface, employer string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(employerContractIndex, []string{employer})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var contractIDs []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		contractIDs = append(contractIDs, keyParts[1])
+	}
+
+	return contractIDs, nil
+}
+
+// MigrateContractIndexes back-fills the employer index for contracts written before
+// it existed and returns the number of entries created
+func (s *PaymentContract) MigrateContractIndexes(ctx contractapi.TransactionContextInterface) (int, error) {
+	_, err := authorize(ctx, "MigrateContractIndexes")
+	if err != nil {
+		return 0, err
+	}
+
+	// contracts are stored under plain keys, which are the only keys range queries return
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return migrated, err
+		}
+		if isPaymentKey(queryResponse.Key) {
+			continue
+		}
+
+		// advance requests and other records under plain keys carry no employer
+		var contract Contract
+		if json.Unmarshal(queryResponse.Value, &contract) != nil || contract.ID != queryResponse.Key || contract.Employer == "" || contract.Status == "" {
+			continue
+		}
+
+		indexKey, err := ctx.GetStub().CreateCompositeKey(employerContractIndex, []string{contract.Employer, contract.ID})
+		if err != nil {
+			return migrated, fmt.Errorf("failed to create employer index key: %v", err)
+		}
+		indexJSON, err := ctx.GetStub().GetState(indexKey)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if indexJSON != nil {
+			continue
+		}
+
+		err = putEmployerContractIndex(ctx, &contract)
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// payrollRuns returns every payroll run of an employer
+func payrollRuns(ctx contractapi.TransactionContextInterface, employer string) ([]*PayrollRun, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(payrollRunIndex, []string{employer})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var runs []*PayrollRun
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var run PayrollRun
+		err = json.Unmarshal(queryResponse.Value, &run)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, &run)
+	}
+
+	return runs, nil
+}
+
+// payrollPayment builds the payment of one contract for the interval [start, end) in a
+// payroll run, or returns an error explaining why the contract cannot be paid
+func payrollPayment(ctx contractapi.TransactionContextInterface, contract *Contract, runID string, start time.Time, end time.Time, now time.Time) (*Payment, *YearToDate, error) {
+	// the payment covers one pay period of the contract, which its payslip is found by
+	frequency := contract.termsAt(start).withDefaults().PayFrequency
+	if periodStart, periodEnd := payPeriod(frequency, start); !periodStart.Equal(start) || !periodEnd.Equal(end) {
+		return nil, nil, fmt.Errorf("the interval %s to %s is not a %s pay period of the contract", start.Format(dateLayout), end.AddDate(0, 0, -1).Format(dateLayout), frequency)
+	}
+
+	err := loadContractPay(ctx, contract)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	components, err := payComponents(contract, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	gross, err := components[0].Amount.Add(components[1].Amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !gross.IsPositive() {
+		return nil, nil, fmt.Errorf("no pay is due for the interval")
+	}
+	err = checkPaymentLimits(ctx, contract, gross, start)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// regular pay is made once per pay period, as in ProcessPayment
+	paidBy, err := paidForPeriod(ctx, contract, start, end.AddDate(0, 0, -1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if paidBy != "" {
+		return nil, nil, fmt.Errorf("the interval overlaps the pay period of payment %s", paidBy)
+	}
+
+	payment := Payment{
+		ID:           recordID(ctx, "PAY", contract.ID, contract.Employee),
+		ContractID:   contract.ID,
+		Employee:     contract.Employee,
+		Amount:       gross,
+		Date:         now,
+		Type:         RegularPayment,
+		PayrollRunID: runID,
+		PeriodStart:  start,
+		PeriodEnd:    end.AddDate(0, 0, -1),
+		Components:   components,
+	}
+	payment.snapshotTerms(contract)
+
+	ytd, err := withholdPayment(ctx, contract, &payment, daysBetween(start, end))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &payment, ytd, nil
+}
+
+// RunPayroll pays every Active contract of the calling employer for an interval and
+// records a summary of the run in the same transaction. Contracts that cannot be paid,
+// among them those whose pay period is not the interval, are listed as failures
+// without stopping the run. A run is refused if its interval
+// overlaps an earlier run. The totals of the run are kept in the contract collection
+// and left out of the returned summary, which is recorded in the block; they are read
+// with GetPayrollRuns. A retry with the same idempotency key and parameters returns
+// the original run instead of paying again
+func (s *PaymentContract) RunPayroll(ctx contractapi.TransactionContextInterface, idempotencyKey string, employer string, interval PayrollInterval) (*PayrollRun, error) {
+	caller, err := authorize(ctx, "RunPayroll")
+	if err != nil {
+		return nil, err
+	}
+	err = requireEmployer(ctx, caller, employer)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := startOfDay(interval.StartDate), startOfDay(interval.EndDate)
+	if interval.StartDate.IsZero() || end.Before(start) {
+		return nil, fmt.Errorf("invalid payroll interval %s to %s", start.Format(dateLayout), end.Format(dateLayout))
+	}
+	if days := daysBetween(start, end) + 1; days > maxPayrollDays {
+		return nil, fmt.Errorf("payroll interval of %d days is longer than the %d days of the longest pay period", days, maxPayrollDays)
+	}
+
+	claim, replayed, err := claimIdempotencyKey(ctx, caller, "RunPayroll", idempotencyKey, "", employer, start.Format(dateLayout), end.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return readPayrollRun(ctx, employer, claim.Result)
+	}
+
+	runs, err := payrollRuns(ctx, employer)
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		if !start.After(run.EndDate) && !run.StartDate.After(end) {
+			return nil, fmt.Errorf("payroll interval overlaps run %s covering %s to %s", run.ID, run.StartDate.Format(dateLayout), run.EndDate.Format(dateLayout))
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	run := PayrollRun{
+		ID:        recordID(ctx, "PAYROLL", employer),
+		Employer:  employer,
+		StartDate: start,
+		EndDate:   end,
+		Totals:    map[string]Money{},
+		NetTotals: map[string]Money{},
+		RunBy:     caller.Name,
+		RunAt:     now,
+	}
+
+	contractIDs, err := employerContractIDs(ctx, employer)
+	if err != nil {
+		return nil, err
+	}
+	var paidContractID string
+	for _, contractID := range contractIDs {
+		contract, err := readContract(ctx, contractID)
+		if err != nil {
+			return nil, err
+		}
+		// the first run after a scheduled status change took effect writes it, which
+		// writes off the advances of a contract terminated in the meantime
+		if contract.statusChangesDue(now) {
+			err = putContract(ctx, contract)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if contract.Status != StatusActive {
+			continue
+		}
+
+		payment, ytd, err := payrollPayment(ctx, contract, run.ID, start, end.AddDate(0, 0, 1), now)
+		if err != nil {
+			run.Failed++
+			run.Failures = append(run.Failures, PayrollFailure{ContractID: contractID, Employee: contract.Employee, Reason: err.Error()})
+			continue
+		}
+
+		// failing to write a payment aborts the whole run
+		err = recoverAdvances(ctx, payment)
+		if err != nil {
+			return nil, err
+		}
+		err = recordPayment(ctx, payment, EmployerPayable)
+		if err != nil {
+			return nil, err
+		}
+		err = putYearToDate(ctx, ytd)
+		if err != nil {
+			return nil, err
+		}
+		err = anchorPayslip(ctx, contract, payment)
+		if err != nil {
+			return nil, err
+		}
+
+		err = addTotal(run.Totals, payment.Amount.Currency, payment.Amount)
+		if err != nil {
+			return nil, err
+		}
+		err = addTotal(run.NetTotals, payment.Net.Currency, payment.Net)
+		if err != nil {
+			return nil, err
+		}
+		run.Paid++
+		run.Payments = append(run.Payments, payment.ID)
+		paidContractID = contractID
+	}
+
+	runKey, err := ctx.GetStub().CreateCompositeKey(payrollRunIndex, []string{employer, run.ID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payroll run key: %v", err)
+	}
+
+	public, err := putPayrollRunTotals(ctx, &run, paidContractID)
+	if err != nil {
+		return nil, err
+	}
+
+	runJSON, err := json.Marshal(public)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(runKey, runJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return public, completeIdempotencyKey(ctx, claim, run.ID)
+}
+
+// readPayrollRun reads a payroll run of an employer as written to the world state
+func readPayrollRun(ctx contractapi.TransactionContextInterface, employer string, runID string) (*PayrollRun, error) {
+	runKey, err := ctx.GetStub().CreateCompositeKey(payrollRunIndex, []string{employer, runID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payroll run key: %v", err)
+	}
+
+	runJSON, err := ctx.GetStub().GetState(runKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if runJSON == nil {
+		return nil, fmt.Errorf("the payroll run %s does not exist", runID)
+	}
+
+	var run PayrollRun
+	err = json.Unmarshal(runJSON, &run)
+	if err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// GetPayrollRuns returns the payroll runs of an employer to the employer and auditors
+func (s *PaymentContract) GetPayrollRuns(ctx contractapi.TransactionContextInterface, employer string) ([]*PayrollRun, error) {
+	caller, err := authorize(ctx, "GetPayrollRuns")
+	if err != nil {
+		return nil, err
+	}
+	if caller.Role == RoleEmployer {
+		err = requireEmployer(ctx, caller, employer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	runs, err := payrollRuns(ctx, employer)
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		err = loadVisiblePayrollRunTotals(ctx, run)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return runs, nil
+}
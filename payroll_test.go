@@ -0,0 +1,33 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// A run pays each contract for exactly one of its pay periods, so that every payment
+// of a run has a payslip
+func TestPayrollRunPaysOnePayPeriod(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	straddling := PayrollInterval{StartDate: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2024, 4, 9, 0, 0, 0, 0, time.UTC)}
+	run, err := s.RunPayroll(l.begin(p.employer, nil), "", p.employer.name, straddling)
+	l.must(err)
+	if run.Paid != 0 || run.Failed != 1 || !strings.HasPrefix(run.Failures[0].Reason, "the interval 2024-03-10 to 2024-04-09 is not a Monthly pay period") {
+		t.Errorf("an interval across two pay periods was paid: %+v", run)
+	}
+
+	may := PayrollInterval{StartDate: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)}
+	run, err = s.RunPayroll(l.begin(p.employer, nil), "", p.employer.name, may)
+	l.must(err)
+	if run.Paid != 1 {
+		t.Fatalf("the pay period of May was not paid: %+v", run)
+	}
+	_, err = s.GetPayslip(l.begin(p.employer, nil), "C1", "2024-05-01", "2024-05-31")
+	l.must(err)
+}
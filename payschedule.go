@@ -0,0 +1,375 @@
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Constants for pay frequencies
+const (
+	Weekly      = "Weekly"
+	Biweekly    = "Biweekly"
+	SemiMonthly = "SemiMonthly"
+	Monthly     = "Monthly"
+)
+
+// Constants for the unit a salary is quoted in
+const (
+	AnnualBasis  = "Annual"
+	MonthlyBasis = "Monthly"
+	HourlyBasis  = "Hourly"
+)
+
+// Constants for how partial pay periods are prorated
+const (
+	CalendarDays = "CalendarDays"
+	WorkingDays  = "WorkingDays"
+)
+
+// Constants for when variable pay falls due. PerPeriod spreads it over every pay
+// period, the others pay an installment in the pay period containing the last day
+// of each month, quarter or year
+const (
+	VariablePerPeriod = "PerPeriod"
+	VariableMonthly   = "Monthly"
+	VariableQuarterly = "Quarterly"
+	VariableAnnual    = "Annual"
+)
+
+// pay periods in a year for each frequency
+var periodsPerYear = map[string]int64{
+	Weekly:      52,
+	Biweekly:    26,
+	SemiMonthly: 24,
+	Monthly:     12,
+}
+
+// variable pay installments in a year for each schedule
+var installmentsPerYear = map[string]int64{
+	VariableMonthly:   12,
+	VariableQuarterly: 4,
+	VariableAnnual:    1,
+}
+
+// Monday biweekly pay periods are counted from
+var biweeklyEpoch = time.Date(1970, time.January, 5, 0, 0, 0, 0, time.UTC)
+
+// how the pay of a contract is quoted, paid and prorated. Empty fields take the
+// defaults of contracts recorded before pay schedules: monthly pay of an annual
+// salary, prorated by calendar days, with variable pay spread over every period
+type PaySchedule struct {
+	PayFrequency        string `json:"PayFrequency"`        // Weekly, Biweekly, SemiMonthly or Monthly
+	PayBasis            string `json:"PayBasis"`            // Unit the salary is quoted in: Annual, Monthly or Hourly
+	HoursPerWeek        int64  `json:"HoursPerWeek"`        // Contracted hours per week, required for Hourly pay
+	Proration           string `json:"Proration"`           // CalendarDays or WorkingDays
+	VariablePaySchedule string `json:"VariablePaySchedule"` // PerPeriod, Monthly, Quarterly or Annual
+}
+
+// withDefaults returns the schedule with empty fields set to their defaults
+func (p PaySchedule) withDefaults() PaySchedule {
+	if p.PayFrequency == "" {
+		p.PayFrequency = Monthly
+	}
+	if p.PayBasis == "" {
+		p.PayBasis = AnnualBasis
+	}
+	if p.Proration == "" {
+		p.Proration = CalendarDays
+	}
+	if p.VariablePaySchedule == "" {
+		p.VariablePaySchedule = VariablePerPeriod
+	}
+	return p
+}
+
+// validate checks that every field of a schedule holds a known value
+func (p PaySchedule) validate() error {
+	if _, ok := periodsPerYear[p.PayFrequency]; !ok {
+		return fmt.Errorf("unknown pay frequency %q", p.PayFrequency)
+	}
+	switch p.PayBasis {
+	case AnnualBasis, MonthlyBasis:
+	case HourlyBasis:
+		if p.HoursPerWeek <= 0 || p.HoursPerWeek > 168 {
+			return fmt.Errorf("hourly pay requires between 1 and 168 hours per week, not %d", p.HoursPerWeek)
+		}
+	default:
+		return fmt.Errorf("unknown pay basis %q", p.PayBasis)
+	}
+	if p.Proration != CalendarDays && p.Proration != WorkingDays {
+		return fmt.Errorf("unknown proration method %q", p.Proration)
+	}
+	if _, ok := installmentsPerYear[p.VariablePaySchedule]; !ok && p.VariablePaySchedule != VariablePerPeriod {
+		return fmt.Errorf("unknown variable pay schedule %q", p.VariablePaySchedule)
+	}
+	return nil
+}
+
+// basisPerYear returns how many salary units are paid in a year
+func (p PaySchedule) basisPerYear() int64 {
+	switch p.PayBasis {
+	case MonthlyBasis:
+		return 12
+	case HourlyBasis:
+		return p.HoursPerWeek * 52
+	}
+	return 1
+}
+
+// payPeriod returns the pay period of a frequency containing t as a [start, end) interval.
+// Weeks start on Monday
+func payPeriod(frequency string, t time.Time) (time.Time, time.Time) {
+	day := startOfDay(t)
+	switch frequency {
+	case Weekly:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case Biweekly:
+		days := daysBetween(biweeklyEpoch, day)
+		periods := days / 14
+		if days < 0 && days%14 != 0 {
+			periods--
+		}
+		start := biweeklyEpoch.AddDate(0, 0, int(periods*14))
+		return start, start.AddDate(0, 0, 14)
+	case SemiMonthly:
+		monthStart, monthEnd := monthOf(day)
+		middle := monthStart.AddDate(0, 0, 15)
+		if day.Before(middle) {
+			return monthStart, middle
+		}
+		return middle, monthEnd
+	}
+	return monthOf(day)
+}
+
+// variablePayPeriod returns the month, quarter or year containing t as a [start, end) interval
+func variablePayPeriod(schedule string, t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	switch schedule {
+	case VariableQuarterly:
+		start := time.Date(t.Year(), t.Month()-(t.Month()-1)%3, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 3, 0)
+	case VariableAnnual:
+		start := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0)
+	}
+	return monthOf(t)
+}
+
+// prorationUnits returns the days in [start, end) counted by a proration method
+func prorationUnits(method string, start time.Time, end time.Time) int64 {
+	if method != WorkingDays {
+		return daysBetween(start, end)
+	}
+
+	var days int64
+	for day := startOfDay(start); day.Before(end); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() != time.Saturday && day.Weekday() != time.Sunday {
+			days++
+		}
+	}
+	return days
+}
+
+// terminationDate returns the day a terminated contract stopped being paid, or the
+// zero time if it was not terminated
+func (c *Contract) terminationDate() time.Time {
+	for i := len(c.StatusHistory) - 1; i >= 0; i-- {
+		if c.StatusHistory[i].To == StatusTerminated {
+			return startOfDay(c.StatusHistory[i].EffectiveDate)
+		}
+	}
+	return time.Time{}
+}
+
+// coverage calls fn for each version of the terms in force during [start, end) with
+// the part of the interval it covers. Days before the first version and from the
+// termination date on are not covered
+func (c *Contract) coverage(start time.Time, end time.Time, fn func(terms ContractTerms, from time.Time, until time.Time) error) error {
+	versions := c.agreedVersions()
+	if len(versions) == 0 {
+		versions = []TermsVersion{{ContractTerms: c.ContractTerms}}
+	}
+	if terminated := c.terminationDate(); !terminated.IsZero() && terminated.Before(end) {
+		end = terminated
+	}
+
+	for i, version := range versions {
+		from := startOfDay(version.EffectiveFrom)
+		if from.Before(start) {
+			from = start
+		}
+		until := end
+		if i+1 < len(versions) && startOfDay(versions[i+1].EffectiveFrom).Before(until) {
+			until = startOfDay(versions[i+1].EffectiveFrom)
+		}
+		if !from.Before(until) {
+			continue
+		}
+
+		err := fn(version.ContractTerms, from, until)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// a component of the gross amount of a payment
+type PayComponent struct {
+	Code   string `json:"Code"`   // Salary, VariablePay or the type of an ad hoc payment
+	Amount Money  `json:"Amount"` // Gross amount of the component
+}
+
+// Constants for the codes of calculated pay components
+const (
+	SalaryComponent      = "Salary"
+	VariablePayComponent = "VariablePay"
+)
+
+// grossPay returns the gross pay of a contract for the days [start, end)
+func grossPay(contract *Contract, start time.Time, end time.Time) (Money, error) {
+	components, err := payComponents(contract, start, end)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return components[0].Amount.Add(components[1].Amount)
+}
+
+// payComponents returns the salary and variable pay of a contract for the days
+// [start, end). The days are split into pay periods of the contract's frequency.
+// Each period pays the period share of the salary and variable pay of the terms in
+// force, prorated when the days or the employment cover only part of the period.
+// Variable pay on its own schedule falls due in the period containing the last day
+// of its month, quarter or year
+func payComponents(contract *Contract, start time.Time, end time.Time) ([]PayComponent, error) {
+	start, end = startOfDay(start), startOfDay(end)
+	if !start.Before(end) {
+		return nil, fmt.Errorf("pay period %s to %s is empty", start.Format(dateLayout), end.Format(dateLayout))
+	}
+
+	salary, err := ZeroMoney(contract.termsAt(start).Currency)
+	if err != nil {
+		return nil, err
+	}
+	variable := salary
+	add := func(total *Money, pay Money, err error) error {
+		if err != nil {
+			return err
+		}
+		*total, err = total.Add(pay)
+		if err != nil {
+			return fmt.Errorf("pay period spans a currency change: %v", err)
+		}
+		return nil
+	}
+
+	for from := start; from.Before(end); {
+		schedule := contract.termsAt(from).withDefaults()
+		periodStart, periodEnd := payPeriod(schedule.PayFrequency, from)
+		until := periodEnd
+		if end.Before(until) {
+			until = end
+		}
+		// terms paid at another frequency start their own periods
+		for _, version := range contract.agreedVersions() {
+			effective := startOfDay(version.EffectiveFrom)
+			if effective.After(from) && effective.Before(until) && version.withDefaults().PayFrequency != schedule.PayFrequency {
+				until = effective
+				break
+			}
+		}
+
+		periodUnits := prorationUnits(schedule.Proration, periodStart, periodEnd)
+		periods := periodsPerYear[schedule.PayFrequency]
+		err = contract.coverage(from, until, func(terms ContractTerms, coveredFrom time.Time, coveredUntil time.Time) error {
+			covered := prorationUnits(schedule.Proration, coveredFrom, coveredUntil)
+			termsSchedule := terms.withDefaults()
+			pay, err := terms.Salary.MulRat(termsSchedule.basisPerYear()*covered, periods*periodUnits, RoundHalfEven)
+			err = add(&salary, pay, err)
+			if err != nil || schedule.VariablePaySchedule != VariablePerPeriod {
+				return err
+			}
+			pay, err = terms.VariablePay.MulRat(covered, periods*periodUnits, RoundHalfEven)
+			return add(&variable, pay, err)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// installments of scheduled variable pay whose last day falls in [from, until),
+		// prorated to the calendar days of their month, quarter or year that were worked
+		if installments, ok := installmentsPerYear[schedule.VariablePaySchedule]; ok {
+			for day := from; day.Before(until); {
+				variableStart, variableEnd := variablePayPeriod(schedule.VariablePaySchedule, day)
+				if variableEnd.AddDate(0, 0, -1).Before(until) {
+					variableDays := daysBetween(variableStart, variableEnd)
+					err = contract.coverage(variableStart, variableEnd, func(terms ContractTerms, coveredFrom time.Time, coveredUntil time.Time) error {
+						pay, err := terms.VariablePay.MulRat(daysBetween(coveredFrom, coveredUntil), installments*variableDays, RoundHalfEven)
+						return add(&variable, pay, err)
+					})
+					if err != nil {
+						return nil, err
+					}
+				}
+				day = variableEnd
+			}
+		}
+
+		from = until
+	}
+
+	return []PayComponent{{SalaryComponent, salary}, {VariablePayComponent, variable}}, nil
+}
+
+// paidForPeriod returns the ID of a regular payment or correction of a contract whose
+// pay period overlaps start to end (both inclusive), or "" when none does. Reversed
+// payments no longer cover their period, and payments recorded without a period
+// cover the pay period they were made in
+func paidForPeriod(ctx contractapi.TransactionContextInterface, contract *Contract, start time.Time, end time.Time) (string, error) {
+	entries, err := getPaymentIndexEntries(ctx, contract.ID)
+	if err != nil {
+		return "", err
+	}
+
+	reversed := map[string]bool{}
+	var paid []*Payment
+	for _, entry := range entries {
+		if entry.Type != RegularPayment && entry.Type != CorrectionPayment && entry.Type != ReversalPayment {
+			continue
+		}
+
+		payment, err := readPayment(ctx, entry.PaymentID)
+		if err != nil {
+			return "", err
+		}
+		if payment.Type == ReversalPayment {
+			reversed[payment.Adjusts] = true
+			continue
+		}
+		paid = append(paid, payment)
+	}
+
+	for _, payment := range paid {
+		if reversed[payment.ID] {
+			continue
+		}
+
+		periodStart, periodEnd := payment.PeriodStart, payment.PeriodEnd
+		if periodStart.IsZero() {
+			var nextStart time.Time
+			periodStart, nextStart = payPeriod(contract.termsAt(payment.Date).withDefaults().PayFrequency, payment.Date)
+			periodEnd = nextStart.AddDate(0, 0, -1)
+		}
+		if !periodStart.After(end) && !start.After(periodEnd) {
+			return payment.ID, nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,104 @@
+package chaincode
+
+import (
+	"testing"
+	"time"
+)
+
+// dayOf2024 returns midnight UTC of a day in 2024
+func dayOf2024(month time.Month, day int) time.Time {
+	return time.Date(2024, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// versionedContract returns a contract in EUR paid on the schedule under the given
+// terms versions
+func versionedContract(t *testing.T, schedule PaySchedule, versions ...TermsVersion) *Contract {
+	t.Helper()
+
+	contract := &Contract{ID: "C1"}
+	for i := range versions {
+		versions[i].Version = i + 1
+		versions[i].Currency = "EUR"
+		versions[i].PaySchedule = schedule
+	}
+	contract.TermsVersions = versions
+	contract.ContractTerms = versions[0].ContractTerms
+	return contract
+}
+
+// termsVersion returns terms with a salary and variable pay in EUR effective from a date
+func termsVersion(t *testing.T, salary string, variablePay string, from time.Time) TermsVersion {
+	t.Helper()
+
+	salaryAmount, err := ParseMoney(salary, "EUR", RoundExact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	variableAmount, err := ParseMoney(variablePay, "EUR", RoundExact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return TermsVersion{ContractTerms: ContractTerms{Salary: salaryAmount, VariablePay: variableAmount}, EffectiveFrom: from}
+}
+
+// Pay periods the employment or a version of the terms covers only in part are
+// prorated by the days of the period covered, counted as the schedule says
+func TestPayIsProratedOverPartialPeriods(t *testing.T) {
+	monthly := PaySchedule{}
+	workingDays := PaySchedule{Proration: WorkingDays}
+
+	terminated := versionedContract(t, monthly, termsVersion(t, "60000.00", "0", dayOf2024(1, 1)))
+	terminated.StatusHistory = []StatusChange{{To: StatusTerminated, EffectiveDate: dayOf2024(3, 11)}}
+
+	for name, test := range map[string]struct {
+		contract *Contract
+		gross    string
+	}{
+		// a full month is a twelfth of the annual salary
+		"full month": {versionedContract(t, monthly, termsVersion(t, "60000.00", "0", dayOf2024(1, 1))), "5000.00 EUR"},
+		// 16 of the 31 days of March
+		"hired mid-month": {versionedContract(t, monthly, termsVersion(t, "60000.00", "0", dayOf2024(3, 16))), "2580.65 EUR"},
+		// 15 days at the old salary and 16 at the new one
+		"raise mid-month": {versionedContract(t, monthly, termsVersion(t, "60000.00", "0", dayOf2024(1, 1)), termsVersion(t, "72000.00", "0", dayOf2024(3, 16))), "5516.12 EUR"},
+		// 10 of the 21 working days of March
+		"working days": {versionedContract(t, workingDays, termsVersion(t, "60000.00", "0", dayOf2024(3, 18))), "2380.95 EUR"},
+		// paid until the day before the termination took effect
+		"terminated": {terminated, "1612.90 EUR"},
+	} {
+		gross, err := grossPay(test.contract, dayOf2024(3, 1), dayOf2024(4, 1))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if gross.String() != test.gross {
+			t.Errorf("%s: March pays %s, want %s", name, gross, test.gross)
+		}
+	}
+}
+
+// Hourly pay is paid per week of the contracted hours, and variable pay on its own
+// schedule falls due in the period holding the last day of its quarter
+func TestPayFollowsTheSchedule(t *testing.T) {
+	hourly := versionedContract(t, PaySchedule{PayFrequency: Weekly, PayBasis: HourlyBasis, HoursPerWeek: 40}, termsVersion(t, "25.00", "0", dayOf2024(1, 1)))
+	gross, err := grossPay(hourly, dayOf2024(3, 4), dayOf2024(3, 11))
+	if err != nil || gross.String() != "1000.00 EUR" {
+		t.Errorf("a week of 40 hours pays %s: %v", gross, err)
+	}
+
+	quarterly := versionedContract(t, PaySchedule{VariablePaySchedule: VariableQuarterly}, termsVersion(t, "60000.00", "4000.00", dayOf2024(1, 1)))
+	for _, month := range []struct {
+		start    time.Time
+		variable string
+	}{
+		{dayOf2024(2, 1), "0.00 EUR"},
+		{dayOf2024(3, 1), "1000.00 EUR"},
+	} {
+		components, err := payComponents(quarterly, month.start, month.start.AddDate(0, 1, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if components[1].Amount.String() != month.variable {
+			t.Errorf("the variable pay of %s is %s, want %s", month.start.Month(), components[1].Amount, month.variable)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key storing payslip hashes
+const payslipIndex = "Payslip"
+
+// statement of how a regular payment was computed
+type Payslip struct {
+	PaymentID         string           `json:"PaymentID"`         // ID of the payment the payslip is for
+	ContractID        string           `json:"ContractID"`        // ID of the contract
+	Employer          string           `json:"Employer"`          // Name of the employer
+	Employee          string           `json:"Employee"`          // Name of the employee
+	Position          string           `json:"Position"`          // Position of the employee during the period
+	PeriodStart       time.Time        `json:"PeriodStart"`       // First day of the pay period
+	PeriodEnd         time.Time        `json:"PeriodEnd"`         // Last day of the pay period
+	PayDate           time.Time        `json:"PayDate"`           // Date the payment was recorded
+	Currency          string           `json:"Currency"`          // Currency of every amount on the payslip
+	Components        []PayComponent   `json:"Components"`        // Gross pay by component
+	Gross             Money            `json:"Gross"`             // Gross pay
+	Deductions        []DeductionLine  `json:"Deductions"`        // Tax and contributions withheld
+	AdvancesRecovered Money            `json:"AdvancesRecovered"` // Advance installments kept from the net pay
+	Net               Money            `json:"Net"`               // Net pay after tax and contributions
+	YTDGross          Money            `json:"YTDGross"`          // Gross pay of the year up to and including this payment
+	YTDDeductions     map[string]Money `json:"YTDDeductions"`     // Deductions of the year per code
+	YTDNet            Money            `json:"YTDNet"`            // Net pay of the year
+	Salt              string           `json:"Salt,omitempty"`    // Salt hashed with the payslip when its pay is private, so its hash cannot be guessed
+	Hash              string           `json:"Hash"`              // Hex SHA-256 hash of the payslip anchored on the ledger
+}
+
+// hash of a payslip recorded when its payment was made
+type PayslipAnchor struct {
+	PaymentID  string    `json:"PaymentID"`  // ID of the payment
+	ContractID string    `json:"ContractID"` // ID of the contract
+	Hash       string    `json:"Hash"`       // Hex SHA-256 hash of the payslip JSON without its Hash field
+	Salted     bool      `json:"Salted"`     // Whether the payslip was hashed with its salt, false for anchors recorded before
+	TxID       string    `json:"TxID"`       // Transaction that anchored the hash
+	AnchoredAt time.Time `json:"AnchoredAt"` // Transaction timestamp of the anchor
+}
+
+// buildPayslip builds the payslip of a regular payment from the terms it was paid on
+// and the earlier payments of the year. The payment itself may not be on the ledger yet, nor
+// the sameTx payments recorded before it in the same transaction
+func buildPayslip(ctx contractapi.TransactionContextInterface, contract *Contract, payment *Payment, sameTx ...*Payment) (*Payslip, error) {
+	currency := payment.Amount.Currency
+	zero, err := ZeroMoney(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	payslip := Payslip{
+		PaymentID:         payment.ID,
+		ContractID:        contract.ID,
+		Employer:          contract.Employer,
+		Employee:          payment.Employee,
+		Position:          payment.Position,
+		PeriodStart:       payment.PeriodStart,
+		PeriodEnd:         payment.PeriodEnd,
+		PayDate:           payment.Date,
+		Currency:          currency,
+		Components:        payment.Components,
+		Gross:             payment.Amount,
+		Deductions:        payment.Deductions,
+		AdvancesRecovered: zero,
+		Net:               payment.Net,
+		YTDGross:          zero,
+		YTDDeductions:     map[string]Money{},
+		YTDNet:            zero,
+	}
+	if payment.Recovered.IsPositive() {
+		payslip.AdvancesRecovered = payment.Recovered
+	}
+	// payments recorded before the terms were kept on them were paid on the terms then in force
+	if payment.TermsVersion == 0 && payment.Position == "" {
+		payslip.Position = contract.termsAt(payment.PeriodStart).Position
+	}
+
+	// year-to-date totals of the regular pay recorded before this payment
+	entries, err := getPaymentIndexEntries(ctx, payment.ContractID, payment.Employee)
+	if err != nil {
+		return nil, err
+	}
+	earlier := append([]*Payment{payment}, sameTx...)
+	for _, entry := range entries {
+		if entry.PaymentID == payment.ID {
+			break
+		}
+		if entry.Date.Year() != payment.Date.Year() || !paysEmployee(entry) {
+			continue
+		}
+
+		previous, err := readPaymentAmounts(ctx, entry.PaymentID)
+		if err != nil {
+			return nil, err
+		}
+		if previous.PeriodStart.IsZero() || previous.Amount.Currency != currency {
+			continue
+		}
+		earlier = append(earlier, previous)
+	}
+
+	for _, paid := range earlier {
+		payslip.YTDGross, err = payslip.YTDGross.Add(paid.Amount)
+		if err != nil {
+			return nil, err
+		}
+		payslip.YTDNet, err = payslip.YTDNet.Add(paid.Net)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range paid.Deductions {
+			err = addTotal(payslip.YTDDeductions, line.Code, line.Amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &payslip, nil
+}
+
+// paysEmployee reports whether an index entry is a payment of pay to the employee,
+// or the reversal of one. Advances, withdrawals and bank transfers have no payslip
+func paysEmployee(entry PaymentIndexEntry) bool {
+	switch entry.Type {
+	case AdvancePayment, WithdrawalPayment, CrossBorder, Local:
+		return false
+	}
+	return true
+}
+
+// payslipSalt returns the salt of the payslip of a payment anchored under key, derived
+// from the salt of the payment's amounts, or "" while they are public
+func payslipSalt(payment *Payment, key string) string {
+	if payment.salt == "" {
+		return ""
+	}
+	return deriveSalt(payment.salt, key)
+}
+
+// hashPayslip returns the hex SHA-256 hash of the JSON of a payslip without its hash
+func hashPayslip(payslip *Payslip) (string, error) {
+	unhashed := *payslip
+	unhashed.Hash = ""
+
+	payslipJSON, err := json.Marshal(unhashed)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(payslipJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// anchorPayslip records the hash of the payslip of a regular payment made in this
+// transaction. sameTx are the payments recorded before it in the transaction, which
+// must precede it in the payment index
+func anchorPayslip(ctx contractapi.TransactionContextInterface, contract *Contract, payment *Payment, sameTx ...*Payment) error {
+	payslip, err := buildPayslip(ctx, contract, payment, sameTx...)
+	if err != nil {
+		return err
+	}
+
+	anchorKey, err := ctx.GetStub().CreateCompositeKey(payslipIndex, []string{payment.ContractID, payment.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create payslip key: %v", err)
+	}
+
+	payslip.Salt = payslipSalt(payment, anchorKey)
+
+	hash, err := hashPayslip(payslip)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	anchor := PayslipAnchor{
+		PaymentID:  payment.ID,
+		ContractID: payment.ContractID,
+		Hash:       hash,
+		Salted:     true,
+		TxID:       ctx.GetStub().GetTxID(),
+		AnchoredAt: now,
+	}
+
+	anchorJSON, err := json.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(anchorKey, anchorJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// readPayment reads a payment record from the world state
+func readPayment(ctx contractapi.TransactionContextInterface, paymentID string) (*Payment, error) {
+	paymentJSON, err := ctx.GetStub().GetState(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if paymentJSON == nil {
+		return nil, fmt.Errorf("the payment %s does not exist", paymentID)
+	}
+
+	var payment Payment
+	err = json.Unmarshal(paymentJSON, &payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+// GetPayslip returns the payslip of the regular payment of a contract for the pay
+// period periodStart to periodEnd ("YYYY-MM-DD", both inclusive). The payslip is
+// rebuilt from ledger data and checked against the hash anchored when it was paid
+func (s *PaymentContract) GetPayslip(ctx contractapi.TransactionContextInterface, contractID string, periodStart string, periodEnd string) (*Payslip, error) {
+	err := authorizeRead(ctx, "GetPayslip", contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := parseDate(periodStart)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseDate(periodEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	// the latest payment for the period, should it have been paid more than once or corrected
+	entries, err := getPaymentIndexEntries(ctx, contractID, contract.Employee)
+	if err != nil {
+		return nil, err
+	}
+	var payment *Payment
+	for _, entry := range entries {
+		if !paysEmployee(entry) || entry.Type == ReversalPayment {
+			continue
+		}
+
+		candidate, err := readPayment(ctx, entry.PaymentID)
+		if err != nil {
+			return nil, err
+		}
+		if candidate.PeriodStart.Equal(startOfDay(start)) && candidate.PeriodEnd.Equal(startOfDay(end)) {
+			payment = candidate
+		}
+	}
+	if payment == nil {
+		return nil, fmt.Errorf("contract %s has no payment for the period %s to %s", contractID, startOfDay(start).Format(dateLayout), startOfDay(end).Format(dateLayout))
+	}
+
+	// the amounts of a payslip are private, so it is only built by a peer of the client's org
+	if payment.PrivateDataHash != "" {
+		visible, err := clientOrgIsPeerOrg(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !visible {
+			return nil, fmt.Errorf("the payslip of payment %s is only returned by a peer of the client's org", payment.ID)
+		}
+	}
+	err = loadPaymentAmounts(ctx, payment, false)
+	if err != nil {
+		return nil, err
+	}
+
+	payslip, err := buildPayslip(ctx, contract, payment)
+	if err != nil {
+		return nil, err
+	}
+
+	anchorKey, err := ctx.GetStub().CreateCompositeKey(payslipIndex, []string{contractID, payment.ID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payslip key: %v", err)
+	}
+	anchorJSON, err := ctx.GetStub().GetState(anchorKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if anchorJSON == nil {
+		return nil, fmt.Errorf("no payslip hash was anchored for payment %s", payment.ID)
+	}
+
+	var anchor PayslipAnchor
+	err = json.Unmarshal(anchorJSON, &anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	if anchor.Salted {
+		payslip.Salt = payslipSalt(payment, anchorKey)
+	}
+	hash, err := hashPayslip(payslip)
+	if err != nil {
+		return nil, err
+	}
+	if hash != anchor.Hash {
+		return nil, fmt.Errorf("payslip of payment %s does not match its anchored hash %s", payment.ID, anchor.Hash)
+	}
+	payslip.Hash = anchor.Hash
+
+	return payslip, nil
+}
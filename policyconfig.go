@@ -0,0 +1,352 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key storing policy configuration versions
+const policyConfigIndex = "PolicyConfig"
+
+// Constants for the scopes a policy configuration is set at. A contract override
+// takes precedence over its employer's, which takes precedence over the channel's
+const (
+	ChannelScope  = "Channel"
+	EmployerScope = "Employer"
+	ContractScope = "Contract"
+)
+
+// business limits checked by the payment transactions. Zero fields inherit the
+// value of the broader scope, and the built-in defaults when no scope sets them.
+// Fields named in Cleared go back to the built-in defaults before the fields set at
+// the scope apply, which lifts a cap or currency restriction of a broader scope.
+// Caps only apply to amounts in their own currency
+type PolicyConfig struct {
+	AdvanceMultiplier        int64    `json:"AdvanceMultiplier"`        // Cap on advances pending or owed, in basis points of a month's gross pay
+	AdvanceRequestsPerPeriod int      `json:"AdvanceRequestsPerPeriod"` // Advance requests allowed per pay period
+	AdvanceInstallments      int      `json:"AdvanceInstallments"`      // Most installments an advance can be repaid in
+	PaymentMultiplier        int64    `json:"PaymentMultiplier"`        // Cap on a single payment, in basis points of a month's gross pay
+	PaymentCap               Money    `json:"PaymentCap"`               // Absolute cap on a single payment
+	WithdrawalLimit          Money    `json:"WithdrawalLimit"`          // Most that can be withdrawn in one withdrawal
+	AllowedCurrencies        []string `json:"AllowedCurrencies"`        // Currencies contracts may be paid in, empty for any
+	SettlementTimeoutHours   int64    `json:"SettlementTimeoutHours"`   // Hours a settlement may stay in a state before it is flagged
+	FxFeeLimit               int64    `json:"FxFeeLimit"`               // Most an FX quote may charge, in basis points of the payment amount
+	ReconciliationDays       int64    `json:"ReconciliationDays"`       // Days a statement may book a payment before or after it completed
+	Cleared                  []string `json:"Cleared"`                  // Names of the fields reset to their defaults at this scope
+}
+
+// a version of the policy configuration of a scope
+type PolicyConfigVersion struct {
+	Scope   string `json:"Scope"`   // Channel, Employer or Contract
+	Target  string `json:"Target"`  // Employer name or contract ID, empty for the channel
+	Version int    `json:"Version"` // Version number, starting at 1
+	PolicyConfig
+	UpdatedBy string    `json:"UpdatedBy"` // Name of the admin that set the version
+	UpdatedAt time.Time `json:"UpdatedAt"` // Transaction timestamp of the version
+}
+
+// limits that applied before they could be configured on the ledger
+var defaultPolicyConfig = PolicyConfig{
+	AdvanceMultiplier:        2 * basisPoints,
+	AdvanceRequestsPerPeriod: 2,
+	AdvanceInstallments:      24,
+	PaymentMultiplier:        2 * basisPoints,
+	SettlementTimeoutHours:   48,
+	FxFeeLimit:               200, // fees were not capped before, 2% of the payment
+	ReconciliationDays:       3,   // the default tolerance of the reconcile tool
+}
+
+// validate rejects negative limits and unknown currencies
+func (p *PolicyConfig) validate() error {
+	if p.AdvanceMultiplier < 0 || p.PaymentMultiplier < 0 || p.AdvanceRequestsPerPeriod < 0 || p.AdvanceInstallments < 0 || p.SettlementTimeoutHours < 0 || p.FxFeeLimit < 0 || p.ReconciliationDays < 0 {
+		return fmt.Errorf("policy limits cannot be negative")
+	}
+	if p.FxFeeLimit >= basisPoints {
+		return fmt.Errorf("an FX fee limit of %d basis points would take the whole payment", p.FxFeeLimit)
+	}
+	if p.PaymentCap.IsNegative() || p.WithdrawalLimit.IsNegative() {
+		return fmt.Errorf("policy caps cannot be negative")
+	}
+	for _, currency := range p.AllowedCurrencies {
+		if _, err := CurrencyExponent(currency); err != nil {
+			return err
+		}
+	}
+	for _, field := range p.Cleared {
+		if _, ok := p.clear(field); !ok {
+			return fmt.Errorf("unknown policy field %q", field)
+		}
+	}
+	return nil
+}
+
+// clear returns the configuration with a field reset to its built-in default, and
+// whether the field exists
+func (p PolicyConfig) clear(field string) (PolicyConfig, bool) {
+	switch field {
+	case "AdvanceMultiplier":
+		p.AdvanceMultiplier = defaultPolicyConfig.AdvanceMultiplier
+	case "AdvanceRequestsPerPeriod":
+		p.AdvanceRequestsPerPeriod = defaultPolicyConfig.AdvanceRequestsPerPeriod
+	case "AdvanceInstallments":
+		p.AdvanceInstallments = defaultPolicyConfig.AdvanceInstallments
+	case "PaymentMultiplier":
+		p.PaymentMultiplier = defaultPolicyConfig.PaymentMultiplier
+	case "PaymentCap":
+		p.PaymentCap = defaultPolicyConfig.PaymentCap
+	case "WithdrawalLimit":
+		p.WithdrawalLimit = defaultPolicyConfig.WithdrawalLimit
+	case "AllowedCurrencies":
+		p.AllowedCurrencies = defaultPolicyConfig.AllowedCurrencies
+	case "SettlementTimeoutHours":
+		p.SettlementTimeoutHours = defaultPolicyConfig.SettlementTimeoutHours
+	case "FxFeeLimit":
+		p.FxFeeLimit = defaultPolicyConfig.FxFeeLimit
+	case "ReconciliationDays":
+		p.ReconciliationDays = defaultPolicyConfig.ReconciliationDays
+	default:
+		return p, false
+	}
+	return p, true
+}
+
+// overlay returns the configuration with the fields cleared by override reset and
+// its non-zero fields applied
+func (p PolicyConfig) overlay(override PolicyConfig) PolicyConfig {
+	for _, field := range override.Cleared {
+		p, _ = p.clear(field)
+	}
+	if override.AdvanceMultiplier != 0 {
+		p.AdvanceMultiplier = override.AdvanceMultiplier
+	}
+	if override.AdvanceRequestsPerPeriod != 0 {
+		p.AdvanceRequestsPerPeriod = override.AdvanceRequestsPerPeriod
+	}
+	if override.AdvanceInstallments != 0 {
+		p.AdvanceInstallments = override.AdvanceInstallments
+	}
+	if override.PaymentMultiplier != 0 {
+		p.PaymentMultiplier = override.PaymentMultiplier
+	}
+	if !override.PaymentCap.IsZero() {
+		p.PaymentCap = override.PaymentCap
+	}
+	if !override.WithdrawalLimit.IsZero() {
+		p.WithdrawalLimit = override.WithdrawalLimit
+	}
+	if len(override.AllowedCurrencies) > 0 {
+		p.AllowedCurrencies = override.AllowedCurrencies
+	}
+	if override.SettlementTimeoutHours != 0 {
+		p.SettlementTimeoutHours = override.SettlementTimeoutHours
+	}
+	if override.FxFeeLimit != 0 {
+		p.FxFeeLimit = override.FxFeeLimit
+	}
+	if override.ReconciliationDays != 0 {
+		p.ReconciliationDays = override.ReconciliationDays
+	}
+	return p
+}
+
+// requireCurrency rejects currencies the configuration does not allow
+func (p PolicyConfig) requireCurrency(currency string) error {
+	if len(p.AllowedCurrencies) == 0 {
+		return nil
+	}
+	for _, allowed := range p.AllowedCurrencies {
+		if allowed == currency {
+			return nil
+		}
+	}
+	return fmt.Errorf("currency %s is not allowed, use one of %v", currency, p.AllowedCurrencies)
+}
+
+// checkCap rejects an amount above a cap in the same currency. Unset caps and caps
+// in other currencies do not apply
+func checkCap(amount Money, limit Money, what string) error {
+	if limit.IsZero() || limit.Currency != amount.Currency {
+		return nil
+	}
+	exceeded, err := exceedsLimit(amount, limit)
+	if err != nil {
+		return err
+	}
+	if exceeded {
+		return fmt.Errorf("%s %s exceeds the limit of %s", what, amount, limit)
+	}
+	return nil
+}
+
+// checkPaymentLimits checks a single payment of a contract against its policy: the
+// currency must be allowed, and the amount may exceed neither the payment cap nor
+// the payment multiplier of the gross pay of the month of at. The contract's pay
+// must be loaded
+func checkPaymentLimits(ctx contractapi.TransactionContextInterface, contract *Contract, amount Money, at time.Time) error {
+	policy, err := effectivePolicy(ctx, contract.Employer, contract.ID)
+	if err != nil {
+		return err
+	}
+	err = policy.requireCurrency(amount.Currency)
+	if err != nil {
+		return err
+	}
+
+	monthStart, monthEnd := monthOf(at)
+	monthlyPay, err := grossPay(contract, monthStart, monthEnd)
+	if err != nil {
+		return err
+	}
+	limit, err := monthlyPay.MulRat(policy.PaymentMultiplier, basisPoints, RoundDown)
+	if err != nil {
+		return err
+	}
+	exceeded, err := exceedsLimit(amount, limit)
+	if err != nil {
+		return err
+	}
+	if exceeded {
+		return fmt.Errorf("payment amount exceeds limit")
+	}
+
+	return checkCap(amount, policy.PaymentCap, "payment amount")
+}
+
+// policyConfigVersions returns every version of the configuration of a scope
+func policyConfigVersions(ctx contractapi.TransactionContextInterface, scope string, target string) ([]*PolicyConfigVersion, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(policyConfigIndex, []string{scope, target})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var versions []*PolicyConfigVersion
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var version PolicyConfigVersion
+		err = json.Unmarshal(queryResponse.Value, &version)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, &version)
+	}
+
+	return versions, nil
+}
+
+// effectivePolicy returns the limits that apply to a contract of an employer: the
+// defaults overlaid with the latest channel, employer and contract versions
+func effectivePolicy(ctx contractapi.TransactionContextInterface, employer string, contractID string) (PolicyConfig, error) {
+	policy := defaultPolicyConfig
+	for _, scope := range [][2]string{{ChannelScope, ""}, {EmployerScope, employer}, {ContractScope, contractID}} {
+		versions, err := policyConfigVersions(ctx, scope[0], scope[1])
+		if err != nil {
+			return PolicyConfig{}, err
+		}
+		if n := len(versions); n > 0 {
+			policy = policy.overlay(versions[n-1].PolicyConfig)
+		}
+	}
+	return policy, nil
+}
+
+// SetPolicyConfig records a new version of the business limits of the channel
+// (empty target), an employer or a contract
+func (s *PaymentContract) SetPolicyConfig(ctx contractapi.TransactionContextInterface, scope string, target string, config PolicyConfig) (*PolicyConfigVersion, error) {
+	caller, err := authorize(ctx, "SetPolicyConfig")
+	if err != nil {
+		return nil, err
+	}
+
+	switch scope {
+	case ChannelScope:
+		if target != "" {
+			return nil, fmt.Errorf("the channel policy takes no target")
+		}
+	case EmployerScope, ContractScope:
+		if target == "" {
+			return nil, fmt.Errorf("a %s policy needs a target", scope)
+		}
+	default:
+		return nil, fmt.Errorf("unknown policy scope %q", scope)
+	}
+
+	err = config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := policyConfigVersions(ctx, scope, target)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	version := PolicyConfigVersion{
+		Scope:        scope,
+		Target:       target,
+		Version:      len(versions) + 1,
+		PolicyConfig: config,
+		UpdatedBy:    caller.Name,
+		UpdatedAt:    now,
+	}
+
+	// zero padded so that versions sort numerically
+	versionKey, err := ctx.GetStub().CreateCompositeKey(policyConfigIndex, []string{scope, target, fmt.Sprintf("%06d", version.Version)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create policy config key: %v", err)
+	}
+
+	versionJSON, err := json.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(versionKey, versionJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return &version, nil
+}
+
+// GetPolicyConfigs returns every version of the business limits of a scope
+func (s *PaymentContract) GetPolicyConfigs(ctx contractapi.TransactionContextInterface, scope string, target string) ([]*PolicyConfigVersion, error) {
+	_, err := authorize(ctx, "GetPolicyConfigs")
+	if err != nil {
+		return nil, err
+	}
+
+	return policyConfigVersions(ctx, scope, target)
+}
+
+// GetEffectivePolicy returns the business limits that apply to a contract
+func (s *PaymentContract) GetEffectivePolicy(ctx contractapi.TransactionContextInterface, contractID string) (*PolicyConfig, error) {
+	err := authorizeRead(ctx, "GetEffectivePolicy", contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := effectivePolicy(ctx, contract.Employer, contract.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
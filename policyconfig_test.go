@@ -0,0 +1,52 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// A narrower scope lifts a cap or currency restriction of a broader one by clearing it
+func TestPolicyOverrideClearsFields(t *testing.T) {
+	limit, err := ParseMoney("1000.00", "EUR", RoundExact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := defaultPolicyConfig.overlay(PolicyConfig{PaymentCap: limit, AllowedCurrencies: []string{"EUR"}, PaymentMultiplier: 5000})
+
+	employer := channel.overlay(PolicyConfig{Cleared: []string{"PaymentCap", "AllowedCurrencies", "PaymentMultiplier"}, PaymentMultiplier: 10000})
+	if !employer.PaymentCap.IsZero() || len(employer.AllowedCurrencies) != 0 || employer.PaymentMultiplier != 10000 {
+		t.Errorf("the override left %+v", employer)
+	}
+	if kept := channel.overlay(PolicyConfig{}); kept.PaymentCap != limit || kept.PaymentMultiplier != 5000 {
+		t.Errorf("an empty override changed %+v", kept)
+	}
+
+	if err := (&PolicyConfig{Cleared: []string{"Salary"}}).validate(); err == nil {
+		t.Error("an unknown field was cleared")
+	}
+}
+
+// Payroll runs are held to the same payment limits as ProcessPayment, and cover at
+// most one pay period
+func TestPayrollRunsAreLimited(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	year := PayrollInterval{StartDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)}
+	if _, err := s.RunPayroll(l.begin(p.employer, nil), "", p.employer.name, year); err == nil || !strings.HasPrefix(err.Error(), "payroll interval of 366 days") {
+		t.Errorf("a year was paid in one run: %v", err)
+	}
+
+	_, err := s.SetPolicyConfig(l.begin(p.admin, nil), ContractScope, "C1", PolicyConfig{AllowedCurrencies: []string{"USD"}})
+	l.must(err)
+	march := PayrollInterval{StartDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)}
+	run, err := s.RunPayroll(l.begin(p.employer, nil), "", p.employer.name, march)
+	l.must(err)
+	if run.Paid != 0 || run.Failed != 1 || !strings.HasPrefix(run.Failures[0].Reason, "currency EUR is not allowed") {
+		t.Errorf("the run paid in a currency the policy does not allow: %+v", run)
+	}
+}
@@ -0,0 +1,1259 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Constants for the private data collections declared in collections_config.json,
+// which cmd/collections generates for the MSPs of a deployment. Every employer org
+// has a contract and a ledger collection of its own, named by EmployerCollection
+const (
+	ContractCollection = "contractPrivateDetails" // Pay of an employer's contracts and the amounts paid under them, shared with the employee orgs
+	LedgerCollection   = "ledgerPrivateDetails"   // Balances of an employer's contracts and the amounts of its bank payments, also shared with banks
+	AccountCollection  = "accountPrivateDetails"  // Tax, financial and bank details of accounts, also shared with banks
+)
+
+// EmployerCollection returns the name of the contract or ledger collection of an
+// employer org, which no other employer org is a member of
+func EmployerCollection(collection string, employerMSPID string) string {
+	return collection + "_" + employerMSPID
+}
+
+// Constants for the transient map keys private details are submitted under
+const (
+	transientPayKey     = "pay"
+	transientAccountKey = "account"
+)
+
+// minimum length of the salt submitted with private details, so their public hash
+// cannot be reversed by guessing the values
+const minSaltLength = 16
+
+// pay of a version of the contract terms
+type PrivatePay struct {
+	Version     int   `json:"Version"`     // Terms version the pay belongs to
+	Salary      Money `json:"Salary"`      // Salary of the employee per unit of the pay basis
+	VariablePay Money `json:"VariablePay"` // Annual variable pay for the employee
+}
+
+// private details of a contract, stored in the contract collection under the contract ID
+type ContractPrivateDetails struct {
+	ContractID string       `json:"ContractID"` // ID of the contract
+	Salt       string       `json:"Salt"`       // Salt of the public hash of these details
+	Current    PrivatePay   `json:"Current"`    // Pay of the latest terms
+	Versions   []PrivatePay `json:"Versions"`   // Pay of every terms version
+}
+
+// private details of an account, stored in the account collection under the account ID
+type AccountPrivateDetails struct {
+	AccountID         string `json:"AccountID"`               // ID of the account
+	Salt              string `json:"Salt"`                    // Salt of the public hash of these details
+	TaxComplianceInfo string `json:"TaxComplianceInfo"`       // Tax compliance information
+	FinancialInfo     string `json:"FinancialInfo"`           // Confidential financial information
+	BankAccount       string `json:"BankAccount"`             // Bank account details
+	BankBIC           string `json:"BankBIC,omitempty"`       // BIC of the bank holding the account, left out of the hash when unset
+	RoutingNumber     string `json:"RoutingNumber,omitempty"` // ABA routing number of a US bank account, left out of the hash when unset
+}
+
+// amounts of a payment, stored in the contract collection under the payment ID
+type PaymentPrivateDetails struct {
+	PaymentID  string            `json:"PaymentID"`  // ID of the payment
+	Salt       string            `json:"Salt"`       // Salt of the public hash of these details
+	Amount     Money             `json:"Amount"`     // Gross amount of the payment
+	Components []PayComponent    `json:"Components"` // Breakdown of the gross amount of regular pay
+	Deductions []DeductionLine   `json:"Deductions"` // Tax and contributions withheld from regular pay
+	Net        Money             `json:"Net"`        // Net pay after tax and contributions
+	Recoveries []AdvanceRecovery `json:"Recoveries"` // Advance installments kept from the net pay
+	Recovered  Money             `json:"Recovered"`  // Total of the recoveries
+}
+
+// year-to-date totals, stored in the contract collection under the key of their public record
+type YearToDatePrivateDetails struct {
+	Key               string           `json:"Key"`               // World state key of the totals
+	Salt              string           `json:"Salt"`              // Salt of the public hash of these details
+	Gross             Money            `json:"Gross"`             // Gross pay
+	Net               Money            `json:"Net"`               // Net pay
+	Deductions        map[string]Money `json:"Deductions"`        // Amounts withheld per deduction code
+	ContributionBases map[string]Money `json:"ContributionBases"` // Gross levied per contribution
+}
+
+// totals of a payroll run, stored in the contract collection under the run ID
+type PayrollRunPrivateDetails struct {
+	RunID     string           `json:"RunID"`     // ID of the payroll run
+	Salt      string           `json:"Salt"`      // Salt of the public hash of these details
+	Totals    map[string]Money `json:"Totals"`    // Gross paid per currency
+	NetTotals map[string]Money `json:"NetTotals"` // Net paid per currency after withholding
+}
+
+// amounts of an advance request, stored in the contract collection under the request ID
+type AdvancePrivateDetails struct {
+	RequestID          string             `json:"RequestID"`          // ID of the advance request
+	Salt               string             `json:"Salt"`               // Salt of the public hash of these details
+	Amount             Money              `json:"Amount"`             // Amount advanced
+	Fee                Money              `json:"Fee"`                // Flat fee charged on the advance
+	Charges            Money              `json:"Charges"`            // Fee and interest owed on top of the amount
+	Installment        Money              `json:"Installment"`        // Amount recovered from each regular payment
+	Outstanding        Money              `json:"Outstanding"`        // Amount and charges not yet repaid
+	ChargesOutstanding Money              `json:"ChargesOutstanding"` // Part of Outstanding that is fee and interest
+	Repayments         []AdvanceRepayment `json:"Repayments"`         // Every repayment and write-off of the advance
+}
+
+// amount of a payment adjustment, stored in the contract collection under the adjustment ID
+type AdjustmentPrivateDetails struct {
+	AdjustmentID string `json:"AdjustmentID"` // ID of the adjustment
+	Salt         string `json:"Salt"`         // Salt of the public hash of these details
+	Amount       Money  `json:"Amount"`       // Gross amount the payment is corrected to
+}
+
+// amounts of a cross-border or local payment, stored in the ledger collection under
+// the payment ID
+type BankPaymentPrivateDetails struct {
+	PaymentID    string `json:"PaymentID"`    // ID of the payment
+	Salt         string `json:"Salt"`         // Salt of the public hash of these details
+	Amount       Money  `json:"Amount"`       // Amount of the payment
+	SourceAmount Money  `json:"SourceAmount"` // Amount converted from, once converted
+	TargetAmount Money  `json:"TargetAmount"` // Amount converted to, once converted
+	Fee          Money  `json:"Fee"`          // Conversion fee, once converted
+	Reconciled   Money  `json:"Reconciled"`   // Amount booked on the statement, once reconciled
+}
+
+// amounts of an FX quote, stored in the ledger collection under the quote ID
+type FxQuotePrivateDetails struct {
+	QuoteID      string `json:"QuoteID"`      // ID of the quote
+	Salt         string `json:"Salt"`         // Salt of the public hash of these details
+	Fee          Money  `json:"Fee"`          // Conversion fee in the source currency
+	SourceAmount Money  `json:"SourceAmount"` // Amount of the payment
+	TargetAmount Money  `json:"TargetAmount"` // Amount credited after fee and spread
+}
+
+// control total of a batch file, stored in the ledger collection under the file ID
+type BatchFilePrivateDetails struct {
+	FileID       string `json:"FileID"`       // ID of the file
+	Salt         string `json:"Salt"`         // Salt of the public hash of these details
+	ControlTotal Money  `json:"ControlTotal"` // Sum of the entry amounts
+}
+
+// totals of a ledger account, stored in the ledger collection under the key of its public record
+type LedgerAccountPrivateDetails struct {
+	Key     string `json:"Key"`     // World state key of the account
+	Salt    string `json:"Salt"`    // Salt of the public hash of these details
+	Debits  Money  `json:"Debits"`  // Sum of all debits posted to the account
+	Credits Money  `json:"Credits"` // Sum of all credits posted to the account
+}
+
+// amount of a posting, stored in the ledger collection under the posting ID
+type PostingPrivateDetails struct {
+	PostingID string `json:"PostingID"` // ID of the posting
+	Salt      string `json:"Salt"`      // Salt of the public hash of these details
+	Amount    Money  `json:"Amount"`    // Amount posted
+}
+
+// pay submitted in the transient map under transientPayKey, e.g.
+// {"Salary": "85000.00", "VariablePay": "5000.00", "Salt": "<random string>"}
+type transientPay struct {
+	Salary      string `json:"Salary"`
+	VariablePay string `json:"VariablePay"`
+	Salt        string `json:"Salt"`
+}
+
+// account details submitted in the transient map under transientAccountKey
+type transientAccount struct {
+	TaxComplianceInfo string `json:"TaxComplianceInfo"`
+	FinancialInfo     string `json:"FinancialInfo"`
+	BankAccount       string `json:"BankAccount"`
+	BankBIC           string `json:"BankBIC"`
+	RoutingNumber     string `json:"RoutingNumber"`
+	Salt              string `json:"Salt"`
+}
+
+// readTransient decodes the JSON value submitted in the transient map under key
+func readTransient(ctx contractapi.TransactionContextInterface, key string, value interface{}) error {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient data: %v", err)
+	}
+
+	data, ok := transientMap[key]
+	if !ok || len(data) == 0 {
+		return fmt.Errorf("%s details must be submitted in the transient map", key)
+	}
+
+	err = json.Unmarshal(data, value)
+	if err != nil {
+		return fmt.Errorf("failed to decode transient %s details: %v", key, err)
+	}
+
+	return nil
+}
+
+// readTransientPay reads the salary, variable pay and salt submitted with a transaction
+func readTransientPay(ctx contractapi.TransactionContextInterface) (*transientPay, error) {
+	var pay transientPay
+	err := readTransient(ctx, transientPayKey, &pay)
+	if err != nil {
+		return nil, err
+	}
+	if len(pay.Salt) < minSaltLength {
+		return nil, fmt.Errorf("the salt must be at least %d characters", minSaltLength)
+	}
+
+	return &pay, nil
+}
+
+// saltedHash returns the hex SHA-256 hash of a salt followed by the JSON of value
+func saltedHash(salt string, value interface{}) (string, error) {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(append([]byte(salt), valueJSON...))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// clientOrgIsPeerOrg reports whether the submitting client belongs to the org of the
+// endorsing peer. Only peers of member orgs hold private data, so private details are
+// returned to a client only through a peer of its own org
+func clientOrgIsPeerOrg(ctx contractapi.TransactionContextInterface) (bool, error) {
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return false, fmt.Errorf("failed to read client MSP: %v", err)
+	}
+
+	peerMSPID, err := shim.GetMSPID()
+	if err != nil {
+		return false, fmt.Errorf("failed to read peer MSP: %v", err)
+	}
+
+	return clientMSPID == peerMSPID, nil
+}
+
+// withoutPay returns the terms with salary and variable pay cleared
+func (t ContractTerms) withoutPay() ContractTerms {
+	t.Salary = Money{}
+	t.VariablePay = Money{}
+	return t
+}
+
+// publicContract returns the copy of a contract written to the world state. Once a
+// contract's pay is kept in the contract collection it is cleared from public state
+func publicContract(contract *Contract) *Contract {
+	if contract.PrivateDataHash == "" {
+		return contract
+	}
+
+	public := *contract
+	public.ContractTerms = contract.ContractTerms.withoutPay()
+	public.TermsVersions = make([]TermsVersion, len(contract.TermsVersions))
+	for i, version := range contract.TermsVersions {
+		version.ContractTerms = version.ContractTerms.withoutPay()
+		public.TermsVersions[i] = version
+	}
+	return &public
+}
+
+// collection returns the contract or ledger collection of the employer of a contract
+func (c *Contract) collection(name string) (string, error) {
+	if c.EmployerIdentity.MSPID == "" {
+		return "", fmt.Errorf("contract %s has no employer MSP whose collection could hold its private details", c.ID)
+	}
+	return EmployerCollection(name, c.EmployerIdentity.MSPID), nil
+}
+
+// readCollection returns the contract or ledger collection of the employer of the
+// contract with the given ID
+func readCollection(ctx contractapi.TransactionContextInterface, contractID string, name string) (string, error) {
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return "", err
+	}
+
+	return contract.collection(name)
+}
+
+// getContractPrivateDetails reads the private details of a contract, returning nil
+// when this peer holds none
+func getContractPrivateDetails(ctx contractapi.TransactionContextInterface, contract *Contract) (*ContractPrivateDetails, error) {
+	collection, err := contract.collection(ContractCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	detailsJSON, err := ctx.GetStub().GetPrivateData(collection, contract.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private data: %v", err)
+	}
+	if detailsJSON == nil {
+		return nil, nil
+	}
+
+	var details ContractPrivateDetails
+	err = json.Unmarshal(detailsJSON, &details)
+	if err != nil {
+		return nil, err
+	}
+
+	return &details, nil
+}
+
+// applyContractPay copies the pay held in private details onto a contract read from public state
+func applyContractPay(contract *Contract, details *ContractPrivateDetails) {
+	contract.Salary = details.Current.Salary
+	contract.VariablePay = details.Current.VariablePay
+	for i, version := range contract.TermsVersions {
+		for _, pay := range details.Versions {
+			if pay.Version == version.Version {
+				contract.TermsVersions[i].Salary = pay.Salary
+				contract.TermsVersions[i].VariablePay = pay.VariablePay
+				break
+			}
+		}
+		// the current terms are a copy of a version once amendments can be pending
+		if version.Version == contract.TermsVersion {
+			contract.Salary = contract.TermsVersions[i].Salary
+			contract.VariablePay = contract.TermsVersions[i].VariablePay
+		}
+	}
+}
+
+// loadContractPay fills in the pay of a contract from the contract collection.
+// Contracts recorded before pay was private still carry it on public state
+func loadContractPay(ctx contractapi.TransactionContextInterface, contract *Contract) error {
+	if contract.PrivateDataHash == "" {
+		return nil
+	}
+
+	details, err := getContractPrivateDetails(ctx, contract)
+	if err != nil {
+		return err
+	}
+	if details == nil {
+		return fmt.Errorf("the pay of contract %s is not available on this peer", contract.ID)
+	}
+
+	applyContractPay(contract, details)
+	return nil
+}
+
+// loadVisibleContractPay fills in the pay of a contract for a read transaction when
+// the client may see it: its org is the peer's org and the peer holds the pay
+func loadVisibleContractPay(ctx contractapi.TransactionContextInterface, contract *Contract) error {
+	if contract.PrivateDataHash == "" {
+		return nil
+	}
+
+	visible, err := clientOrgIsPeerOrg(ctx)
+	if err != nil || !visible {
+		return err
+	}
+
+	details, err := getContractPrivateDetails(ctx, contract)
+	if err != nil || details == nil {
+		return err
+	}
+
+	applyContractPay(contract, details)
+	return nil
+}
+
+// readContractPay reads a contract together with its private pay
+func readContractPay(ctx contractapi.TransactionContextInterface, contractID string) (*Contract, error) {
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadContractPay(ctx, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	return contract, nil
+}
+
+// putContractPay writes the pay of a contract's current terms and every version to
+// the contract collection and records their salted hash on the contract. An empty
+// salt keeps the salt already stored for the contract
+func putContractPay(ctx contractapi.TransactionContextInterface, contract *Contract, salt string) error {
+	if salt == "" {
+		existing, err := getContractPrivateDetails(ctx, contract)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return fmt.Errorf("a salt is required to store the pay of contract %s", contract.ID)
+		}
+		salt = existing.Salt
+	}
+
+	details := ContractPrivateDetails{
+		ContractID: contract.ID,
+		Current:    PrivatePay{Salary: contract.Salary, VariablePay: contract.VariablePay},
+	}
+	details.Current.Version = contract.TermsVersion
+	if n := len(contract.TermsVersions); n > 0 && contract.TermsVersion == 0 {
+		details.Current.Version = contract.TermsVersions[n-1].Version
+	}
+	for _, version := range contract.TermsVersions {
+		details.Versions = append(details.Versions, PrivatePay{Version: version.Version, Salary: version.Salary, VariablePay: version.VariablePay})
+	}
+
+	hash, err := saltedHash(salt, details)
+	if err != nil {
+		return err
+	}
+
+	collection, err := contract.collection(ContractCollection)
+	if err != nil {
+		return err
+	}
+
+	details.Salt = salt
+	err = putPrivateDetails(ctx, collection, contract.ID, details)
+	if err != nil {
+		return err
+	}
+
+	contract.PrivateDataHash = hash
+	return nil
+}
+
+// deriveSalt returns the salt of the record under key derived from another salt
+func deriveSalt(salt string, key string) string {
+	hash := sha256.Sum256([]byte(salt + "\x00" + key))
+	return hex.EncodeToString(hash[:])
+}
+
+// amountsSalt returns the contract collection of a contract and the salt of the
+// amounts recorded there under key. The salt is derived from the salt of the
+// contract's pay, so every endorsing peer computes the same salt and it is known only
+// to the orgs that may see the pay. Contracts whose pay is still public return an
+// empty salt and keep their amounts public too
+func amountsSalt(ctx contractapi.TransactionContextInterface, contractID string, key string) (string, string, error) {
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return "", "", err
+	}
+	if contract.PrivateDataHash == "" {
+		return "", "", nil
+	}
+
+	details, err := getContractPrivateDetails(ctx, contract)
+	if err != nil {
+		return "", "", err
+	}
+	if details == nil {
+		return "", "", fmt.Errorf("the pay of contract %s is not available on this peer", contractID)
+	}
+
+	collection, err := contract.collection(ContractCollection)
+	if err != nil {
+		return "", "", err
+	}
+
+	return collection, deriveSalt(details.Salt, key), nil
+}
+
+// salt the amounts a contract records in the ledger collection are salted with,
+// stored there under the contract ID
+type LedgerPrivateDetails struct {
+	ContractID string `json:"ContractID"` // ID of the contract
+	Salt       string `json:"Salt"`       // Salt the ledger amounts of the contract are derived from
+}
+
+// ledgerAmountsSalt returns the ledger collection of a contract and the salt of the
+// amounts recorded there under key. The ledger salt is derived from the salt of the
+// contract's pay when the employer or employee first records an amount, and kept in
+// the ledger collection so that banks, which do not see the pay, can record amounts
+// of the contract too. Contracts whose pay is still public return an empty salt
+func ledgerAmountsSalt(ctx contractapi.TransactionContextInterface, contractID string, key string) (string, string, error) {
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return "", "", err
+	}
+	if contract.PrivateDataHash == "" {
+		return "", "", nil
+	}
+
+	collection, err := contract.collection(LedgerCollection)
+	if err != nil {
+		return "", "", err
+	}
+
+	var details LedgerPrivateDetails
+	found, err := getPrivateDetails(ctx, collection, contractID, &details)
+	if err != nil {
+		return "", "", err
+	}
+	if !found {
+		_, salt, err := amountsSalt(ctx, contractID, LedgerCollection)
+		if err != nil {
+			return "", "", err
+		}
+		details = LedgerPrivateDetails{ContractID: contractID, Salt: salt}
+		err = putPrivateDetails(ctx, collection, contractID, details)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return collection, deriveSalt(details.Salt, key), nil
+}
+
+// approvalTerms returns what approvers of a request sign: the terms hashed with the
+// salt of the request's amounts, which the approvals would otherwise carry onto public
+// state. Requests whose amounts are still public sign the plain terms
+func approvalTerms(salt string, terms string) (string, error) {
+	if salt == "" {
+		return terms, nil
+	}
+
+	return saltedHash(salt, terms)
+}
+
+// putPrivateDetails writes details to a collection under key
+func putPrivateDetails(ctx contractapi.TransactionContextInterface, collection string, key string, details interface{}) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutPrivateData(collection, key, detailsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put private data: %v", err)
+	}
+
+	return nil
+}
+
+// getPrivateDetails reads the details stored in a collection under key into details,
+// reporting false when this peer holds none
+func getPrivateDetails(ctx contractapi.TransactionContextInterface, collection string, key string, details interface{}) (bool, error) {
+	detailsJSON, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read private data: %v", err)
+	}
+	if detailsJSON == nil {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(detailsJSON, details)
+}
+
+// readPrivateDetails reads the details of a record whose amounts are kept in a
+// collection under key. When visibleOnly is set, as in read transactions, it reports
+// false unless the client's org is the peer's org and the peer holds them; otherwise
+// details the peer does not hold are an error
+func readPrivateDetails(ctx contractapi.TransactionContextInterface, collection string, key string, details interface{}, visibleOnly bool) (bool, error) {
+	if visibleOnly {
+		visible, err := clientOrgIsPeerOrg(ctx)
+		if err != nil || !visible {
+			return false, err
+		}
+	}
+
+	found, err := getPrivateDetails(ctx, collection, key, details)
+	if err != nil {
+		return false, err
+	}
+	if !found && !visibleOnly {
+		return false, fmt.Errorf("the private details of %s are not available on this peer", key)
+	}
+
+	return found, nil
+}
+
+// putPaymentAmounts writes the amounts of a payment to the contract collection, records
+// their salted hash on the payment and returns the copy written to the world state
+func putPaymentAmounts(ctx contractapi.TransactionContextInterface, payment *Payment) (*Payment, error) {
+	collection, salt, err := amountsSalt(ctx, payment.ContractID, payment.ID)
+	if err != nil || salt == "" {
+		return payment, err
+	}
+
+	details := PaymentPrivateDetails{
+		PaymentID:  payment.ID,
+		Amount:     payment.Amount,
+		Components: payment.Components,
+		Deductions: payment.Deductions,
+		Net:        payment.Net,
+		Recoveries: payment.Recoveries,
+		Recovered:  payment.Recovered,
+	}
+
+	hash, err := saltedHash(salt, details)
+	if err != nil {
+		return nil, err
+	}
+
+	details.Salt = salt
+	err = putPrivateDetails(ctx, collection, payment.ID, details)
+	if err != nil {
+		return nil, err
+	}
+
+	payment.PrivateDataHash = hash
+	payment.salt = salt
+	public := *payment
+	public.Amount = Money{}
+	public.Components = nil
+	public.Deductions = nil
+	public.Net = Money{}
+	public.Recoveries = nil
+	public.Recovered = Money{}
+	return &public, nil
+}
+
+// loadPaymentAmounts fills in the amounts of a payment from the contract collection.
+// Payments recorded before amounts were private still carry them on public state.
+// When visibleOnly is set, as in read transactions, they are filled in only if the
+// client's org is the peer's org and the peer holds them
+func loadPaymentAmounts(ctx contractapi.TransactionContextInterface, payment *Payment, visibleOnly bool) error {
+	if payment.PrivateDataHash == "" {
+		return nil
+	}
+
+	collection, err := readCollection(ctx, payment.ContractID, ContractCollection)
+	if err != nil {
+		return err
+	}
+
+	var details PaymentPrivateDetails
+	found, err := readPrivateDetails(ctx, collection, payment.ID, &details, visibleOnly)
+	if err != nil || !found {
+		return err
+	}
+
+	payment.salt = details.Salt
+	payment.Amount = details.Amount
+	payment.Components = details.Components
+	payment.Deductions = details.Deductions
+	payment.Net = details.Net
+	payment.Recoveries = details.Recoveries
+	payment.Recovered = details.Recovered
+	return nil
+}
+
+// readPaymentAmounts reads a payment together with its private amounts
+func readPaymentAmounts(ctx contractapi.TransactionContextInterface, paymentID string) (*Payment, error) {
+	payment, err := readPayment(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadPaymentAmounts(ctx, payment, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// putYearToDateTotals writes year-to-date totals to the contract collection under key,
+// records their salted hash on them and returns the copy written to the world state
+func putYearToDateTotals(ctx contractapi.TransactionContextInterface, key string, ytd *YearToDate) (*YearToDate, error) {
+	collection, salt, err := amountsSalt(ctx, ytd.ContractID, key)
+	if err != nil || salt == "" {
+		return ytd, err
+	}
+
+	details := YearToDatePrivateDetails{
+		Key:               key,
+		Gross:             ytd.Gross,
+		Net:               ytd.Net,
+		Deductions:        ytd.Deductions,
+		ContributionBases: ytd.ContributionBases,
+	}
+
+	hash, err := saltedHash(salt, details)
+	if err != nil {
+		return nil, err
+	}
+
+	details.Salt = salt
+	err = putPrivateDetails(ctx, collection, key, details)
+	if err != nil {
+		return nil, err
+	}
+
+	ytd.PrivateDataHash = hash
+	public := *ytd
+	public.Gross = Money{}
+	public.Net = Money{}
+	public.Deductions = nil
+	public.ContributionBases = nil
+	return &public, nil
+}
+
+// loadYearToDateTotals fills in year-to-date totals read from the world state under key
+// from the contract collection. When visibleOnly is set, as in read transactions, they
+// are filled in only if the client's org is the peer's org and the peer holds them
+func loadYearToDateTotals(ctx contractapi.TransactionContextInterface, key string, ytd *YearToDate, visibleOnly bool) error {
+	if ytd.PrivateDataHash == "" {
+		return nil
+	}
+
+	collection, err := readCollection(ctx, ytd.ContractID, ContractCollection)
+	if err != nil {
+		return err
+	}
+
+	var details YearToDatePrivateDetails
+	found, err := readPrivateDetails(ctx, collection, key, &details, visibleOnly)
+	if err != nil || !found {
+		return err
+	}
+
+	ytd.Gross = details.Gross
+	ytd.Net = details.Net
+	ytd.Deductions = details.Deductions
+	ytd.ContributionBases = details.ContributionBases
+	return nil
+}
+
+// putPayrollRunTotals writes the totals of a payroll run to the contract collection,
+// records their salted hash on the run and returns the copy written to the world
+// state. The salt is derived from that of a contract the run paid, as the totals are
+// made of its pay; a run that paid nothing has no totals to hide
+func putPayrollRunTotals(ctx contractapi.TransactionContextInterface, run *PayrollRun, contractID string) (*PayrollRun, error) {
+	if contractID == "" {
+		return run, nil
+	}
+
+	collection, salt, err := amountsSalt(ctx, contractID, run.ID)
+	if err != nil || salt == "" {
+		return run, err
+	}
+
+	details := PayrollRunPrivateDetails{
+		RunID:     run.ID,
+		Totals:    run.Totals,
+		NetTotals: run.NetTotals,
+	}
+
+	hash, err := saltedHash(salt, details)
+	if err != nil {
+		return nil, err
+	}
+
+	details.Salt = salt
+	err = putPrivateDetails(ctx, collection, run.ID, details)
+	if err != nil {
+		return nil, err
+	}
+
+	run.PrivateDataHash = hash
+	public := *run
+	public.Totals = nil
+	public.NetTotals = nil
+	return &public, nil
+}
+
+// loadVisiblePayrollRunTotals fills in the totals of a payroll run for a read
+// transaction when the client's org is the peer's org and the peer holds them
+func loadVisiblePayrollRunTotals(ctx contractapi.TransactionContextInterface, run *PayrollRun) error {
+	if run.PrivateDataHash == "" {
+		return nil
+	}
+
+	employer, err := employerIdentity(ctx, run.Employer)
+	if err != nil || employer == nil {
+		return err
+	}
+
+	var details PayrollRunPrivateDetails
+	found, err := readPrivateDetails(ctx, EmployerCollection(ContractCollection, employer.MSPID), run.ID, &details, true)
+	if err != nil || !found {
+		return err
+	}
+
+	run.Totals = details.Totals
+	run.NetTotals = details.NetTotals
+	return nil
+}
+
+// putLedgerAccountTotals writes the totals of a ledger account to the ledger collection
+// under key, records their salted hash on the account and returns the copy written to
+// the world state
+func putLedgerAccountTotals(ctx contractapi.TransactionContextInterface, key string, account *LedgerAccount) (*LedgerAccount, error) {
+	collection, salt, err := ledgerAmountsSalt(ctx, account.ContractID, key)
+	if err != nil || salt == "" {
+		return account, err
+	}
+
+	details := LedgerAccountPrivateDetails{
+		Key:     key,
+		Debits:  account.Debits,
+		Credits: account.Credits,
+	}
+
+	hash, err := saltedHash(salt, details)
+	if err != nil {
+		return nil, err
+	}
+
+	details.Salt = salt
+	err = putPrivateDetails(ctx, collection, key, details)
+	if err != nil {
+		return nil, err
+	}
+
+	account.PrivateDataHash = hash
+	public := *account
+	public.Debits = Money{}
+	public.Credits = Money{}
+	return &public, nil
+}
+
+// loadLedgerAccountTotals fills in the totals of a ledger account read from the world
+// state under key from the ledger collection. When visibleOnly is set, as in read
+// transactions, they are filled in only if the client's org is the peer's org and the
+// peer holds them
+func loadLedgerAccountTotals(ctx contractapi.TransactionContextInterface, key string, account *LedgerAccount, visibleOnly bool) error {
+	if account.PrivateDataHash == "" {
+		return nil
+	}
+
+	collection, err := readCollection(ctx, account.ContractID, LedgerCollection)
+	if err != nil {
+		return err
+	}
+
+	var details LedgerAccountPrivateDetails
+	found, err := readPrivateDetails(ctx, collection, key, &details, visibleOnly)
+	if err != nil || !found {
+		return err
+	}
+
+	account.Debits = details.Debits
+	account.Credits = details.Credits
+	return nil
+}
+
+// putPostingAmount writes the amount of a posting to the ledger collection, records
+// its salted hash on the posting and returns the copy written to the world state
+func putPostingAmount(ctx contractapi.TransactionContextInterface, posting *Posting) (*Posting, error) {
+	collection, salt, err := ledgerAmountsSalt(ctx, posting.ContractID, posting.ID)
+	if err != nil || salt == "" {
+		return posting, err
+	}
+
+	details := PostingPrivateDetails{
+		PostingID: posting.ID,
+		Amount:    posting.Amount,
+	}
+
+	hash, err := saltedHash(salt, details)
+	if err != nil {
+		return nil, err
+	}
+
+	details.Salt = salt
+	err = putPrivateDetails(ctx, collection, posting.ID, details)
+	if err != nil {
+		return nil, err
+	}
+
+	posting.PrivateDataHash = hash
+	public := *posting
+	public.Amount = Money{}
+	return &public, nil
+}
+
+// loadPostingAmount fills in the amount of a posting from the ledger collection. When
+// visibleOnly is set, as in read transactions, it is filled in only if the client's
+// org is the peer's org and the peer holds it
+func loadPostingAmount(ctx contractapi.TransactionContextInterface, posting *Posting, visibleOnly bool) error {
+	if posting.PrivateDataHash == "" {
+		return nil
+	}
+
+	collection, err := readCollection(ctx, posting.ContractID, LedgerCollection)
+	if err != nil {
+		return err
+	}
+
+	var details PostingPrivateDetails
+	found, err := readPrivateDetails(ctx, collection, posting.ID, &details, visibleOnly)
+	if err != nil || !found {
+		return err
+	}
+
+	posting.Amount = details.Amount
+	return nil
+}
+
+// putAdvanceAmounts writes the amounts of an advance request to the contract
+// collection, records their salted hash on the request and returns the copy written
+// to the world state
+func putAdvanceAmounts(ctx contractapi.TransactionContextInterface, request *AdvanceRequest) (*AdvanceRequest, error) {
+	collection, salt, err := amountsSalt(ctx, request.ContractID, request.ID)
+	if err != nil || salt == "" {
+		return request, err
+	}
+
+	details := AdvancePrivateDetails{
+		RequestID:          request.ID,
+		Amount:             request.Amount,
+		Fee:                request.Fee,
+		Charges:            request.Charges,
+		Installment:        request.Installment,
+		Outstanding:        request.Outstanding,
+		ChargesOutstanding: request.ChargesOutstanding,
+		Repayments:         request.Repayments,
+	}
+
+	hash, err := saltedHash(salt, details)
+	if err != nil {
+		return nil, err
+	}
+
+	details.Salt = salt
+	err = putPrivateDetails(ctx, collection, request.ID, details)
+	if err != nil {
+		return nil, err
+	}
+
+	request.PrivateDataHash = hash
+	request.salt = salt
+	public := *request
+	public.Amount = Money{}
+	public.Fee = Money{}
+	public.Charges = Money{}
+	public.Installment = Money{}
+	public.Outstanding = Money{}
+	public.ChargesOutstanding = Money{}
+	public.Repayments = nil
+	return &public, nil
+}
+
+// loadAdvanceAmounts fills in the amounts of an advance request from the contract
+// collection. When visibleOnly is set, as in read transactions, they are filled in
+// only if the client's org is the peer's org and the peer holds them
+func loadAdvanceAmounts(ctx contractapi.TransactionContextInterface, request *AdvanceRequest, visibleOnly bool) error {
+	if request.PrivateDataHash == "" {
+		return nil
+	}
+
+	collection, err := readCollection(ctx, request.ContractID, ContractCollection)
+	if err != nil {
+		return err
+	}
+
+	var details AdvancePrivateDetails
+	found, err := readPrivateDetails(ctx, collection, request.ID, &details, visibleOnly)
+	if err != nil || !found {
+		return err
+	}
+
+	request.salt = details.Salt
+	request.Amount = details.Amount
+	request.Fee = details.Fee
+	request.Charges = details.Charges
+	request.Installment = details.Installment
+	request.Outstanding = details.Outstanding
+	request.ChargesOutstanding = details.ChargesOutstanding
+	request.Repayments = details.Repayments
+	return nil
+}
+
+// putAdjustmentAmount writes the amount of a payment adjustment to the contract
+// collection, records its salted hash on the adjustment and returns the copy written
+// to the world state
+func putAdjustmentAmount(ctx contractapi.TransactionContextInterface, adjustment *PaymentAdjustment) (*PaymentAdjustment, error) {
+	collection, salt, err := amountsSalt(ctx, adjustment.ContractID, adjustment.ID)
+	if err != nil || salt == "" {
+		return adjustment, err
+	}
+
+	details := AdjustmentPrivateDetails{
+		AdjustmentID: adjustment.ID,
+		Amount:       adjustment.Amount,
+	}
+
+	hash, err := saltedHash(salt, details)
+	if err != nil {
+		return nil, err
+	}
+
+	details.Salt = salt
+	err = putPrivateDetails(ctx, collection, adjustment.ID, details)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustment.PrivateDataHash = hash
+	adjustment.salt = salt
+	public := *adjustment
+	public.Amount = Money{}
+	return &public, nil
+}
+
+// loadAdjustmentAmount fills in the amount of a payment adjustment from the contract
+// collection. When visibleOnly is set, as in read transactions, it is filled in only
+// if the client's org is the peer's org and the peer holds it
+func loadAdjustmentAmount(ctx contractapi.TransactionContextInterface, adjustment *PaymentAdjustment, visibleOnly bool) error {
+	if adjustment.PrivateDataHash == "" {
+		return nil
+	}
+
+	collection, err := readCollection(ctx, adjustment.ContractID, ContractCollection)
+	if err != nil {
+		return err
+	}
+
+	var details AdjustmentPrivateDetails
+	found, err := readPrivateDetails(ctx, collection, adjustment.ID, &details, visibleOnly)
+	if err != nil || !found {
+		return err
+	}
+
+	adjustment.salt = details.Salt
+	adjustment.Amount = details.Amount
+	return nil
+}
+
+// loadBankPaymentAmounts fills in the amounts of a bank payment from the ledger
+// collection and keeps their salt for put. When visibleOnly is set, as in read
+// transactions, they are filled in only if the client's org is the peer's org and the
+// peer holds them
+func loadBankPaymentAmounts(ctx contractapi.TransactionContextInterface, payment *bankPayment, visibleOnly bool) error {
+	if payment.PrivateDataHash == "" {
+		return nil
+	}
+
+	collection, err := readCollection(ctx, payment.ContractID, LedgerCollection)
+	if err != nil {
+		return err
+	}
+
+	var details BankPaymentPrivateDetails
+	found, err := readPrivateDetails(ctx, collection, payment.ID, &details, visibleOnly)
+	if err != nil || !found {
+		return err
+	}
+
+	payment.collection = collection
+	payment.salt = details.Salt
+	payment.setAmounts(details)
+	return nil
+}
+
+// putFxQuoteAmounts writes the amounts of an FX quote to the ledger collection of the
+// contract paid, records their salted hash on the quote and returns the copy written
+// to the world state
+func putFxQuoteAmounts(ctx contractapi.TransactionContextInterface, quote *FxQuote, contractID string) (*FxQuote, error) {
+	collection, salt, err := ledgerAmountsSalt(ctx, contractID, quote.ID)
+	if err != nil || salt == "" {
+		return quote, err
+	}
+
+	details := FxQuotePrivateDetails{
+		QuoteID:      quote.ID,
+		Fee:          quote.Fee,
+		SourceAmount: quote.SourceAmount,
+		TargetAmount: quote.TargetAmount,
+	}
+
+	hash, err := saltedHash(salt, details)
+	if err != nil {
+		return nil, err
+	}
+
+	details.Salt = salt
+	err = putPrivateDetails(ctx, collection, quote.ID, details)
+	if err != nil {
+		return nil, err
+	}
+
+	quote.PrivateDataHash = hash
+	public := *quote
+	public.Fee = Money{}
+	public.SourceAmount = Money{}
+	public.TargetAmount = Money{}
+	return &public, nil
+}
+
+// loadFxQuoteAmounts fills in the amounts of an FX quote for a payment of a contract
+// from the ledger collection. When visibleOnly is set, as in read transactions, they
+// are filled in only if the client's org is the peer's org and the peer holds them
+func loadFxQuoteAmounts(ctx contractapi.TransactionContextInterface, quote *FxQuote, contractID string, visibleOnly bool) error {
+	if quote.PrivateDataHash == "" {
+		return nil
+	}
+
+	collection, err := readCollection(ctx, contractID, LedgerCollection)
+	if err != nil {
+		return err
+	}
+
+	var details FxQuotePrivateDetails
+	found, err := readPrivateDetails(ctx, collection, quote.ID, &details, visibleOnly)
+	if err != nil || !found {
+		return err
+	}
+
+	quote.Fee = details.Fee
+	quote.SourceAmount = details.SourceAmount
+	quote.TargetAmount = details.TargetAmount
+	return nil
+}
+
+// putBatchFileTotal writes the control total of a batch file to the ledger collection,
+// records its salted hash on the file and returns the copy written to the world state.
+// The salt is derived from that of a contract the file pays, as payroll run totals are
+func putBatchFileTotal(ctx contractapi.TransactionContextInterface, file *BatchFile, contractID string) (*BatchFile, error) {
+	collection, salt, err := ledgerAmountsSalt(ctx, contractID, file.FileID)
+	if err != nil || salt == "" {
+		return file, err
+	}
+
+	details := BatchFilePrivateDetails{
+		FileID:       file.FileID,
+		ControlTotal: file.ControlTotal,
+	}
+
+	hash, err := saltedHash(salt, details)
+	if err != nil {
+		return nil, err
+	}
+
+	details.Salt = salt
+	err = putPrivateDetails(ctx, collection, file.FileID, details)
+	if err != nil {
+		return nil, err
+	}
+
+	file.PrivateDataHash = hash
+	public := *file
+	public.ControlTotal = Money{}
+	return &public, nil
+}
+
+// loadVisibleBatchFileTotal fills in the control total of a batch file for a read
+// transaction when the client's org is the peer's org and the peer holds it
+func loadVisibleBatchFileTotal(ctx contractapi.TransactionContextInterface, file *BatchFile) error {
+	if file.PrivateDataHash == "" {
+		return nil
+	}
+
+	employer, err := employerIdentity(ctx, file.Employer)
+	if err != nil || employer == nil {
+		return err
+	}
+
+	var details BatchFilePrivateDetails
+	found, err := readPrivateDetails(ctx, EmployerCollection(LedgerCollection, employer.MSPID), file.FileID, &details, true)
+	if err != nil || !found {
+		return err
+	}
+
+	file.ControlTotal = details.ControlTotal
+	return nil
+}
+
+// getAccountPrivateDetails reads the private details of an account, returning nil
+// when this peer holds none
+func getAccountPrivateDetails(ctx contractapi.TransactionContextInterface, accountID string) (*AccountPrivateDetails, error) {
+	detailsJSON, err := ctx.GetStub().GetPrivateData(AccountCollection, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private data: %v", err)
+	}
+	if detailsJSON == nil {
+		return nil, nil
+	}
+
+	var details AccountPrivateDetails
+	err = json.Unmarshal(detailsJSON, &details)
+	if err != nil {
+		return nil, err
+	}
+
+	return &details, nil
+}
+
+// loadVisibleAccountDetails fills in the private details of an account for a read
+// transaction when the client's org is the peer's org and the peer holds them
+func loadVisibleAccountDetails(ctx contractapi.TransactionContextInterface, account *Account) error {
+	if account.PrivateDataHash == "" {
+		return nil
+	}
+
+	visible, err := clientOrgIsPeerOrg(ctx)
+	if err != nil || !visible {
+		return err
+	}
+
+	details, err := getAccountPrivateDetails(ctx, account.AccountID)
+	if err != nil || details == nil {
+		return err
+	}
+
+	account.TaxComplianceInfo = details.TaxComplianceInfo
+	account.FinancialInfo = details.FinancialInfo
+	account.BankAccount = details.BankAccount
+	account.BankBIC = details.BankBIC
+	account.RoutingNumber = details.RoutingNumber
+	return nil
+}
+
+// putAccountDetails writes the tax, financial and bank details submitted in the
+// transient map to the account collection and records their salted hash on the account
+func putAccountDetails(ctx contractapi.TransactionContextInterface, account *Account) error {
+	var submitted transientAccount
+	err := readTransient(ctx, transientAccountKey, &submitted)
+	if err != nil {
+		return err
+	}
+	if len(submitted.Salt) < minSaltLength {
+		return fmt.Errorf("the salt must be at least %d characters", minSaltLength)
+	}
+	if submitted.BankAccount == "" {
+		return fmt.Errorf("bank account details are required")
+	}
+	if submitted.BankBIC != "" && !bicPattern.MatchString(submitted.BankBIC) {
+		return fmt.Errorf("bank BIC %q is not a valid BIC", submitted.BankBIC)
+	}
+	if submitted.RoutingNumber != "" && !validRoutingNumber(submitted.RoutingNumber) {
+		return fmt.Errorf("routing number %q is not a valid ABA routing number", submitted.RoutingNumber)
+	}
+
+	details := AccountPrivateDetails{
+		AccountID:         account.AccountID,
+		TaxComplianceInfo: submitted.TaxComplianceInfo,
+		FinancialInfo:     submitted.FinancialInfo,
+		BankAccount:       submitted.BankAccount,
+		BankBIC:           submitted.BankBIC,
+		RoutingNumber:     submitted.RoutingNumber,
+	}
+
+	hash, err := saltedHash(submitted.Salt, details)
+	if err != nil {
+		return err
+	}
+
+	details.Salt = submitted.Salt
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutPrivateData(AccountCollection, account.AccountID, detailsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put private data: %v", err)
+	}
+
+	account.TaxComplianceInfo = ""
+	account.FinancialInfo = ""
+	account.BankAccount = ""
+	account.BankBIC = ""
+	account.RoutingNumber = ""
+	account.PrivateDataHash = hash
+	return nil
+}
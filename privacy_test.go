@@ -0,0 +1,157 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// The amounts of payments, year-to-date totals and payroll runs stay off the public
+// state, which only holds their salted hash
+func TestPayrollAmountsArePrivate(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	run, err := s.RunPayroll(l.begin(p.employer, nil), "", p.employer.name, PayrollInterval{StartDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)})
+	l.must(err)
+	if run.Paid != 1 {
+		t.Fatalf("the run paid %d contracts: %+v", run.Paid, run.Failures)
+	}
+	if run.Totals != nil || run.NetTotals != nil || run.PrivateDataHash == "" {
+		t.Errorf("the run returned its totals: %+v", run)
+	}
+
+	var public Payment
+	l.must(json.Unmarshal(l.stub.State[run.Payments[0]], &public))
+	if public.PrivateDataHash == "" || public.Amount != (Money{}) || public.Net != (Money{}) || public.Components != nil || public.Deductions != nil {
+		t.Errorf("the payment is public: %+v", public)
+	}
+
+	var details PaymentPrivateDetails
+	l.must(json.Unmarshal(l.stub.PvtState[EmployerCollection(ContractCollection, p.employer.mspID)][public.ID], &details))
+	salt := details.Salt
+	details.Salt = ""
+	hash, err := saltedHash(salt, details)
+	l.must(err)
+	if hash != public.PrivateDataHash || !details.Amount.IsPositive() {
+		t.Errorf("the private amounts %+v do not match the public hash %s", details, public.PrivateDataHash)
+	}
+
+	for key, value := range l.stub.State {
+		var ytd YearToDate
+		if json.Unmarshal(value, &ytd) == nil && ytd.Year != 0 && (ytd.Gross != (Money{}) || ytd.PrivateDataHash == "") {
+			t.Errorf("the year-to-date totals under %q are public: %+v", key, ytd)
+		}
+	}
+
+	// the employer reads through a peer of its own org, the employee through another
+	last, err := s.GetLastPayment(l.begin(p.employer, nil), "C1", p.employee.name)
+	l.must(err)
+	if last.Amount != details.Amount {
+		t.Errorf("the employer read %s, want %s", last.Amount, details.Amount)
+	}
+	last, err = s.GetLastPayment(l.begin(p.employee, nil), "C1", p.employee.name)
+	l.must(err)
+	if last.Amount != (Money{}) {
+		t.Errorf("a peer of another org returned the amount %s", last.Amount)
+	}
+
+	runs, err := s.GetPayrollRuns(l.begin(p.employer, nil), p.employer.name)
+	l.must(err)
+	if len(runs) != 1 || runs[0].Totals["EUR"] != details.Amount {
+		t.Errorf("the employer read the runs %+v", runs)
+	}
+	totals, err := s.GetYearToDate(l.begin(p.employer, nil), "C1", 2024)
+	l.must(err)
+	if len(totals) != 1 || totals[0].Gross != details.Amount {
+		t.Errorf("the employer read the year-to-date totals %+v", totals)
+	}
+}
+
+// publicAmounts returns the paths of the non-zero amounts held in a JSON value
+func publicAmounts(path string, value interface{}) []string {
+	var found []string
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if units, ok := value["Units"].(float64); ok && units != 0 {
+			if _, ok := value["Currency"]; ok {
+				return []string{path}
+			}
+		}
+		for key, field := range value {
+			found = append(found, publicAmounts(path+"."+key, field)...)
+		}
+	case []interface{}:
+		for _, item := range value {
+			found = append(found, publicAmounts(path+"[]", item)...)
+		}
+	}
+	return found
+}
+
+// No amount the contract's transactions write, from the ledger postings and balances
+// to advances, adjustments, bank payments and batch files, reaches the public state
+func TestLedgerAmountsArePrivate(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+	openDebtorAccount(l, s, p, "ACME1")
+
+	run, err := s.RunPayroll(l.begin(p.employer, nil), "", p.employer.name, PayrollInterval{StartDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)})
+	l.must(err)
+	l.must(s.AdvanceRequest(l.begin(p.employee, nil), "ADV1", "C1", p.employee.name, "100.00", 2))
+	l.must(s.ApproveAdvanceRequest(l.begin(p.employer, nil), "", "ADV1", "5.00", 0))
+	l.must(s.RepayAdvance(l.begin(p.employee, nil), "", "ADV1", "10.00"))
+	l.must(s.CorrectPayment(l.begin(p.employer, nil), "ADJ1", run.Payments[0], "4000.00", ReasonIncorrectAmount, ""))
+	_, err = s.WithdrawPayment(l.begin(p.employee, nil), "", "C1", p.employee.name, "50.00")
+	l.must(err)
+
+	settlementDate := l.clock.AddDate(0, 0, 1).Format(dateLayout)
+	paymentID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", Local)
+	l.must(err)
+	l.must(s.ProcessLocalPayment(l.begin(p.bank, nil), paymentID, settlementDate))
+	file, err := s.GenerateSepaFile(l.begin(p.employer, nil), settlementDate, "ACME1")
+	l.must(err)
+	if file.ControlTotal != (Money{}) {
+		t.Errorf("the generation returned the control total %s", file.ControlTotal)
+	}
+
+	for key, value := range l.stub.State {
+		var record interface{}
+		if json.Unmarshal(value, &record) != nil {
+			continue
+		}
+		for _, path := range publicAmounts("", record) {
+			t.Errorf("the public state holds an amount at %s under %q", path, key)
+		}
+	}
+
+	// the parties still read the amounts through a peer of the employer's org
+	balance, err := s.GetBalance(l.begin(p.employer, nil), "C1", p.employee.name)
+	l.must(err)
+	if !balance.Available.IsPositive() {
+		t.Errorf("the employer read the balance %+v", balance)
+	}
+	postings, err := s.GetPostings(l.begin(p.employer, nil), "C1", p.employee.name)
+	l.must(err)
+	for _, posting := range postings {
+		if !posting.Amount.IsPositive() {
+			t.Errorf("the employer read the posting %+v", posting)
+		}
+	}
+	file, err = s.GetBatchFile(l.begin(p.employer, nil), file.FileID)
+	l.must(err)
+	if want, _ := ParseMoney("1000.00", "EUR", RoundExact); file.ControlTotal != want {
+		t.Errorf("the employer read the control total %s", file.ControlTotal)
+	}
+	balance, err = s.GetBalance(l.begin(p.employee, nil), "C1", p.employee.name)
+	l.must(err)
+	if balance.Available != (Money{}) {
+		t.Errorf("a peer of another org returned the balance %s", balance.Available)
+	}
+}
@@ -0,0 +1,192 @@
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key indexing bank payments by ISO 20022 end-to-end ID
+const endToEndIndex = "EndToEndID"
+
+// confirmation that a settled payment appears on a bank statement
+type PaymentReconciliation struct {
+	StatementRef string    `json:"StatementRef"` // Statement and entry reference the payment was matched to
+	Amount       Money     `json:"Amount"`       // Amount booked on the statement
+	BookingDate  time.Time `json:"BookingDate"`  // Booking date of the statement entry
+	ReconciledBy string    `json:"ReconciledBy"` // Name of the bank client that reconciled the payment
+	ReconciledAt time.Time `json:"ReconciledAt"` // Transaction timestamp of the reconciliation
+}
+
+// what a reconciliation tool needs of a bank payment to match statement entries to it
+type ReconciliationCandidate struct {
+	PaymentID    string    `json:"PaymentID"`    // ID of the cross-border or local payment
+	Type         string    `json:"Type"`         // CrossBorder or Local
+	Status       string    `json:"Status"`       // Settlement status
+	EndToEndID   string    `json:"EndToEndID"`   // ISO 20022 end-to-end identifier
+	Amounts      []string  `json:"Amounts"`      // Amounts a statement may book, e.g. "1234.56 EUR"
+	SettledAt    time.Time `json:"SettledAt"`    // Timestamp of the last settlement hop
+	Reconciled   bool      `json:"Reconciled"`   // Whether the payment was already reconciled
+	StatementRef string    `json:"StatementRef"` // Statement reference of the reconciliation, if any
+}
+
+// putEndToEndIndex indexes a bank payment under its end-to-end ID
+func putEndToEndIndex(ctx contractapi.TransactionContextInterface, endToEndID string, paymentID string) error {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(endToEndIndex, []string{endToEndID})
+	if err != nil {
+		return fmt.Errorf("failed to create end-to-end key: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(indexKey, []byte(paymentID))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// readPaymentByEndToEndID reads the bank payment exported under an end-to-end ID. When
+// visibleOnly is set, as in read transactions, its amounts are filled in only if the
+// client may see them
+func readPaymentByEndToEndID(ctx contractapi.TransactionContextInterface, endToEndID string, visibleOnly bool) (*bankPayment, error) {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(endToEndIndex, []string{endToEndID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create end-to-end key: %v", err)
+	}
+
+	paymentID, err := ctx.GetStub().GetState(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if paymentID == nil {
+		return nil, fmt.Errorf("no payment was exported with end-to-end ID %s", endToEndID)
+	}
+
+	return getBankPayment(ctx, string(paymentID), visibleOnly)
+}
+
+// settledAmounts returns the amounts a statement may book for a payment: the payment
+// amount and, for converted cross-border payments, the amounts on both sides of the
+// conversion
+func settledAmounts(payment *bankPayment) []Money {
+	amounts := []Money{payment.Amount}
+	crossBorder, ok := payment.record.(*CrossBorderPayment)
+	if ok && crossBorder.Conversion.QuoteID != "" {
+		amounts = append(amounts, crossBorder.Conversion.SourceAmount, crossBorder.Conversion.TargetAmount)
+	}
+	return amounts
+}
+
+// GetPaymentByEndToEndID returns what a reconciliation tool needs to match statement
+// entries carrying an end-to-end ID to the bank payment exported under it
+func (s *PaymentContract) GetPaymentByEndToEndID(ctx contractapi.TransactionContextInterface, endToEndID string) (*ReconciliationCandidate, error) {
+	_, err := authorize(ctx, "GetPaymentByEndToEndID")
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := readPaymentByEndToEndID(ctx, endToEndID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	candidate := ReconciliationCandidate{
+		PaymentID:    payment.ID,
+		Type:         payment.Type,
+		Status:       payment.Status,
+		EndToEndID:   payment.EndToEndID,
+		Reconciled:   !payment.Reconciliation.ReconciledAt.IsZero(),
+		StatementRef: payment.Reconciliation.StatementRef,
+	}
+	// the amounts are private, so a peer of another org returns the payment without them
+	for _, amount := range settledAmounts(payment) {
+		if amount.Currency != "" {
+			candidate.Amounts = append(candidate.Amounts, amount.String())
+		}
+	}
+	if n := len(payment.Hops); n > 0 {
+		candidate.SettledAt = payment.Hops[n-1].At
+	}
+
+	return &candidate, nil
+}
+
+// ReconcilePayment records that a completed bank payment was matched to a statement
+// entry by its end-to-end ID. Only the debtor or member bank of the payment reconciles
+// it, the booked amount ("1234.56") must be one the payment settled, the booking date
+// within the reconciliation days of the policy from its completion, and a payment is
+// only reconciled once
+func (s *PaymentContract) ReconcilePayment(ctx contractapi.TransactionContextInterface, endToEndID string, amount string, currency string, bookingDate string, statementRef string) error {
+	caller, err := authorize(ctx, "ReconcilePayment")
+	if err != nil {
+		return err
+	}
+
+	payment, err := readPaymentByEndToEndID(ctx, endToEndID, false)
+	if err != nil {
+		return err
+	}
+	if payment.Status != SettlementCompleted {
+		return fmt.Errorf("payment %s is %s, only Completed payments are reconciled", payment.ID, payment.Status)
+	}
+	if caller.MSPID != payment.Route.DebtorBank && caller.MSPID != payment.Route.MemberBank {
+		return fmt.Errorf("MSP %s is neither the debtor nor the member bank of payment %s", caller.MSPID, payment.ID)
+	}
+	if !payment.Reconciliation.ReconciledAt.IsZero() {
+		return fmt.Errorf("payment %s was already reconciled to %s", payment.ID, payment.Reconciliation.StatementRef)
+	}
+	if statementRef == "" {
+		return fmt.Errorf("a statement reference is required")
+	}
+
+	booked, err := ParseMoney(amount, currency, RoundExact)
+	if err != nil {
+		return err
+	}
+	matched := false
+	for _, settled := range settledAmounts(payment) {
+		if settled == booked {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return fmt.Errorf("booked amount %s does not match payment %s", booked, payment.ID)
+	}
+
+	date, err := parseDate(bookingDate)
+	if err != nil {
+		return err
+	}
+	date = startOfDay(date)
+
+	contract, err := readContract(ctx, payment.ContractID)
+	if err != nil {
+		return err
+	}
+	policy, err := effectivePolicy(ctx, contract.Employer, contract.ID)
+	if err != nil {
+		return err
+	}
+	completed := startOfDay(payment.Hops[len(payment.Hops)-1].At)
+	days := int64(date.Sub(completed).Hours() / 24)
+	if days < -policy.ReconciliationDays || days > policy.ReconciliationDays {
+		return fmt.Errorf("booking date %s is more than %d days from the completion of payment %s on %s", date.Format(dateLayout), policy.ReconciliationDays, payment.ID, completed.Format(dateLayout))
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	payment.Reconciliation = PaymentReconciliation{
+		StatementRef: statementRef,
+		Amount:       booked,
+		BookingDate:  date,
+		ReconciledBy: caller.Name,
+		ReconciledAt: now,
+	}
+
+	return payment.put(ctx)
+}
@@ -0,0 +1,45 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Only the banks that settled a payment reconcile it, and only to a statement entry
+// booked close to its completion
+func TestReconciliationIsCheckedOnChain(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+	fundWallet(l, s, p, "C1")
+	openDebtorAccount(l, s, p, "ACME1")
+
+	paymentID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", Local)
+	l.must(err)
+	l.must(s.ProcessLocalPayment(l.begin(p.bank, nil), paymentID, l.clock.Format(dateLayout)))
+	_, err = s.ExportSettlementMessage(l.begin(p.employer, nil), Pain001, "ACME1", []string{paymentID})
+	l.must(err)
+	l.must(s.InitiateSettlement(l.begin(p.bank, nil), paymentID, SettlementRoute{MemberBank: p.bank.mspID}, "SENT1"))
+	l.must(s.AdvanceSettlement(l.begin(p.bank, nil), paymentID, CreditedToMemberBank, "RCVD1"))
+	l.must(s.AdvanceSettlement(l.begin(p.bank, nil), paymentID, SettlementCompleted, "DONE1"))
+	endToEndID := isoIdentifier("E2E", paymentID)
+	completed := startOfDay(l.clock)
+
+	other := newTestClient(t, "other", "OtherBankMSP", RoleBank)
+	l.must(s.RegisterBankMSP(l.begin(p.admin, nil), other.mspID))
+	err = s.ReconcilePayment(l.begin(other, nil), endToEndID, "1000.00", "EUR", completed.Format(dateLayout), "STMT1/1")
+	if err == nil || err.Error() != "MSP OtherBankMSP is neither the debtor nor the member bank of payment "+paymentID {
+		t.Errorf("a bank off the route reconciled the payment: %v", err)
+	}
+
+	late := completed.Add(4 * 24 * time.Hour).Format(dateLayout)
+	err = s.ReconcilePayment(l.begin(p.bank, nil), endToEndID, "1000.00", "EUR", late, "STMT1/1")
+	if err == nil || !strings.HasPrefix(err.Error(), "booking date "+late) {
+		t.Errorf("the payment completed on %s was reconciled to a booking on %s: %v", completed.Format(dateLayout), late, err)
+	}
+
+	l.must(s.ReconcilePayment(l.begin(p.bank, nil), endToEndID, "1000.00", "EUR", completed.Add(3*24*time.Hour).Format(dateLayout), "STMT1/1"))
+}
@@ -0,0 +1,690 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key of bank payments being settled
+const openSettlementIndex = "OpenSettlement"
+
+// object type of the composite key of the settlement exception queue
+const settlementExceptionIndex = "SettlementException"
+
+// object type of the composite key binding an employer to the bank its payments are made from
+const employerBankIndex = "EmployerBank"
+
+// Constants for the statuses of cross-border and local payments. Pending and Approved
+// precede settlement; the others are settlement states recorded hop by hop
+const (
+	BankPaymentPending      = "Pending"                 // awaiting approval
+	BankPaymentApproved     = "Approved"                // approved, awaiting initiation by the debtor bank
+	SettlementInitiated     = "Initiated"               // the debtor bank sent the payment
+	CentralBankApproved     = "CentralBankApproved"     // the central bank of the debtor approved it
+	FxConverted             = "FxConverted"             // the forex bank converted the currency
+	ReceivedByBeneficiaryCB = "ReceivedByBeneficiaryCB" // the central bank of the beneficiary received it
+	CreditedToMemberBank    = "CreditedToMemberBank"    // the beneficiary's member bank received it
+	SettlementCompleted     = "Completed"               // the member bank credited the beneficiary's account
+	SettlementFailed        = "Failed"                  // settlement stopped before the funds reached the beneficiary
+	SettlementReturned      = "Returned"                // the member bank sent the funds back
+)
+
+// hops of a settlement in order, per payment type
+var settlementPaths = map[string][]string{
+	CrossBorder: {SettlementInitiated, CentralBankApproved, FxConverted, ReceivedByBeneficiaryCB, CreditedToMemberBank, SettlementCompleted},
+	Local:       {SettlementInitiated, CreditedToMemberBank, SettlementCompleted},
+}
+
+// MSPs of the banks a payment is settled through. Local payments only go through
+// the debtor and member banks
+type SettlementRoute struct {
+	DebtorBank             string `json:"DebtorBank"`             // MSP of the bank that initiated the settlement
+	CentralBank            string `json:"CentralBank"`            // MSP of the central bank of the debtor
+	FxBank                 string `json:"FxBank"`                 // MSP of the forex bank converting the currency
+	BeneficiaryCentralBank string `json:"BeneficiaryCentralBank"` // MSP of the central bank of the beneficiary
+	MemberBank             string `json:"MemberBank"`             // MSP of the bank holding the beneficiary's account
+}
+
+// a state a settlement reached
+type SettlementHop struct {
+	Status    string    `json:"Status"`    // State reached
+	Bank      string    `json:"Bank"`      // MSP of the bank that recorded the hop
+	Actor     string    `json:"Actor"`     // Name of the bank client that recorded the hop
+	Reference string    `json:"Reference"` // Bank reference of the hop, or why the payment failed or was returned
+	At        time.Time `json:"At"`        // Transaction timestamp of the hop
+}
+
+// settlement progress shared by cross-border and local payments
+type Settlement struct {
+	Type   string          `json:"Type"`   // CrossBorder or Local
+	Status string          `json:"Status"` // Pending, Approved or a settlement state
+	Route  SettlementRoute `json:"Route"`  // Banks the payment is settled through
+	Hops   []SettlementHop `json:"Hops"`   // Settlement states reached, in order
+
+	ApprovedAt time.Time `json:"ApprovedAt"` // Transaction timestamp of the approval
+	Debited    bool      `json:"Debited"`    // Whether the amount left the employee's wallet, to go back on failure or return
+
+	EndToEndID string              `json:"EndToEndID"` // ISO 20022 end-to-end identifier, set when first exported
+	Messages   []SettlementMessage `json:"Messages"`   // ISO 20022 messages the payment was exported in
+
+	Reconciliation PaymentReconciliation `json:"Reconciliation"` // Statement entry the payment was reconciled to, if any
+
+	PrivateDataHash string `json:"PrivateDataHash"` // Salted hash of the amounts held in the ledger collection
+}
+
+// an ISO 20022 message a payment was exported in
+type SettlementMessage struct {
+	MessageID  string    `json:"MessageID"`  // MsgId of the message
+	Type       string    `json:"Type"`       // pain.001 or pacs.008
+	ExportedBy string    `json:"ExportedBy"` // Name of the client that exported the message
+	ExportedAt time.Time `json:"ExportedAt"` // Transaction timestamp of the export
+}
+
+// a settlement that did not reach its next state in time
+type SettlementException struct {
+	PaymentID string    `json:"PaymentID"` // ID of the cross-border or local payment
+	Type      string    `json:"Type"`      // CrossBorder or Local
+	Status    string    `json:"Status"`    // State the settlement is stuck in
+	Since     time.Time `json:"Since"`     // Timestamp of the last hop, or of the approval or settlement date before the first
+	FlaggedAt time.Time `json:"FlaggedAt"` // Transaction timestamp the timeout was detected
+}
+
+// bankPayment is a cross-border or local payment read for settlement
+type bankPayment struct {
+	*Settlement
+	ID         string
+	ContractID string
+	Employee   string
+	Amount     Money
+	record     interface{} // *CrossBorderPayment or *LocalPayment, written back by put
+	collection string      // ledger collection the amounts are kept in
+	salt       string      // salt of the amounts, empty while they are public
+}
+
+// newBankPayment wraps a new cross-border or local payment for settlement, salting its
+// amounts for the ledger collection of its contract
+func newBankPayment(ctx contractapi.TransactionContextInterface, record interface{}) (*bankPayment, error) {
+	var payment *bankPayment
+	switch record := record.(type) {
+	case *CrossBorderPayment:
+		payment = &bankPayment{Settlement: &record.Settlement, ID: record.ID, ContractID: record.ContractID, Employee: record.Employee, Amount: record.Amount, record: record}
+	case *LocalPayment:
+		payment = &bankPayment{Settlement: &record.Settlement, ID: record.ID, ContractID: record.ContractID, Employee: record.Employee, Amount: record.Amount, record: record}
+	default:
+		return nil, fmt.Errorf("%T is not a cross-border or local payment", record)
+	}
+
+	var err error
+	payment.collection, payment.salt, err = ledgerAmountsSalt(ctx, payment.ContractID, payment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// readBankPayment reads a cross-border or local payment together with its private amounts
+func readBankPayment(ctx contractapi.TransactionContextInterface, paymentID string) (*bankPayment, error) {
+	return getBankPayment(ctx, paymentID, false)
+}
+
+// getBankPayment reads a cross-border or local payment. When visibleOnly is set, as in
+// read transactions, the amounts are filled in only if the client may see them
+func getBankPayment(ctx contractapi.TransactionContextInterface, paymentID string, visibleOnly bool) (*bankPayment, error) {
+	payment, err := readBankPaymentRecord(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadBankPaymentAmounts(ctx, payment, visibleOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// readBankPaymentRecord reads a cross-border or local payment as recorded on the world
+// state, without the private amounts, for transactions any org may endorse. Payments
+// recorded before their type was stored are told apart by the prefix of their ID
+func readBankPaymentRecord(ctx contractapi.TransactionContextInterface, paymentID string) (*bankPayment, error) {
+	paymentJSON, err := ctx.GetStub().GetState(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if paymentJSON == nil {
+		return nil, fmt.Errorf("the bank payment %s does not exist", paymentID)
+	}
+
+	var settlement Settlement
+	err = json.Unmarshal(paymentJSON, &settlement)
+	if err != nil {
+		return nil, err
+	}
+	if settlement.Type == "" {
+		switch {
+		case strings.HasPrefix(paymentID, "CROSS_"):
+			settlement.Type = CrossBorder
+		case strings.HasPrefix(paymentID, "LOCAL_"):
+			settlement.Type = Local
+		}
+	}
+
+	switch settlement.Type {
+	case CrossBorder:
+		var payment CrossBorderPayment
+		err = json.Unmarshal(paymentJSON, &payment)
+		if err != nil {
+			return nil, err
+		}
+		payment.Type = CrossBorder
+		return &bankPayment{Settlement: &payment.Settlement, ID: payment.ID, ContractID: payment.ContractID, Employee: payment.Employee, Amount: payment.Amount, record: &payment}, nil
+	case Local:
+		var payment LocalPayment
+		err = json.Unmarshal(paymentJSON, &payment)
+		if err != nil {
+			return nil, err
+		}
+		payment.Type = Local
+		return &bankPayment{Settlement: &payment.Settlement, ID: payment.ID, ContractID: payment.ContractID, Employee: payment.Employee, Amount: payment.Amount, record: &payment}, nil
+	}
+
+	return nil, fmt.Errorf("%s is not a cross-border or local payment", paymentID)
+}
+
+// amounts returns the private details of the amounts of the payment
+func (p *bankPayment) amounts() BankPaymentPrivateDetails {
+	details := BankPaymentPrivateDetails{
+		PaymentID:  p.ID,
+		Amount:     p.Amount,
+		Reconciled: p.Reconciliation.Amount,
+	}
+	if crossBorder, ok := p.record.(*CrossBorderPayment); ok {
+		details.SourceAmount = crossBorder.Conversion.SourceAmount
+		details.TargetAmount = crossBorder.Conversion.TargetAmount
+		details.Fee = crossBorder.Conversion.Fee
+	}
+	return details
+}
+
+// setAmounts copies the amounts of private details onto the payment and its record
+func (p *bankPayment) setAmounts(details BankPaymentPrivateDetails) {
+	p.Amount = details.Amount
+	p.Reconciliation.Amount = details.Reconciled
+	switch record := p.record.(type) {
+	case *CrossBorderPayment:
+		record.Amount = details.Amount
+		record.Conversion.SourceAmount = details.SourceAmount
+		record.Conversion.TargetAmount = details.TargetAmount
+		record.Conversion.Fee = details.Fee
+	case *LocalPayment:
+		record.Amount = details.Amount
+	}
+}
+
+// put writes the payment back to the world state, and its amounts to the ledger
+// collection unless they are still public. The amounts are cleared from the public
+// record while it is written
+func (p *bankPayment) put(ctx contractapi.TransactionContextInterface) error {
+	if p.PrivateDataHash != "" && p.salt == "" {
+		return fmt.Errorf("the amounts of bank payment %s are not available on this peer", p.ID)
+	}
+
+	var amounts BankPaymentPrivateDetails
+	if p.salt != "" {
+		amounts = p.amounts()
+		hash, err := saltedHash(p.salt, amounts)
+		if err != nil {
+			return err
+		}
+
+		details := amounts
+		details.Salt = p.salt
+		err = putPrivateDetails(ctx, p.collection, p.ID, details)
+		if err != nil {
+			return err
+		}
+
+		p.PrivateDataHash = hash
+		p.setAmounts(BankPaymentPrivateDetails{})
+	}
+
+	paymentJSON, err := json.Marshal(p.record)
+	if p.salt != "" {
+		p.setAmounts(amounts)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(p.ID, paymentJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// responsibleBank returns the MSP of the bank that records a settlement state. Any
+// bank of the route may record a failure
+func (s *Settlement) responsibleBank(status string) string {
+	switch status {
+	case SettlementInitiated:
+		return s.Route.DebtorBank
+	case CentralBankApproved:
+		return s.Route.CentralBank
+	case FxConverted:
+		return s.Route.FxBank
+	case ReceivedByBeneficiaryCB:
+		return s.Route.BeneficiaryCentralBank
+	case CreditedToMemberBank, SettlementCompleted, SettlementReturned:
+		return s.Route.MemberBank
+	}
+	return ""
+}
+
+// onRoute reports whether an MSP is one of the banks of the route
+func (r SettlementRoute) onRoute(mspID string) bool {
+	for _, bank := range []string{r.DebtorBank, r.CentralBank, r.FxBank, r.BeneficiaryCentralBank, r.MemberBank} {
+		if bank != "" && bank == mspID {
+			return true
+		}
+	}
+	return false
+}
+
+// canMoveTo reports whether a settlement may move from its status to another. Hops
+// are taken in order; a payment fails before its funds are credited to the member
+// bank, and is returned once they were
+func (s *Settlement) canMoveTo(status string) bool {
+	path := settlementPaths[s.Type]
+	current := -1
+	for i, hop := range path {
+		if hop == s.Status {
+			current = i
+		}
+	}
+	if current < 0 {
+		return false
+	}
+
+	switch status {
+	case SettlementFailed:
+		return s.Status != SettlementCompleted && s.Status != CreditedToMemberBank
+	case SettlementReturned:
+		return s.Status == CreditedToMemberBank || s.Status == SettlementCompleted
+	}
+	return current+1 < len(path) && path[current+1] == status
+}
+
+// putOpenSettlement adds a payment to the open settlement index once it is approved,
+// or takes it out when its settlement ended
+func putOpenSettlement(ctx contractapi.TransactionContextInterface, paymentID string, open bool) error {
+	openKey, err := ctx.GetStub().CreateCompositeKey(openSettlementIndex, []string{paymentID})
+	if err != nil {
+		return fmt.Errorf("failed to create open settlement key: %v", err)
+	}
+
+	if open {
+		err = ctx.GetStub().PutState(openKey, []byte{0x00})
+	} else {
+		err = ctx.GetStub().DelState(openKey)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// approveBankPayment approves a cross-border or local payment for settlement. An
+// approved payment is open, so it is flagged if its settlement is not initiated in time
+func approveBankPayment(ctx contractapi.TransactionContextInterface, payment *bankPayment) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	payment.Status = BankPaymentApproved
+	payment.ApprovedAt = now
+	err = putOpenSettlement(ctx, payment.ID, true)
+	if err != nil {
+		return err
+	}
+
+	return payment.put(ctx)
+}
+
+// employerBank returns the MSP of the bank an employer's payments are made from
+func employerBank(ctx contractapi.TransactionContextInterface, employer string) (string, error) {
+	bankKey, err := ctx.GetStub().CreateCompositeKey(employerBankIndex, []string{employer})
+	if err != nil {
+		return "", fmt.Errorf("failed to create employer bank key: %v", err)
+	}
+
+	bankJSON, err := ctx.GetStub().GetState(bankKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if bankJSON == nil {
+		return "", fmt.Errorf("employer %s has not set the bank its payments are made from", employer)
+	}
+
+	return string(bankJSON), nil
+}
+
+// requireDebtorBank checks that the caller is the bank of the employer owing a payment
+func requireDebtorBank(ctx contractapi.TransactionContextInterface, caller *identity, payment *bankPayment) error {
+	contract, err := readContract(ctx, payment.ContractID)
+	if err != nil {
+		return err
+	}
+
+	bank, err := employerBank(ctx, contract.Employer)
+	if err != nil {
+		return err
+	}
+	if caller.MSPID != bank {
+		return fmt.Errorf("MSP %s is not the bank of employer %s that payment %s is made from", caller.MSPID, contract.Employer, payment.ID)
+	}
+
+	return nil
+}
+
+// SetEmployerBank sets the registered bank an employer's payments are made from. Only
+// that bank approves the employer's local payments and initiates their settlement
+func (s *PaymentContract) SetEmployerBank(ctx contractapi.TransactionContextInterface, employer string, mspID string) error {
+	caller, err := authorize(ctx, "SetEmployerBank")
+	if err != nil {
+		return err
+	}
+
+	err = bindEmployer(ctx, caller, employer)
+	if err != nil {
+		return err
+	}
+
+	registered, err := isBankMSP(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if !registered {
+		return fmt.Errorf("MSP %s is not a registered bank", mspID)
+	}
+
+	bankKey, err := ctx.GetStub().CreateCompositeKey(employerBankIndex, []string{employer})
+	if err != nil {
+		return fmt.Errorf("failed to create employer bank key: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(bankKey, []byte(mspID))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// recordHop moves a settlement to a state, keeps the open settlement index in step
+// and takes the payment off the exception queue
+func recordHop(ctx contractapi.TransactionContextInterface, payment *bankPayment, caller *identity, status string, reference string) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	payment.Status = status
+	payment.Hops = append(payment.Hops, SettlementHop{
+		Status:    status,
+		Bank:      caller.MSPID,
+		Actor:     caller.Name,
+		Reference: reference,
+		At:        now,
+	})
+
+	switch status {
+	case SettlementCompleted, SettlementFailed, SettlementReturned:
+		err = putOpenSettlement(ctx, payment.ID, false)
+	default:
+		err = putOpenSettlement(ctx, payment.ID, true)
+	}
+	if err != nil {
+		return err
+	}
+
+	exceptionKey, err := ctx.GetStub().CreateCompositeKey(settlementExceptionIndex, []string{payment.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create settlement exception key: %v", err)
+	}
+	err = ctx.GetStub().DelState(exceptionKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state. %v", err)
+	}
+
+	return payment.put(ctx)
+}
+
+// InitiateSettlement starts the settlement of an approved cross-border or local
+// payment through a route of registered banks. The calling bank must be the bank of
+// the employer, which becomes the debtor bank
+func (s *PaymentContract) InitiateSettlement(ctx contractapi.TransactionContextInterface, paymentID string, route SettlementRoute, reference string) error {
+	caller, err := authorize(ctx, "InitiateSettlement")
+	if err != nil {
+		return err
+	}
+
+	payment, err := readBankPayment(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+	if payment.Status != BankPaymentApproved {
+		return fmt.Errorf("payment %s is %s, only Approved payments can be settled", paymentID, payment.Status)
+	}
+	err = requireDebtorBank(ctx, caller, payment)
+	if err != nil {
+		return err
+	}
+
+	route.DebtorBank = caller.MSPID
+	required := []string{route.MemberBank}
+	if payment.Type == CrossBorder {
+		required = append(required, route.CentralBank, route.FxBank, route.BeneficiaryCentralBank)
+	} else if route.CentralBank != "" || route.FxBank != "" || route.BeneficiaryCentralBank != "" {
+		return fmt.Errorf("local payment %s is settled without central or forex banks", paymentID)
+	}
+	for _, bank := range required {
+		if bank == "" {
+			return fmt.Errorf("the route of %s payment %s is missing a bank", payment.Type, paymentID)
+		}
+		registered, err := isBankMSP(ctx, bank)
+		if err != nil {
+			return err
+		}
+		if !registered {
+			return fmt.Errorf("MSP %s is not a registered bank", bank)
+		}
+	}
+	payment.Route = route
+
+	err = removeApprovedLocal(ctx, payment)
+	if err != nil {
+		return err
+	}
+
+	return recordHop(ctx, payment, caller, SettlementInitiated, reference)
+}
+
+// AdvanceSettlement records the next settlement state of a cross-border or local
+// payment, or its failure or return, with the bank's reference. Each state can only
+// be recorded by the bank of the route responsible for it
+func (s *PaymentContract) AdvanceSettlement(ctx contractapi.TransactionContextInterface, paymentID string, status string, reference string) error {
+	caller, err := authorize(ctx, "AdvanceSettlement")
+	if err != nil {
+		return err
+	}
+
+	payment, err := readBankPayment(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+	if !payment.canMoveTo(status) {
+		return fmt.Errorf("%s payment %s cannot move from %s to %s", payment.Type, paymentID, payment.Status, status)
+	}
+
+	if status == SettlementFailed {
+		if !payment.Route.onRoute(caller.MSPID) {
+			return fmt.Errorf("MSP %s is not on the route of payment %s", caller.MSPID, paymentID)
+		}
+		if reference == "" {
+			return fmt.Errorf("a reason is required to fail payment %s", paymentID)
+		}
+	} else if bank := payment.responsibleBank(status); caller.MSPID != bank {
+		return fmt.Errorf("only MSP %s can record %s for payment %s", bank, status, paymentID)
+	}
+
+	// the conversion is settled at the rate locked by the payment's FX quote
+	if status == FxConverted {
+		err = convertPayment(ctx, payment.record.(*CrossBorderPayment))
+		if err != nil {
+			return err
+		}
+	}
+
+	// the funds of a payment that did not reach the beneficiary go back to the wallet
+	if (status == SettlementFailed || status == SettlementReturned) && payment.Debited {
+		err = post(ctx, payment.ContractID, payment.Employee, recordID(ctx, "REFUND", payment.ID), postingLeg{SettlementClearing, EmployeeWallet, payment.Amount})
+		if err != nil {
+			return err
+		}
+	}
+
+	return recordHop(ctx, payment, caller, status, reference)
+}
+
+// FlagStuckSettlements puts the settlements that stayed in a state longer than the
+// settlement timeout of their contract on the exception queue, and returns the ones
+// newly flagged. An approved payment is stuck if its settlement is not initiated in
+// time after its approval, or after the settlement date of a local payment. It is
+// meant to be submitted periodically by a bank or the admin
+func (s *PaymentContract) FlagStuckSettlements(ctx contractapi.TransactionContextInterface) ([]*SettlementException, error) {
+	_, err := authorize(ctx, "FlagStuckSettlements")
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(openSettlementIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var flagged []*SettlementException
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		payment, err := readBankPaymentRecord(ctx, keyParts[0])
+		if err != nil {
+			return nil, err
+		}
+		since := payment.ApprovedAt
+		if len(payment.Hops) > 0 {
+			since = payment.Hops[len(payment.Hops)-1].At
+		} else if local, ok := payment.record.(*LocalPayment); ok && local.SettlementDate.After(since) {
+			since = local.SettlementDate
+		}
+		if since.IsZero() {
+			continue
+		}
+
+		exceptionKey, err := ctx.GetStub().CreateCompositeKey(settlementExceptionIndex, []string{payment.ID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create settlement exception key: %v", err)
+		}
+		existing, err := ctx.GetStub().GetState(exceptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if existing != nil {
+			continue
+		}
+
+		contract, err := readContract(ctx, payment.ContractID)
+		if err != nil {
+			return nil, err
+		}
+		policy, err := effectivePolicy(ctx, contract.Employer, contract.ID)
+		if err != nil {
+			return nil, err
+		}
+		if now.Before(since.Add(time.Duration(policy.SettlementTimeoutHours) * time.Hour)) {
+			continue
+		}
+
+		exception := SettlementException{
+			PaymentID: payment.ID,
+			Type:      payment.Type,
+			Status:    payment.Status,
+			Since:     since,
+			FlaggedAt: now,
+		}
+		exceptionJSON, err := json.Marshal(exception)
+		if err != nil {
+			return nil, err
+		}
+		err = ctx.GetStub().PutState(exceptionKey, exceptionJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to put to world state. %v", err)
+		}
+		flagged = append(flagged, &exception)
+	}
+
+	return flagged, nil
+}
+
+// GetSettlementExceptions returns the settlements on the exception queue. A payment
+// leaves the queue when its settlement moves on
+func (s *PaymentContract) GetSettlementExceptions(ctx contractapi.TransactionContextInterface) ([]*SettlementException, error) {
+	_, err := authorize(ctx, "GetSettlementExceptions")
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(settlementExceptionIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var exceptions []*SettlementException
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var exception SettlementException
+		err = json.Unmarshal(queryResponse.Value, &exception)
+		if err != nil {
+			return nil, err
+		}
+		exceptions = append(exceptions, &exception)
+	}
+
+	return exceptions, nil
+}
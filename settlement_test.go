@@ -0,0 +1,140 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Settlement states are recorded in order, each by the bank of the route responsible
+// for it, and only the bank of the employer approves and initiates the payment
+func TestSettlementIsAdvancedByTheResponsibleBank(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+	fundWallet(l, s, p, "C1")
+
+	other := newTestClient(t, "other", "OtherBankMSP", RoleBank)
+	l.must(s.RegisterBankMSP(l.begin(p.admin, nil), other.mspID))
+
+	paymentID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", Local)
+	l.must(err)
+	if err := s.ApproveCrossBorderPayment(l.begin(p.bank, nil), paymentID); err == nil {
+		t.Error("a local payment was approved as a cross-border payment")
+	}
+	if err := s.ProcessLocalPayment(l.begin(other, nil), paymentID, l.clock.Format(dateLayout)); err == nil {
+		t.Error("a bank other than the employer's approved the payment")
+	}
+	l.must(s.ProcessLocalPayment(l.begin(p.bank, nil), paymentID, l.clock.Format(dateLayout)))
+
+	route := SettlementRoute{MemberBank: other.mspID}
+	if err := s.InitiateSettlement(l.begin(other, nil), paymentID, route, "SENT1"); err == nil {
+		t.Error("a bank other than the employer's initiated the settlement")
+	}
+	l.must(s.InitiateSettlement(l.begin(p.bank, nil), paymentID, route, "SENT1"))
+
+	for _, step := range []struct {
+		bank   *testClient
+		status string
+		ok     bool
+	}{
+		{p.bank, CreditedToMemberBank, false},
+		{other, SettlementCompleted, false},
+		{other, CreditedToMemberBank, true},
+		{other, SettlementFailed, false},
+		{other, SettlementCompleted, true},
+		{other, SettlementCompleted, false},
+	} {
+		err := s.AdvanceSettlement(l.begin(step.bank, nil), paymentID, step.status, "REF")
+		if (err == nil) != step.ok {
+			t.Errorf("recording %s by %s returned %v", step.status, step.bank.mspID, err)
+		}
+	}
+
+	payment, err := readBankPayment(l.begin(p.employer, nil), paymentID)
+	l.must(err)
+	var statuses []string
+	for _, hop := range payment.Hops {
+		statuses = append(statuses, hop.Status)
+	}
+	if len(statuses) != 3 || statuses[2] != SettlementCompleted || payment.Hops[2].Bank != other.mspID {
+		t.Errorf("the settlement went through %v", payment.Hops)
+	}
+}
+
+// A bank payment leaves the wallet when it is made and goes back to it when the
+// payment fails
+func TestFailedBankPaymentIsPutBackInTheWallet(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+	fundWallet(l, s, p, "C1")
+
+	available := func() Money {
+		l.t.Helper()
+		balance, err := s.GetBalance(l.begin(p.employer, nil), "C1", p.employee.name)
+		l.must(err)
+		return balance.Available
+	}
+	funded := available()
+
+	if _, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "100000.00", Local); err == nil {
+		t.Error("a bank payment was made of more than the wallet holds")
+	}
+	paymentID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", Local)
+	l.must(err)
+	if got, _ := funded.Sub(available()); got.String() != "1000.00 EUR" {
+		t.Errorf("the payment took %s from the wallet", got)
+	}
+	if _, err := s.WithdrawPayment(l.begin(p.employee, nil), "", "C1", p.employee.name, strings.TrimSuffix(funded.String(), " EUR")); err == nil {
+		t.Error("the funds of the bank payment were withdrawn again")
+	}
+
+	l.must(s.ProcessLocalPayment(l.begin(p.bank, nil), paymentID, l.clock.Format(dateLayout)))
+	l.must(s.InitiateSettlement(l.begin(p.bank, nil), paymentID, SettlementRoute{MemberBank: p.bank.mspID}, "SENT1"))
+	l.must(s.AdvanceSettlement(l.begin(p.bank, nil), paymentID, SettlementFailed, "account closed"))
+	if got := available(); got != funded {
+		t.Errorf("the wallet holds %s after the payment failed, want %s", got, funded)
+	}
+}
+
+// A payment left Approved is flagged once the timeout passes after its settlement date
+func TestApprovedPaymentsThatAreNotInitiatedAreFlagged(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+	fundWallet(l, s, p, "C1")
+
+	paymentID, err := s.ProcessBankPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", Local)
+	l.must(err)
+	settlementDate := startOfDay(l.clock.AddDate(0, 0, 2))
+	l.must(s.ProcessLocalPayment(l.begin(p.bank, nil), paymentID, settlementDate.Format(dateLayout)))
+
+	// the payment only waits for its settlement date; every transaction moves the clock an hour on
+	l.clock = settlementDate.Add(46 * time.Hour)
+	flagged, err := s.FlagStuckSettlements(l.begin(p.bank, nil))
+	l.must(err)
+	if len(flagged) != 0 {
+		t.Errorf("the payment was flagged before the timeout: %+v", flagged[0])
+	}
+
+	flagged, err = s.FlagStuckSettlements(l.begin(p.bank, nil))
+	l.must(err)
+	if len(flagged) != 1 || flagged[0].PaymentID != paymentID || flagged[0].Status != BankPaymentApproved || !flagged[0].Since.Equal(settlementDate) {
+		t.Errorf("flagged %+v", flagged)
+	}
+
+	// initiating the settlement takes it off the queue
+	l.must(s.InitiateSettlement(l.begin(p.bank, nil), paymentID, SettlementRoute{MemberBank: p.bank.mspID}, "SENT1"))
+	exceptions, err := s.GetSettlementExceptions(l.begin(p.bank, nil))
+	l.must(err)
+	if len(exceptions) != 0 {
+		t.Errorf("the exception queue holds %+v", exceptions)
+	}
+}
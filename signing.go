@@ -0,0 +1,397 @@
+package chaincode
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object type of the composite key storing contract signatures
+const signatureIndex = "ContractSignature"
+
+// Constants for the signing parties of a contract
+const (
+	PartyEmployer = "Employer"
+	PartyEmployee = "Employee"
+)
+
+// evidence that a party signed a hash of the contract terms
+type ContractSignature struct {
+	ContractID  string    `json:"ContractID"`  // ID of the signed contract
+	TermsHash   string    `json:"TermsHash"`   // Hex SHA-256 hash of the signed terms
+	Party       string    `json:"Party"`       // Signing party (Employer or Employee)
+	Signer      string    `json:"Signer"`      // Name of the signer
+	MSPID       string    `json:"MSPID"`       // MSP of the signer's certificate
+	Signature   string    `json:"Signature"`   // Base64 signature over the raw terms hash
+	Certificate string    `json:"Certificate"` // PEM certificate the signature was verified with
+	TxID        string    `json:"TxID"`        // Transaction that recorded the signature
+	SignedAt    time.Time `json:"SignedAt"`    // Transaction timestamp of the signature
+}
+
+// newContractTerms parses the negotiable terms of a contract from transaction arguments
+func newContractTerms(position string, salary string, variablePay string, currency string, account string, schedule PaySchedule) (ContractTerms, error) {
+	salaryAmount, err := parseAmount(salary, currency)
+	if err != nil {
+		return ContractTerms{}, fmt.Errorf("invalid salary: %v", err)
+	}
+	variablePayAmount, err := ParseMoney(variablePay, currency, RoundExact)
+	if err != nil {
+		return ContractTerms{}, fmt.Errorf("invalid variable pay: %v", err)
+	}
+	if variablePayAmount.IsNegative() {
+		return ContractTerms{}, fmt.Errorf("variable pay %s must not be negative", variablePayAmount)
+	}
+	schedule = schedule.withDefaults()
+	if err := schedule.validate(); err != nil {
+		return ContractTerms{}, err
+	}
+
+	return ContractTerms{
+		Position:    position,
+		Salary:      salaryAmount,
+		VariablePay: variablePayAmount,
+		Currency:    currency,
+		AccountID:   account,
+		PaySchedule: schedule,
+	}, nil
+}
+
+// hashTerms returns the hex SHA-256 hash of the parties and terms of a contract,
+// which both parties sign. The salt of the private pay is hashed along with the terms
+// so that the public hash does not reveal the pay
+func hashTerms(contractID string, employer string, employee string, terms ContractTerms, salt string) (string, error) {
+	termsJSON, err := json.Marshal(struct {
+		ID       string        `json:"ID"`
+		Employer string        `json:"Employer"`
+		Employee string        `json:"Employee"`
+		Terms    ContractTerms `json:"Terms"`
+		Salt     string        `json:"Salt,omitempty"`
+	}{contractID, employer, employee, terms, salt})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(termsJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// HashContractTerms returns the hash a party must sign to propose, counter or accept
+// the given terms. Salary, variable pay and salt are read from the transient map
+func (s *PaymentContract) HashContractTerms(ctx contractapi.TransactionContextInterface, contractID string, employer string, employee string, position string, currency string, account string, schedule PaySchedule) (string, error) {
+	_, err := authorize(ctx, "HashContractTerms")
+	if err != nil {
+		return "", err
+	}
+
+	pay, err := readTransientPay(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	terms, err := newContractTerms(position, pay.Salary, pay.VariablePay, currency, account, schedule)
+	if err != nil {
+		return "", err
+	}
+
+	return hashTerms(contractID, employer, employee, terms, pay.Salt)
+}
+
+// AcceptContract records the caller's signature over the current terms of a contract
+// pending signature, activating it once both parties have signed
+func (s *PaymentContract) AcceptContract(ctx contractapi.TransactionContextInterface, contractID string, signature string) error {
+	caller, err := authorize(ctx, "AcceptContract")
+	if err != nil {
+		return err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return err
+	}
+	if contract.Status != StatusPendingSignature {
+		return fmt.Errorf("contract %s is %s, not %s", contractID, contract.Status, StatusPendingSignature)
+	}
+
+	party, err := contractParty(caller, contract)
+	if err != nil {
+		return err
+	}
+
+	err = signContract(ctx, caller, contract, party, signature)
+	if err != nil {
+		return err
+	}
+
+	// activate once the other party has signed the same terms
+	otherParty := PartyEmployer
+	if party == PartyEmployer {
+		otherParty = PartyEmployee
+	}
+	signed, err := hasSigned(ctx, contract, otherParty)
+	if err != nil {
+		return err
+	}
+	if !signed {
+		return nil
+	}
+
+	// the signed terms are the first version, effective from the day of activation
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	err = loadContractPay(ctx, contract)
+	if err != nil {
+		return err
+	}
+	contract.TermsVersions = []TermsVersion{{
+		Version:       1,
+		ContractTerms: contract.ContractTerms,
+		EffectiveFrom: startOfDay(now),
+		Reason:        "Signed terms",
+		AmendedBy:     contract.ProposedBy,
+		RecordedAt:    now,
+		TermsHash:     contract.TermsHash,
+	}}
+	if contract.PrivateDataHash != "" {
+		err = putContractPay(ctx, contract, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return transitionContract(ctx, contract, StatusActive, "Signed by both parties", "", caller.Name)
+}
+
+// CounterContract replaces the terms of a contract pending signature with a counter
+// proposal signed by the caller. The other party then has to accept the new terms.
+// The employer proposes a withdrawn Draft contract again the same way. Salary,
+// variable pay and salt are read from the transient map
+func (s *PaymentContract) CounterContract(ctx contractapi.TransactionContextInterface, contractID string, position string, currency string, account string, schedule PaySchedule, signature string) error {
+	caller, err := authorize(ctx, "CounterContract")
+	if err != nil {
+		return err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return err
+	}
+	if contract.Status != StatusPendingSignature && contract.Status != StatusDraft {
+		return fmt.Errorf("contract %s is %s, not %s", contractID, contract.Status, StatusPendingSignature)
+	}
+
+	party, err := contractParty(caller, contract)
+	if err != nil {
+		return err
+	}
+	if contract.Status == StatusDraft && party != PartyEmployer {
+		return fmt.Errorf("only the employer %s can propose draft contract %s", contract.Employer, contractID)
+	}
+
+	pay, err := readTransientPay(ctx)
+	if err != nil {
+		return err
+	}
+
+	terms, err := newContractTerms(position, pay.Salary, pay.VariablePay, currency, account, schedule)
+	if err != nil {
+		return err
+	}
+
+	policy, err := effectivePolicy(ctx, contract.Employer, contract.ID)
+	if err != nil {
+		return err
+	}
+	err = policy.requireCurrency(terms.Currency)
+	if err != nil {
+		return err
+	}
+
+	err = validateEmployeeAccount(ctx, account, contract)
+	if err != nil {
+		return err
+	}
+	if account != contract.AccountID {
+		err = unlinkAccount(ctx, contract.AccountID, contract.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	contract.ContractTerms = terms
+	contract.TermsHash, err = hashTerms(contract.ID, contract.Employer, contract.Employee, contract.ContractTerms, pay.Salt)
+	if err != nil {
+		return err
+	}
+	contract.ProposedBy = caller.Name
+
+	err = putContractPay(ctx, contract, pay.Salt)
+	if err != nil {
+		return err
+	}
+
+	err = signContract(ctx, caller, contract, party, signature)
+	if err != nil {
+		return err
+	}
+
+	if contract.Status == StatusDraft {
+		return transitionContract(ctx, contract, StatusPendingSignature, "Proposed", "", caller.Name)
+	}
+	return putContract(ctx, contract)
+}
+
+// GetContractSignatures returns every signature recorded on a contract, including
+// those over terms that were later countered
+func (s *PaymentContract) GetContractSignatures(ctx contractapi.TransactionContextInterface, contractID string) ([]*ContractSignature, error) {
+	err := authorizeRead(ctx, "GetContractSignatures", contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(signatureIndex, []string{contractID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var signatures []*ContractSignature
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var signature ContractSignature
+		err = json.Unmarshal(queryResponse.Value, &signature)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, &signature)
+	}
+
+	return signatures, nil
+}
+
+// contractParty returns which party of the contract the caller is
+func contractParty(caller *identity, contract *Contract) (string, error) {
+	switch {
+	case caller.isEmployerOf(contract):
+		return PartyEmployer, nil
+	case caller.isEmployeeOf(contract):
+		return PartyEmployee, nil
+	}
+
+	return "", fmt.Errorf("%s is not a party to contract %s", caller.Name, contract.ID)
+}
+
+// signContract verifies the caller's signature over the contract's terms hash with
+// the public key of their client certificate and records it as evidence
+func signContract(ctx contractapi.TransactionContextInterface, caller *identity, contract *Contract, party string, signature string) error {
+	return signTerms(ctx, caller, contract.ID, contract.TermsHash, party, signature)
+}
+
+// signTerms verifies the caller's signature over a terms hash of a contract, either
+// its proposed terms or an amendment, and records it as evidence
+func signTerms(ctx contractapi.TransactionContextInterface, caller *identity, contractID string, termsHash string, party string, signature string) error {
+	hash, err := hex.DecodeString(termsHash)
+	if err != nil {
+		return fmt.Errorf("invalid terms hash: %v", err)
+	}
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("signature must be base64 encoded: %v", err)
+	}
+
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("failed to read client certificate: %v", err)
+	}
+
+	err = verifySignature(cert, hash, signatureBytes)
+	if err != nil {
+		return fmt.Errorf("signature of %s over terms %s is invalid: %v", caller.Name, termsHash, err)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	evidence := ContractSignature{
+		ContractID:  contractID,
+		TermsHash:   termsHash,
+		Party:       party,
+		Signer:      caller.Name,
+		MSPID:       caller.MSPID,
+		Signature:   signature,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		TxID:        ctx.GetStub().GetTxID(),
+		SignedAt:    now,
+	}
+
+	signatureKey, err := ctx.GetStub().CreateCompositeKey(signatureIndex, []string{contractID, termsHash, party})
+	if err != nil {
+		return fmt.Errorf("failed to create signature key: %v", err)
+	}
+
+	evidenceJSON, err := json.Marshal(evidence)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(signatureKey, evidenceJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// hasSigned reports whether a party signed the current terms of a contract
+func hasSigned(ctx contractapi.TransactionContextInterface, contract *Contract, party string) (bool, error) {
+	signatureKey, err := ctx.GetStub().CreateCompositeKey(signatureIndex, []string{contract.ID, contract.TermsHash, party})
+	if err != nil {
+		return false, fmt.Errorf("failed to create signature key: %v", err)
+	}
+
+	signatureJSON, err := ctx.GetStub().GetState(signatureKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	return signatureJSON != nil, nil
+}
+
+// verifySignature checks a signature over a SHA-256 digest against the public key of a certificate
+func verifySignature(cert *x509.Certificate, digest []byte, signature []byte) error {
+	switch publicKey := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(publicKey, digest, signature) {
+			return fmt.Errorf("ecdsa signature verification failed")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest, signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(publicKey, digest, signature) {
+			return fmt.Errorf("ed25519 signature verification failed")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported public key type %T", cert.PublicKey)
+}
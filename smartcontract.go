@@ -3,6 +3,7 @@ package chaincode
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -17,49 +18,106 @@ type SmartContract struct {
 
 // details of the payment contract
 type Contract struct {
-	ID          string  `json:"ID"`          // Unique identifier for the contract
-	Employer    string  `json:"Employer"`    // Name of the employer
-	Employee    string  `json:"Employee"`    // Name of the employee
-	Position    string  `json:"Position"`    // Position of the employee
-	Salary      float64 `json:"Salary"`      // Annual salary of the employee
-	VariablePay float64 `json:"VariablePay"` // Variable pay for the employee
-	Currency    string  `json:"Currency"`    // Preferred currency for payment
-	AccountID   string  `json:"Account"`     // Employee's bank account details
-	Status      string  `json:"Status"`      // Status of the contract (active, revoked, etc.)
+	ID       string `json:"ID"`       // Unique identifier for the contract
+	Employer string `json:"Employer"` // Name of the employer
+	Employee string `json:"Employee"` // Name of the employee
+
+	EmployerIdentity PartyIdentity `json:"EmployerIdentity"` // MSP and client identity of the employer that proposed the contract
+	EmployeeIdentity PartyIdentity `json:"EmployeeIdentity"` // MSP and client identity of the employee, the owner of the account paid
+	ContractTerms
+	TermsVersions   []TermsVersion `json:"TermsVersions"`   // Signed terms and every amendment with its effective date
+	TermsVersion    int            `json:"TermsVersion"`    // Version of the current terms
+	TermsHash       string         `json:"TermsHash"`       // Hash of the terms both parties sign
+	PrivateDataHash string         `json:"PrivateDataHash"` // Salted hash of the pay held in the contract collection
+	ProposedBy      string         `json:"ProposedBy"`      // Name of the party that proposed the current terms
+	Status          string         `json:"Status"`          // Status of the contract (Draft, Active, Suspended, etc.)
+	StatusHistory   []StatusChange `json:"StatusHistory"`   // Every status change with its reason and effective date
+}
+
+// negotiable terms of a payment contract. On a Contract these are the terms in effect
+// when it was last written, see TermsVersions for the terms effective on a given date. Salary and variable pay
+// are kept in the contract collection and are empty on public state
+type ContractTerms struct {
+	Position    string `json:"Position"`    // Position of the employee
+	Salary      Money  `json:"Salary"`      // Salary of the employee per unit of the pay basis
+	VariablePay Money  `json:"VariablePay"` // Annual variable pay for the employee
+	Currency    string `json:"Currency"`    // Preferred currency for payment
+	AccountID   string `json:"Account"`     // ID of the employee's Account the pay goes to
+	PaySchedule
 }
 
 //details of a user account
 type Account struct {
-	AccountID         string `json:"AccountID"`         // Unique identifier for the account
-	Company           string `json:"Company"`           // Company name
-	TaxComplianceInfo string `json:"TaxComplianceInfo"` // Tax compliance information
-	FinancialInfo     string `json:"FinancialInfo"`     // Confidential financial information
-	PreferredCurrency string `json:"PreferredCurrency"` // Preferred currency for payment
-	BankAccount       string `json:"BankAccount"`       // Bank account details
-	ContractID        string `json:"ContractID"`        // ID of the associated contract
-	ContractStatus    string `json:"ContractStatus"`    // Status of the associated contract
+	AccountID         string        `json:"AccountID"`         // Unique identifier for the account
+	Owner             string        `json:"Owner"`             // Name of the party that owns the account
+	OwnerIdentity     PartyIdentity `json:"OwnerIdentity"`     // MSP and client identity of the owner
+	Status            string        `json:"Status"`            // Status of the account (Open, Closed)
+	Company           string        `json:"Company"`           // Company name
+	TaxComplianceInfo string        `json:"TaxComplianceInfo"` // Tax compliance information (private)
+	FinancialInfo     string        `json:"FinancialInfo"`     // Confidential financial information (private)
+	TaxJurisdiction   string        `json:"TaxJurisdiction"`   // Jurisdiction whose tax rules apply to pay into the account
+	PreferredCurrency string        `json:"PreferredCurrency"` // Preferred currency for payment
+	BankAccount       string        `json:"BankAccount"`       // Bank account details (private)
+	BankBIC           string        `json:"BankBIC"`           // BIC of the bank holding the account (private)
+	RoutingNumber     string        `json:"RoutingNumber"`     // ABA routing number of a US bank account (private)
+	ContractID        string        `json:"ContractID"`        // ID of the associated contract
+	ContractStatus    string        `json:"ContractStatus"`    // Status of the associated contract
+	PrivateDataHash   string        `json:"PrivateDataHash"`   // Salted hash of the details held in the account collection
 }
 
 //details of an advance payment request
 type AdvanceRequest struct {
-	ID         string  `json:"ID"`
-	ContractID string  `json:"ContractID"`
-	Employee   string  `json:"Employee"`
-	Amount     float64 `json:"Amount"`
-	Status     string  `json:"Status"`
+	ID                 string             `json:"ID"`
+	ContractID         string             `json:"ContractID"`
+	Employee           string             `json:"Employee"`
+	Amount             Money              `json:"Amount"`
+	Status             string             `json:"Status"`
+	ApprovedBy         string             `json:"ApprovedBy"`
+	RequestedAt        time.Time          `json:"RequestedAt"`        // Transaction timestamp of the request
+	ClosedBy           string             `json:"ClosedBy"`           // Name of the party that rejected or cancelled the request
+	Reason             string             `json:"Reason"`             // Why the request was rejected or cancelled
+	ClosedAt           time.Time          `json:"ClosedAt"`           // Transaction timestamp of the rejection or cancellation
+	Approvals          []Approval         `json:"Approvals"`          // Signatures collected under the employer's approval policy
+	ApprovedAt         time.Time          `json:"ApprovedAt"`         // Transaction timestamp of the approval
+	Installments       int                `json:"Installments"`       // Number of regular payments the advance is recovered from
+	Fee                Money              `json:"Fee"`                // Flat fee charged on the advance
+	InterestRate       int64              `json:"InterestRate"`       // Annual interest in basis points charged over the installments
+	Charges            Money              `json:"Charges"`            // Fee and interest owed on top of the amount
+	Installment        Money              `json:"Installment"`        // Amount recovered from each regular payment
+	Outstanding        Money              `json:"Outstanding"`        // Amount and charges not yet repaid
+	ChargesOutstanding Money              `json:"ChargesOutstanding"` // Part of Outstanding that is fee and interest
+	Repayments         []AdvanceRepayment `json:"Repayments"`         // Every repayment and write-off of the advance
+
+	PrivateDataHash string `json:"PrivateDataHash"` // Salted hash of the amounts held in the contract collection
+	salt            string // salt of the amounts, once written or read from the contract collection
 }
 
 // payment transaction
 type Payment struct {
-	ID         string    `json:"ID"`
-	ContractID string    `json:"ContractID"`
-	Employee   string    `json:"Employee"`
-	Amount     float64   `json:"Amount"`
-	Date       time.Time `json:"Date"`
-	Type       string    `json:"Type"`
+	ID           string            `json:"ID"`
+	ContractID   string            `json:"ContractID"`
+	Employee     string            `json:"Employee"`
+	Amount       Money             `json:"Amount"` // Gross amount of the payment
+	Date         time.Time         `json:"Date"`
+	Type         string            `json:"Type"`
+	PayrollRunID string            `json:"PayrollRunID"` // Payroll run that made the payment, if any
+	PeriodStart  time.Time         `json:"PeriodStart"`  // First day of the pay period of regular pay
+	PeriodEnd    time.Time         `json:"PeriodEnd"`    // Last day of the pay period of regular pay
+	TermsVersion int               `json:"TermsVersion"` // Version of the contract terms regular pay was paid on
+	Position     string            `json:"Position"`     // Position of the employee under those terms, as on the payslip
+	Components   []PayComponent    `json:"Components"`   // Breakdown of the gross amount of regular pay
+	Deductions   []DeductionLine   `json:"Deductions"`   // Tax and contributions withheld from regular pay
+	Net          Money             `json:"Net"`          // Net pay after tax and contributions
+	Recoveries   []AdvanceRecovery `json:"Recoveries"`   // Advance installments kept from the net pay
+	Recovered    Money             `json:"Recovered"`    // Total of the recoveries; the employee is credited Net less Recovered
+	Adjusts      string            `json:"Adjusts"`      // Payment a reversal or correction compensates
+	AdjustmentID string            `json:"AdjustmentID"` // Adjustment that created a reversal or correction
+
+	PrivateDataHash string `json:"PrivateDataHash"` // Salted hash of the amounts held in the contract collection
+	salt            string // salt of the amounts, once written or read from the contract collection
 }
 
-// the interval for payroll payments
+// the interval for payroll payments. Both dates are inclusive
 type PayrollInterval struct {
 	StartDate time.Time `json:"StartDate"`
 	EndDate   time.Time `json:"EndDate"`
@@ -67,20 +125,29 @@ type PayrollInterval struct {
 
 // cross-border payment transaction
 type CrossBorderPayment struct {
-	ID         string  `json:"ID"`
-	ContractID string  `json:"ContractID"`
-	Employee   string  `json:"Employee"`
-	Amount     float64 `json:"Amount"`
-	Status     string  `json:"Status"`
+	ID         string `json:"ID"`
+	ContractID string `json:"ContractID"`
+	Employee   string `json:"Employee"`
+	Amount     Money  `json:"Amount"`
+	Settlement
+	InitiatedBy       string        `json:"InitiatedBy"`       // Name of the party that initiated the payment
+	InitiatorIdentity PartyIdentity `json:"InitiatorIdentity"` // MSP and client identity of the party that initiated the payment
+	Approvals         []Approval    `json:"Approvals"`         // Signatures collected under the employer's approval policy
+	QuoteID           string        `json:"QuoteID"`           // FX quote locked for the conversion
+	Conversion        FxConversion  `json:"Conversion"`        // Conversion recorded when the payment reached FxConverted
 }
 
 // local payment transaction
 type LocalPayment struct {
-	ID         string  `json:"ID"`
-	ContractID string  `json:"ContractID"`
-	Employee   string  `json:"Employee"`
-	Amount     float64 `json:"Amount"`
-	Status     string  `json:"Status"`
+	ID         string `json:"ID"`
+	ContractID string `json:"ContractID"`
+	Employee   string `json:"Employee"`
+	Amount     Money  `json:"Amount"`
+	Settlement
+
+	SettlementDate time.Time `json:"SettlementDate"` // Date the payment settles, set on approval
+	FileID         string    `json:"FileID"`         // ID of the batch file the payment was included in
+	IndividualID   string    `json:"IndividualID"`   // NACHA individual identification number of its entry, indexed like an end-to-end ID
 }
 
 // Constants for payment types
@@ -91,16 +158,79 @@ const (
 
 // Constants for payment types
 const (
-	RegularPayment = "Regular"
-	AdvancePayment = "Advance"
+	RegularPayment    = "Regular"
+	AdvancePayment    = "Advance"
+	WithdrawalPayment = "Withdrawal"
+	ReversalPayment   = "Reversal"
+	CorrectionPayment = "Correction"
 )
 
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-	contracts := []Asset{}
-
 	return nil
 }
 
+// txTime returns the transaction timestamp proposed by the client. Every endorsing
+// peer sees the same value, unlike time.Now(), so it is safe to write to the ledger.
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+
+	return time.Unix(ts.GetSeconds(), int64(ts.GetNanos())).UTC(), nil
+}
+
+// layout of dates passed as transaction arguments
+const dateLayout = "2006-01-02"
+
+// parseDate parses a transaction argument given either as a date ("2006-01-02") or as an RFC 3339 timestamp
+func parseDate(value string) (time.Time, error) {
+	date, err := time.Parse(dateLayout, value)
+	if err == nil {
+		return date, nil
+	}
+
+	date, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or RFC 3339", value)
+	}
+
+	return date.UTC(), nil
+}
+
+// recordID builds the key of a record created by the current transaction from the
+// given prefix and parts, suffixed with the transaction ID so that it is identical
+// on every endorsing peer
+func recordID(ctx contractapi.TransactionContextInterface, prefix string, parts ...string) string {
+	fields := append([]string{prefix}, parts...)
+	fields = append(fields, ctx.GetStub().GetTxID())
+	return strings.Join(fields, "_")
+}
+
+// parseAmount parses a positive transaction amount in the given currency, rejecting
+// more decimal places than the currency has
+func parseAmount(amount string, currency string) (Money, error) {
+	money, err := ParseMoney(amount, currency, RoundExact)
+	if err != nil {
+		return Money{}, err
+	}
+	if !money.IsPositive() {
+		return Money{}, fmt.Errorf("amount %s must be positive", money)
+	}
+
+	return money, nil
+}
+
+// exceedsLimit reports whether amount is greater than limit
+func exceedsLimit(amount Money, limit Money) (bool, error) {
+	cmp, err := amount.Cmp(limit)
+	if err != nil {
+		return false, err
+	}
+
+	return cmp > 0, nil
+}
+
 // if a contract with the given ID exists
 func (s *PaymentContract) ContractExists(ctx contractapi.TransactionContextInterface, contractID string) (bool, error) {
 	contractJSON, err := ctx.GetStub().GetState(contractID)
@@ -111,8 +241,22 @@ func (s *PaymentContract) ContractExists(ctx contractapi.TransactionContextInter
 	return contractJSON != nil, nil
 }
 
-// CreateContract creates a new payment contract between an employer and an employee
-func (s *PaymentContract) CreateContract(ctx contractapi.TransactionContextInterface, contractID string, employer string, employee string, position string, salary float64, variablePay float64, currency string, account string ) error {
+// ProposeContract proposes a new payment contract between the calling employer and an
+// employee. Salary and variable pay are submitted in the transient map as decimal strings
+// in the contract currency, e.g. "85000.00", with a salt, and are kept in the contract
+// collection. The schedule sets how the salary is quoted and paid. Signature is the
+// employer's base64 signature over the hash returned by HashContractTerms. The contract
+// stays PendingSignature until the employee accepts it
+func (s *PaymentContract) ProposeContract(ctx contractapi.TransactionContextInterface, contractID string, employer string, employee string, position string, currency string, account string, schedule PaySchedule, signature string) error {
+	caller, err := authorize(ctx, "ProposeContract")
+	if err != nil {
+		return err
+	}
+	err = bindEmployer(ctx, caller, employer)
+	if err != nil {
+		return err
+	}
+
 	exists, err := s.ContractExists(ctx, contractID)
 	if err != nil {
 		return err
@@ -121,44 +265,132 @@ func (s *PaymentContract) CreateContract(ctx contractapi.TransactionContextInter
 		return fmt.Errorf("the contract %s already exists", contractID)
 	}
 
+	pay, err := readTransientPay(ctx)
+	if err != nil {
+		return err
+	}
+
+	terms, err := newContractTerms(position, pay.Salary, pay.VariablePay, currency, account, schedule)
+	if err != nil {
+		return err
+	}
+
+	policy, err := effectivePolicy(ctx, employer, contractID)
+	if err != nil {
+		return err
+	}
+	err = policy.requireCurrency(terms.Currency)
+	if err != nil {
+		return err
+	}
+
+	employeeAccount, err := validateContractAccount(ctx, account, contractID, employee)
+	if err != nil {
+		return err
+	}
+	if !employeeAccount.OwnerIdentity.isSet() {
+		return fmt.Errorf("the account %s has no bound owner and cannot be paid", account)
+	}
+
+	termsHash, err := hashTerms(contractID, employer, employee, terms, pay.Salt)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
 	// Create new contract
 	newContract := Contract{
-		ID:          contractID,
-		Employer:    employer,
-		Employee:    employee,
-		Position:    position,
-		Salary:      salary,
-		VariablePay: variablePay,
-		Currency:    currency,
-		Account:     account,
-		Status:      "Active",
+		ID:               contractID,
+		Employer:         employer,
+		Employee:         employee,
+		EmployerIdentity: caller.party(),
+		EmployeeIdentity: employeeAccount.OwnerIdentity,
+		ContractTerms:    terms,
+		TermsHash:        termsHash,
+		ProposedBy:       caller.Name,
+		Status:           StatusPendingSignature,
+		StatusHistory: []StatusChange{{
+			To:            StatusPendingSignature,
+			Reason:        "Proposed",
+			EffectiveDate: now,
+			ChangedBy:     caller.Name,
+			RecordedAt:    now,
+		}},
+	}
+
+	err = signContract(ctx, caller, &newContract, PartyEmployer, signature)
+	if err != nil {
+		return err
 	}
 
-	contractJSON, err := json.Marshal(newContract) //converting into JSON
+	err = putContractPay(ctx, &newContract, pay.Salt)
 	if err != nil {
 		return err
 	}
 
 	// Put the contract on the ledger
-	return ctx.GetStub().PutState(contractID, contractJSON)
-
+	return putContract(ctx, &newContract)
 }
 
-// revoke an existing contract
-func (s *PaymentContract) RevokeContract(ctx contractapi.TransactionContextInterface, contractID string) error {
-	exists, err := s.ContractExists(ctx, contractID)
+// revoke an existing contract. The contract is kept on the ledger with status Revoked
+func (s *PaymentContract) RevokeContract(ctx contractapi.TransactionContextInterface, contractID string, reason string) error {
+	caller, err := authorize(ctx, "RevokeContract")
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return fmt.Errorf("the contract %s does not exist", contractID)
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return err
+	}
+
+	// only the contract's own employer can revoke it
+	if !caller.isEmployerOf(contract) {
+		return fmt.Errorf("only the employer %s can revoke contract %s", contract.Employer, contractID)
 	}
 
-	return ctx.GetStub().DelState(contractID)
+	return transitionContract(ctx, contract, StatusRevoked, reason, "", caller.Name)
 }
 
 //retrieves a contract by its ID
 func (s *PaymentContract) GetContractByID(ctx contractapi.TransactionContextInterface, contractID string) (*Contract, error) {
+	caller, err := authorize(ctx, "GetContractByID")
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = authorizeContractRead(caller, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	// the pay is only returned to clients of orgs in the contract collection
+	err = loadVisibleContractPay(ctx, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	// amendments that took effect since the contract was last written
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contract.advanceTerms(now)
+
+	return contract, nil
+}
+
+// readContract reads a contract from the world state without access checks
+func readContract(ctx contractapi.TransactionContextInterface, contractID string) (*Contract, error) {
 	contractJSON, err := ctx.GetStub().GetState(contractID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
@@ -173,6 +405,14 @@ func (s *PaymentContract) GetContractByID(ctx contractapi.TransactionContextInte
 		return nil, err
 	}
 
+	// scheduled status changes are in force from their effective date, even before
+	// the contract is next written
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contract.Status = contract.statusAt(now)
+
 	return &contract, nil
 }
 
@@ -180,268 +420,612 @@ func (s *PaymentContract) GetContractByID(ctx contractapi.TransactionContextInte
 //Payroll
 //////////////////////////////////////////////////////////////////////////////////////////////////
 
-//monthly payment for an employee based on the contract details
-func (s *PaymentContract) CalculateMonthlyPayment(contract *Contract) (float64, error) {
-	monthlyPayment := contract.Salary + contract.VariablePay
-	return monthlyPayment, nil
+//gross pay of an employee for the days periodStart to periodEnd ("YYYY-MM-DD", both
+//inclusive) under the pay schedule of the contract, prorated across amendments
+func (s *PaymentContract) CalculateMonthlyPayment(ctx contractapi.TransactionContextInterface, contractID string, periodStart string, periodEnd string) (Money, error) {
+	err := authorizeRead(ctx, "CalculateMonthlyPayment", contractID)
+	if err != nil {
+		return Money{}, err
+	}
+
+	contract, err := readContractPay(ctx, contractID)
+	if err != nil {
+		return Money{}, err
+	}
+
+	start, err := parseDate(periodStart)
+	if err != nil {
+		return Money{}, err
+	}
+	end, err := parseDate(periodEnd)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return grossPay(contract, start, end.AddDate(0, 0, 1))
 }
 
-// new advance payment request
-func (s *PaymentContract) AdvanceRequest(ctx contractapi.TransactionContextInterface, requestID string, contractID string, employee string, amount float64) error {
+// new advance payment request, to be recovered from the next installments regular payments
+func (s *PaymentContract) AdvanceRequest(ctx contractapi.TransactionContextInterface, requestID string, contractID string, employee string, amount string, installments int) error {
+	caller, err := authorize(ctx, "AdvanceRequest")
+	if err != nil {
+		return err
+	}
 	// Check if contract exists
-	contract, err := s.GetContractByID(ctx, contractID)
+	contract, err := readContractPay(ctx, contractID)
 	if err != nil {
 		return err
 	}
 
-	// monthly payment
-	monthlyPayment, err := s.CalculateMonthlyPayment(contract)
+	err = requireActive(contract)
+	if err != nil {
+		return err
+	}
+	if employee != contract.Employee || !caller.isEmployeeOf(contract) {
+		return fmt.Errorf("%s cannot request an advance on behalf of %s", caller.Name, employee)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	advance, err := parseAmount(amount, contract.termsAt(now).Currency)
+	if err != nil {
+		return err
+	}
+
+	// gross pay of the current month
+	monthStart, monthEnd := monthOf(now)
+	monthlyPay, err := grossPay(contract, monthStart, monthEnd)
 	if err != nil {
 		return err
 	}
 
 	// limits
-	if amount > monthlyPayment*2 {
+	policy, err := effectivePolicy(ctx, contract.Employer, contract.ID)
+	if err != nil {
+		return err
+	}
+	limit, err := monthlyPay.MulRat(policy.AdvanceMultiplier, basisPoints, RoundDown)
+	if err != nil {
+		return err
+	}
+	exceeded, err := exceedsLimit(advance, limit)
+	if err != nil {
+		return err
+	}
+	if exceeded {
 		return fmt.Errorf("advance amount exceeds limit")
 	}
-
-	// new advance request
-	newRequest := AdvanceRequest{
-		ID:         requestID,
-		ContractID: contractID,
-		Employee:   employee,
-		Amount:     amount,
-		Status:     "Pending", //yet to
+	if installments < 1 || installments > policy.AdvanceInstallments {
+		return fmt.Errorf("an advance is repaid in 1 to %d installments, not %d", policy.AdvanceInstallments, installments)
 	}
 
-	requestJSON, err := json.Marshal(newRequest)
+	// the limit also caps the advances still pending or owed on the contract
+	err = checkAdvanceLimits(ctx, contract, policy, advance, limit, now)
 	if err != nil {
 		return err
 	}
 
+	// an existing request would lose its repayment plan if overwritten
+	existing, err := ctx.GetStub().GetState(requestID)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("the advance request %s already exists", requestID)
+	}
+
+	// new advance request
+	newRequest := AdvanceRequest{
+		ID:           requestID,
+		ContractID:   contractID,
+		Employee:     employee,
+		Amount:       advance,
+		Status:       AdvancePending, //yet to
+		RequestedAt:  now,
+		Installments: installments,
+	}
+
 	// Put the request on the ledger
-	err = ctx.GetStub().PutState(requestID, requestJSON)
+	err = putAdvanceRequest(ctx, &newRequest)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state. %v", err)
+		return err
 	}
 
-	return nil
+	return putContractAdvanceIndex(ctx, &newRequest)
 }
 
-// ApproveAdvanceRequest approves an advance payment request and processes the payment
-func (s *PaymentContract) ApproveAdvanceRequest(ctx contractapi.TransactionContextInterface, requestID string) error {
+// ApproveAdvanceRequest approves an advance payment request, sets its repayment plan
+// and processes the payment. fee is an optional flat fee in the contract currency and
+// interestRate an optional annual rate in basis points charged over the installments.
+// Under an approval policy of the employer each call adds one signature, and the
+// advance is paid once the policy is satisfied. A retry with the same idempotency key
+// and parameters neither signs nor pays again
+func (s *PaymentContract) ApproveAdvanceRequest(ctx contractapi.TransactionContextInterface, idempotencyKey string, requestID string, fee string, interestRate int64) error {
+	caller, err := authorize(ctx, "ApproveAdvanceRequest")
+	if err != nil {
+		return err
+	}
+
 	// Get advance request from the ledger
-	requestJSON, err := ctx.GetStub().GetState(requestID)
+	request, err := readAdvanceRequest(ctx, requestID)
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %v", err)
+		return err
+	}
+
+	claim, replayed, err := claimIdempotencyKey(ctx, caller, "ApproveAdvanceRequest", idempotencyKey, request.ContractID, requestID, fee, fmt.Sprint(interestRate))
+	if err != nil {
+		return err
+	}
+	if replayed {
+		return nil
+	}
+
+	contract, err := readContract(ctx, request.ContractID)
+	if err != nil {
+		return err
+	}
+	if request.Employee != contract.Employee {
+		return fmt.Errorf("%s is not the employee of contract %s", request.Employee, contract.ID)
 	}
-	if requestJSON == nil {
-		return fmt.Errorf("the advance request %s does not exist", requestID)
+
+	// approving a request again must not pay the advance twice
+	switch request.Status {
+	case AdvancePending:
+	case AdvanceApproved, AdvanceRepaid, AdvanceWrittenOff:
+		return nil
+	default:
+		return fmt.Errorf("advance request %s is %s and cannot be approved", requestID, request.Status)
 	}
 
-	// Unmarshal advance request
-	var request AdvanceRequest
-	err = json.Unmarshal(requestJSON, &request)
+	err = requireActive(contract)
 	if err != nil {
 		return err
 	}
 
+	policy, err := currentApprovalPolicy(ctx, contract.Employer, AdvanceApproval)
+	if err != nil {
+		return err
+	}
+	// without a policy only the contract's employer can approve, and never the requester themselves
+	employerApproves := func() error {
+		if !caller.isEmployerOf(contract) {
+			return fmt.Errorf("only the employer %s can approve advances on contract %s", contract.Employer, contract.ID)
+		}
+		if caller.is(contract.EmployeeIdentity) {
+			return fmt.Errorf("%s cannot approve their own advance request", caller.Name)
+		}
+		return nil
+	}
+	if policy == nil {
+		err = employerApproves()
+		if err != nil {
+			return err
+		}
+	} else {
+		now, err := txTime(ctx)
+		if err != nil {
+			return err
+		}
+
+		// every approver signs the same amount, fee and interest
+		terms, err := approvalTerms(request.salt, fmt.Sprintf("%s fee %s interest %d", request.Amount, fee, interestRate))
+		if err != nil {
+			return err
+		}
+		satisfied, err := approve(policy, &request.Approvals, caller, contract, contract.EmployeeIdentity, request.Amount, terms, now, employerApproves)
+		if err != nil {
+			return err
+		}
+		if !satisfied {
+			err = putAdvanceRequest(ctx, request)
+			if err != nil {
+				return err
+			}
+			return completeIdempotencyKey(ctx, claim, requestID)
+		}
+	}
+
 	// Update request status to Approved
-	request.Status = "Approved"
+	request.Status = AdvanceApproved
+	request.ApprovedBy = caller.Name
 
-	// Update request on the ledger
-	requestJSON, err = json.Marshal(request)
+	err = setRepaymentPlan(ctx, request, contract, fee, interestRate)
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().PutState(requestID, requestJSON)
+	// Update request on the ledger
+	err = putAdvanceRequest(ctx, request)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state. %v", err)
+		return err
 	}
 
 	// Process the advance payment
-	err = s.ProcessPayment(ctx, request.ContractID, request.Employee, request.Amount, AdvancePayment)
+	paymentID, err := s.processPayment(ctx, request.ContractID, request.Employee, request.Amount, AdvancePayment)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return completeIdempotencyKey(ctx, claim, paymentID)
 }
 
-// ProcessPayment processes a payment transaction
-func (s *PaymentContract) ProcessPayment(ctx contractapi.TransactionContextInterface, contractID string, employee string, amount float64, paymentType string) error {
+// ProcessPayment processes a regular payment for an amount in the contract currency
+// and returns the payment ID. Advances are only paid by ApproveAdvanceRequest. A retry
+// with the same idempotency key and parameters returns the ID of the original payment
+// instead of paying again
+func (s *PaymentContract) ProcessPayment(ctx contractapi.TransactionContextInterface, idempotencyKey string, contractID string, employee string, amount string, paymentType string) (string, error) {
+	caller, err := authorize(ctx, "ProcessPayment")
+	if err != nil {
+		return "", err
+	}
+
+	if paymentType != RegularPayment {
+		return "", fmt.Errorf("ProcessPayment only makes %s payments, not %s", RegularPayment, paymentType)
+	}
+
+	claim, replayed, err := claimIdempotencyKey(ctx, caller, "ProcessPayment", idempotencyKey, contractID, contractID, employee, amount, paymentType)
+	if err != nil {
+		return "", err
+	}
+	if replayed {
+		return claim.Result, nil
+	}
+
 	// Check if contract exists
-	contract, err := s.GetContractByID(ctx, contractID)
+	contract, err := readContract(ctx, contractID)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if !caller.isEmployerOf(contract) {
+		return "", fmt.Errorf("only the employer %s can pay contract %s", contract.Employer, contractID)
 	}
 
-	// Calculate monthly payment for the contract
-	monthlyPayment, err := s.CalculateMonthlyPayment(contract)
+	terms, err := currentTerms(ctx, contract)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	payment, err := parseAmount(amount, terms.Currency)
+	if err != nil {
+		return "", err
+	}
+
+	paymentID, err := s.processPayment(ctx, contractID, employee, payment, paymentType)
+	if err != nil {
+		return "", err
+	}
+
+	return paymentID, completeIdempotencyKey(ctx, claim, paymentID)
+}
+
+// processPayment records a payment of an already parsed amount and returns its ID
+func (s *PaymentContract) processPayment(ctx contractapi.TransactionContextInterface, contractID string, employee string, amount Money, paymentType string) (string, error) {
+	// Check if contract exists
+	contract, err := readContractPay(ctx, contractID)
+	if err != nil {
+		return "", err
+	}
+
+	// Payments are only made on Active contracts
+	err = requireActive(contract)
+	if err != nil {
+		return "", err
 	}
 
-	// Check if employee already received payment this month
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Check if employee already received payment this pay period
+	periodStart, periodEnd := payPeriod(contract.termsAt(now).withDefaults().PayFrequency, now)
 	if paymentType == RegularPayment {
-		lastPaymentDate, err := s.GetLastPaymentDate(ctx, contractID)
+		paidBy, err := paidForPeriod(ctx, contract, periodStart, periodEnd.AddDate(0, 0, -1))
 		if err != nil {
-			return err
+			return "", err
 		}
-		if lastPaymentDate.Month() == time.Now().Month() {
-			return fmt.Errorf("employee already received payment this month")
+		if paidBy != "" {
+			return "", fmt.Errorf("employee already received payment %s this pay period", paidBy)
 		}
 	}
 
 	// Check if payment amount is within limits
-	if amount > monthlyPayment*2 {
-		return fmt.Errorf("payment amount exceeds limit")
+	err = checkPaymentLimits(ctx, contract, amount, now)
+	if err != nil {
+		return "", err
 	}
 
 	// Create new payment transaction
 	newPayment := Payment{
-		ID:         fmt.Sprintf("PAY_%s_%s_%d", contractID, employee, time.Now().UnixNano()),
+		ID:         recordID(ctx, "PAY", contractID, employee),
 		ContractID: contractID,
 		Employee:   employee,
 		Amount:     amount,
-		Date:       time.Now(),
+		Date:       now,
 		Type:       paymentType,
 	}
 
-	paymentJSON, err := json.Marshal(newPayment)
+	// Advances are credited from the advance the employee owes back, without withholding
+	if paymentType == AdvancePayment {
+		return newPayment.ID, recordPayment(ctx, &newPayment, AdvanceReceivable)
+	}
+
+	// Regular pay is paid for the current pay period, net of tax and contributions
+	newPayment.PeriodStart = periodStart
+	newPayment.snapshotTerms(contract)
+	newPayment.PeriodEnd = periodEnd.AddDate(0, 0, -1)
+	newPayment.Components = []PayComponent{{Code: paymentType, Amount: amount}}
+
+	ytd, err := withholdPayment(ctx, contract, &newPayment, daysBetween(periodStart, periodEnd))
+	if err != nil {
+		return "", err
+	}
+	err = putYearToDate(ctx, ytd)
+	if err != nil {
+		return "", err
+	}
+
+	// Installments of advances are recovered from regular pay only
+	if paymentType == RegularPayment {
+		err = recoverAdvances(ctx, &newPayment)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = recordPayment(ctx, &newPayment, EmployerPayable)
+	if err != nil {
+		return "", err
+	}
+
+	return newPayment.ID, anchorPayslip(ctx, contract, &newPayment)
+}
+
+// recordPayment writes a payment and its index entry to the ledger and credits the
+// employee's wallet from debitAccount with the net amount. Deductions withheld from
+// the payment are credited to WithholdingPayable and advance installments recovered
+// from it to AdvanceReceivable and AdvanceFeeIncome
+func recordPayment(ctx contractapi.TransactionContextInterface, payment *Payment, debitAccount string) error {
+	if payment.Net == (Money{}) {
+		payment.Net = payment.Amount
+	}
+
+	legs, err := paymentLegs(payment, debitAccount)
+	if err != nil {
+		return err
+	}
+
+	err = putPayment(ctx, payment)
+	if err != nil {
+		return err
+	}
+
+	return post(ctx, payment.ContractID, payment.Employee, payment.ID, legs...)
+}
+
+// paymentLegs returns the postings of a payment made from debitAccount: the net pay
+// less recovered advances to the wallet, the deductions to WithholdingPayable and
+// the recovered installments back to the advance accounts
+func paymentLegs(payment *Payment, debitAccount string) ([]postingLeg, error) {
+	var legs []postingLeg
+	credited := payment.Net
+	if payment.Recovered.IsPositive() {
+		var err error
+		credited, err = credited.Sub(payment.Recovered)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if credited.IsPositive() {
+		legs = append(legs, postingLeg{debitAccount, EmployeeWallet, credited})
+	}
+	withheld, err := payment.Amount.Sub(payment.Net)
+	if err != nil {
+		return nil, err
+	}
+	if withheld.IsPositive() {
+		legs = append(legs, postingLeg{debitAccount, WithholdingPayable, withheld})
+	}
+	for _, recovery := range payment.Recoveries {
+		legs = append(legs, repaymentLegs(debitAccount, recovery.Principal, recovery.Charges)...)
+	}
+
+	return legs, nil
+}
+
+// putPayment writes a payment record and its index entry to the ledger. The amounts
+// of the payment are kept in the contract collection once the contract's pay is private
+func putPayment(ctx contractapi.TransactionContextInterface, payment *Payment) error {
+	public, err := putPaymentAmounts(ctx, payment)
+	if err != nil {
+		return err
+	}
+
+	paymentJSON, err := json.Marshal(public)
 	if err != nil {
 		return err
 	}
 
 	// Put the payment transaction on the ledger
-	err = ctx.GetStub().PutState(newPayment.ID, paymentJSON)
+	err = ctx.GetStub().PutState(payment.ID, paymentJSON)
 	if err != nil {
 		return fmt.Errorf("failed to put to world state. %v", err)
 	}
 
-	return nil
+	return putPaymentIndex(ctx, payment.ContractID, payment.Employee, payment.Date, payment.ID, payment.Type)
 }
 
 // WithdrawPayment withdraws the payment amount to the employee's designated account
-func (s *PaymentContract) WithdrawPayment(ctx contractapi.TransactionContextInterface, contractID string, employee string, amount float64) error {
-	// Check if contract exists contract,
-	err := s.GetContractByID(ctx, contractID)
+// and returns the withdrawal ID, or the ID of the original withdrawal when retried
+// with the same idempotency key and parameters
+func (s *PaymentContract) WithdrawPayment(ctx contractapi.TransactionContextInterface, idempotencyKey string, contractID string, employee string, amount string) (string, error) {
+	caller, err := authorize(ctx, "WithdrawPayment")
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	// Get the latest payment transaction for the employee
-	lastPayment, err := s.GetLastPayment(ctx, contractID, employee)
+	claim, replayed, err := claimIdempotencyKey(ctx, caller, "WithdrawPayment", idempotencyKey, contractID, contractID, employee, amount)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if replayed {
+		return claim.Result, nil
+	}
+
+	// Check if contract exists
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return "", err
+	}
+	if employee != contract.Employee || !caller.isEmployeeOf(contract) {
+		return "", fmt.Errorf("%s cannot withdraw on behalf of %s", caller.Name, employee)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	withdrawalAmount, err := parseAmount(amount, contract.termsAt(now).Currency)
+	if err != nil {
+		return "", err
+	}
+
+	// Get the funds available in the employee's wallet
+	available, err := availableBalance(ctx, contract, employee)
+	if err != nil {
+		return "", err
 	}
 
 	// Check if employee is trying to withdraw more than credited
-	if amount > lastPayment.Amount {
-		return fmt.Errorf("withdrawal amount exceeds credited amount")
+	exceeded, err := exceedsLimit(withdrawalAmount, available)
+	if err != nil {
+		return "", err
+	}
+	if exceeded {
+		return "", fmt.Errorf("withdrawal amount exceeds credited amount")
+	}
+
+	policy, err := effectivePolicy(ctx, contract.Employer, contract.ID)
+	if err != nil {
+		return "", err
+	}
+	err = checkCap(withdrawalAmount, policy.WithdrawalLimit, "withdrawal amount")
+	if err != nil {
+		return "", err
 	}
 
 	// Create withdrawal transaction
 	withdrawal := Payment{
-		ID:         fmt.Sprintf("WITHDRAW_%s_%s_%d", contractID, employee, time.Now().UnixNano()),
+		ID:         recordID(ctx, "WITHDRAW", contractID, employee),
 		ContractID: contractID,
 		Employee:   employee,
-		Amount:     amount,
-		Date:       time.Now(),
-		Type:       "Withdrawal",
+		Amount:     withdrawalAmount,
+		Date:       now,
+		Type:       WithdrawalPayment,
 	}
 
-	withdrawalJSON, err := json.Marshal(withdrawal)
+	// Put the withdrawal transaction on the ledger
+	err = putPayment(ctx, &withdrawal)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	// Put the withdrawal transaction on the ledger
-	err = ctx.GetStub().PutState(withdrawal.ID, withdrawalJSON)
+	// Move the withdrawn funds out of the wallet towards the employee's bank
+	err = post(ctx, contractID, employee, withdrawal.ID, postingLeg{EmployeeWallet, SettlementClearing, withdrawalAmount})
 	if err != nil {
-		return fmt.Errorf("failed to put to world state. %v", err)
+		return "", err
 	}
 
-	return nil
+	return withdrawal.ID, completeIdempotencyKey(ctx, claim, withdrawal.ID)
 }
 
-// GetLastPaymentDate retrieves the last payment date for a contract
+// GetLastPaymentDate retrieves the date of the last regular payment for a contract
 func (s *PaymentContract) GetLastPaymentDate(ctx contractapi.TransactionContextInterface, contractID string) (time.Time, error) {
-	// Get all payment transactions for the contract
-	paymentResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("Payment", []string{contractID})
+	err := authorizeRead(ctx, "GetLastPaymentDate", contractID)
 	if err != nil {
 		return time.Time{}, err
 	}
-	defer paymentResultsIterator.Close()
 
-	var lastPaymentDate time.Time
-	for paymentResultsIterator.HasNext() {
-		_, paymentKey, err := paymentResultsIterator.Next()
-		if err != nil {
-			return time.Time{}, err
-		}
+	return lastPaymentDate(ctx, contractID)
+}
 
-		// Extract the timestamp from the composite key
-		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(paymentKey)
-		if err != nil {
-			return time.Time{}, err
-		}
+// lastPaymentDate returns the date of the last regular payment for a contract
+func lastPaymentDate(ctx contractapi.TransactionContextInterface, contractID string) (time.Time, error) {
+	// Get the index entries of all payments for the contract
+	entries, err := getPaymentIndexEntries(ctx, contractID)
+	if err != nil {
+		return time.Time{}, err
+	}
 
-		// Convert the timestamp to time.Time
-		timestamp, err := time.Parse(time.RFC3339Nano, compositeKeyParts[3])
-		if err != nil {
-			return time.Time{}, err
+	var lastPaymentDate time.Time
+	for _, entry := range entries {
+		if entry.Type != RegularPayment {
+			continue
 		}
 
 		// Update lastPaymentDate if this payment is more recent
-		if timestamp.After(lastPaymentDate) {
-			lastPaymentDate = timestamp
+		if entry.Date.After(lastPaymentDate) {
+			lastPaymentDate = entry.Date
 		}
 	}
 
 	return lastPaymentDate, nil
 }
 
-// GetLastPayment retrieves the last payment transaction for an employee in a contract
+// GetLastPayment retrieves the last regular or advance payment credited to an employee in a contract
 func (s *PaymentContract) GetLastPayment(ctx contractapi.TransactionContextInterface, contractID string, employee string) (*Payment, error) {
-	// Get all payment transactions for the contract and employee
-	paymentResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("Payment", []string{contractID, employee})
+	err := authorizeRead(ctx, "GetLastPayment", contractID)
 	if err != nil {
 		return nil, err
 	}
-	defer paymentResultsIterator.Close()
-
-	var lastPayment *Payment
-	for paymentResultsIterator.HasNext() {
-		_, paymentKey, err := paymentResultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
 
-		// Get the payment transaction
-		paymentJSON, err := ctx.GetStub().GetState(paymentKey)
-		if err != nil {
-			return nil, err
-		}
+	// Get the index entries of all payments for the contract and employee
+	entries, err := getPaymentIndexEntries(ctx, contractID, employee)
+	if err != nil {
+		return nil, err
+	}
 
-		var payment Payment
-		err = json.Unmarshal(paymentJSON, &payment)
-		if err != nil {
-			return nil, err
+	var lastEntry *PaymentIndexEntry
+	for i := range entries {
+		if entries[i].Type != RegularPayment && entries[i].Type != AdvancePayment {
+			continue
 		}
 
-		// Update lastPayment if this payment is more recent
-		if lastPayment == nil || payment.Date.After(lastPayment.Date) {
-			lastPayment = &payment
+		// Update lastEntry if this payment is more recent
+		if lastEntry == nil || !entries[i].Date.Before(lastEntry.Date) {
+			lastEntry = &entries[i]
 		}
 	}
 
-	if lastPayment == nil {
+	if lastEntry == nil {
 		return nil, fmt.Errorf("no payments found for employee %s in contract %s", employee, contractID)
 	}
 
-	return lastPayment, nil
+	// Get the payment transaction
+	paymentJSON, err := ctx.GetStub().GetState(lastEntry.PaymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if paymentJSON == nil {
+		return nil, fmt.Errorf("the payment %s does not exist", lastEntry.PaymentID)
+	}
+
+	var payment Payment
+	err = json.Unmarshal(paymentJSON, &payment)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadPaymentAmounts(ctx, &payment, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
 }
 
 //################################################################################################
@@ -450,151 +1034,237 @@ func (s *PaymentContract) GetLastPayment(ctx contractapi.TransactionContextInter
 //################################################################################################
 //################################################################################################
 
-// ProcessPayment processes a payment transaction
-func (s *PaymentContract) ProcessBankPayment(ctx contractapi.TransactionContextInterface, contractID string, employee string, amount float64, paymentType string) error {
+// ProcessBankPayment records a pending cross-border or local bank payment of wallet
+// funds to the employee's bank and returns its ID, or the ID of the original payment
+// when retried with the same idempotency key and parameters. The amount leaves the
+// wallet like a withdrawal, and is put back if the payment fails or is returned
+func (s *PaymentContract) ProcessBankPayment(ctx contractapi.TransactionContextInterface, idempotencyKey string, contractID string, employee string, amount string, paymentType string) (string, error) {
+	caller, err := authorize(ctx, "ProcessBankPayment")
+	if err != nil {
+		return "", err
+	}
+
+	claim, replayed, err := claimIdempotencyKey(ctx, caller, "ProcessBankPayment", idempotencyKey, contractID, contractID, employee, amount, paymentType)
+	if err != nil {
+		return "", err
+	}
+	if replayed {
+		return claim.Result, nil
+	}
+
 	// Check if contract exists
-	contract, err := s.GetContractByID(ctx, contractID)
+	contract, err := readContract(ctx, contractID)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if !caller.isEmployerOf(contract) {
+		return "", fmt.Errorf("only the employer %s can pay contract %s", contract.Employer, contractID)
+	}
+	err = requireActive(contract)
+	if err != nil {
+		return "", err
+	}
+
+	terms, err := currentTerms(ctx, contract)
+	if err != nil {
+		return "", err
+	}
+
+	paymentAmount, err := parseAmount(amount, terms.Currency)
+	if err != nil {
+		return "", err
+	}
+
+	// Check if payment amount is within limits
+	err = loadContractPay(ctx, contract)
+	if err != nil {
+		return "", err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	err = checkPaymentLimits(ctx, contract, paymentAmount, now)
+	if err != nil {
+		return "", err
+	}
+
+	// Check if the wallet holds the amount
+	available, err := availableBalance(ctx, contract, employee)
+	if err != nil {
+		return "", err
+	}
+	exceeded, err := exceedsLimit(paymentAmount, available)
+	if err != nil {
+		return "", err
+	}
+	if exceeded {
+		return "", fmt.Errorf("payment amount exceeds credited amount")
 	}
 
 	// Create new payment transaction
+	var paymentID string
 	var newPayment interface{}
 	switch paymentType {
 	case CrossBorder:
-		newPayment = CrossBorderPayment{
-			ID:         fmt.Sprintf("CROSS_%s_%s_%d", contractID, employee, time.Now().UnixNano()),
-			ContractID: contractID,
-			Employee:   employee,
-			Amount:     amount,
-			Status:     "Pending",
+		paymentID = recordID(ctx, "CROSS", contractID, employee)
+		newPayment = &CrossBorderPayment{
+			ID:                paymentID,
+			ContractID:        contractID,
+			Employee:          employee,
+			Amount:            paymentAmount,
+			Settlement:        Settlement{Type: CrossBorder, Status: BankPaymentPending, Debited: true},
+			InitiatedBy:       caller.Name,
+			InitiatorIdentity: caller.party(),
 		}
 	case Local:
-		newPayment = LocalPayment{
-			ID:         fmt.Sprintf("LOCAL_%s_%s_%d", contractID, employee, time.Now().UnixNano()),
+		paymentID = recordID(ctx, "LOCAL", contractID, employee)
+		newPayment = &LocalPayment{
+			ID:         paymentID,
 			ContractID: contractID,
 			Employee:   employee,
-			Amount:     amount,
-			Status:     "Pending",
+			Amount:     paymentAmount,
+			Settlement: Settlement{Type: Local, Status: BankPaymentPending, Debited: true},
 		}
 	default:
-		return fmt.Errorf("invalid payment type")
+		return "", fmt.Errorf("invalid payment type")
 	}
 
-	paymentJSON, err := json.Marshal(newPayment)
+	payment, err := newBankPayment(ctx, newPayment)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// Put the payment transaction on the ledger
-	err = ctx.GetStub().PutState(newPayment.ID, paymentJSON)
+	err = payment.put(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state. %v", err)
+		return "", err
 	}
 
-	return nil
+	err = putPaymentIndex(ctx, contractID, employee, now, paymentID, paymentType)
+	if err != nil {
+		return "", err
+	}
+
+	// Move the paid funds out of the wallet towards the employee's bank
+	err = post(ctx, contractID, employee, paymentID, postingLeg{EmployeeWallet, SettlementClearing, paymentAmount})
+	if err != nil {
+		return "", err
+	}
+
+	return paymentID, completeIdempotencyKey(ctx, claim, paymentID)
 }
 
-// ApproveCrossBorderPayment approves a cross-border payment and processes the transaction
+// ApproveCrossBorderPayment approves a cross-border payment for settlement. Without an
+// approval policy of the employer a single bank approval is enough. Under a policy each
+// call adds one signature, and the payment is approved once it is satisfied
 func (s *PaymentContract) ApproveCrossBorderPayment(ctx contractapi.TransactionContextInterface, paymentID string) error {
-	// Get cross-border payment from the ledger
-	paymentJSON, err := ctx.GetStub().GetState(paymentID)
+	caller, err := authorize(ctx, "ApproveCrossBorderPayment")
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %v", err)
-	}
-	if paymentJSON == nil {
-		return fmt.Errorf("the cross-border payment %s does not exist", paymentID)
+		return err
 	}
 
-	// Unmarshal cross-border payment
-	var payment CrossBorderPayment
-	err = json.Unmarshal(paymentJSON, &payment)
+	// Get cross-border payment from the ledger
+	payment, crossBorder, err := readCrossBorderPayment(ctx, paymentID)
 	if err != nil {
 		return err
 	}
 
-	// Approve the cross-border payment
-	payment.Status = "Approved"
+	if payment.Status != BankPaymentPending {
+		return fmt.Errorf("cross-border payment %s is %s, only Pending payments can be approved", paymentID, payment.Status)
+	}
 
-	// Update payment on the ledger
-	paymentJSON, err = json.Marshal(payment)
+	contract, err := readContract(ctx, payment.ContractID)
 	if err != nil {
 		return err
 	}
-
-	err = ctx.GetStub().PutState(paymentID, paymentJSON)
+	policy, err := currentApprovalPolicy(ctx, contract.Employer, CrossBorderApproval)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state. %v", err)
+		return err
 	}
+	// without a policy a bank approves
+	bankApproves := func() error {
+		if caller.Role != RoleBank {
+			return fmt.Errorf("only a bank can approve cross-border payments of %s", contract.Employer)
+		}
+		return nil
+	}
+	if policy == nil {
+		err = bankApproves()
+		if err != nil {
+			return err
+		}
+	} else {
+		now, err := txTime(ctx)
+		if err != nil {
+			return err
+		}
 
-	// Process the cross-border payment (simulation)
-	err = s.ProcessCrossBorderTransaction(ctx, payment)
-	if err != nil {
-		return err
+		terms, err := approvalTerms(payment.salt, payment.Amount.String())
+		if err != nil {
+			return err
+		}
+		satisfied, err := approve(policy, &crossBorder.Approvals, caller, contract, crossBorder.InitiatorIdentity, payment.Amount, terms, now, bankApproves)
+		if err != nil {
+			return err
+		}
+		if !satisfied {
+			return payment.put(ctx)
+		}
 	}
 
-	return nil
+	// Approve the cross-border payment, the debtor bank initiates its settlement
+	return approveBankPayment(ctx, payment)
 }
 
-// ProcessCrossBorderTransaction simulates the cross-border payment process
-func (s *PaymentContract) ProcessCrossBorderTransaction(ctx contractapi.TransactionContextInterface, payment CrossBorderPayment) error {
-	// In a real-world scenario, this function would interact with banks and forex services
-
-	// Step 1: Central Bank "C" approves the transaction
-	// Step 2: Central Bank "C" requests currency conversion from Forex Bank "B"
-	// Step 3: Forex Bank "B" converts currency from currency "A" to "B"
-	// Step 4: Central Bank of recipient nation receives converted amount
-	// Step 5: Central Bank of recipient nation transfers amount to routing/member bank of payee
-
-	// Simulating the process with logs
-	fmt.Printf("Processing cross-border payment for contract %s, employee %s, amount %f\n", payment.ContractID, payment.Employee, payment.Amount)
-	fmt.Println("Step 1: Central Bank C approves the transaction")
-	fmt.Println("Step 2: Central Bank C requests currency conversion from Forex Bank B")
-	fmt.Println("Step 3: Forex Bank B converts currency from currency A to B")
-	fmt.Println("Step 4: Central Bank of recipient nation receives converted amount")
-	fmt.Println("Step 5: Central Bank of recipient nation transfers amount to routing/member bank of payee")
-
-	// Update payment status to completed
-	payment.Status = "Completed"
-
-	// Update payment on the ledger
-	paymentJSON, err := json.Marshal(payment)
+// ProcessLocalPayment approves a pending local payment for settlement by the debtor bank
+// on a settlement date, from which it is picked up by that day's batch files. Only the
+// bank of the employer approves it
+func (s *PaymentContract) ProcessLocalPayment(ctx contractapi.TransactionContextInterface, paymentID string, settlementDate string) error {
+	caller, err := authorize(ctx, "ProcessLocalPayment")
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().PutState(payment.ID, paymentJSON)
+	payment, err := readBankPayment(ctx, paymentID)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state. %v", err)
+		return err
+	}
+	if payment.Type != Local {
+		return fmt.Errorf("%s is not a local payment", paymentID)
+	}
+	if payment.Status != BankPaymentPending {
+		return fmt.Errorf("local payment %s is %s, only Pending payments can be approved", paymentID, payment.Status)
+	}
+	err = requireDebtorBank(ctx, caller, payment)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
-
-// ProcessLocalPayment processes a local payment transaction
-func (s *PaymentContract) ProcessLocalPayment(ctx contractapi.TransactionContextInterface, payment LocalPayment) error {
-	// In a real-world scenario, this function would interact with local banks
-
-	// Simulating the process with logs
-	fmt.Printf("Processing local payment for contract %s, employee %s, amount %f\n", payment.ContractID, payment.Employee, payment.Amount)
-	fmt.Println("Step 1: Bank C transfers money from party A to Bank D")
-	fmt.Println("Step 2: Bank D credits amount to party B's account")
-
-	// Update payment status to completed
-	payment.Status = "Completed"
-
-	// Update payment on the ledger
-	paymentJSON, err := json.Marshal(payment)
+	date, err := parseDate(settlementDate)
 	if err != nil {
 		return err
 	}
+	date = startOfDay(date)
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if date.Before(startOfDay(now)) {
+		return fmt.Errorf("settlement date %s has passed", date.Format(dateLayout))
+	}
 
-	err = ctx.GetStub().PutState(payment.ID, paymentJSON)
+	indexKey, err := approvedLocalKey(ctx, date, paymentID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(indexKey, []byte{0x00})
 	if err != nil {
 		return fmt.Errorf("failed to put to world state. %v", err)
 	}
 
-	return nil
+	payment.record.(*LocalPayment).SettlementDate = date
+	return approveBankPayment(ctx, payment)
 }
 
 /*
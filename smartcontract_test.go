@@ -0,0 +1,341 @@
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// a client of the test network with a self-signed certificate
+type testClient struct {
+	name  string
+	mspID string
+	role  string
+	key   *ecdsa.PrivateKey
+	cert  *x509.Certificate
+}
+
+func newTestClient(t *testing.T, name string, mspID string, role string) *testClient {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testClient{name: name, mspID: mspID, role: role, key: key, cert: cert}
+}
+
+func (c *testClient) GetID() (string, error) {
+	return base64.StdEncoding.EncodeToString([]byte("x509::CN=" + c.name + "::CN=ca." + c.mspID)), nil
+}
+
+func (c *testClient) GetMSPID() (string, error) {
+	return c.mspID, nil
+}
+
+func (c *testClient) GetAttributeValue(attrName string) (string, bool, error) {
+	if attrName != roleAttribute {
+		return "", false, nil
+	}
+	return c.role, true, nil
+}
+
+func (c *testClient) AssertAttributeValue(attrName string, attrValue string) error {
+	value, found, _ := c.GetAttributeValue(attrName)
+	if !found || value != attrValue {
+		return fmt.Errorf("attribute %s is not %s", attrName, attrValue)
+	}
+	return nil
+}
+
+func (c *testClient) GetX509Certificate() (*x509.Certificate, error) {
+	return c.cert, nil
+}
+
+// sign returns the base64 signature of the client over a hex encoded hash
+func (c *testClient) sign(t *testing.T, hexHash string) string {
+	t.Helper()
+
+	hash, err := hex.DecodeString(hexHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := ecdsa.SignASN1(rand.Reader, c.key, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+// recordingStub is a mock stub that records the write set of a transaction
+type recordingStub struct {
+	*shimtest.MockStub
+	writes map[string][]byte
+}
+
+func (s *recordingStub) PutState(key string, value []byte) error {
+	s.writes[key] = value
+	return s.MockStub.PutState(key, value)
+}
+
+func (s *recordingStub) DelState(key string) error {
+	s.writes[key] = nil
+	return s.MockStub.DelState(key)
+}
+
+func (s *recordingStub) PutPrivateData(collection string, key string, value []byte) error {
+	s.writes[collection+"/"+key] = value
+	return s.MockStub.PutPrivateData(collection, key, value)
+}
+
+// a ledger the tests run transactions against
+type testLedger struct {
+	t     *testing.T
+	stub  *recordingStub
+	clock time.Time
+	txs   int
+}
+
+// newTestLedger returns an empty ledger on a peer of the employer's org
+func newTestLedger(t *testing.T) *testLedger {
+	t.Setenv("CORE_PEER_LOCALMSPID", "EmployerMSP")
+	return &testLedger{
+		t:     t,
+		stub:  &recordingStub{MockStub: shimtest.NewMockStub("payroll", nil), writes: map[string][]byte{}},
+		clock: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+	}
+}
+
+// clone copies the world state and private data of the ledger into a new one
+func (l *testLedger) clone() *testLedger {
+	copied := newTestLedger(l.t)
+	copied.clock = l.clock
+	copied.txs = l.txs
+
+	copied.stub.MockTransactionStart("clone")
+	for key, value := range l.stub.State {
+		copied.stub.MockStub.PutState(key, append([]byte(nil), value...))
+	}
+	for collection, values := range l.stub.PvtState {
+		copied.stub.PvtState[collection] = map[string][]byte{}
+		for key, value := range values {
+			copied.stub.PvtState[collection][key] = append([]byte(nil), value...)
+		}
+	}
+	copied.stub.MockTransactionEnd("clone")
+
+	return copied
+}
+
+// begin starts a transaction submitted by client with the given transient map and
+// returns its context. Every transaction advances the clock by an hour
+func (l *testLedger) begin(client *testClient, transient map[string]interface{}) contractapi.TransactionContextInterface {
+	l.txs++
+	l.clock = l.clock.Add(time.Hour)
+	return l.beginAt(fmt.Sprintf("tx%04d", l.txs), l.clock, client, transient)
+}
+
+// beginAt starts a transaction with a fixed transaction ID and timestamp
+func (l *testLedger) beginAt(txID string, at time.Time, client *testClient, transient map[string]interface{}) contractapi.TransactionContextInterface {
+	l.stub.MockTransactionStart(txID)
+	l.stub.TxTimestamp = timestamppb.New(at)
+	l.stub.writes = map[string][]byte{}
+
+	transientMap := map[string][]byte{}
+	for key, value := range transient {
+		valueJSON, err := json.Marshal(value)
+		if err != nil {
+			l.t.Fatal(err)
+		}
+		transientMap[key] = valueJSON
+	}
+	l.stub.TransientMap = transientMap
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(l.stub)
+	ctx.SetClientIdentity(client)
+	return ctx
+}
+
+// must fails the test on a transaction error
+func (l *testLedger) must(err error) {
+	l.t.Helper()
+	if err != nil {
+		l.t.Fatal(err)
+	}
+}
+
+// the parties of a test contract
+type testParties struct {
+	admin    *testClient
+	employer *testClient
+	employee *testClient
+	bank     *testClient
+}
+
+func newTestParties(t *testing.T) *testParties {
+	return &testParties{
+		admin:    newTestClient(t, "admin", "RegulatorMSP", RoleAdmin),
+		employer: newTestClient(t, "acme", "EmployerMSP", RoleEmployer),
+		employee: newTestClient(t, "alice", "EmployeeMSP", RoleEmployee),
+		bank:     newTestClient(t, "bank", "BankMSP", RoleBank),
+	}
+}
+
+// initialize binds the admin, auditor and bank roles to the MSPs of the test parties
+func initialize(l *testLedger, s *PaymentContract, p *testParties) {
+	l.t.Helper()
+
+	l.must(s.Initialize(l.begin(p.admin, nil), AccessConfig{AdminMSPs: []string{p.admin.mspID}, AuditorMSPs: []string{p.admin.mspID}, BankMSPs: []string{p.bank.mspID}}))
+}
+
+// setupContract opens the employee's account and signs an Active contract between the parties
+func setupContract(l *testLedger, s *PaymentContract, p *testParties, contractID string) {
+	l.t.Helper()
+
+	account := map[string]interface{}{transientAccountKey: transientAccount{BankAccount: "DE89370400440532013000", BankBIC: "COBADEFFXXX", Salt: "account-salt-0123456789"}}
+	l.must(s.CreateAccount(l.begin(p.employee, account), "ACC1", "Acme", "", "EUR"))
+
+	pay := map[string]interface{}{transientPayKey: transientPay{Salary: "60000.00", VariablePay: "0", Salt: "contract-salt-0123456789"}}
+	hash, err := s.HashContractTerms(l.begin(p.employer, pay), contractID, p.employer.name, p.employee.name, "Engineer", "EUR", "ACC1", PaySchedule{})
+	l.must(err)
+	l.must(s.ProposeContract(l.begin(p.employer, pay), contractID, p.employer.name, p.employee.name, "Engineer", "EUR", "ACC1", PaySchedule{}, p.employer.sign(l.t, hash)))
+	l.must(s.AcceptContract(l.begin(p.employee, nil), contractID, p.employee.sign(l.t, hash)))
+	l.must(s.SetEmployerBank(l.begin(p.employer, nil), p.employer.name, p.bank.mspID))
+}
+
+// fundWallet pays the employee the regular pay of the current period, which bank
+// payments are then made from
+func fundWallet(l *testLedger, s *PaymentContract, p *testParties, contractID string) {
+	l.t.Helper()
+
+	_, err := s.ProcessPayment(l.begin(p.employer, nil), "", contractID, p.employee.name, "5000.00", RegularPayment)
+	l.must(err)
+}
+
+// writeSet renders the writes of the last transaction in key order
+func (l *testLedger) writeSet() []string {
+	var writes []string
+	for key, value := range l.stub.writes {
+		writes = append(writes, fmt.Sprintf("%q=%x", key, sha256.Sum256(value)))
+	}
+	sort.Strings(writes)
+	return writes
+}
+
+// Endorsing peers run a transaction independently and their write sets must match,
+// so a transaction may only depend on its arguments, its ID and its timestamp
+func TestProcessPaymentIsDeterministic(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	at := l.clock.Add(24 * time.Hour)
+	var writeSets [][]string
+	var results []string
+	for i := 0; i < 2; i++ {
+		peer := l.clone()
+		paymentID, err := s.ProcessPayment(peer.beginAt("tx-pay", at, p.employer, nil), "key-1", "C1", p.employee.name, "1000.00", RegularPayment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, paymentID)
+		writeSets = append(writeSets, peer.writeSet())
+	}
+
+	if results[0] != results[1] {
+		t.Errorf("payment IDs differ: %s and %s", results[0], results[1])
+	}
+	if len(writeSets[0]) == 0 {
+		t.Fatal("the payment wrote nothing")
+	}
+	a, b := writeSets[0], writeSets[1]
+	if len(a) != len(b) {
+		t.Fatalf("write sets differ in size: %d and %d", len(a), len(b))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Errorf("write sets differ: %s and %s", a[i], b[i])
+		}
+	}
+}
+
+// A pay period is paid once, whenever the payment for it is recorded
+func TestRegularPayIsOncePerPeriod(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	paymentID, err := s.ProcessPayment(l.begin(p.employer, nil), "key-1", "C1", p.employee.name, "1000.00", RegularPayment)
+	l.must(err)
+
+	// a payroll for March run in April must not pay March again
+	l.clock = time.Date(2024, 4, 2, 9, 0, 0, 0, time.UTC)
+	march := PayrollInterval{StartDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)}
+	run, err := s.RunPayroll(l.begin(p.employer, nil), "", p.employer.name, march)
+	l.must(err)
+	if run.Paid != 0 || run.Failed != 1 {
+		t.Fatalf("the run paid March again: %+v", run)
+	}
+	if want := "the interval overlaps the pay period of payment " + paymentID; run.Failures[0].Reason != want {
+		t.Errorf("the run failed with %q, want %q", run.Failures[0].Reason, want)
+	}
+
+	// while April is still unpaid
+	_, err = s.ProcessPayment(l.begin(p.employer, nil), "key-2", "C1", p.employee.name, "1000.00", RegularPayment)
+	l.must(err)
+	_, err = s.ProcessPayment(l.begin(p.employer, nil), "key-3", "C1", p.employee.name, "1000.00", RegularPayment)
+	if err == nil {
+		t.Error("April was paid twice")
+	}
+}
+
+// Advances are paid against an approved request only, never directly
+func TestProcessPaymentOnlyPaysRegularPay(t *testing.T) {
+	s := new(PaymentContract)
+	p := newTestParties(t)
+	l := newTestLedger(t)
+	initialize(l, s, p)
+	setupContract(l, s, p, "C1")
+
+	for _, paymentType := range []string{AdvancePayment, WithdrawalPayment, ReversalPayment, CorrectionPayment, ""} {
+		_, err := s.ProcessPayment(l.begin(p.employer, nil), "", "C1", p.employee.name, "1000.00", paymentType)
+		if err == nil {
+			t.Errorf("ProcessPayment made a %q payment", paymentType)
+		}
+	}
+}
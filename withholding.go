@@ -0,0 +1,623 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// object types of the composite keys used by the withholding engine
+const (
+	taxRuleSetIndex = "TaxRuleSet"
+	yearToDateIndex = "YearToDate"
+)
+
+// code of the deduction line holding income tax
+const IncomeTax = "IncomeTax"
+
+// rates are given in basis points, hundredths of a percent
+const basisPoints = 10000
+
+// days a year is annualised over when withholding income tax
+const daysPerYear = 365
+
+// an income tax bracket. The rate applies to annual taxable income from the
+// threshold up to the threshold of the next bracket
+type TaxBracket struct {
+	Threshold Money `json:"Threshold"` // Annual taxable income the bracket starts at
+	Rate      int64 `json:"Rate"`      // Rate in basis points
+}
+
+// a flat-rate statutory contribution such as social security
+type Contribution struct {
+	Code      string `json:"Code"`      // Code of the deduction line, e.g. SocialSecurity
+	Rate      int64  `json:"Rate"`      // Rate in basis points
+	AnnualCap Money  `json:"AnnualCap"` // Yearly gross the contribution is levied on, zero for no cap
+}
+
+// the withholding rules of a jurisdiction
+type TaxRules struct {
+	Currency      string         `json:"Currency"`      // Currency of every amount in the rules
+	Allowance     Money          `json:"Allowance"`     // Annual income free of income tax
+	Brackets      []TaxBracket   `json:"Brackets"`      // Income tax brackets by ascending threshold
+	Contributions []Contribution `json:"Contributions"` // Flat-rate contributions
+}
+
+// a version of the withholding rules of a jurisdiction and the date it takes effect
+type TaxRuleSet struct {
+	Jurisdiction string `json:"Jurisdiction"` // Tax jurisdiction, e.g. a country or state code
+	Version      int    `json:"Version"`      // Version number, starting at 1
+	TaxRules
+	EffectiveFrom time.Time `json:"EffectiveFrom"` // First day the rules apply
+	PublishedBy   string    `json:"PublishedBy"`   // Name of the party that published the rules
+	RecordedAt    time.Time `json:"RecordedAt"`    // Transaction timestamp of the version
+}
+
+// an amount withheld from a payment
+type DeductionLine struct {
+	Code   string `json:"Code"`   // IncomeTax or the code of a contribution
+	Base   Money  `json:"Base"`   // Amount the rate was applied to
+	Rate   int64  `json:"Rate"`   // Rate in basis points, the top marginal rate for income tax
+	Amount Money  `json:"Amount"` // Amount withheld
+}
+
+// year-to-date totals of the regular pay of an employee in a contract
+type YearToDate struct {
+	Employee          string           `json:"Employee"`          // Name of the employee
+	ContractID        string           `json:"ContractID"`        // ID of the contract
+	Year              int              `json:"Year"`              // Calendar year
+	Currency          string           `json:"Currency"`          // Currency of the totals
+	Gross             Money            `json:"Gross"`             // Gross pay
+	Net               Money            `json:"Net"`               // Net pay
+	Deductions        map[string]Money `json:"Deductions"`        // Amounts withheld per deduction code
+	ContributionBases map[string]Money `json:"ContributionBases"` // Gross levied per contribution, which caps apply to
+	PrivateDataHash   string           `json:"PrivateDataHash"`   // Salted hash of the totals held in the contract collection
+}
+
+// what a withholding rule needs to know about a payment
+type withholdingInput struct {
+	gross Money       // Gross amount of the payment
+	days  int64       // Days of pay the payment covers
+	ytd   *YearToDate // Totals before the payment
+}
+
+// a rule that withholds part of a payment. New kinds of statutory deductions are
+// added by implementing this interface and building them in TaxRules.rules
+type withholdingRule interface {
+	withhold(input withholdingInput) (*DeductionLine, error)
+}
+
+// progressive income tax on the annualised pay above an allowance
+type incomeTaxRule struct {
+	allowance Money
+	brackets  []TaxBracket
+}
+
+// withhold annualises the gross over the days it covers, taxes it by bracket and
+// withholds the share of the annual tax for those days
+func (r incomeTaxRule) withhold(input withholdingInput) (*DeductionLine, error) {
+	annual, err := input.gross.MulRat(daysPerYear, input.days, RoundHalfEven)
+	if err != nil {
+		return nil, err
+	}
+	taxable, err := annual.Sub(r.allowance)
+	if err != nil {
+		return nil, err
+	}
+
+	// sum of each bracket's income times its rate, in minor units times basis points
+	rated, err := ZeroMoney(input.gross.Currency)
+	if err != nil {
+		return nil, err
+	}
+	var topRate int64
+	for i, bracket := range r.brackets {
+		upper := taxable
+		if i+1 < len(r.brackets) {
+			if cmp, _ := r.brackets[i+1].Threshold.Cmp(taxable); cmp < 0 {
+				upper = r.brackets[i+1].Threshold
+			}
+		}
+		income, err := upper.Sub(bracket.Threshold)
+		if err != nil {
+			return nil, err
+		}
+		if !income.IsPositive() {
+			break
+		}
+		weighted, err := income.Mul(bracket.Rate)
+		if err != nil {
+			return nil, err
+		}
+		rated, err = rated.Add(weighted)
+		if err != nil {
+			return nil, err
+		}
+		topRate = bracket.Rate
+	}
+
+	amount, err := rated.MulRat(input.days, basisPoints*daysPerYear, RoundHalfEven)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeductionLine{Code: IncomeTax, Base: input.gross, Rate: topRate, Amount: amount}, nil
+}
+
+// flat-rate contribution on gross pay up to an annual cap
+type contributionRule struct {
+	Contribution
+}
+
+// withhold levies the rate on the gross still under the cap for the year
+func (r contributionRule) withhold(input withholdingInput) (*DeductionLine, error) {
+	base := input.gross
+	if !r.AnnualCap.IsZero() {
+		levied, ok := input.ytd.ContributionBases[r.Code]
+		if !ok {
+			levied = Money{Currency: input.gross.Currency}
+		}
+		remaining, err := r.AnnualCap.Sub(levied)
+		if err != nil {
+			return nil, err
+		}
+		if !remaining.IsPositive() {
+			remaining = Money{Currency: input.gross.Currency}
+		}
+		if cmp, _ := remaining.Cmp(base); cmp < 0 {
+			base = remaining
+		}
+	}
+
+	amount, err := base.MulRat(r.Rate, basisPoints, RoundHalfEven)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeductionLine{Code: r.Code, Base: base, Rate: r.Rate, Amount: amount}, nil
+}
+
+// rules returns the withholding rules of a rule set in the order they are applied
+func (t TaxRules) rules() []withholdingRule {
+	var rules []withholdingRule
+	if len(t.Brackets) > 0 {
+		rules = append(rules, incomeTaxRule{allowance: t.Allowance, brackets: t.Brackets})
+	}
+	for _, contribution := range t.Contributions {
+		rules = append(rules, contributionRule{contribution})
+	}
+	return rules
+}
+
+// validate checks the amounts, rates and ordering of a set of rules and sets
+// amounts left unset to zero in the currency of the rules
+func (t *TaxRules) validate() error {
+	zero, err := ZeroMoney(t.Currency)
+	if err != nil {
+		return err
+	}
+	inCurrency := func(name string, amount *Money) error {
+		if *amount == (Money{}) {
+			*amount = zero
+		}
+		if amount.Currency != t.Currency {
+			return fmt.Errorf("%s must be in %s, not %s", name, t.Currency, amount.Currency)
+		}
+		if amount.IsNegative() {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+		return nil
+	}
+	validRate := func(name string, rate int64) error {
+		if rate < 0 || rate > basisPoints {
+			return fmt.Errorf("%s rate %d must be between 0 and %d basis points", name, rate, basisPoints)
+		}
+		return nil
+	}
+
+	if err := inCurrency("allowance", &t.Allowance); err != nil {
+		return err
+	}
+
+	previous := zero
+	for i := range t.Brackets {
+		bracket := &t.Brackets[i]
+		if err := inCurrency("bracket threshold", &bracket.Threshold); err != nil {
+			return err
+		}
+		if err := validRate("bracket", bracket.Rate); err != nil {
+			return err
+		}
+		if cmp, _ := bracket.Threshold.Cmp(previous); i > 0 && cmp <= 0 {
+			return fmt.Errorf("bracket thresholds must be in ascending order")
+		}
+		previous = bracket.Threshold
+	}
+
+	codes := map[string]bool{IncomeTax: true}
+	for i := range t.Contributions {
+		contribution := &t.Contributions[i]
+		if contribution.Code == "" || codes[contribution.Code] {
+			return fmt.Errorf("contribution code %q is empty or used twice", contribution.Code)
+		}
+		codes[contribution.Code] = true
+		if err := inCurrency(contribution.Code+" cap", &contribution.AnnualCap); err != nil {
+			return err
+		}
+		if err := validRate(contribution.Code, contribution.Rate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// taxRuleSets returns every version of the rules of a jurisdiction in version order
+func taxRuleSets(ctx contractapi.TransactionContextInterface, jurisdiction string) ([]*TaxRuleSet, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(taxRuleSetIndex, []string{jurisdiction})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var ruleSets []*TaxRuleSet
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var ruleSet TaxRuleSet
+		err = json.Unmarshal(queryResponse.Value, &ruleSet)
+		if err != nil {
+			return nil, err
+		}
+		ruleSets = append(ruleSets, &ruleSet)
+	}
+
+	return ruleSets, nil
+}
+
+// taxRuleSetAt returns the rules of a jurisdiction in force on a date
+func taxRuleSetAt(ctx contractapi.TransactionContextInterface, jurisdiction string, date time.Time) (*TaxRuleSet, error) {
+	ruleSets, err := taxRuleSets(ctx, jurisdiction)
+	if err != nil {
+		return nil, err
+	}
+
+	var inForce *TaxRuleSet
+	for _, ruleSet := range ruleSets {
+		if ruleSet.EffectiveFrom.After(date) {
+			break
+		}
+		inForce = ruleSet
+	}
+	if inForce == nil {
+		return nil, fmt.Errorf("no tax rules of jurisdiction %s are in force on %s", jurisdiction, date.Format(dateLayout))
+	}
+
+	return inForce, nil
+}
+
+// PublishTaxRuleSet records a new version of the withholding rules of a jurisdiction
+// taking effect on effectiveFrom ("YYYY-MM-DD"). Earlier versions are kept so that
+// past payments can be explained by the rules that applied to them
+func (s *PaymentContract) PublishTaxRuleSet(ctx contractapi.TransactionContextInterface, jurisdiction string, effectiveFrom string, rules TaxRules) (*TaxRuleSet, error) {
+	caller, err := authorize(ctx, "PublishTaxRuleSet")
+	if err != nil {
+		return nil, err
+	}
+	if jurisdiction == "" {
+		return nil, fmt.Errorf("a jurisdiction is required")
+	}
+
+	err = rules.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	effective, err := parseDate(effectiveFrom)
+	if err != nil {
+		return nil, err
+	}
+	effective = startOfDay(effective)
+
+	ruleSets, err := taxRuleSets(ctx, jurisdiction)
+	if err != nil {
+		return nil, err
+	}
+	version := 1
+	if n := len(ruleSets); n > 0 {
+		latest := ruleSets[n-1]
+		if !effective.After(latest.EffectiveFrom) {
+			return nil, fmt.Errorf("tax rules must take effect after version %d, effective %s", latest.Version, latest.EffectiveFrom.Format(dateLayout))
+		}
+		version = latest.Version + 1
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ruleSet := TaxRuleSet{
+		Jurisdiction:  jurisdiction,
+		Version:       version,
+		TaxRules:      rules,
+		EffectiveFrom: effective,
+		PublishedBy:   caller.Name,
+		RecordedAt:    now,
+	}
+
+	// zero padded so that versions sort numerically
+	ruleSetKey, err := ctx.GetStub().CreateCompositeKey(taxRuleSetIndex, []string{jurisdiction, fmt.Sprintf("%06d", version)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tax rule set key: %v", err)
+	}
+
+	ruleSetJSON, err := json.Marshal(ruleSet)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(ruleSetKey, ruleSetJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return &ruleSet, nil
+}
+
+// GetTaxRuleSets returns every version of the withholding rules of a jurisdiction
+func (s *PaymentContract) GetTaxRuleSets(ctx contractapi.TransactionContextInterface, jurisdiction string) ([]*TaxRuleSet, error) {
+	_, err := authorize(ctx, "GetTaxRuleSets")
+	if err != nil {
+		return nil, err
+	}
+
+	return taxRuleSets(ctx, jurisdiction)
+}
+
+// yearToDateKey returns the world state key of the totals of an employee in a contract
+func yearToDateKey(ctx contractapi.TransactionContextInterface, employee string, contractID string, year int, currency string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(yearToDateIndex, []string{employee, contractID, fmt.Sprintf("%04d", year), currency})
+	if err != nil {
+		return "", fmt.Errorf("failed to create year-to-date key: %v", err)
+	}
+	return key, nil
+}
+
+// getYearToDate reads the totals of an employee in a contract, starting from zero
+// when nothing was paid yet that year
+func getYearToDate(ctx contractapi.TransactionContextInterface, employee string, contractID string, year int, currency string) (*YearToDate, error) {
+	key, err := yearToDateKey(ctx, employee, contractID, year, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	ytdJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	if ytdJSON == nil {
+		zero, err := ZeroMoney(currency)
+		if err != nil {
+			return nil, err
+		}
+		return &YearToDate{
+			Employee:          employee,
+			ContractID:        contractID,
+			Year:              year,
+			Currency:          currency,
+			Gross:             zero,
+			Net:               zero,
+			Deductions:        map[string]Money{},
+			ContributionBases: map[string]Money{},
+		}, nil
+	}
+
+	var ytd YearToDate
+	err = json.Unmarshal(ytdJSON, &ytd)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadYearToDateTotals(ctx, key, &ytd, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ytd, nil
+}
+
+// putYearToDate writes the totals of an employee in a contract, keeping the amounts
+// in the contract collection once the contract's pay is
+func putYearToDate(ctx contractapi.TransactionContextInterface, ytd *YearToDate) error {
+	key, err := yearToDateKey(ctx, ytd.Employee, ytd.ContractID, ytd.Year, ytd.Currency)
+	if err != nil {
+		return err
+	}
+
+	public, err := putYearToDateTotals(ctx, key, ytd)
+	if err != nil {
+		return err
+	}
+
+	ytdJSON, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, ytdJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	return nil
+}
+
+// taxJurisdiction returns the jurisdiction of the account a contract pays into.
+// Contracts whose account has none, or predates accounts, are paid without withholding
+func taxJurisdiction(ctx contractapi.TransactionContextInterface, contract *Contract, date time.Time) (string, error) {
+	accountID := contract.termsAt(date).AccountID
+	exists, err := accountExists(ctx, accountID)
+	if err != nil || !exists {
+		return "", err
+	}
+
+	account, err := readAccount(ctx, accountID)
+	if err != nil {
+		return "", err
+	}
+
+	return account.TaxJurisdiction, nil
+}
+
+// withholdPayment applies the rules of the contract's tax jurisdiction to a regular
+// payment covering days of pay, filling in its deduction lines and net amount, and
+// returns the employee's year-to-date totals including the payment. The totals are
+// not written, so the caller can still decide not to make the payment
+func withholdPayment(ctx contractapi.TransactionContextInterface, contract *Contract, payment *Payment, days int64) (*YearToDate, error) {
+	ytd, err := getYearToDate(ctx, payment.Employee, payment.ContractID, payment.Date.Year(), payment.Amount.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withholdOnTotals(ctx, contract, payment, days, ytd)
+	if err != nil {
+		return nil, err
+	}
+
+	return ytd, nil
+}
+
+// withholdOnTotals withholds from a payment as withholdPayment does, given the
+// year-to-date totals before the payment, and adds the payment to them
+func withholdOnTotals(ctx contractapi.TransactionContextInterface, contract *Contract, payment *Payment, days int64, ytd *YearToDate) error {
+	gross := payment.Amount
+	jurisdiction, err := taxJurisdiction(ctx, contract, payment.Date)
+	if err != nil {
+		return err
+	}
+
+	net := gross
+	payment.Deductions = nil
+	if jurisdiction != "" {
+		ruleSet, err := taxRuleSetAt(ctx, jurisdiction, payment.Date)
+		if err != nil {
+			return err
+		}
+		if ruleSet.Currency != gross.Currency {
+			return fmt.Errorf("tax rules of %s are in %s, the payment is in %s", jurisdiction, ruleSet.Currency, gross.Currency)
+		}
+
+		input := withholdingInput{gross: gross, days: days, ytd: ytd}
+		for _, rule := range ruleSet.rules() {
+			line, err := rule.withhold(input)
+			if err != nil {
+				return err
+			}
+			if line.Amount.IsZero() {
+				continue
+			}
+			payment.Deductions = append(payment.Deductions, *line)
+			net, err = net.Sub(line.Amount)
+			if err != nil {
+				return err
+			}
+		}
+		if net.IsNegative() {
+			return fmt.Errorf("deductions of %s exceed the gross pay", payment.ID)
+		}
+	}
+	payment.Net = net
+
+	// accumulate the totals the caps of the next payment will use
+	return ytd.add(payment)
+}
+
+// add adds the gross, net and deductions of a payment to the totals. Adding a
+// reversal, whose amounts are negated, takes the reversed payment out again
+func (y *YearToDate) add(payment *Payment) error {
+	var err error
+	y.Gross, err = y.Gross.Add(payment.Amount)
+	if err != nil {
+		return err
+	}
+	y.Net, err = y.Net.Add(payment.Net)
+	if err != nil {
+		return err
+	}
+	for _, line := range payment.Deductions {
+		err = addTotal(y.Deductions, line.Code, line.Amount)
+		if err != nil {
+			return err
+		}
+		if line.Code != IncomeTax {
+			err = addTotal(y.ContributionBases, line.Code, line.Base)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// addTotal adds an amount to the total kept under code
+func addTotal(totals map[string]Money, code string, amount Money) error {
+	total, ok := totals[code]
+	if !ok {
+		total = Money{Currency: amount.Currency}
+	}
+
+	total, err := total.Add(amount)
+	if err != nil {
+		return err
+	}
+	totals[code] = total
+	return nil
+}
+
+// GetYearToDate returns the year-to-date totals of the employee of a contract for a year
+func (s *PaymentContract) GetYearToDate(ctx contractapi.TransactionContextInterface, contractID string, year int) ([]*YearToDate, error) {
+	err := authorizeRead(ctx, "GetYearToDate", contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := readContract(ctx, contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(yearToDateIndex, []string{contract.Employee, contractID, fmt.Sprintf("%04d", year)})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var totals []*YearToDate
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var ytd YearToDate
+		err = json.Unmarshal(queryResponse.Value, &ytd)
+		if err != nil {
+			return nil, err
+		}
+		err = loadYearToDateTotals(ctx, queryResponse.Key, &ytd, true)
+		if err != nil {
+			return nil, err
+		}
+		totals = append(totals, &ytd)
+	}
+
+	return totals, nil
+}
@@ -0,0 +1,117 @@
+package chaincode
+
+import (
+	"testing"
+)
+
+// euros parses an amount in EUR or fails the test
+func euros(t *testing.T, value string) Money {
+	t.Helper()
+
+	amount, err := ParseMoney(value, "EUR", RoundExact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amount
+}
+
+// emptyYearToDate returns the totals of an employee nothing was paid to yet this year
+func emptyYearToDate(t *testing.T) *YearToDate {
+	t.Helper()
+
+	return &YearToDate{Gross: euros(t, "0"), Net: euros(t, "0"), Deductions: map[string]Money{}, ContributionBases: map[string]Money{}}
+}
+
+// Income tax is levied by bracket on the pay annualised over the days it covers,
+// above the allowance, and the share of those days is withheld
+func TestIncomeTaxIsWithheldByBracket(t *testing.T) {
+	rule := incomeTaxRule{
+		allowance: euros(t, "10000.00"),
+		brackets:  []TaxBracket{{Threshold: euros(t, "0"), Rate: 2000}, {Threshold: euros(t, "40000.00"), Rate: 4000}},
+	}
+
+	for _, test := range []struct {
+		gross    string
+		days     int64
+		withheld string
+		rate     int64
+	}{
+		// 40000.00 at 20% and 10000.00 at 40% of a taxable 50000.00
+		{"60000.00", 365, "12000.00 EUR", 4000},
+		// a fifth of the same year
+		{"12000.00", 73, "2400.00 EUR", 4000},
+		// 20% of 20000.00 in the first bracket only
+		{"30000.00", 365, "4000.00 EUR", 2000},
+		// below the allowance
+		{"8000.00", 365, "0.00 EUR", 0},
+	} {
+		line, err := rule.withhold(withholdingInput{gross: euros(t, test.gross), days: test.days, ytd: emptyYearToDate(t)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line.Amount.String() != test.withheld || line.Rate != test.rate {
+			t.Errorf("%s over %d days withholds %s at %d, want %s at %d", test.gross, test.days, line.Amount, line.Rate, test.withheld, test.rate)
+		}
+	}
+}
+
+// A contribution is levied on the gross of the year up to its cap
+func TestContributionStopsAtTheAnnualCap(t *testing.T) {
+	rule := contributionRule{Contribution{Code: "SocialSecurity", Rate: 1000, AnnualCap: euros(t, "50000.00")}}
+
+	for _, test := range []struct {
+		levied   string
+		base     string
+		withheld string
+	}{
+		{"0", "5000.00 EUR", "500.00 EUR"},
+		{"48000.00", "2000.00 EUR", "200.00 EUR"},
+		{"50000.00", "0.00 EUR", "0.00 EUR"},
+	} {
+		ytd := emptyYearToDate(t)
+		ytd.ContributionBases["SocialSecurity"] = euros(t, test.levied)
+		line, err := rule.withhold(withholdingInput{gross: euros(t, "5000.00"), days: 31, ytd: ytd})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line.Base.String() != test.base || line.Amount.String() != test.withheld {
+			t.Errorf("after %s levied, %s is withheld on %s, want %s on %s", test.levied, line.Amount, line.Base, test.withheld, test.base)
+		}
+	}
+
+	// the totals carry the base levied, which the cap of the next payment uses
+	ytd := emptyYearToDate(t)
+	payment := &Payment{Amount: euros(t, "5000.00"), Net: euros(t, "4500.00"), Deductions: []DeductionLine{{Code: "SocialSecurity", Base: euros(t, "5000.00"), Rate: 1000, Amount: euros(t, "500.00")}}}
+	if err := ytd.add(payment); err != nil {
+		t.Fatal(err)
+	}
+	if base := ytd.ContributionBases["SocialSecurity"]; base.String() != "5000.00 EUR" || ytd.Net.String() != "4500.00 EUR" {
+		t.Errorf("the totals are %+v", ytd)
+	}
+}
+
+// Rules are rejected unless their amounts are in their currency, their rates within
+// 0 to 100% and their brackets in ascending order
+func TestTaxRulesAreValidated(t *testing.T) {
+	valid := TaxRules{Currency: "EUR", Brackets: []TaxBracket{{Rate: 1000}, {Threshold: euros(t, "20000.00"), Rate: 3000}}, Contributions: []Contribution{{Code: "Pension", Rate: 900}}}
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if valid.Allowance != euros(t, "0") || valid.Brackets[0].Threshold != euros(t, "0") || valid.Contributions[0].AnnualCap != euros(t, "0") {
+		t.Errorf("unset amounts were not set to zero in EUR: %+v", valid)
+	}
+
+	dollars, _ := NewMoney(100, "USD")
+	for name, rules := range map[string]TaxRules{
+		"descending brackets":      {Currency: "EUR", Brackets: []TaxBracket{{Threshold: euros(t, "20000.00"), Rate: 1000}, {Threshold: euros(t, "10000.00"), Rate: 2000}}},
+		"a rate above 100%":        {Currency: "EUR", Brackets: []TaxBracket{{Rate: basisPoints + 1}}},
+		"a negative rate":          {Currency: "EUR", Contributions: []Contribution{{Code: "Pension", Rate: -1}}},
+		"an allowance in USD":      {Currency: "EUR", Allowance: dollars},
+		"a contribution named tax": {Currency: "EUR", Contributions: []Contribution{{Code: IncomeTax, Rate: 100}}},
+		"an unknown currency":      {Currency: "XXX"},
+	} {
+		if err := rules.validate(); err == nil {
+			t.Errorf("rules with %s were accepted", name)
+		}
+	}
+}